@@ -34,6 +34,7 @@ const (
 const (
 	DHTChangeOK = iota
 	DHTChangeUnknownHashQueuedForRetry
+	DHTChangeValidationQueuedForRetry
 )
 
 // Arg holds the definition of an API function argument
@@ -126,11 +127,15 @@ func (h *Holochain) ValidateAction(a ValidatingAction, entryType string, pkg *Pa
 		h.Debugf("Sys ValidateAction(%T) err:%v\n", a, err)
 		return
 	}
-	if !def.IsSysEntry() {
+	if !def.IsSysEntry() && !isSealedAction(a) {
 
 		// validation actions for application defined entry types
 		var vpkg *ValidationPackage
-		vpkg, err = MakeValidationPackage(h, pkg)
+		var source peer.ID
+		if len(sources) > 0 {
+			source = sources[0]
+		}
+		vpkg, err = MakeValidationPackage(h, pkg, source)
 		if err != nil {
 			return
 		}
@@ -189,6 +194,14 @@ func (h *Holochain) GetValidationResponse(a ValidatingAction, hash Hash) (resp V
 		// if headers entry there no extra info to return in the package so do nothing
 	case DelEntryType:
 		// if del entry there no extra info to return in the package so do nothing
+	case ChunkEntryType:
+		// if blob chunk there no extra info to return in the package so do nothing
+	case PrivateChunkEntryType:
+		// the chunks of private blobs are never given out
+		err = ErrHashNotFound
+		return
+	case MigrationEntryType:
+		// if migration entry there no extra info to return in the package so do nothing
 	case AgentEntryType:
 		// if agent, the package to return is the entry-type chain
 		// so that sys validation can confirm this agent entry in the chain
@@ -207,6 +220,18 @@ func (h *Holochain) GetValidationResponse(a ValidatingAction, hash Hash) (resp V
 			return
 		}
 
+		if def.isSharingPartial() {
+			// partially shared entries are only given out sealed, and because they
+			// can't be validated by the app there's no package to send
+			var sealed SealedEntry
+			sealed, err = h.getSealedEntry(hash)
+			if err != nil {
+				return
+			}
+			resp.Entry = GobEntry{C: sealed}
+			return
+		}
+
 		// get the packaging request from the app
 		var n Ribosome
 		n, err = z.MakeRibosome(h)
@@ -296,6 +321,7 @@ func (h *Holochain) doCommit(a CommittingAction, change Hash) (d *EntryDef, err
 		chain = bundle.chain
 	}
 
+	var sealed bool
 	// retry loop incase someone sneaks a new commit in between prepareHeader and addEntry
 	for !added {
 		chain.lk.RLock()
@@ -312,6 +338,15 @@ func (h *Holochain) doCommit(a CommittingAction, change Hash) (d *EntryDef, err
 			return
 		}
 
+		// partially shared entries are sealed before they are added so that there's
+		// never a committed entry without the sealed copy that gets put to the DHT
+		if s, ok := a.(sealingAction); ok && d.isSharingPartial() && !sealed {
+			if err = s.seal(h); err != nil {
+				return
+			}
+			sealed = true
+		}
+
 		chain.lk.Lock()
 		if count == len(chain.Headers) {
 			err = chain.addEntry(l, hash, header, entry)
@@ -362,10 +397,14 @@ func isValidPubKey(b58pk string) bool {
 const (
 	ValidationFailureBadPublicKeyFormat  = "bad public key format"
 	ValidationFailureBadRevocationFormat = "bad revocation format"
+	ValidationFailureEntryTooLarge       = "entry too large"
+	ValidationFailureBadHeaderSignature  = "bad header signature"
+	ValidationFailureBadHeadersFormat    = "bad headers format"
+	ValidationFailureUnrevokedKeyChange  = "agent key changed without revoking the previous key"
 )
 
 // sysValidateEntry does system level validation for adding an entry (put or commit)
-// It checks that entry is not nil, and that it conforms to the entry schema in the definition
+// It checks that entry is not nil, not larger than the DNA's MaxEntrySize, and that it conforms to the entry schema in the definition
 // if it's a Links entry that the contents are correctly structured
 // if it's a new agent entry, that identity matches the defined identity structure
 // if it's a key that the structure is actually a public key
@@ -406,9 +445,21 @@ func sysValidateEntry(h *Holochain, def *EntryDef, entry Entry, pkg *Package) (e
 
 		// TODO check anything in the package
 	case HeadersEntryType:
-		// TODO check signatures!
+		if entry != nil {
+			err = checkHeadersSignatures(h, entry)
+			if err != nil {
+				return
+			}
+		}
 	case DelEntryType:
 		// TODO checks according to CRDT configuration?
+	case MigrationEntryType:
+		if entry != nil {
+			err = sysValidateMigrationEntry(entry)
+			if err != nil {
+				return
+			}
+		}
 	}
 
 	if entry == nil {
@@ -416,6 +467,21 @@ func sysValidateEntry(h *Holochain, def *EntryDef, entry Entry, pkg *Package) (e
 		return
 	}
 
+	if err = sysValidateBinaryEntry(&h.nucleus.dna.DHTConfig, def, entry); err != nil {
+		return
+	}
+
+	// don't let anyone push entries bigger than the DNA allows into the DHT
+	var b []byte
+	b, err = entry.Marshal()
+	if err != nil {
+		return
+	}
+	if len(b) > h.nucleus.dna.DHTConfig.maxEntrySize() {
+		err = ValidationFailed(ValidationFailureEntryTooLarge)
+		return
+	}
+
 	// see if there is a schema validator for the entry type and validate it if so
 	if def.validator != nil {
 		var input interface{}
@@ -488,14 +554,21 @@ func sysValidateEntry(h *Holochain, def *EntryDef, entry Entry, pkg *Package) (e
 }
 
 func RunValidationPhase(h *Holochain, source peer.ID, msgType MsgType, query Hash, handler func(resp ValidateResponse) error) (err error) {
+	_, err = runValidationPhase(h, source, msgType, query, handler)
+	return
+}
+
+// runValidationPhase is RunValidationPhase that also returns whether the source answered
+func runValidationPhase(h *Holochain, source peer.ID, msgType MsgType, query Hash, handler func(resp ValidateResponse) error) (answered bool, err error) {
 	var r interface{}
 	msg := h.node.NewMessage(msgType, ValidateQuery{H: query})
-	r, err = h.Send(h.node.ctx, ValidateProtocol, source, msg, 0)
+	r, answered, err = h.send(h.node.ctx, ValidateProtocol, source, msg, 0)
 	if err != nil {
 		return
 	}
 	switch resp := r.(type) {
 	case ValidateResponse:
+		h.dht.watchHeader(source, &resp.Header)
 		err = handler(resp)
 	default:
 		err = fmt.Errorf("expected ValidateResponse from validator got %T", r)
@@ -51,6 +51,35 @@ func (a *ActionListAdd) Receive(dht *DHT, msg *Message) (response interface{}, e
 		return
 	}
 
+	// check that the warrant covers the peers being added
+	switch tw := w.(type) {
+	case *BlockWarrant:
+		// a block warrant only covers blocks made by the sender of the request, and as
+		// it isn't evidence of the peers' behavior it only counts towards blocking them
+		// for a while rather than adding them to the blocklist
+		err = tw.checkListAdd(msg.From, t.Peers)
+		if err == nil {
+			err = tw.checkTime(dht.config.blockWarrantWindow())
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %v", prefix, err)
+			return
+		}
+		var peers []peer.ID
+		for _, r := range a.list.Records {
+			peers = append(peers, r.ID)
+		}
+		dht.noteBlockWarrant(msg.From, peers)
+		response = DHTChangeOK
+		return
+	case *ForkWarrant:
+		err = tw.checkListAdd(t.Peers)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %v", prefix, err)
+		return
+	}
+
 	// TODO verify that the warrant, if valid, is sufficient to allow list addition #300
 
 	err = dht.addToList(msg, a.list)
@@ -61,6 +90,9 @@ func (a *ActionListAdd) Receive(dht *DHT, msg *Message) (response interface{}, e
 	// special case to add blockedlist peers to node cache and delete them from the gossipers list
 	if a.list.Type == BlockedList {
 		for _, node := range a.list.Records {
+			if node.ID == dht.h.nodeID {
+				continue
+			}
 			dht.h.node.Block(node.ID)
 			dht.DeleteGossiper(node.ID) // ignore error
 		}
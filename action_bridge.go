@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 //------------------------------------------------------------
@@ -27,9 +28,32 @@ func (fn *APIFnBridge) Args() []Arg {
 }
 
 func (fn *APIFnBridge) Call(h *Holochain) (response interface{}, err error) {
-	body := bytes.NewBuffer([]byte(fn.args.(string)))
+	args := fn.args.(string)
+	body := bytes.NewBuffer([]byte(args))
+	var req *http.Request
+	req, err = http.NewRequest("POST", fmt.Sprintf("%s/bridge/%s/%s/%s", fn.url, fn.token, fn.zome, fn.function), body)
+	if err != nil {
+		return
+	}
+
+	// identify ourselves to the callee in case the token was granted only to specific agents
+	var sig Signature
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	sig, err = h.Sign([]byte(bridgeCallData(fn.token, fn.zome, fn.function, timestamp, args)))
+	if err != nil {
+		return
+	}
+	var pk string
+	pk, err = h.agent.EncodePubKey()
+	if err != nil {
+		return
+	}
+	req.Header.Set(BridgeAgentHeader, pk)
+	req.Header.Set(BridgeTimeHeader, timestamp)
+	req.Header.Set(BridgeSignatureHeader, sig.B58String())
+
 	var resp *http.Response
-	resp, err = http.Post(fmt.Sprintf("%s/bridge/%s/%s/%s", fn.url, fn.token, fn.zome, fn.function), "", body)
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		return
 	}
@@ -3,6 +3,7 @@ package holochain
 import (
 	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
+	"reflect"
 )
 
 //------------------------------------------------------------
@@ -17,11 +18,16 @@ func (fn *APIFnCommit) Name() string {
 }
 
 func (fn *APIFnCommit) Args() []Arg {
-	return []Arg{{Name: "entryType", Type: StringArg}, {Name: "entry", Type: EntryArg}}
+	return []Arg{{Name: "entryType", Type: StringArg}, {Name: "entry", Type: EntryArg}, {Name: "options", Type: MapArg, MapType: reflect.TypeOf(CommitOptions{}), Optional: true}}
 }
 
 func (fn *APIFnCommit) Call(h *Holochain) (response interface{}, err error) {
-	response, err = h.commitAndShare(&fn.action, NullHash())
+	a := &fn.action
+	a.entry, err = h.prepareBinaryEntry(a.entryType, a.entry, true)
+	if err != nil {
+		return
+	}
+	response, err = h.commitAndShare(a, NullHash())
 	return
 }
 
@@ -30,9 +36,10 @@ func (fn *APIFnCommit) SetAction(a *ActionCommit) {
 }
 
 type ActionCommit struct {
-	entryType string
-	entry     Entry
-	header    *Header
+	entryType  string
+	entry      Entry
+	header     *Header
+	recipients []string
 }
 
 func NewCommitAction(entryType string, entry Entry) *ActionCommit {
@@ -40,6 +47,11 @@ func NewCommitAction(entryType string, entry Entry) *ActionCommit {
 	return &a
 }
 
+// SetRecipients sets the agents a partially shared entry is shared with
+func (a *ActionCommit) SetRecipients(recipients []string) {
+	a.recipients = recipients
+}
+
 func (a *ActionCommit) Entry() Entry {
 	return a.entry
 }
@@ -81,7 +93,7 @@ func (a *ActionCommit) Share(h *Holochain, def *EntryDef) (err error) {
 			}
 		}
 	}
-	if def.isSharingPublic() {
+	if def.isSharingPublic() || def.isSharingPartial() {
 		// otherwise we check to see if it's a public entry and if so send the DHT put message
 		err = h.dht.Change(a.header.EntryLink, PUT_REQUEST, HoldReq{EntryHash: a.header.EntryLink})
 		if err == ErrEmptyRoutingTable {
@@ -92,6 +104,12 @@ func (a *ActionCommit) Share(h *Holochain, def *EntryDef) (err error) {
 	return
 }
 
+// seal seals a partially shared entry for its recipients, as it's put to the DHT sealed
+func (a *ActionCommit) seal(h *Holochain) (err error) {
+	err = h.sealPartialEntry(a.header.EntryLink, a.entry, a.recipients)
+	return
+}
+
 func (a *ActionCommit) SysValidation(h *Holochain, def *EntryDef, pkg *Package, sources []peer.ID) (err error) {
 	err = sysValidateEntry(h, def, a.entry, pkg)
 	return
@@ -92,6 +92,9 @@ func (a *ActionDel) Receive(dht *DHT, msg *Message) (response interface{}, err e
 		if err != nil {
 			// how do we record an invalid DEL?
 			//@TODO store as REJECTED
+			if IsValidationFailedErr(err) {
+				dht.ratePeer(msg.From, ReputationValidationFailed)
+			}
 		} else {
 			err = dht.Del(msg, delEntry.Hash)
 			if err == nil {
@@ -32,8 +32,17 @@ func callGet(h *Holochain, req GetReq, options *GetOptions) (response interface{
 
 func (fn *APIFnGet) Call(h *Holochain) (response interface{}, err error) {
 	a := &fn.action
+	if a.options.History {
+		response, err = h.getHistory(a.req.H)
+		return
+	}
 	if a.options.Local {
-		response, err = a.getLocal(h.chain)
+		var resp GetResp
+		resp, err = a.getLocal(h.chain)
+		if err == nil {
+			err = h.getBlob(&resp, chainChunkFetcher(h.chain))
+		}
+		response = resp
 		return
 	}
 	if a.options.Bundle {
@@ -42,7 +51,12 @@ func (fn *APIFnGet) Call(h *Holochain) (response interface{}, err error) {
 			err = ErrBundleNotStarted
 			return
 		}
-		response, err = a.getLocal(bundle.chain)
+		var resp GetResp
+		resp, err = a.getLocal(bundle.chain)
+		if err == nil {
+			err = h.getBlob(&resp, chainChunkFetcher(bundle.chain, h.chain))
+		}
+		response = resp
 		return
 	}
 	rsp, err := h.dht.Query(a.req.H, GET_REQUEST, a.req)
@@ -69,6 +83,15 @@ func (fn *APIFnGet) Call(h *Holochain) (response interface{}, err error) {
 	}
 	switch t := rsp.(type) {
 	case GetResp:
+		// partially shared entries come back sealed
+		err = h.openGetResp(a.req.H, &t)
+		if err != nil {
+			return
+		}
+		err = h.getBlob(&t, h.getChunk)
+		if err != nil {
+			return
+		}
 		response = t
 	default:
 		err = fmt.Errorf("expected GetResp response from GET_REQUEST, got: %T", t)
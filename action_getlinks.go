@@ -1,7 +1,6 @@
 package holochain
 
 import (
-	"encoding/json"
 	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
@@ -31,6 +30,12 @@ func (fn *APIFnGetLinks) Call(h *Holochain) (response interface{}, err error) {
 	if err == nil {
 		switch t := r.(type) {
 		case *LinkQueryResp:
+			// an unpaged query can't hand back the cursor, so rather than
+			// silently truncating the links tell the caller to page
+			if t.Cursor != "" && a.linkQuery.Count <= 0 && a.linkQuery.Cursor == "" {
+				err = ErrLinksCapped
+				return
+			}
 			response = t
 			if a.options.Load {
 				for i := range t.Links {
@@ -52,12 +57,8 @@ func (fn *APIFnGetLinks) Call(h *Holochain) (response interface{}, err error) {
 						case string:
 							t.Links[i].E = content
 						case []byte:
-							var j []byte
-							j, err = json.Marshal(content)
-							if err != nil {
-								return
-							}
-							t.Links[i].E = string(j)
+							// binary entries are passed to the ribosomes base64 encoded
+							t.Links[i].E = entryContentString(&entry)
 						default:
 							err = fmt.Errorf("bad type in entry content: %T:%v", content, content)
 						}
@@ -95,7 +96,7 @@ func (a *ActionGetLinks) SysValidation(h *Holochain, d *EntryDef, pkg *Package,
 func (a *ActionGetLinks) Receive(dht *DHT, msg *Message) (response interface{}, err error) {
 	lq := msg.Body.(LinkQuery)
 	var r LinkQueryResp
-	r.Links, err = dht.GetLinks(lq.Base, lq.T, lq.StatusMask)
+	r.Links, r.Cursor, err = dht.QueryLinks(&lq)
 	response = &r
 
 	return
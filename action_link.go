@@ -53,6 +53,9 @@ func (a *ActionLink) Receive(dht *DHT, msg *Message) (response interface{}, err
 		if err != nil {
 			// how do we record an invalid linking?
 			//@TODO store as REJECTED
+			if IsValidationFailedErr(err) {
+				dht.ratePeer(msg.From, ReputationValidationFailed)
+			}
 		} else {
 			base := t.RelatedHash.String()
 			for _, l := range le.Links {
@@ -25,8 +25,13 @@ func (a *APIFnMakeHash) Args() []Arg {
 }
 
 func (a *APIFnMakeHash) Call(h *Holochain) (response interface{}, err error) {
+	var entry Entry
+	entry, err = h.prepareBinaryEntry(a.entryType, a.entry, false)
+	if err != nil {
+		return
+	}
 	var hash Hash
-	hash, err = a.entry.Sum(h.hashSpec)
+	hash, err = entry.Sum(h.hashSpec)
 	if err != nil {
 		return
 	}
@@ -1,16 +1,24 @@
 package holochain
 
 import (
+	"errors"
+
+	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
 )
 
 //------------------------------------------------------------
 // Put
 
+// ErrValidationUnanswered is returned when the source of a put didn't answer our validation
+// request, in which case the put is queued for retry
+var ErrValidationUnanswered = errors.New("source didn't answer validation request")
+
 type ActionPut struct {
 	entryType string
 	entry     Entry
 	header    *Header
+	hash      Hash // the hash being put, when received from the DHT
 }
 
 func NewPutAction(entryType string, entry Entry, header *Header) *ActionPut {
@@ -23,6 +31,16 @@ func (a *ActionPut) Name() string {
 }
 
 func (a *ActionPut) SysValidation(h *Holochain, def *EntryDef, pkg *Package, sources []peer.ID) (err error) {
+	var sealed bool
+	sealed, err = sysValidateSealed(h, def, a.entryType, a.hash, a.entry, a.header, sources)
+	if sealed || err != nil {
+		return
+	}
+	if def.isSharingPartial() {
+		// partially shared entries are only put to the DHT sealed
+		err = ValidationFailed(ValidationFailurePartialNotSealed)
+		return
+	}
 	err = sysValidateEntry(h, def, a.entry, pkg)
 	return
 }
@@ -43,20 +61,25 @@ func (a *ActionPut) Receive(dht *DHT, msg *Message) (response interface{}, err e
 		return
 	}
 
-	err = RunValidationPhase(dht.h, msg.From, VALIDATE_PUT_REQUEST, t.EntryHash, func(resp ValidateResponse) error {
+	var answered bool
+	answered, err = runValidationPhase(dht.h, msg.From, VALIDATE_PUT_REQUEST, t.EntryHash, func(resp ValidateResponse) error {
 		a := NewPutAction(resp.Type, &resp.Entry, &resp.Header)
+		a.hash = t.EntryHash
 		_, err := dht.h.ValidateAction(a, a.entryType, &resp.Package, []peer.ID{msg.From})
 
 		var status int
 		if err != nil {
 			dht.dlog.Logf("Put %v rejected: %v", t.EntryHash, err)
+			if IsValidationFailedErr(err) {
+				dht.ratePeer(msg.From, ReputationValidationFailed)
+			}
 			status = StatusRejected
 		} else {
 			status = StatusLive
 		}
 		entry := resp.Entry
 		var b []byte
-		b, err = entry.Marshal()
+		b, err = marshalEntryAs(&entry, dht.h.nucleus.dna.DHTConfig.Encoding)
 		if err == nil {
 			err = dht.Put(msg, resp.Type, t.EntryHash, msg.From, b, status)
 		}
@@ -65,6 +88,12 @@ func (a *ActionPut) Receive(dht *DHT, msg *Message) (response interface{}, err e
 		}
 		return err
 	})
+	if answered {
+		dht.validationFailures.succeeded(validationFailureKey(t.EntryHash, msg.From))
+	} else if err != nil {
+		err = dht.putValidationUnanswered(msg, t.EntryHash, err)
+		return
+	}
 
 	r := dht.h.RedundancyFactor()
 	if r == 0 {
@@ -89,3 +118,26 @@ func (a *ActionPut) Receive(dht *DHT, msg *Message) (response interface{}, err e
 func (a *ActionPut) CheckValidationRequest(def *EntryDef) (err error) {
 	return
 }
+
+// putValidationUnanswered handles a put whose source didn't answer our validation request,
+// because sending it failed or timed out.
+// It returns ErrValidationUnanswered so the put gets retried, until the source has been
+// failing to answer for longer than the DNA's ValidationTimeout, after which the put is
+// dropped.  Nothing is recorded for the hash as the source not answering says nothing
+// about the entry, and other sources may still put it.
+func (dht *DHT) putValidationUnanswered(msg *Message, hash Hash, sendErr error) (err error) {
+	if dht.validationFailures.failed(validationFailureKey(hash, msg.From), dht.config.validationTimeout()) {
+		dht.dlog.Logf("%v never answered validation of %v, dropping put", msg.From, hash)
+		err = sendErr
+		return
+	}
+	dht.dlog.Logf("%v didn't answer validation of %v (%v)", msg.From, hash, sendErr)
+	err = ErrValidationUnanswered
+	return
+}
+
+// validationFailureKey returns the key that unanswered validation requests for a put of
+// hash from a source are tracked under
+func validationFailureKey(hash Hash, from peer.ID) string {
+	return hash.String() + ":" + peer.IDB58Encode(from)
+}
@@ -123,6 +123,31 @@ func TestSysValidateEntry(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 
+	Convey("a headers entry should have headers signed by their source", t, func() {
+		hd := h.Chain().Top()
+		j, _ := hd.ToJSON()
+		e := &GobEntry{C: fmt.Sprintf(`[{"Header":%s,"Role":"someRole","Source":"%s"}]`, j, h.nodeID.Pretty())}
+		err := sysValidateEntry(h, HeadersEntryDef, e, nil)
+		So(err, ShouldBeNil)
+
+		e.C = `{"Header":"fish"}`
+		err = sysValidateEntry(h, HeadersEntryDef, e, nil)
+		So(err.Error(), ShouldEqual, ValidationFailedErrMsg+": "+ValidationFailureBadHeadersFormat)
+
+		other, key := makePeer("peer_bar")
+		h.node.peerstore.AddPubKey(other, key.GetPublic())
+		e.C = fmt.Sprintf(`[{"Header":%s,"Role":"someRole","Source":"%s"}]`, j, other.Pretty())
+		err = sysValidateEntry(h, HeadersEntryDef, e, nil)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("%s: %s for entry %v from %s", ValidationFailedErrMsg, ValidationFailureBadHeaderSignature, hd.EntryLink, other.Pretty()))
+
+		tampered := *hd
+		tampered.HeaderLink = hd.EntryLink
+		j, _ = tampered.ToJSON()
+		e.C = fmt.Sprintf(`[{"Header":%s,"Role":"someRole","Source":"%s"}]`, j, h.nodeID.Pretty())
+		err = sysValidateEntry(h, HeadersEntryDef, e, nil)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("%s: %s for entry %v from %s", ValidationFailedErrMsg, ValidationFailureBadHeaderSignature, hd.EntryLink, h.nodeID.Pretty()))
+	})
+
 	_, def, _ := h.GetEntryDef("rating")
 
 	Convey("a nil entry is invalid", t, func() {
@@ -131,6 +156,14 @@ func TestSysValidateEntry(t *testing.T) {
 		So(err.Error(), ShouldEqual, "Validation Failed: nil entry invalid")
 	})
 
+	Convey("an entry larger than the DNA's MaxEntrySize is invalid", t, func() {
+		h.nucleus.dna.DHTConfig.MaxEntrySize = 20
+		defer func() { h.nucleus.dna.DHTConfig.MaxEntrySize = 0 }()
+		err := sysValidateEntry(h, def, &GobEntry{C: `{"Links":[{"Base":"QmdRXz53TVT9qBYfbXctHyy2GpTNa6YrpAy6ZcDGG8Xhc5","Link":"QmdRXz53TVT9qBYfbXctHyy2GpTNa6YrpAy6ZcDGG8Xhc5","Tag":"sometag"}]}`}, nil)
+		So(IsValidationFailedErr(err), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "Validation Failed: entry too large")
+	})
+
 	Convey("validate on a schema based entry should check entry against the schema", t, func() {
 		profile := `{"firstName":"Eric"}` // missing required lastName
 		_, def, _ := h.GetEntryDef("profile")
@@ -22,6 +22,10 @@ func (fn *APIFnMod) Args() []Arg {
 
 func (fn *APIFnMod) Call(h *Holochain) (response interface{}, err error) {
 	a := &fn.action
+	a.entry, err = h.prepareBinaryEntry(a.entryType, a.entry, true)
+	if err != nil {
+		return
+	}
 	response, err = h.commitAndShare(a, a.replaces)
 	return
 }
@@ -31,6 +35,7 @@ type ActionMod struct {
 	entry     Entry
 	header    *Header
 	replaces  Hash
+	hash      Hash // the hash of the update, when received from the DHT
 }
 
 func NewModAction(entryType string, entry Entry, replaces Hash) *ActionMod {
@@ -58,8 +63,19 @@ func (a *ActionMod) GetHeader() (header *Header) {
 	return a.header
 }
 
+// seal seals a partially shared update for the recipients of the entry it replaces
+func (a *ActionMod) seal(h *Holochain) (err error) {
+	var recipients []string
+	recipients, err = h.sealedRecipients(a.replaces)
+	if err != nil {
+		return
+	}
+	err = h.sealPartialEntry(a.header.EntryLink, a.entry, recipients)
+	return
+}
+
 func (a *ActionMod) Share(h *Holochain, def *EntryDef) (err error) {
-	if def.isSharingPublic() {
+	if def.isSharingPublic() || def.isSharingPartial() {
 		// if it's a public entry send the DHT MOD & PUT messages
 		// TODO handle errors better!!
 		h.dht.Change(a.header.EntryLink, PUT_REQUEST, HoldReq{EntryHash: a.header.EntryLink})
@@ -114,6 +130,11 @@ func (a *ActionMod) SysValidation(h *Holochain, def *EntryDef, pkg *Package, sou
 				return
 			}
 		}*/
+	var sealed bool
+	sealed, err = sysValidateSealed(h, def, a.entryType, a.hash, a.entry, a.header, sources)
+	if sealed || err != nil {
+		return
+	}
 	err = sysValidateEntry(h, def, a.entry, pkg)
 	return
 }
@@ -126,12 +147,16 @@ func (a *ActionMod) Receive(dht *DHT, msg *Message) (response interface{}, err e
 	err = RunValidationPhase(dht.h, msg.From, VALIDATE_MOD_REQUEST, t.EntryHash, func(resp ValidateResponse) error {
 		a := NewModAction(resp.Type, &resp.Entry, t.RelatedHash)
 		a.header = &resp.Header
+		a.hash = t.EntryHash
 
 		//@TODO what comes back from Validate Mod
 		_, err = dht.h.ValidateAction(a, resp.Type, &resp.Package, []peer.ID{msg.From})
 		if err != nil {
 			// how do we record an invalid Mod?
 			//@TODO store as REJECTED?
+			if IsValidationFailedErr(err) {
+				dht.ratePeer(msg.From, ReputationValidationFailed)
+			}
 		} else {
 			err = dht.Mod(msg, t.RelatedHash, t.EntryHash)
 			if err == nil {
@@ -13,6 +13,8 @@ import (
 type APIFnModAgent struct {
 	Identity   AgentIdentity
 	Revocation string
+
+	agent Agent // the agent to revoke the key for, with its new keys already generated
 }
 
 func (fn *APIFnModAgent) Args() []Arg {
@@ -24,17 +26,25 @@ func (fn *APIFnModAgent) Name() string {
 }
 func (fn *APIFnModAgent) Call(h *Holochain) (response interface{}, err error) {
 	var ok bool
-	var newAgent LibP2PAgent = *h.agent.(*LibP2PAgent)
+	newAgent := fn.agent
+	if newAgent == nil {
+		newAgent, err = copyAgent(h.agent)
+		if err != nil {
+			return
+		}
+	}
 	if fn.Identity != "" {
-		newAgent.identity = fn.Identity
+		newAgent.SetIdentity(fn.Identity)
 		ok = true
 	}
 
 	var revocation *SelfRevocation
 	if fn.Revocation != "" {
-		err = newAgent.GenKeys(nil)
-		if err != nil {
-			return
+		if fn.agent == nil {
+			err = newAgent.GenKeys(nil)
+			if err != nil {
+				return
+			}
 		}
 		revocation, err = NewSelfRevocation(h.agent.PrivKey(), newAgent.PrivKey(), []byte(fn.Revocation))
 		if err != nil {
@@ -47,19 +57,19 @@ func (fn *APIFnModAgent) Call(h *Holochain) (response interface{}, err error) {
 	} else {
 
 		//TODO: synchronize this, what happens if two new agent request come in back to back?
-		h.agent = &newAgent
-		// add a new agent entry and update
+		// add a new agent entry, signed with the current key, and update
 		var agentHash Hash
-		_, agentHash, err = h.AddAgentEntry(revocation)
+		_, agentHash, err = h.addAgentEntry(newAgent, revocation)
 		if err != nil {
 			return
 		}
+		h.agent = newAgent
 		h.agentTopHash = agentHash
 
 		// if there was a revocation put the new key to the DHT and then reset the node ID data
 		// TODO make sure this doesn't introduce race conditions in the DHT between new and old identity #284
 		if revocation != nil {
-			err = h.dht.putKey(&newAgent)
+			err = h.dht.putKey(newAgent)
 			if err != nil {
 				return
 			}
@@ -82,10 +92,11 @@ func (fn *APIFnModAgent) Call(h *Holochain) (response interface{}, err error) {
 				panic(err)
 			}
 
-			// close the old node and add the new node
-			// TODO currently ignoring the error from node.Close() is this OK?
-			h.node.Close()
-			h.createNode()
+			// replace the old node with one for the new key
+			err = h.rekeyNode()
+			if err != nil {
+				return
+			}
 
 			h.dht.Change(oldKey, MOD_REQUEST, HoldReq{RelatedHash: oldKey, EntryHash: newKey})
 
@@ -6,18 +6,79 @@
 package holochain
 
 import (
+	"bytes"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	b58 "github.com/jbenet/go-base58"
 	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
+	"golang.org/x/crypto/scrypt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
+const (
+	AgentPassphraseEnvar     = "HC_AGENT_PASSPHRASE"      // passphrase agent keys are encrypted with
+	AgentPassphraseFileEnvar = "HC_AGENT_PASSPHRASE_FILE" // file to read the passphrase from instead
+
+	agentKeyMagic    = "HCKEY1"
+	agentKeySaltSize = 32
+
+	// scrypt cost parameters for new key files, N = 2^agentKeyScryptLogN uses 32MB of
+	// memory per guess of the passphrase
+	agentKeyScryptLogN = 15
+	agentKeyScryptR    = 8
+	agentKeyScryptP    = 1
+
+	// the largest scrypt parameters a key file may ask for, so that a tampered file
+	// can't make loading it use unbounded memory or time
+	agentKeyScryptMaxLogN = 20
+	agentKeyScryptMaxR    = 16
+	agentKeyScryptMaxP    = 4
+
+	// StagedPrivKeyFileName is the file the key being rotated to is kept in until the
+	// rotation is done
+	StagedPrivKeyFileName = PrivKeyFileName + ".new"
+)
+
+var ErrAgentKeyRevoked = errors.New("agent key has been revoked")
+var ErrAgentPassphraseRequired = errors.New("agent key is encrypted: passphrase required")
+var ErrAgentKeyDecryption = errors.New("unable to decrypt agent key (wrong passphrase?)")
+var ErrAgentKeyEncrypted = errors.New("agent key is already encrypted")
+
+// AgentPassphraseFn returns the passphrase for an agent's key file.  newKey is true when
+// the passphrase is for writing a key file, in which case an empty passphrase means
+// the key is written unencrypted.
+type AgentPassphraseFn func(newKey bool) (passphrase string, err error)
+
+// AgentPassphrase gets the passphrase agent key files are encrypted with.  By default it
+// comes from the environment, command line tools replace it to prompt the user.
+var AgentPassphrase AgentPassphraseFn = AgentPassphraseFromEnv
+
+// AgentPassphraseFromEnv returns the passphrase from the HC_AGENT_PASSPHRASE environment
+// variable, or read from the file named by HC_AGENT_PASSPHRASE_FILE
+func AgentPassphraseFromEnv(newKey bool) (passphrase string, err error) {
+	passphrase = os.Getenv(AgentPassphraseEnvar)
+	if passphrase != "" {
+		return
+	}
+	file := os.Getenv(AgentPassphraseFileEnvar)
+	if file != "" {
+		var b []byte
+		b, err = ioutil.ReadFile(file)
+		if err != nil {
+			return
+		}
+		passphrase = strings.TrimRight(string(b), "\r\n")
+	}
+	return
+}
+
 // AgentIdentity is the user's unique identity information in context of this holochain.
 // it follows AgentIdentitySchema in DNA
 type AgentIdentity string
@@ -26,6 +87,7 @@ type AgentType int
 
 const (
 	LibP2P = iota
+	ExternalSigner
 )
 
 // Agent abstracts the key behaviors and connection to a holochain node address
@@ -70,8 +132,14 @@ func (a *LibP2PAgent) PubKey() ic.PubKey {
 }
 
 func (a *LibP2PAgent) EncodePubKey() (b58pk string, err error) {
+	b58pk, err = encodePubKey(a.pub)
+	return
+}
+
+// encodePubKey returns the b58 encoding of a public key, the inverse of DecodePubKey
+func encodePubKey(pubKey ic.PubKey) (b58pk string, err error) {
 	var pk []byte
-	pk, err = ic.MarshalPublicKey(a.pub)
+	pk, err = ic.MarshalPublicKey(pubKey)
 	if err != nil {
 		return
 	}
@@ -109,7 +177,12 @@ func (a *LibP2PAgent) NodeID() (nodeID peer.ID, nodeIDStr string, err error) {
 }
 
 func (a *LibP2PAgent) AgentEntry(revocation Revocation) (entry AgentEntry, err error) {
+	entry, err = agentEntry(a, revocation)
+	return
+}
 
+// agentEntry builds the agent entry for an agent's identity and public key
+func agentEntry(a Agent, revocation Revocation) (entry AgentEntry, err error) {
 	entry = AgentEntry{
 		Identity: a.Identity(),
 	}
@@ -139,18 +212,140 @@ func NewAgent(agentType AgentType, identity AgentIdentity, seed io.Reader) (agen
 			return
 		}
 		agent = &a
+	case ExternalSigner:
+		err = errors.New("signer agents must be created with NewSignerAgent")
 	default:
 		err = fmt.Errorf("unknown key type: %d", agentType)
 	}
 	return
 }
 
-// SaveAgent saves out the keys and agent name to the given directory
+// agentKeyFromPassphrase derives the key that encrypts a key file from a passphrase using
+// scrypt, which is memory-hard so that guessing passphrases is expensive
+func agentKeyFromPassphrase(passphrase string, salt []byte, logN, r, p int) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<uint(logN), r, p, 32)
+}
+
+// sealAgentKey encrypts a marshaled private key with a passphrase, prefixing it with
+// a marker, the scrypt parameters and the salt
+func sealAgentKey(k []byte, passphrase string) (data []byte, err error) {
+	salt := make([]byte, agentKeySaltSize)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return
+	}
+	var key []byte
+	key, err = agentKeyFromPassphrase(passphrase, salt, agentKeyScryptLogN, agentKeyScryptR, agentKeyScryptP)
+	if err != nil {
+		return
+	}
+	var c *storeCipher
+	c, err = newStoreCipher(key)
+	if err != nil {
+		return
+	}
+	var sealed []byte
+	sealed, err = c.seal(k)
+	if err != nil {
+		return
+	}
+	data = append([]byte(agentKeyMagic), agentKeyScryptLogN, agentKeyScryptR, agentKeyScryptP)
+	data = append(data, salt...)
+	data = append(data, sealed...)
+	return
+}
+
+// openAgentKey decrypts a key file sealed by sealAgentKey
+func openAgentKey(data []byte, passphrase string) (k []byte, err error) {
+	data = data[len(agentKeyMagic):]
+	if len(data) < 3+agentKeySaltSize {
+		err = ErrAgentKeyDecryption
+		return
+	}
+	logN, r, p := int(data[0]), int(data[1]), int(data[2])
+	if logN < 1 || logN > agentKeyScryptMaxLogN || r < 1 || r > agentKeyScryptMaxR || p < 1 || p > agentKeyScryptMaxP {
+		err = ErrAgentKeyDecryption
+		return
+	}
+	salt := data[3 : 3+agentKeySaltSize]
+	sealed := data[3+agentKeySaltSize:]
+	if !bytes.HasPrefix(sealed, []byte(storeSealMagic)) {
+		err = ErrAgentKeyDecryption
+		return
+	}
+	var key []byte
+	key, err = agentKeyFromPassphrase(passphrase, salt, logN, r, p)
+	if err != nil {
+		return
+	}
+	var c *storeCipher
+	c, err = newStoreCipher(key)
+	if err != nil {
+		return
+	}
+	k, err = c.open(sealed)
+	if err != nil {
+		err = ErrAgentKeyDecryption
+	}
+	return
+}
+
+func isSealedAgentKey(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(agentKeyMagic))
+}
+
+// writeAgentKey writes a marshaled private key to a read-only key file, encrypting it
+// if there's a passphrase for it
+func writeAgentKey(k []byte, path string, name string) (err error) {
+	var passphrase string
+	passphrase, err = AgentPassphrase(true)
+	if err != nil {
+		return
+	}
+	if passphrase != "" {
+		k, err = sealAgentKey(k, passphrase)
+		if err != nil {
+			return
+		}
+	}
+	err = WriteFile(k, path, name)
+	if err != nil {
+		return
+	}
+	os.Chmod(filepath.Join(path, name), OS_USER_R)
+	return
+}
+
+// readAgentKey reads a marshaled private key from a key file, decrypting it if need be
+func readAgentKey(path string, name string) (k []byte, err error) {
+	k, err = ReadFile(path, name)
+	if err != nil || !isSealedAgentKey(k) {
+		return
+	}
+	var passphrase string
+	passphrase, err = AgentPassphrase(false)
+	if err != nil {
+		return
+	}
+	if passphrase == "" {
+		err = ErrAgentPassphraseRequired
+		return
+	}
+	k, err = openAgentKey(k, passphrase)
+	return
+}
+
+// SaveAgent saves out the keys and agent name to the given directory.  The private key
+// is encrypted if AgentPassphrase returns a passphrase.  For signer agents the signer's
+// socket is saved instead of the key.
 func SaveAgent(path string, agent Agent) (err error) {
 	WriteFile([]byte(agent.Identity()), path, AgentFileName)
 	if err != nil {
 		return
 	}
+	if a, ok := agent.(*SignerAgent); ok {
+		err = saveSigner(path, a)
+		return
+	}
 	if FileExists(path, PrivKeyFileName) {
 		return errors.New("keys already exist")
 	}
@@ -159,14 +354,139 @@ func SaveAgent(path string, agent Agent) (err error) {
 	if err != nil {
 		return
 	}
-	err = WriteFile(k, path, PrivKeyFileName)
-	os.Chmod(filepath.Join(path, PrivKeyFileName), OS_USER_R)
+	err = writeAgentKey(k, path, PrivKeyFileName)
 	return
 }
 
-// LoadAgent gets the agent identity and private key from the specified directory
+// EncryptAgentKey encrypts the unencrypted private key in the given directory, along
+// with any unencrypted keys in its revoked keys archive, with a passphrase
+func EncryptAgentKey(path string, passphrase string) (err error) {
+	if passphrase == "" {
+		err = ErrAgentPassphraseRequired
+		return
+	}
+	var k []byte
+	k, err = ReadFile(path, PrivKeyFileName)
+	if err != nil {
+		return
+	}
+	if isSealedAgentKey(k) {
+		err = ErrAgentKeyEncrypted
+		return
+	}
+	files := []string{filepath.Join(path, PrivKeyFileName)}
+	archived, _ := filepath.Glob(filepath.Join(path, RevokedKeysDir, "*"))
+	files = append(files, archived...)
+	for _, f := range files {
+		err = encryptKeyFile(f, passphrase)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// encryptKeyFile replaces an unencrypted key file with an encrypted one
+func encryptKeyFile(file string, passphrase string) (err error) {
+	var k []byte
+	k, err = ioutil.ReadFile(file)
+	if err != nil || isSealedAgentKey(k) {
+		return
+	}
+	k, err = sealAgentKey(k, passphrase)
+	if err != nil {
+		return
+	}
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, k, 0600)
+	if err != nil {
+		return
+	}
+	os.Chmod(tmp, OS_USER_R)
+	err = os.Rename(tmp, file)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// stageAgentKey writes the agent's private key to the staged key file, replacing any left
+// by a rotation that failed, so that the key being rotated to is on disk before it's used
+func stageAgentKey(path string, agent Agent) (err error) {
+	var k []byte
+	k, err = agent.PrivKey().Bytes()
+	if err != nil {
+		return
+	}
+	tmp := StagedPrivKeyFileName + ".tmp"
+	os.Remove(filepath.Join(path, tmp))
+	err = writeAgentKey(k, path, tmp)
+	if err != nil {
+		return
+	}
+	err = os.Rename(filepath.Join(path, tmp), filepath.Join(path, StagedPrivKeyFileName))
+	return
+}
+
+// installStagedAgentKey moves the staged key into place as the agent's key
+func installStagedAgentKey(path string) (err error) {
+	err = os.Rename(filepath.Join(path, StagedPrivKeyFileName), filepath.Join(path, PrivKeyFileName))
+	return
+}
+
+// archiveAgentKey moves the agent's private key into the directory's revoked keys archive,
+// where LoadAgent refuses to use it, making room for the key the agent rotated to
+func archiveAgentKey(path string, agent Agent) (err error) {
+	var nodeIDStr string
+	_, nodeIDStr, err = agent.NodeID()
+	if err != nil {
+		return
+	}
+	var k []byte
+	k, err = agent.PrivKey().Bytes()
+	if err != nil {
+		return
+	}
+	dir := filepath.Join(path, RevokedKeysDir)
+	if !DirExists(dir) {
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return
+		}
+	}
+	if !FileExists(dir, nodeIDStr) {
+		err = writeAgentKey(k, dir, nodeIDStr)
+		if err != nil {
+			return
+		}
+	}
+	if FileExists(path, PrivKeyFileName) {
+		err = os.Remove(filepath.Join(path, PrivKeyFileName))
+	}
+	return
+}
+
+// LoadAgent gets the agent identity and private key from the specified directory, decrypting
+// the key with the passphrase from AgentPassphrase if it's encrypted.  It returns
+// ErrAgentKeyRevoked if the key has been archived as revoked
 // TODO confirm against chain?
 func LoadAgent(path string) (agent Agent, err error) {
+	if FileExists(path, SignerFileName) {
+		var identity []byte
+		identity, err = ReadFile(path, AgentFileName)
+		if err != nil {
+			return
+		}
+		agent, err = loadSigner(path, AgentIdentity(identity))
+		return
+	}
+
+	// finish a key rotation that was interrupted after archiving the old key
+	if !FileExists(path, PrivKeyFileName) && FileExists(path, StagedPrivKeyFileName) {
+		if err = installStagedAgentKey(path); err != nil {
+			return
+		}
+	}
+
 	var perms os.FileMode
 
 	// TODO, make this check also work on windows instead of just bypassing!
@@ -188,7 +508,7 @@ func LoadAgent(path string) (agent Agent, err error) {
 	a := LibP2PAgent{
 		identity: AgentIdentity(identity),
 	}
-	k, err := ReadFile(path, PrivKeyFileName)
+	k, err := readAgentKey(path, PrivKeyFileName)
 	if err != nil {
 		return nil, err
 	}
@@ -197,6 +517,16 @@ func LoadAgent(path string) (agent Agent, err error) {
 		return
 	}
 	a.pub = a.priv.GetPublic()
+
+	var nodeIDStr string
+	_, nodeIDStr, err = a.NodeID()
+	if err != nil {
+		return
+	}
+	if FileExists(path, RevokedKeysDir, nodeIDStr) {
+		err = ErrAgentKeyRevoked
+		return
+	}
 	agent = &a
 	return
 }
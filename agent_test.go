@@ -1,12 +1,14 @@
 package holochain
 
 import (
+	"bytes"
 	b58 "github.com/jbenet/go-base58"
 	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/smartystreets/goconvey/convey"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -104,3 +106,152 @@ func TestLibP2PAgent(t *testing.T) {
 		So(n1, ShouldNotEqual, n2)
 	})
 }
+
+func TestArchiveAgentKey(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	a, _ := NewAgent(LibP2P, "zippy@someemail.com", MakeTestSeed(""))
+	SaveAgent(d, a)
+	_, nodeIDStr, _ := a.NodeID()
+
+	Convey("it should archive the key out of the agent's place", t, func() {
+		err := archiveAgentKey(d, a)
+		So(err, ShouldBeNil)
+		So(FileExists(d, PrivKeyFileName), ShouldBeFalse)
+		So(FileExists(d, RevokedKeysDir, nodeIDStr), ShouldBeTrue)
+	})
+
+	Convey("an archived key should not be loadable", t, func() {
+		err := SaveAgent(d, a)
+		So(err, ShouldBeNil)
+		_, err = LoadAgent(d)
+		So(err, ShouldEqual, ErrAgentKeyRevoked)
+
+		err = archiveAgentKey(d, a)
+		So(err, ShouldBeNil)
+		a1, _ := NewAgent(LibP2P, "zippy@someemail.com", MakeTestSeed("new key"))
+		err = SaveAgent(d, a1)
+		So(err, ShouldBeNil)
+		a2, err := LoadAgent(d)
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(a1.PrivKey(), a2.PrivKey()), ShouldBeTrue)
+	})
+}
+
+func TestStageAgentKey(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	a, _ := NewAgent(LibP2P, "zippy@someemail.com", MakeTestSeed(""))
+	SaveAgent(d, a)
+	a1, _ := NewAgent(LibP2P, "zippy@someemail.com", MakeTestSeed("new key"))
+
+	Convey("it should stage the new key without touching the agent's key", t, func() {
+		err := stageAgentKey(d, a1)
+		So(err, ShouldBeNil)
+		// staging again replaces what a failed rotation left
+		err = stageAgentKey(d, a1)
+		So(err, ShouldBeNil)
+		So(FileExists(d, StagedPrivKeyFileName), ShouldBeTrue)
+		a2, err := LoadAgent(d)
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(a.PrivKey(), a2.PrivKey()), ShouldBeTrue)
+	})
+
+	Convey("loading should finish a rotation interrupted after archiving the old key", t, func() {
+		err := archiveAgentKey(d, a)
+		So(err, ShouldBeNil)
+		a2, err := LoadAgent(d)
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(a1.PrivKey(), a2.PrivKey()), ShouldBeTrue)
+		So(FileExists(d, StagedPrivKeyFileName), ShouldBeFalse)
+	})
+}
+
+func TestEncryptedAgentKey(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	defer os.Unsetenv(AgentPassphraseEnvar)
+	a, _ := NewAgent(LibP2P, "zippy@someemail.com", MakeTestSeed(""))
+
+	Convey("it should encrypt the key when saving with a passphrase", t, func() {
+		os.Setenv(AgentPassphraseEnvar, "secret")
+		err := SaveAgent(d, a)
+		So(err, ShouldBeNil)
+		data, _ := ReadFile(d, PrivKeyFileName)
+		So(string(data[:len(agentKeyMagic)]), ShouldEqual, agentKeyMagic)
+		k, _ := a.PrivKey().Bytes()
+		So(bytes.Contains(data, k), ShouldBeFalse)
+	})
+
+	Convey("it should decrypt the key when loading with the passphrase", t, func() {
+		a1, err := LoadAgent(d)
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(a.PrivKey(), a1.PrivKey()), ShouldBeTrue)
+	})
+
+	Convey("it should not load the key without the right passphrase", t, func() {
+		os.Setenv(AgentPassphraseEnvar, "wrong")
+		_, err := LoadAgent(d)
+		So(err, ShouldEqual, ErrAgentKeyDecryption)
+		os.Unsetenv(AgentPassphraseEnvar)
+		_, err = LoadAgent(d)
+		So(err, ShouldEqual, ErrAgentPassphraseRequired)
+	})
+
+	Convey("it should reject key files asking for excessive scrypt parameters", t, func() {
+		data, _ := ReadFile(d, PrivKeyFileName)
+		for i, max := range []byte{agentKeyScryptMaxLogN, agentKeyScryptMaxR, agentKeyScryptMaxP} {
+			bad := append([]byte{}, data...)
+			bad[len(agentKeyMagic)+i] = max + 1
+			_, err := openAgentKey(bad, "secret")
+			So(err, ShouldEqual, ErrAgentKeyDecryption)
+		}
+	})
+
+	Convey("it should read the passphrase from a file", t, func() {
+		WriteFile([]byte("secret\n"), d, "passphrase")
+		os.Setenv(AgentPassphraseFileEnvar, filepath.Join(d, "passphrase"))
+		defer os.Unsetenv(AgentPassphraseFileEnvar)
+		a1, err := LoadAgent(d)
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(a.PrivKey(), a1.PrivKey()), ShouldBeTrue)
+	})
+}
+
+func TestEncryptAgentKey(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	defer os.Unsetenv(AgentPassphraseEnvar)
+	a, _ := NewAgent(LibP2P, "zippy@someemail.com", MakeTestSeed(""))
+	SaveAgent(d, a)
+	archiveAgentKey(d, a)
+	a1, _ := NewAgent(LibP2P, "zippy@someemail.com", MakeTestSeed("new key"))
+	SaveAgent(d, a1)
+	_, nodeIDStr, _ := a.NodeID()
+
+	Convey("it should require a passphrase", t, func() {
+		So(EncryptAgentKey(d, ""), ShouldEqual, ErrAgentPassphraseRequired)
+	})
+
+	Convey("it should encrypt the key and the revoked keys", t, func() {
+		err := EncryptAgentKey(d, "secret")
+		So(err, ShouldBeNil)
+		data, _ := ReadFile(d, PrivKeyFileName)
+		So(isSealedAgentKey(data), ShouldBeTrue)
+		data, _ = ReadFile(d, RevokedKeysDir, nodeIDStr)
+		So(isSealedAgentKey(data), ShouldBeTrue)
+		if runtime.GOOS != "windows" {
+			perms, _ := filePerms(d, PrivKeyFileName)
+			So(perms, ShouldEqual, OS_USER_R)
+		}
+
+		os.Setenv(AgentPassphraseEnvar, "secret")
+		a2, err := LoadAgent(d)
+		So(err, ShouldBeNil)
+		So(ic.KeyEqual(a1.PrivKey(), a2.PrivKey()), ShouldBeTrue)
+	})
+
+	Convey("it should not encrypt a key twice", t, func() {
+		So(EncryptAgentKey(d, "secret"), ShouldEqual, ErrAgentKeyEncrypted)
+	})
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the service's registry of named agents that chains can be bound to
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// AgentsDir is the directory in the service directory that holds the named agents
+	AgentsDir string = "agents"
+
+	// ServiceAgentName names the agent whose key is kept in the service directory itself,
+	// which is the default agent until another one is made the default
+	ServiceAgentName string = "service"
+)
+
+var ErrAgentNameInvalid = errors.New("invalid agent name")
+var ErrChainAgentBound = errors.New("chain has already been started by a different agent")
+
+// checkAgentName makes sure an agent name can be used as a directory name
+func checkAgentName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return ErrAgentNameInvalid
+	}
+	return nil
+}
+
+// agentPath returns the directory the named agent is saved in
+func (s *Service) agentPath(name string) string {
+	if name == "" || name == ServiceAgentName {
+		return s.Path
+	}
+	return filepath.Join(s.Path, AgentsDir, name)
+}
+
+// DefaultAgentName returns the name of the agent that newly joined chains are bound to
+func (s *Service) DefaultAgentName() string {
+	if s.Settings.DefaultAgentName == "" {
+		return ServiceAgentName
+	}
+	return s.Settings.DefaultAgentName
+}
+
+// AddAgent saves an agent to the service's registry under the given name
+func (s *Service) AddAgent(name string, agent Agent) (err error) {
+	if err = checkAgentName(name); err != nil {
+		return
+	}
+	if name == ServiceAgentName {
+		err = fmt.Errorf("agent %s already exists", name)
+		return
+	}
+	path := s.agentPath(name)
+	if DirExists(path) {
+		err = fmt.Errorf("agent %s already exists", name)
+		return
+	}
+	if err = os.MkdirAll(path, os.ModePerm); err != nil {
+		return
+	}
+	err = SaveAgent(path, agent)
+	if err != nil {
+		os.RemoveAll(path)
+	}
+	return
+}
+
+// NewAgent generates a new agent with the given identity and adds it to the service's
+// registry under the given name
+func (s *Service) NewAgent(name string, identity AgentIdentity) (agent Agent, err error) {
+	agent, err = NewAgent(LibP2P, identity, nil)
+	if err != nil {
+		return
+	}
+	err = s.AddAgent(name, agent)
+	return
+}
+
+// LoadAgent loads the named agent from the service's registry, an empty name being
+// the service's own agent
+func (s *Service) LoadAgent(name string) (agent Agent, err error) {
+	if name != "" {
+		if err = checkAgentName(name); err != nil {
+			return
+		}
+	}
+	path := s.agentPath(name)
+	if !DirExists(path) {
+		err = fmt.Errorf("no agent named %s", name)
+		return
+	}
+	agent, err = LoadAgent(path)
+	return
+}
+
+// AgentNames returns the names of the agents in the service's registry
+func (s *Service) AgentNames() (names []string, err error) {
+	names = []string{ServiceAgentName}
+	var files []os.FileInfo
+	files, err = ioutil.ReadDir(filepath.Join(s.Path, AgentsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var agents []string
+	for _, f := range files {
+		if f.IsDir() && FileExists(s.Path, AgentsDir, f.Name(), AgentFileName) {
+			agents = append(agents, f.Name())
+		}
+	}
+	sort.Strings(agents)
+	names = append(names, agents...)
+	return
+}
+
+// SetDefaultAgent makes the named agent the one that newly joined chains are bound to.
+// Chains that have already been joined keep their agent.
+func (s *Service) SetDefaultAgent(name string) (err error) {
+	var agent Agent
+	agent, err = s.LoadAgent(name)
+	if err != nil {
+		return
+	}
+	settings := s.Settings
+	settings.DefaultAgentName = name
+	if name == ServiceAgentName {
+		settings.DefaultAgentName = ""
+	}
+	err = writeToml(s.Path, SysFileName, settings, true)
+	if err != nil {
+		return
+	}
+	s.Settings = settings
+	s.DefaultAgent = agent
+	return
+}
+
+// SetChainAgent binds an installed chain to the named agent.  A chain that has already
+// been started can't be bound to an agent with a different key.
+func (s *Service) SetChainAgent(chain string, name string) (err error) {
+	var agent Agent
+	agent, err = s.LoadAgent(name)
+	if err != nil {
+		return
+	}
+	var h *Holochain
+	h, err = s.Load(chain)
+	if err != nil {
+		return
+	}
+	defer h.Close()
+	if h.Started() && !agent.PubKey().Equals(h.agent.PubKey()) {
+		err = ErrChainAgentBound
+		return
+	}
+	h.Config.Agent = name
+	p := filepath.Join(h.rootPath, ConfigFileName+"."+h.encodingFormat)
+	var f *os.File
+	f, err = os.Create(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	err = Encode(f, h.encodingFormat, &h.Config)
+	return
+}
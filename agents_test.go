@@ -0,0 +1,91 @@
+package holochain
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServiceAgents(t *testing.T) {
+	d, s := setupTestService()
+	defer CleanupTestDir(d)
+
+	Convey("the service's own agent should be the default", t, func() {
+		So(s.DefaultAgentName(), ShouldEqual, ServiceAgentName)
+		names, err := s.AgentNames()
+		So(err, ShouldBeNil)
+		So(names, ShouldResemble, []string{ServiceAgentName})
+		a, err := s.LoadAgent(ServiceAgentName)
+		So(err, ShouldBeNil)
+		So(a.Identity(), ShouldEqual, s.DefaultAgent.Identity())
+	})
+
+	Convey("it should add named agents", t, func() {
+		a, err := s.NewAgent("bot", "Ops Bot <bot@example.com>")
+		So(err, ShouldBeNil)
+		_, err = s.NewAgent("alice", "Alice <alice@example.com>")
+		So(err, ShouldBeNil)
+		names, err := s.AgentNames()
+		So(err, ShouldBeNil)
+		So(names, ShouldResemble, []string{ServiceAgentName, "alice", "bot"})
+		a1, err := s.LoadAgent("bot")
+		So(err, ShouldBeNil)
+		So(a1.PubKey().Equals(a.PubKey()), ShouldBeTrue)
+	})
+
+	Convey("it should reject bad and duplicate agent names", t, func() {
+		_, err := s.NewAgent("bot", "Other Bot <bot@example.com>")
+		So(err.Error(), ShouldEqual, "agent bot already exists")
+		_, err = s.NewAgent(ServiceAgentName, "Other <o@example.com>")
+		So(err.Error(), ShouldEqual, "agent service already exists")
+		_, err = s.NewAgent("../bot", "Other Bot <bot@example.com>")
+		So(err, ShouldEqual, ErrAgentNameInvalid)
+		_, err = s.LoadAgent("carol")
+		So(err.Error(), ShouldEqual, "no agent named carol")
+	})
+
+	Convey("it should set the default agent", t, func() {
+		err := s.SetDefaultAgent("bot")
+		So(err, ShouldBeNil)
+		So(s.DefaultAgentName(), ShouldEqual, "bot")
+		So(s.DefaultAgent.Identity(), ShouldEqual, AgentIdentity("Ops Bot <bot@example.com>"))
+		s1, err := LoadService(s.Path)
+		So(err, ShouldBeNil)
+		So(s1.DefaultAgent.Identity(), ShouldEqual, AgentIdentity("Ops Bot <bot@example.com>"))
+
+		err = s.SetDefaultAgent(ServiceAgentName)
+		So(err, ShouldBeNil)
+		So(s.Settings.DefaultAgentName, ShouldEqual, "")
+		So(s.SetDefaultAgent("carol"), ShouldNotBeNil)
+	})
+}
+
+func TestSetChainAgent(t *testing.T) {
+	d, s, h := SetupTestChain("test")
+	defer CleanupTestChain(h, d)
+	bot, err := s.NewAgent("bot", "Ops Bot <bot@example.com>")
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should bind a chain that hasn't been started to an agent", t, func() {
+		err := s.SetChainAgent("test", "bot")
+		So(err, ShouldBeNil)
+		h1, err := s.Load("test")
+		So(err, ShouldBeNil)
+		defer h1.Close()
+		So(h1.Config.Agent, ShouldEqual, "bot")
+		So(h1.agent.PubKey().Equals(bot.PubKey()), ShouldBeTrue)
+	})
+
+	Convey("it should not rebind a started chain to a different agent", t, func() {
+		h1, err := s.Load("test")
+		So(err, ShouldBeNil)
+		h1.Config.DHTPort, _ = getFreePort()
+		_, err = h1.GenChain()
+		So(err, ShouldBeNil)
+		h1.Close()
+		So(s.SetChainAgent("test", ServiceAgentName), ShouldEqual, ErrChainAgentBound)
+		So(s.SetChainAgent("test", "bot"), ShouldBeNil)
+	})
+}
@@ -258,6 +258,10 @@ func (b *benchmark) updateBytesSent(bsc chan BytesSent) {
 	switch bs.MsgType {
 	case GOSSIP_REQUEST:
 		fallthrough
+	case GOSSIP_DIGEST_REQUEST:
+		fallthrough
+	case GOSSIP_FETCH_REQUEST:
+		fallthrough
 	case VALIDATE_PUT_REQUEST:
 		fallthrough
 	case VALIDATE_LINK_REQUEST:
@@ -0,0 +1,363 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements binary entries, and blob entries whose content is split into chunks that are
+// committed and published separately and reassembled on get
+
+package holochain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	. "github.com/holochain/holochain-proto/hash"
+)
+
+const (
+	ChunkEntryType        = SysEntryTypePrefix + "chunk"
+	PrivateChunkEntryType = SysEntryTypePrefix + "privatechunk"
+
+	// DefaultBlobChunkSize is the size blobs are split into chunks of when the DNA
+	// doesn't set one
+	DefaultBlobChunkSize = 256 * 1024
+
+	// BlobFetchConcurrency is how many of a blob's chunks are fetched at the same time
+	BlobFetchConcurrency = 8
+
+	// MaxBlobSize is the largest blob that can be committed or put back together
+	MaxBlobSize = 1024 * 1024 * 1024
+
+	// blobChunkOverhead is room left in chunks for encoding them as entries
+	blobChunkOverhead = 64
+
+	ValidationFailureBadBytesEntry = "bad bytes entry"
+	ValidationFailureBadBlobEntry  = "bad blob manifest"
+)
+
+var ChunkEntryDef = &EntryDef{Name: ChunkEntryType, DataFormat: DataFormatBytes, Sharing: Public}
+
+// PrivateChunkEntryDef is the definition of the chunks of private blobs, which like the
+// blobs are kept out of the DHT and validation packages
+var PrivateChunkEntryDef = &EntryDef{Name: PrivateChunkEntryType, DataFormat: DataFormatBytes, Sharing: Private}
+
+var ErrBlobChunkInvalid = errors.New("blob chunk doesn't match its hash")
+var ErrBlobSizeMismatch = errors.New("blob doesn't match the size in its manifest")
+var ErrBlobPartial = errors.New("blob entries can't be partially shared")
+var ErrBlobTooLarge = errors.New("blob is larger than MaxBlobSize")
+
+// BlobManifest is the content of a blob entry, which lists the hashes of the chunks the
+// blob was split into
+type BlobManifest struct {
+	Size      int64
+	ChunkSize int
+	Chunks    []string
+}
+
+// blobChunkSize returns the configured BlobChunkSize or the default, limited so that
+// chunks fit in the DNA's MaxEntrySize
+func (c *DHTConfig) blobChunkSize() int {
+	size := c.BlobChunkSize
+	if size <= 0 {
+		size = DefaultBlobChunkSize
+	}
+	if max := c.maxEntrySize() - blobChunkOverhead; size > max {
+		size = max
+	}
+	return size
+}
+
+// entryContentString returns an entry's content as the string the ribosomes see, which
+// for binary content is its base64 encoding
+func entryContentString(entry Entry) string {
+	switch c := entry.Content().(type) {
+	case string:
+		return c
+	case []byte:
+		return base64.StdEncoding.EncodeToString(c)
+	}
+	return fmt.Sprintf("%v", entry.Content())
+}
+
+// entryBytes returns the binary content of an entry, which the ribosomes pass base64
+// encoded
+func entryBytes(entry Entry) (data []byte, err error) {
+	switch c := entry.Content().(type) {
+	case []byte:
+		data = c
+	case string:
+		data, err = base64.StdEncoding.DecodeString(c)
+	default:
+		err = fmt.Errorf("expected binary entry content, got %T", c)
+	}
+	return
+}
+
+// prepareBinaryEntry converts the content of bytes and blob entries for committing or
+// hashing.  Bytes entries are committed as raw bytes, and blobs are split into chunks,
+// committed first if commit is set, so the entry committed for the blob is its manifest.
+func (h *Holochain) prepareBinaryEntry(entryType string, entry Entry, commit bool) (e Entry, err error) {
+	e = entry
+	_, def, defErr := h.GetEntryDef(entryType)
+	if defErr != nil || entry == nil {
+		// leave it to the commit to report
+		return
+	}
+	switch def.DataFormat {
+	case DataFormatBytes:
+		var data []byte
+		data, err = entryBytes(entry)
+		if err != nil {
+			return
+		}
+		e = &GobEntry{C: data}
+	case DataFormatBlob:
+		if def.isSharingPartial() {
+			err = ErrBlobPartial
+			return
+		}
+		var data []byte
+		data, err = entryBytes(entry)
+		if err != nil {
+			return
+		}
+		var manifest BlobManifest
+		manifest, err = h.blobManifest(data, commit, def.isSharingPublic())
+		if err != nil {
+			return
+		}
+		var j []byte
+		j, err = json.Marshal(manifest)
+		if err != nil {
+			return
+		}
+		e = &GobEntry{C: string(j)}
+	}
+	return
+}
+
+// blobManifest splits a blob into chunks and returns its manifest, committing the chunks
+// that aren't already on the chain if commit is set.  The chunks of private blobs are
+// committed as private chunks, which aren't published.
+func (h *Holochain) blobManifest(data []byte, commit bool, publish bool) (manifest BlobManifest, err error) {
+	if len(data) > MaxBlobSize {
+		err = ErrBlobTooLarge
+		return
+	}
+	manifest.Size = int64(len(data))
+	manifest.ChunkSize = h.nucleus.dna.DHTConfig.blobChunkSize()
+	manifest.Chunks = make([]string, 0)
+	for start := 0; start < len(data); start += manifest.ChunkSize {
+		end := start + manifest.ChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := &GobEntry{C: data[start:end]}
+		var hash Hash
+		hash, err = chunk.Sum(h.hashSpec)
+		if err != nil {
+			return
+		}
+		if commit {
+			// a chunk that was only committed privately still has to be published
+			if _, t, e := h.chain.GetEntry(hash); e == ErrHashNotFound || (publish && t == PrivateChunkEntryType) {
+				chunkType := ChunkEntryType
+				if !publish {
+					chunkType = PrivateChunkEntryType
+				}
+				a := NewCommitAction(chunkType, chunk)
+				if publish {
+					_, err = h.commitAndShare(a, NullHash())
+				} else {
+					_, err = h.doCommit(a, NullHash())
+				}
+				if err != nil {
+					return
+				}
+			}
+		}
+		manifest.Chunks = append(manifest.Chunks, hash.String())
+	}
+	return
+}
+
+// blobManifestFromJSON unmarshals and checks a blob manifest against the limits of the
+// DNA's DHT config
+func blobManifestFromJSON(j string, config *DHTConfig) (manifest BlobManifest, err error) {
+	err = json.Unmarshal([]byte(j), &manifest)
+	if err != nil {
+		return
+	}
+	if manifest.Size < 0 || manifest.ChunkSize <= 0 {
+		err = errors.New("bad blob size")
+		return
+	}
+	if manifest.Size > MaxBlobSize {
+		err = ErrBlobTooLarge
+		return
+	}
+	if manifest.ChunkSize > config.blobChunkSize() {
+		err = errors.New("blob chunk size larger than the DNA allows")
+		return
+	}
+	chunks := (manifest.Size + int64(manifest.ChunkSize) - 1) / int64(manifest.ChunkSize)
+	if int64(len(manifest.Chunks)) != chunks {
+		err = errors.New("wrong number of blob chunks")
+		return
+	}
+	for _, c := range manifest.Chunks {
+		if _, err = NewHash(c); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// assembleBlob fetches the chunks of a blob in parallel, checks that they match their
+// hashes and the sizes in the manifest, and puts them back together
+func assembleBlob(hashSpec HashSpec, manifest BlobManifest, fetch func(Hash) (Entry, error)) (data []byte, err error) {
+	chunks := make([][]byte, len(manifest.Chunks))
+	errs := make([]error, len(manifest.Chunks))
+	sem := make(chan bool, BlobFetchConcurrency)
+	var wg sync.WaitGroup
+	for i, c := range manifest.Chunks {
+		wg.Add(1)
+		sem <- true
+		go func(i int, c string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			chunks[i], errs[i] = fetchBlobChunk(hashSpec, c, fetch)
+		}(i, c)
+	}
+	wg.Wait()
+	for _, e := range errs {
+		if e != nil {
+			err = e
+			return
+		}
+	}
+	// the size in the manifest isn't trusted until the chunks add up to it
+	for i, chunk := range chunks {
+		want := int64(manifest.ChunkSize)
+		if i == len(chunks)-1 {
+			want = manifest.Size - int64(i)*int64(manifest.ChunkSize)
+		}
+		if int64(len(chunk)) != want {
+			data = nil
+			err = ErrBlobSizeMismatch
+			return
+		}
+		data = append(data, chunk...)
+	}
+	return
+}
+
+// fetchBlobChunk fetches one of a blob's chunks and checks that it matches its hash
+func fetchBlobChunk(hashSpec HashSpec, c string, fetch func(Hash) (Entry, error)) (chunk []byte, err error) {
+	var hash Hash
+	hash, err = NewHash(c)
+	if err != nil {
+		return
+	}
+	var entry Entry
+	entry, err = fetch(hash)
+	if err != nil {
+		return
+	}
+	data, ok := entry.Content().([]byte)
+	if !ok {
+		err = ErrBlobChunkInvalid
+		return
+	}
+	var sum Hash
+	sum, err = entry.Sum(hashSpec)
+	if err != nil {
+		return
+	}
+	if !sum.Equal(hash) {
+		err = ErrBlobChunkInvalid
+		return
+	}
+	chunk = data
+	return
+}
+
+// getChunk fetches a blob chunk from the DHT
+func (h *Holochain) getChunk(hash Hash) (entry Entry, err error) {
+	var r interface{}
+	r, err = callGet(h, GetReq{H: hash, StatusMask: StatusDefault, GetMask: GetMaskEntry}, &GetOptions{StatusMask: StatusDefault, GetMask: GetMaskEntry})
+	if err != nil {
+		return
+	}
+	e := r.(GetResp).Entry
+	entry = &e
+	return
+}
+
+// chainChunkFetcher returns a function that fetches blob chunks from the given chains
+func chainChunkFetcher(chains ...*Chain) func(Hash) (Entry, error) {
+	return func(hash Hash) (entry Entry, err error) {
+		for _, c := range chains {
+			entry, _, err = c.GetEntry(hash)
+			if err != ErrHashNotFound {
+				return
+			}
+		}
+		return
+	}
+}
+
+// getBlob replaces the manifest of a blob in a get response with the blob
+func (h *Holochain) getBlob(resp *GetResp, fetch func(Hash) (Entry, error)) (err error) {
+	if resp.EntryType == "" {
+		return
+	}
+	j, ok := resp.Entry.C.(string)
+	if !ok {
+		return
+	}
+	var def *EntryDef
+	_, def, err = h.GetEntryDef(resp.EntryType)
+	if err != nil || def.DataFormat != DataFormatBlob {
+		err = nil
+		return
+	}
+	var manifest BlobManifest
+	manifest, err = blobManifestFromJSON(j, &h.nucleus.dna.DHTConfig)
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = assembleBlob(h.hashSpec, manifest, fetch)
+	if err != nil {
+		return
+	}
+	resp.Entry = GobEntry{C: data}
+	return
+}
+
+// sysValidateBinaryEntry checks that the content of bytes and blob entries is what they
+// are committed as
+func sysValidateBinaryEntry(config *DHTConfig, def *EntryDef, entry Entry) (err error) {
+	switch def.DataFormat {
+	case DataFormatBytes:
+		if _, ok := entry.Content().([]byte); !ok {
+			err = ValidationFailed(ValidationFailureBadBytesEntry)
+		}
+	case DataFormatBlob:
+		j, ok := entry.Content().(string)
+		if !ok {
+			err = ValidationFailed(ValidationFailureBadBlobEntry)
+			return
+		}
+		if _, e := blobManifestFromJSON(j, config); e != nil {
+			err = ValidationFailed(fmt.Sprintf("%s: %v", ValidationFailureBadBlobEntry, e))
+		}
+	}
+	return
+}
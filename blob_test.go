@@ -0,0 +1,230 @@
+package holochain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBlobManifest(t *testing.T) {
+	hash := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1"
+	config := &DHTConfig{}
+	Convey("it should check the number of chunks against the size", t, func() {
+		_, err := blobManifestFromJSON(`{"Size":20,"ChunkSize":10,"Chunks":["`+hash+`","`+hash+`"]}`, config)
+		So(err, ShouldBeNil)
+		_, err = blobManifestFromJSON(`{"Size":21,"ChunkSize":10,"Chunks":["`+hash+`","`+hash+`"]}`, config)
+		So(err.Error(), ShouldEqual, "wrong number of blob chunks")
+		_, err = blobManifestFromJSON(`{"Size":0,"ChunkSize":10,"Chunks":[]}`, config)
+		So(err, ShouldBeNil)
+		_, err = blobManifestFromJSON(`{"Size":10,"ChunkSize":0,"Chunks":["`+hash+`"]}`, config)
+		So(err.Error(), ShouldEqual, "bad blob size")
+	})
+
+	Convey("it should check the sizes against the limits", t, func() {
+		_, err := blobManifestFromJSON(fmt.Sprintf(`{"Size":%d,"ChunkSize":%d,"Chunks":["%s"]}`, DefaultBlobChunkSize+1, DefaultBlobChunkSize+1, hash), config)
+		So(err.Error(), ShouldEqual, "blob chunk size larger than the DNA allows")
+		_, err = blobManifestFromJSON(fmt.Sprintf(`{"Size":%d,"ChunkSize":%d,"Chunks":[]}`, MaxBlobSize+1, DefaultBlobChunkSize), config)
+		So(err, ShouldEqual, ErrBlobTooLarge)
+	})
+
+	Convey("it should check the chunk hashes", t, func() {
+		_, err := blobManifestFromJSON(`{"Size":10,"ChunkSize":10,"Chunks":["fish"]}`, config)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should limit the chunk size to the max entry size", t, func() {
+		c := DHTConfig{}
+		So(c.blobChunkSize(), ShouldEqual, DefaultBlobChunkSize)
+		c.MaxEntrySize = 1000
+		So(c.blobChunkSize(), ShouldEqual, 1000-blobChunkOverhead)
+	})
+}
+
+func TestAssembleBlob(t *testing.T) {
+	hashSpec, _, _ := chainTestSetup()
+	chunks := make(map[string]Entry)
+	var manifest BlobManifest
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	manifest.Size = int64(len(data))
+	manifest.ChunkSize = 10
+	for start := 0; start < len(data); start += 10 {
+		end := start + 10
+		if end > len(data) {
+			end = len(data)
+		}
+		e := &GobEntry{C: data[start:end]}
+		hash, _ := e.Sum(hashSpec)
+		chunks[hash.String()] = e
+		manifest.Chunks = append(manifest.Chunks, hash.String())
+	}
+	fetch := func(hash Hash) (Entry, error) {
+		e, ok := chunks[hash.String()]
+		if !ok {
+			return nil, ErrHashNotFound
+		}
+		return e, nil
+	}
+
+	Convey("it should put the chunks back together", t, func() {
+		b, err := assembleBlob(hashSpec, manifest, fetch)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, string(data))
+	})
+
+	Convey("it should reject chunks that don't match the sizes in the manifest", t, func() {
+		m := manifest
+		m.Size = 1 << 40
+		_, err := assembleBlob(hashSpec, m, fetch)
+		So(err, ShouldEqual, ErrBlobSizeMismatch)
+		m.Size = manifest.Size
+		m.ChunkSize = 11
+		_, err = assembleBlob(hashSpec, m, fetch)
+		So(err, ShouldEqual, ErrBlobSizeMismatch)
+	})
+
+	Convey("it should reject chunks that don't match their hash", t, func() {
+		chunks[manifest.Chunks[1]] = &GobEntry{C: []byte("something ")}
+		_, err := assembleBlob(hashSpec, manifest, fetch)
+		So(err, ShouldEqual, ErrBlobChunkInvalid)
+	})
+
+	Convey("it should report missing chunks", t, func() {
+		delete(chunks, manifest.Chunks[2])
+		_, err := assembleBlob(hashSpec, manifest, fetch)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestBinaryEntries(t *testing.T) {
+	nodesCount := 3
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h2 := nodes[2]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+	for _, h := range nodes {
+		h.nucleus.dna.DHTConfig.BlobChunkSize = 100
+	}
+
+	data := make([]byte, 250)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	Convey("bytes entries should be committed as raw bytes", t, func() {
+		changeEntryDef(nodes, "review", func(def *EntryDef) { def.DataFormat = DataFormatBytes })
+		defer changeEntryDef(nodes, "review", func(def *EntryDef) { def.DataFormat = DataFormatString })
+		fn := &APIFnCommit{action: *NewCommitAction("review", &GobEntry{C: encoded})}
+		r, err := fn.Call(h0)
+		So(err, ShouldBeNil)
+		hash := r.(Hash)
+		entry, _, err := h0.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(entry.Content().([]byte), data), ShouldBeTrue)
+
+		mh := &APIFnMakeHash{entryType: "review", entry: &GobEntry{C: encoded}}
+		r, err = mh.Call(h0)
+		So(err, ShouldBeNil)
+		So(r.(Hash).String(), ShouldEqual, hash.String())
+	})
+
+	Convey("bytes entries should not take anything but base64", t, func() {
+		changeEntryDef(nodes, "review", func(def *EntryDef) { def.DataFormat = DataFormatBytes })
+		defer changeEntryDef(nodes, "review", func(def *EntryDef) { def.DataFormat = DataFormatString })
+		fn := &APIFnCommit{action: *NewCommitAction("review", &GobEntry{C: "not base64!"})}
+		_, err := fn.Call(h0)
+		So(err, ShouldNotBeNil)
+	})
+
+	changeEntryDef(nodes, "review", func(def *EntryDef) { def.DataFormat = DataFormatBlob })
+	count := len(h0.chain.Headers)
+	var hash Hash
+	Convey("blob entries should be committed as chunks and a manifest", t, func() {
+		fn := &APIFnCommit{action: *NewCommitAction("review", &GobEntry{C: encoded})}
+		r, err := fn.Call(h0)
+		So(err, ShouldBeNil)
+		hash = r.(Hash)
+		So(len(h0.chain.Headers), ShouldEqual, count+4)
+		So(h0.chain.Headers[count].Type, ShouldEqual, ChunkEntryType)
+
+		entry, _, err := h0.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		var manifest BlobManifest
+		err = json.Unmarshal([]byte(entry.Content().(string)), &manifest)
+		So(err, ShouldBeNil)
+		So(manifest.Size, ShouldEqual, 250)
+		So(len(manifest.Chunks), ShouldEqual, 3)
+	})
+
+	Convey("makeHash should hash the manifest without committing the chunks", t, func() {
+		mh := &APIFnMakeHash{entryType: "review", entry: &GobEntry{C: encoded}}
+		r, err := mh.Call(h0)
+		So(err, ShouldBeNil)
+		So(r.(Hash).String(), ShouldEqual, hash.String())
+		So(len(h0.chain.Headers), ShouldEqual, count+4)
+	})
+
+	Convey("committing the same blob again should reuse its chunks", t, func() {
+		fn := &APIFnCommit{action: *NewCommitAction("review", &GobEntry{C: encoded})}
+		_, err := fn.Call(h0)
+		So(err, ShouldBeNil)
+		So(len(h0.chain.Headers), ShouldEqual, count+5)
+	})
+
+	Convey("get should reassemble the blob", t, func() {
+		for _, h := range nodes {
+			processChangeRequestsInTesting(h)
+		}
+		req := GetReq{H: hash, StatusMask: StatusDefault, GetMask: GetMaskEntry | GetMaskEntryType}
+		r, err := callGet(h2, req, &GetOptions{StatusMask: StatusDefault, GetMask: req.GetMask})
+		So(err, ShouldBeNil)
+		So(bytes.Equal(r.(GetResp).Entry.C.([]byte), data), ShouldBeTrue)
+
+		r, err = callGet(h0, req, &GetOptions{GetMask: req.GetMask, Local: true})
+		So(err, ShouldBeNil)
+		So(bytes.Equal(r.(GetResp).Entry.C.([]byte), data), ShouldBeTrue)
+	})
+
+	Convey("the chunks of private blobs should be private and kept out of packages", t, func() {
+		changeEntryDef(nodes, "review", func(def *EntryDef) { def.Sharing = Private })
+		defer changeEntryDef(nodes, "review", func(def *EntryDef) { def.Sharing = Public })
+		secret := make([]byte, 250)
+		for i := range secret {
+			secret[i] = byte(255 - i)
+		}
+		count := len(h0.chain.Headers)
+		fn := &APIFnCommit{action: *NewCommitAction("review", &GobEntry{C: base64.StdEncoding.EncodeToString(secret)})}
+		_, err := fn.Call(h0)
+		So(err, ShouldBeNil)
+		So(len(h0.chain.Headers), ShouldEqual, count+4)
+		for i := count; i < count+3; i++ {
+			So(h0.chain.Headers[i].Type, ShouldEqual, PrivateChunkEntryType)
+		}
+
+		pkg, err := MakePackage(h0, PackagingReq{PkgReqChain: int64(PkgReqChainOptFull)})
+		So(err, ShouldBeNil)
+		So(len(pkg.Chain), ShouldBeGreaterThan, 0)
+		for start := 0; start < len(secret); start += 100 {
+			end := start + 100
+			if end > len(secret) {
+				end = len(secret)
+			}
+			So(bytes.Contains(pkg.Chain, secret[start:end]), ShouldBeFalse)
+		}
+	})
+
+	Convey("blob entries can't be partially shared", t, func() {
+		changeEntryDef(nodes, "review", func(def *EntryDef) { def.Sharing = Partial })
+		defer changeEntryDef(nodes, "review", func(def *EntryDef) { def.Sharing = Public })
+		fn := &APIFnCommit{action: *NewCommitAction("review", &GobEntry{C: encoded})}
+		_, err := fn.Call(h0)
+		So(err, ShouldEqual, ErrBlobPartial)
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements managing the blocklist of peers a node ignores
+
+package holochain
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	DefaultBlockWarrantThreshold = 3
+	DefaultBlockWarrantWindow    = 60 * 60 * 24 // one day
+
+	// BlockWarrantClockSkew is how far in the future a block warrant's time can be, to
+	// allow for the blocker's clock being ahead of ours
+	BlockWarrantClockSkew = time.Minute
+)
+
+var ErrBlockSelf = errors.New("can't block ourselves")
+
+// blockWarrantThreshold returns the configured BlockWarrantThreshold or the default
+func (c *DHTConfig) blockWarrantThreshold() int {
+	if c.BlockWarrantThreshold <= 0 {
+		return DefaultBlockWarrantThreshold
+	}
+	return c.BlockWarrantThreshold
+}
+
+// blockWarrantWindow returns the configured BlockWarrantWindow or the default
+func (c *DHTConfig) blockWarrantWindow() time.Duration {
+	if c.BlockWarrantWindow <= 0 {
+		return DefaultBlockWarrantWindow * time.Second
+	}
+	return time.Duration(c.BlockWarrantWindow) * time.Second
+}
+
+// BlockPeer adds a peer to the node's blocklist, which is kept in the DHT's store so
+// that it survives restarts.  The blocklist is for blocks made by the operator and ones
+// backed by evidence such as fork warrants, peers with a bad reputation are only blocked
+// for a while.  If share is true the block is also sent to the peer's neighbors in a
+// LISTADD_REQUEST along with a BlockWarrant signed by our agent, which they only count
+// towards blocking the peer for a while.
+func (h *Holochain) BlockPeer(id peer.ID, reason string, share bool) (err error) {
+	if id == h.nodeID {
+		err = ErrBlockSelf
+		return
+	}
+	if share {
+		var w *BlockWarrant
+		w, err = NewBlockWarrant(h, []peer.ID{id}, reason)
+		if err != nil {
+			return
+		}
+		var data []byte
+		data, err = w.Encode()
+		if err != nil {
+			return
+		}
+		// the neighbors of the blocked peer are the ones closest to its hash
+		err = h.dht.Change(HashFromPeerID(id), LISTADD_REQUEST,
+			ListAddReq{
+				ListType:    BlockedList,
+				Peers:       []string{peer.IDB58Encode(id)},
+				WarrantType: BlockType,
+				Warrant:     data,
+			})
+		if err != nil {
+			return
+		}
+	}
+	err = h.dht.blockPeer(id, reason)
+	return
+}
+
+// UnblockPeer removes a peer from the node's blocklist and clears its reputation.
+// Blocks that were shared with other nodes aren't undone.
+func (h *Holochain) UnblockPeer(id peer.ID) (err error) {
+	err = h.dht.ht.RemoveFromList(PeerList{Type: BlockedList, Records: []PeerRecord{{ID: id}}})
+	if err != nil {
+		return
+	}
+	h.node.Unblock(id)
+	return
+}
+
+// BlockedPeers returns the node's blocklist
+func (h *Holochain) BlockedPeers() (list PeerList, err error) {
+	list, err = h.dht.getList(BlockedList)
+	return
+}
+
+// blockPeer blocks a peer and records the block in our blocklist without gossiping it
+func (dht *DHT) blockPeer(id peer.ID, reason string) (err error) {
+	err = dht.addToList(nil, PeerList{Type: BlockedList, Records: []PeerRecord{{ID: id, Warrant: reason}}})
+	if err != nil {
+		return
+	}
+	dht.h.node.Block(id)
+	dht.DeleteGossiper(id) // ignore error
+	return
+}
+
+// noteBlockWarrant counts a block warrant from blocker towards blocking the peers it covers,
+// blocking them for ReputationBlockDuration once enough peers have warranted it
+func (dht *DHT) noteBlockWarrant(blocker peer.ID, peers []peer.ID) {
+	node := dht.h.node
+	if node.IsBlocked(blocker) || node.reputation.rank(blocker) < 0 {
+		return
+	}
+	warranted := node.reputation.warrant(blocker, peers, dht.config.blockWarrantWindow(), dht.config.blockWarrantThreshold())
+	for _, id := range warranted {
+		if id == dht.h.nodeID {
+			continue
+		}
+		dht.dlog.Logf("%v warranted blocked by %d peers, blocking for %v", id, dht.config.blockWarrantThreshold(), ReputationBlockDuration)
+		node.BlockFor(id, ReputationBlockDuration)
+		dht.DeleteGossiper(id) // ignore error
+	}
+}
@@ -0,0 +1,68 @@
+package holochain
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBlockPeer(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	pid, _ := makePeer("peer_foo")
+	shared, _ := makePeer("peer_bar")
+
+	Convey("it should not block ourselves", t, func() {
+		So(h.BlockPeer(h.nodeID, "", false), ShouldEqual, ErrBlockSelf)
+	})
+
+	Convey("it should block and record the peer without sharing the block", t, func() {
+		idx, _ := h.dht.GetIdx()
+		err := h.BlockPeer(pid, "spamming", false)
+		So(err, ShouldBeNil)
+		So(h.node.IsBlocked(pid), ShouldBeTrue)
+		list, err := h.BlockedPeers()
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 1)
+		So(list.Records[0].ID, ShouldEqual, pid)
+		So(list.Records[0].Warrant, ShouldEqual, "spamming")
+		newIdx, _ := h.dht.GetIdx()
+		So(newIdx, ShouldEqual, idx)
+	})
+
+	Convey("it should share a block with a warrant", t, func() {
+		h.node.Rate(shared, ReputationTimeout)
+		idx, _ := h.dht.GetIdx()
+		queued := h.dht.changeQueue.Len()
+		err := h.BlockPeer(shared, "spamming", true)
+		So(err, ShouldBeNil)
+		So(h.node.IsBlocked(shared), ShouldBeTrue)
+		newIdx, _ := h.dht.GetIdx()
+		So(newIdx, ShouldEqual, idx+1)
+		So(h.dht.changeQueue.Len(), ShouldEqual, queued+1)
+		list, err := h.BlockedPeers()
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 2)
+	})
+
+	Convey("the blocklist should be loaded when the node is prepared", t, func() {
+		h.node.Unblock(pid)
+		h.node.InitBlockedList(PeerList{})
+		list, err := h.BlockedPeers()
+		So(err, ShouldBeNil)
+		h.node.InitBlockedList(list)
+		So(h.node.IsBlocked(pid), ShouldBeTrue)
+	})
+
+	Convey("it should unblock peers", t, func() {
+		So(h.node.ReputationScore(shared), ShouldBeLessThan, 0)
+		err := h.UnblockPeer(shared)
+		So(err, ShouldBeNil)
+		So(h.node.IsBlocked(shared), ShouldBeFalse)
+		So(h.node.ReputationScore(shared), ShouldEqual, 0)
+		list, err := h.BlockedPeers()
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 1)
+		So(list.Records[0].ID, ShouldEqual, pid)
+	})
+}
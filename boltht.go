@@ -0,0 +1,809 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements a boltdb based instance of HashTable
+// unlike BuntHT which holds all its data in memory, BoltHT pages its data
+// from disk so it's suitable for nodes holding large portions of the DHT
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// names of the buckets used in the bolt store
+var (
+	boltEntryBucket       = []byte("entry")
+	boltTypeBucket        = []byte("type")
+	boltSrcBucket         = []byte("src")
+	boltStatusBucket      = []byte("status")
+	boltReplacedByBucket  = []byte("replacedBy")
+	boltLinkBucket        = []byte("link")
+	boltLinkTagBucket     = []byte("linkTag")
+	boltLinkTimeBucket    = []byte("linkTime")
+	boltIdxBucket         = []byte("idx")
+	boltFingerprintBucket = []byte("f")
+	boltPeerBucket        = []byte("peer")
+	boltListBucket        = []byte("list")
+	boltMetaBucket        = []byte("meta")
+
+	boltBuckets = [][]byte{
+		boltEntryBucket, boltTypeBucket, boltSrcBucket, boltStatusBucket,
+		boltReplacedByBucket, boltLinkBucket, boltLinkTagBucket, boltLinkTimeBucket,
+		boltIdxBucket, boltFingerprintBucket, boltPeerBucket, boltListBucket, boltMetaBucket,
+	}
+
+	boltIdxKey = []byte("_idx")
+)
+
+const (
+	BoltOpenTimeout = time.Second
+)
+
+type BoltHT struct {
+	db *bolt.DB
+}
+
+// NewBoltHT creates and opens a BoltHT stored in the given directory
+func NewBoltHT(dbPath string) (ht HashTable, err error) {
+	ht = &BoltHT{}
+	err = ht.Open(filepath.Join(dbPath, DHTBoltStoreFileName))
+	return
+}
+
+// Open initializes the table
+func (ht *BoltHT) Open(options interface{}) (err error) {
+	file := options.(string)
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: BoltOpenTimeout})
+	if err != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			_, e := tx.CreateBucketIfNotExists(name)
+			if e != nil {
+				return e
+			}
+		}
+		return boltIndexLinks(tx)
+	})
+	if err != nil {
+		db.Close()
+		return
+	}
+	ht.db = db
+	return
+}
+
+// Close cleans up any resources used by the table
+func (ht *BoltHT) Close() {
+	ht.db.Close()
+	ht.db = nil
+}
+
+// boltGet returns a copy of the value at key in the named bucket, or ok false if it doesn't exist
+func boltGet(tx *bolt.Tx, bucket []byte, key string) (val string, ok bool) {
+	v := tx.Bucket(bucket).Get([]byte(key))
+	if v == nil {
+		return
+	}
+	return string(v), true
+}
+
+func boltSet(tx *bolt.Tx, bucket []byte, key string, val string) error {
+	return tx.Bucket(bucket).Put([]byte(key), []byte(val))
+}
+
+// boltIdxKeyFor encodes a change index so that the keys sort numerically
+func boltIdxKeyFor(idx int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(idx))
+	return k
+}
+
+// boltGetIntVal returns an integer value at a given key, and assumes the value 0 if the key doesn't exist
+func boltGetIntVal(tx *bolt.Tx, bucket []byte, key string) (i int, err error) {
+	val, ok := boltGet(tx, bucket, key)
+	if ok {
+		i, err = strconv.Atoi(val)
+	}
+	return
+}
+
+// boltIncIdx adds a new index record to dht for gossiping later
+func boltIncIdx(tx *bolt.Tx, m *Message) (err error) {
+	// if message is nil we can't record this for gossiping
+	// this should only be the case for the DNA
+	if m == nil {
+		return
+	}
+
+	var idx int
+	idx, err = boltGetIntVal(tx, boltMetaBucket, string(boltIdxKey))
+	if err != nil {
+		return
+	}
+	idx++
+	index := fmt.Sprintf("%d", idx)
+	if err = boltSet(tx, boltMetaBucket, string(boltIdxKey), index); err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ByteEncoder(m)
+	if err != nil {
+		return
+	}
+	if err = tx.Bucket(boltIdxBucket).Put(boltIdxKeyFor(idx), b); err != nil {
+		return
+	}
+
+	f, err := m.Fingerprint()
+	if err != nil {
+		return
+	}
+	err = boltSet(tx, boltFingerprintBucket, f.String(), index)
+	return
+}
+
+// Put stores a value to the DHT store
+// N.B. This call assumes that the value has already been validated
+func (ht *BoltHT) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	k := key.String()
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		if err := boltIncIdx(tx, m); err != nil {
+			return err
+		}
+		if err := boltSet(tx, boltEntryBucket, k, string(value)); err != nil {
+			return err
+		}
+		if err := boltSet(tx, boltTypeBucket, k, entryType); err != nil {
+			return err
+		}
+		if err := boltSet(tx, boltSrcBucket, k, peer.IDB58Encode(src)); err != nil {
+			return err
+		}
+		return boltSet(tx, boltStatusBucket, k, fmt.Sprintf("%d", status))
+	})
+	return
+}
+
+func boltSetStatus(tx *bolt.Tx, m *Message, key string, status int) (err error) {
+	if _, ok := boltGet(tx, boltEntryBucket, key); !ok {
+		err = ErrHashNotFound
+		return
+	}
+	if err = boltIncIdx(tx, m); err != nil {
+		return
+	}
+	err = boltSet(tx, boltStatusBucket, key, fmt.Sprintf("%d", status))
+	return
+}
+
+// Del moves the given hash to the StatusDeleted status
+// N.B. this functions assumes that the validity of this action has been confirmed
+func (ht *BoltHT) Del(m *Message, key Hash) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		return boltSetStatus(tx, m, key.String(), StatusDeleted)
+	})
+	return
+}
+
+// Mod moves the given hash to the StatusModified status
+// N.B. this functions assumes that the validity of this action has been confirmed
+func (ht *BoltHT) Mod(m *Message, key Hash, newkey Hash) (err error) {
+	k := key.String()
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		err := boltSetStatus(tx, m, k, StatusModified)
+		if err != nil {
+			return err
+		}
+		link := newkey.String()
+		err = boltLink(tx, k, link, SysTagReplacedBy, m, StatusLive, newkey)
+		if err != nil {
+			return err
+		}
+		return boltSet(tx, boltReplacedByBucket, k, link)
+	})
+	return
+}
+
+// boltGetEntry is the bolt equivalent of _get, returning the value of an entry
+// taking into account the status mask
+func boltGetEntry(tx *bolt.Tx, k string, statusMask int) (val string, err error) {
+	var ok bool
+	val, ok = boltGet(tx, boltEntryBucket, k)
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	statusVal, ok := boltGet(tx, boltStatusBucket, k)
+	if !ok {
+		return
+	}
+	if statusMask == StatusDefault {
+		// if the status mask is not given (i.e. Default) then
+		// we return information about the status if it's other than live
+		switch statusVal {
+		case StatusDeletedVal:
+			err = ErrHashDeleted
+		case StatusModifiedVal:
+			val, ok = boltGet(tx, boltReplacedByBucket, k)
+			if !ok {
+				panic("missing expected replacedBy record")
+			}
+			err = ErrHashModified
+		case StatusRejectedVal:
+			err = ErrHashRejected
+		case StatusLiveVal:
+		default:
+			panic("unknown status!")
+		}
+	} else {
+		// otherwise we return the value only if the status is in the mask
+		var status int
+		status, err = strconv.Atoi(statusVal)
+		if err == nil {
+			if (status & statusMask) == 0 {
+				err = ErrHashNotFound
+			}
+		}
+	}
+	return
+}
+
+// Exists checks for the existence of the hash in the store
+func (ht *BoltHT) Exists(key Hash, statusMask int) (err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		_, err := boltGetEntry(tx, key.String(), statusMask)
+		return err
+	})
+	return
+}
+
+// Source returns the source node address of a given hash
+func (ht *BoltHT) Source(key Hash) (id peer.ID, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) (e error) {
+		val, ok := boltGet(tx, boltSrcBucket, key.String())
+		if !ok {
+			return ErrHashNotFound
+		}
+		id, e = peer.IDB58Decode(val)
+		return
+	})
+	return
+}
+
+// Get retrieves a value from the DHT store
+func (ht *BoltHT) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	if getMask == GetMaskDefault {
+		getMask = GetMaskEntry
+	}
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		k := key.String()
+		val, err := boltGetEntry(tx, k, statusMask)
+		data = []byte(val) // gotta do this because value is valid if ErrHashModified
+		if err != nil {
+			return err
+		}
+
+		if (getMask & GetMaskEntryType) != 0 {
+			entryType, _ = boltGet(tx, boltTypeBucket, k)
+		}
+		if (getMask & GetMaskSources) != 0 {
+			src, ok := boltGet(tx, boltSrcBucket, k)
+			if !ok {
+				return ErrHashNotFound
+			}
+			sources = append(sources, src)
+		}
+
+		val, _ = boltGet(tx, boltStatusBucket, k)
+		status, err = strconv.Atoi(val)
+		return err
+	})
+	return
+}
+
+// boltLink is a low level routine to add a link, also used by delLink
+// this ensure monotonic recording of linking attempts
+func boltLink(tx *bolt.Tx, base string, link string, tag string, m *Message, status int, linkingEntryHash Hash) (err error) {
+	key := base + ":" + link + ":" + tag
+	var records []linkEvent
+	val, ok := boltGet(tx, boltLinkBucket, key)
+	if ok {
+		// load the previous value so we can append to it.
+		json.Unmarshal([]byte(val), &records)
+	} else if status == StatusDeleted {
+		// when deleting the key must exist
+		err = ErrLinkNotFound
+		return
+	}
+	if len(records) > 0 {
+		err = boltUnindexLink(tx, base, link, tag, records[len(records)-1].Time)
+		if err != nil {
+			return
+		}
+	}
+	records = append(records, linkEvent{status, peer.IDB58Encode(m.From), linkingEntryHash.String(), m.Time.UnixNano()})
+	var b []byte
+	b, err = json.Marshal(records)
+	if err != nil {
+		return
+	}
+	err = tx.Bucket(boltLinkBucket).Put([]byte(key), b)
+	if err != nil {
+		return
+	}
+	err = boltIndexLink(tx, base, link, tag, m.Time.UnixNano())
+	return
+}
+
+// boltIndexLink adds a link to the tag and time ordered link indexes
+func boltIndexLink(tx *bolt.Tx, base string, link string, tag string, changed int64) (err error) {
+	k, _ := linkOrderKey(LinkOrderTag, link, tag, changed)
+	err = tx.Bucket(boltLinkTagBucket).Put([]byte(base+":"+k), []byte{})
+	if err != nil {
+		return
+	}
+	k, _ = linkOrderKey(LinkOrderTime, link, tag, changed)
+	err = tx.Bucket(boltLinkTimeBucket).Put([]byte(base+":"+k), []byte{})
+	return
+}
+
+// boltUnindexLink removes a link's entry in the time ordered index for when it last changed
+func boltUnindexLink(tx *bolt.Tx, base string, link string, tag string, changed int64) (err error) {
+	k, _ := linkOrderKey(LinkOrderTime, link, tag, changed)
+	err = tx.Bucket(boltLinkTimeBucket).Delete([]byte(base + ":" + k))
+	return
+}
+
+// boltIndexLinks builds the ordered link indexes for stores made before they were kept
+func boltIndexLinks(tx *bolt.Tx) (err error) {
+	if k, _ := tx.Bucket(boltLinkTagBucket).Cursor().First(); k != nil {
+		return
+	}
+	err = tx.Bucket(boltLinkBucket).ForEach(func(k, v []byte) error {
+		x := strings.SplitN(string(k), ":", 3)
+		if len(x) != 3 {
+			return nil
+		}
+		var records []linkEvent
+		json.Unmarshal(v, &records)
+		if len(records) == 0 {
+			return nil
+		}
+		return boltIndexLink(tx, x[0], x[1], x[2], records[len(records)-1].Time)
+	})
+	return
+}
+
+// boltLinkIndexBucket returns the bucket whose keys are in the given link order
+func boltLinkIndexBucket(order string) []byte {
+	switch order {
+	case LinkOrderTag:
+		return boltLinkTagBucket
+	case LinkOrderTime:
+		return boltLinkTimeBucket
+	}
+	return boltLinkBucket
+}
+
+// boltSeekLinks is the linkIndexSeeker for a bolt transaction
+func boltSeekLinks(tx *bolt.Tx) linkIndexSeeker {
+	return func(order string, base string, from string, descending bool, fn func(key string) bool) error {
+		prefix := []byte(base + ":")
+		c := tx.Bucket(boltLinkIndexBucket(order)).Cursor()
+		var k []byte
+		if !descending {
+			k, _ = c.Seek([]byte(base + ":" + from))
+			if from != "" && k != nil && string(k) == base+":"+from {
+				k, _ = c.Next()
+			}
+			for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				if !fn(string(k[len(prefix):])) {
+					break
+				}
+			}
+			return nil
+		}
+		// seek to the first key after the ones we want and step back from it
+		if from == "" {
+			k, _ = c.Seek([]byte(linkPrefixEnd(base + ":")))
+		} else {
+			k, _ = c.Seek([]byte(base + ":" + from))
+		}
+		if k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Prev() {
+			if !fn(string(k[len(prefix):])) {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+func (ht *BoltHT) link(m *Message, base string, link string, tag string, status int) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		_, err := boltGetEntry(tx, base, StatusLive)
+		if err != nil {
+			return err
+		}
+		err = boltLink(tx, base, link, tag, m, status, m.Body.(HoldReq).EntryHash)
+		if err != nil {
+			return err
+		}
+		return boltIncIdx(tx, m)
+	})
+	return
+}
+
+// PutLink associates a link with a stored hash
+// N.B. this function assumes that the data associated has been properly retrieved
+// and validated from the cource chain
+func (ht *BoltHT) PutLink(m *Message, base string, link string, tag string) (err error) {
+	err = ht.link(m, base, link, tag, StatusLive)
+	return
+}
+
+// DelLink removes a link and tag associated with a stored hash
+// N.B. this function assumes that the action has been properly validated
+func (ht *BoltHT) DelLink(m *Message, base string, link string, tag string) (err error) {
+	err = ht.link(m, base, link, tag, StatusDeleted)
+	return
+}
+
+// boltAscendLinks calls fn for every link record on the given base, in key order
+func boltAscendLinks(tx *bolt.Tx, base string, fn func(link string, tag string, value []byte) bool) {
+	prefix := []byte(base + ":")
+	c := tx.Bucket(boltLinkBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		x := strings.SplitN(string(k[len(prefix):]), ":", 2)
+		if len(x) != 2 {
+			continue
+		}
+		if !fn(x[0], x[1], v) {
+			return
+		}
+	}
+}
+
+// GetLinks retrieves meta value associated with a base
+func (ht *BoltHT) GetLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error) {
+	b := base.String()
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		_, err := boltGetEntry(tx, b, StatusLive+StatusModified) //only get links on live and modified bases
+		if err != nil {
+			return err
+		}
+
+		if statusMask == StatusDefault {
+			statusMask = StatusLive
+		}
+
+		results = make([]TaggedHash, 0)
+		boltAscendLinks(tx, b, func(link string, t string, value []byte) bool {
+			if tag == "" || tag == t {
+				var records []linkEvent
+				json.Unmarshal(value, &records)
+				l := len(records)
+				//TODO: this is totally bogus currently simply
+				// looking at the last item we ever got
+				if l > 0 {
+					entry := records[l-1]
+					if (entry.Status & statusMask) > 0 {
+						th := TaggedHash{H: link, Source: entry.Source, changed: entry.Time}
+						if tag == "" {
+							th.T = t
+						}
+						results = append(results, th)
+					}
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+// QueryLinks returns a page of the links on a base in the query's order
+func (ht *BoltHT) QueryLinks(query *LinkQuery) (results []TaggedHash, cursor string, err error) {
+	b := query.Base.String()
+	err = ht.db.View(func(tx *bolt.Tx) (e error) {
+		_, e = boltGetEntry(tx, b, StatusLive+StatusModified) //only get links on live and modified bases
+		if e != nil {
+			return
+		}
+		results, cursor, e = queryLinkIndex(query, boltSeekLinks(tx), func(base string, link string, tag string) (records []linkEvent, ok bool) {
+			var val string
+			val, ok = boltGet(tx, boltLinkBucket, base+":"+link+":"+tag)
+			if ok {
+				ok = json.Unmarshal([]byte(val), &records) == nil
+			}
+			return
+		})
+		return
+	})
+	return
+}
+
+// GetIdx returns the current index of changes to the HashTable
+func (ht *BoltHT) GetIdx() (idx int, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) (e error) {
+		idx, e = boltGetIntVal(tx, boltMetaBucket, string(boltIdxKey))
+		return
+	})
+	return
+}
+
+// GetIdxMessage returns the messages that causes the change at a given index
+func (ht *BoltHT) GetIdxMessage(idx int) (msg Message, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltIdxBucket).Get(boltIdxKeyFor(idx))
+		if v == nil {
+			return ErrNoSuchIdx
+		}
+		return ByteDecoder(v, &msg)
+	})
+	return
+}
+
+// dumpIdx converts message and data of a DHT change request to a string for human consumption
+func (ht *BoltHT) dumpIdx(idx int) (str string, err error) {
+	return dumpIdx(ht, idx)
+}
+
+// dumpIdxJSON converts message and data of a DHT change request to a JSON string representation.
+func (ht *BoltHT) dumpIdxJSON(idx int) (str string, err error) {
+	return dumpIdxJSON(ht, idx)
+}
+
+// String converts the table into a human readable string
+func (ht *BoltHT) String() (result string) {
+	idx, err := ht.GetIdx()
+	if err != nil {
+		return err.Error()
+	}
+	result += fmt.Sprintf("DHT changes: %d\n", idx)
+	for i := 1; i <= idx; i++ {
+		str, err := ht.dumpIdx(i)
+		if err != nil {
+			result += fmt.Sprintf("%d Error:%v\n", i, err)
+		} else {
+			result += fmt.Sprintf("%d\n%v\n", i, str)
+		}
+	}
+
+	result += fmt.Sprintf("DHT entries:\n")
+	ht.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEntryBucket).ForEach(func(key, value []byte) error {
+			k := string(key)
+			status, ok := boltGet(tx, boltStatusBucket, k)
+			if !ok {
+				status = "<err getting status:not found>"
+			} else {
+				status = statusValueToString(status)
+			}
+			sources, ok := boltGet(tx, boltSrcBucket, k)
+			if !ok {
+				sources = "<err getting sources:not found>"
+			}
+			var links string
+			boltAscendLinks(tx, k, func(link string, tag string, v []byte) bool {
+				links += fmt.Sprintf("Linked to: %s with tag %s\n", link, tag)
+				links += string(v) + "\n"
+				return true
+			})
+			result += fmt.Sprintf("Hash--%s (status %s):\nValue: %s\nSources: %s\n%s\n", k, status, string(value), sources, links)
+			return nil
+		})
+	})
+	return
+}
+
+// JSON converts the table into a JSON string representation.
+func (ht *BoltHT) JSON() (result string, err error) {
+	var buffer, entries bytes.Buffer
+	idx, err := ht.GetIdx()
+	if err != nil {
+		return "", err
+	}
+	buffer.WriteString("{ \"dht_changes\": [")
+	for i := 1; i <= idx; i++ {
+		json, err := ht.dumpIdxJSON(i)
+		if err != nil {
+			return "", fmt.Errorf("DHT Change %d,  Error: %v", i, err)
+		}
+		buffer.WriteString(json)
+		if i < idx {
+			buffer.WriteString(",")
+		}
+	}
+	buffer.WriteString("], \"dht_entries\": [")
+	ht.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEntryBucket).ForEach(func(key, value []byte) error {
+			k := string(key)
+			status, ok := boltGet(tx, boltStatusBucket, k)
+			if !ok {
+				status = "<err getting status:not found>"
+			} else {
+				status = statusValueToString(status)
+			}
+			sources, ok := boltGet(tx, boltSrcBucket, k)
+			if !ok {
+				sources = "<err getting sources:not found>"
+			}
+			var links bytes.Buffer
+			boltAscendLinks(tx, k, func(link string, tag string, v []byte) bool {
+				links.WriteString(fmt.Sprintf("{ \"linkTo\": \"%s\",", link))
+				links.WriteString(fmt.Sprintf("\"tag\": \"%s\",", tag))
+				links.WriteString(fmt.Sprintf("\"value\": \"%s\" },", EscapeJSONValue(string(v))))
+				return true
+			})
+			entries.WriteString(fmt.Sprintf("{ \"hash\": \"%s\",", k))
+			entries.WriteString(fmt.Sprintf("\"status\": \"%s\",", status))
+			entries.WriteString(fmt.Sprintf("\"value\": \"%s\",", EscapeJSONValue(string(value))))
+			entries.WriteString(fmt.Sprintf("\"sources\": \"%s\"", sources))
+			if links.Len() > 0 {
+				entries.WriteString(fmt.Sprintf(",\"links\": [%s]", strings.TrimSuffix(links.String(), ",")))
+			}
+			entries.WriteString("},")
+			return nil
+		})
+	})
+	buffer.WriteString(strings.TrimSuffix(entries.String(), ","))
+	buffer.WriteString("]}")
+	return PrettyPrintJSON(buffer.Bytes())
+}
+
+// Iterate call fn on all the hashes in the table
+func (ht *BoltHT) Iterate(fn HashTableIterateFn) {
+	ht.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltEntryBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			hash, err := NewHash(string(k))
+			if err != nil {
+				return err
+			}
+			if !fn(hash) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
+func (ht *BoltHT) GetFingerprint(f Hash) (index int, err error) {
+	index = -1
+	err = ht.db.View(func(tx *bolt.Tx) (e error) {
+		idxStr, ok := boltGet(tx, boltFingerprintBucket, f.String())
+		if ok {
+			index, e = strconv.Atoi(idxStr)
+		}
+		return
+	})
+	return
+}
+
+// GetGossiper returns the last known index of the gossiper, or 0 if it's not known
+func (ht *BoltHT) GetGossiper(id peer.ID) (idx int, err error) {
+	err = ht.db.View(func(tx *bolt.Tx) (e error) {
+		idx, e = boltGetIntVal(tx, boltPeerBucket, peer.IDB58Encode(id))
+		return
+	})
+	return
+}
+
+// UpdateGossiper sets the last known index of a gossiper, adding it if it didn't exist before
+func (ht *BoltHT) UpdateGossiper(id peer.ID, newIdx int) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		key := peer.IDB58Encode(id)
+		idx, e := boltGetIntVal(tx, boltPeerBucket, key)
+		if e != nil {
+			return e
+		}
+		if newIdx < idx {
+			return nil
+		}
+		return boltSet(tx, boltPeerBucket, key, fmt.Sprintf("%d", newIdx))
+	})
+	return
+}
+
+// DeleteGossiper removes a gossiper from the table
+func (ht *BoltHT) DeleteGossiper(id peer.ID) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		key := peer.IDB58Encode(id)
+		if _, ok := boltGet(tx, boltPeerBucket, key); !ok {
+			return ErrGossiperNotFound
+		}
+		return tx.Bucket(boltPeerBucket).Delete([]byte(key))
+	})
+	return
+}
+
+// GetGossipers returns the ids of all the gossipers in the table
+func (ht *BoltHT) GetGossipers() (glist []peer.ID, err error) {
+	glist = make([]peer.ID, 0)
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltPeerBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			id, err := peer.IDB58Decode(string(k))
+			if err != nil {
+				return err
+			}
+			glist = append(glist, id)
+		}
+		return nil
+	})
+	return
+}
+
+// GetList returns the peer list of the given type
+func (ht *BoltHT) GetList(listType PeerListType) (result PeerList, err error) {
+	result.Type = listType
+	result.Records = make([]PeerRecord, 0)
+	err = ht.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(string(listType) + ":")
+		c := tx.Bucket(boltListBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			pid, err := peer.IDB58Decode(string(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			result.Records = append(result.Records, PeerRecord{ID: pid, Warrant: string(v)})
+		}
+		return nil
+	})
+	return
+}
+
+// AddToList adds the peers to a list
+func (ht *BoltHT) AddToList(m *Message, list PeerList) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		if err := boltIncIdx(tx, m); err != nil {
+			return err
+		}
+		for _, r := range list.Records {
+			k := string(list.Type) + ":" + peer.IDB58Encode(r.ID)
+			if err := boltSet(tx, boltListBucket, k, r.Warrant); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// RemoveFromList removes the peers from a list
+func (ht *BoltHT) RemoveFromList(list PeerList) (err error) {
+	err = ht.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltListBucket)
+		for _, r := range list.Records {
+			k := string(list.Type) + ":" + peer.IDB58Encode(r.ID)
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,118 @@
+package holochain
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltHTOpen(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+
+	Convey("It should initialize the data store", t, func() {
+		f := filepath.Join(d, DHTBoltStoreFileName)
+		So(FileExists(f), ShouldBeFalse)
+		ht := &BoltHT{}
+		err := ht.Open(f)
+		So(err, ShouldBeNil)
+		So(FileExists(f), ShouldBeTrue)
+		ht.Close()
+	})
+
+	Convey("It should persist data across opens", t, func() {
+		ht, err := NewBoltHT(d)
+		So(err, ShouldBeNil)
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		err = ht.Put(nil, "someType", hash, "", []byte("some value"), StatusLive)
+		So(err, ShouldBeNil)
+		ht.Close()
+
+		ht, err = NewBoltHT(d)
+		So(err, ShouldBeNil)
+		defer ht.Close()
+		data, _, _, _, err := ht.Get(hash, StatusLive, GetMaskDefault)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "some value")
+	})
+}
+
+func TestBoltHTLinking(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	var id = node.HashAddr
+
+	ht := &BoltHT{}
+	ht.Open(filepath.Join(d, DHTBoltStoreFileName))
+	defer ht.Close()
+
+	baseStr := "QmZcUPvPhD1Xvk6mwijYF8AfR3mG31S1YsEfHG4khrFPRr"
+	base, err := NewHash(baseStr)
+	if err != nil {
+		panic(err)
+	}
+	linkingEntryHashStr := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3"
+	linkingEntryHash, _ := NewHash(linkingEntryHashStr)
+	linkHash1Str := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1"
+	linkHash1, _ := NewHash(linkHash1Str)
+
+	err = ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: base}), "someType", base, id, []byte("some value"), StatusLive)
+	if err != nil {
+		panic(err)
+	}
+
+	fakeMsg := node.NewMessage(LINK_REQUEST, HoldReq{RelatedHash: linkHash1, EntryHash: linkingEntryHash})
+
+	Convey("Low level should add linking events to bolt", t, func() {
+		err := ht.link(fakeMsg, baseStr, linkHash1Str, "link test", StatusLive)
+		So(err, ShouldBeNil)
+		ht.db.View(func(tx *bolt.Tx) error {
+			v := tx.Bucket(boltLinkBucket).Get([]byte(fmt.Sprintf(`%s:%s:link test`, baseStr, linkHash1Str)))
+			So(string(v), ShouldEqual, fmt.Sprintf(`[{"Status":%d,"Source":"%s","LinksEntry":"%s","Time":%d}]`, StatusLive, id.Pretty(), linkingEntryHashStr, fakeMsg.Time.UnixNano()))
+			return nil
+		})
+
+		err = ht.link(fakeMsg, baseStr, linkHash1Str, "link test", StatusDeleted)
+		So(err, ShouldBeNil)
+		ht.db.View(func(tx *bolt.Tx) error {
+			v := tx.Bucket(boltLinkBucket).Get([]byte(fmt.Sprintf(`%s:%s:link test`, baseStr, linkHash1Str)))
+			So(string(v), ShouldEqual, fmt.Sprintf(`[{"Status":%d,"Source":"%s","LinksEntry":"%s","Time":%d},{"Status":%d,"Source":"%s","LinksEntry":"%s","Time":%d}]`, StatusLive, id.Pretty(), linkingEntryHashStr, fakeMsg.Time.UnixNano(), StatusDeleted, id.Pretty(), linkingEntryHashStr, fakeMsg.Time.UnixNano()))
+			return nil
+		})
+	})
+}
+
+func TestBoltHTPeerDecodeErrors(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	ht := &BoltHT{}
+	if err := ht.Open(filepath.Join(d, DHTBoltStoreFileName)); err != nil {
+		panic(err)
+	}
+	defer ht.Close()
+	ht.db.Update(func(tx *bolt.Tx) error {
+		if err := boltSet(tx, boltPeerBucket, "not a peer", "0"); err != nil {
+			return err
+		}
+		return boltSet(tx, boltListBucket, string(BlockedList)+":not a peer", "")
+	})
+
+	Convey("it should return errors decoding gossipers", t, func() {
+		_, err := ht.GetGossipers()
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should return errors decoding peer lists", t, func() {
+		_, err := ht.GetList(BlockedList)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -12,11 +12,13 @@ import (
 	"errors"
 	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
 	"github.com/tidwall/buntdb"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // BridgeApp describes a data necessary for bridging
@@ -40,7 +42,20 @@ type Bridge struct {
 
 type BridgeSpec map[string]map[string]bool
 
+const (
+	// BridgeAgentHeader, BridgeTimeHeader and BridgeSignatureHeader identify the calling
+	// agent on bridge requests
+	BridgeAgentHeader     = "X-Holochain-Agent"
+	BridgeTimeHeader      = "X-Holochain-Time"
+	BridgeSignatureHeader = "X-Holochain-Signature"
+
+	// BridgeCallMaxAge is how far the time a bridge caller signed a call at may be from
+	// the callee's clock before the call is rejected as stale
+	BridgeCallMaxAge = 5 * time.Minute
+)
+
 var BridgeAppNotFoundErr = errors.New("bridge app not found")
+var ErrBridgeCallStale = errors.New("bridge caller signature is stale")
 
 // AddBridgeAsCallee registers a token for allowing bridged calls from some other app
 // and calls bridgeGenesis in any zomes with bridge functions
@@ -119,8 +134,48 @@ func (h *Holochain) makeBridgeSpec() (spec BridgeSpec) {
 	return
 }
 
-// BridgeCall executes a function exposed through a bridge
-func (h *Holochain) BridgeCall(zomeType string, function string, arguments interface{}, token string) (result interface{}, err error) {
+// bridgeCallData returns the data a bridge caller signs to identify itself to the callee,
+// which includes the time of the call so that signatures can't be replayed later
+func bridgeCallData(token string, zomeType string, function string, timestamp string, arguments string) string {
+	return token + "/" + zomeType + "/" + function + "\n" + timestamp + "\n" + arguments
+}
+
+// VerifyBridgeCaller checks the signature a bridge caller made of the call with its agent
+// key and returns the caller's b58 encoded public key so it can be passed to BridgeCall.
+// The timestamp is the RFC3339 time the caller signed the call at, calls signed more than
+// BridgeCallMaxAge away from now are rejected.
+func (h *Holochain) VerifyBridgeCaller(b58pubKey string, b58signature string, token string, zomeType string, function string, timestamp string, arguments string) (who string, err error) {
+	var t time.Time
+	t, err = time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return
+	}
+	if age := time.Since(t); age > BridgeCallMaxAge || age < -BridgeCallMaxAge {
+		err = ErrBridgeCallStale
+		return
+	}
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(b58pubKey)
+	if err != nil {
+		return
+	}
+	var matches bool
+	matches, err = h.VerifySignature(SignatureFromB58String(b58signature), bridgeCallData(token, zomeType, function, timestamp, arguments), pubKey)
+	if err != nil {
+		return
+	}
+	if !matches {
+		err = errors.New("bridge caller signature doesn't match")
+		return
+	}
+	who = b58pubKey
+	return
+}
+
+// BridgeCall executes a function exposed through a bridge.  who is the b58 encoded public
+// key of the calling agent, or empty if the caller is unknown, and must be one of the agents
+// the token was granted to if it was granted to specific agents.
+func (h *Holochain) BridgeCall(zomeType string, function string, arguments interface{}, token string, who string) (result interface{}, err error) {
 	if h.bridgeDB == nil {
 		err = errors.New("no active bridge")
 		return
@@ -128,7 +183,7 @@ func (h *Holochain) BridgeCall(zomeType string, function string, arguments inter
 	c := Capability{Token: token, db: h.bridgeDB}
 
 	var bridgeSpecStr string
-	bridgeSpecStr, err = c.Validate(nil)
+	bridgeSpecStr, err = c.Validate(who)
 	if err == nil {
 		if bridgeSpecStr != "*" {
 			bridgeSpec := make(BridgeSpec)
@@ -326,6 +381,9 @@ func (h *Holochain) GetBridges() (bridges []Bridge, err error) {
 					_, _, name := getBridgeAppVals(value)
 					bridges = append(bridges, Bridge{CalleeApp: hash, CalleeName: name, Side: BridgeCaller})
 				case "tok":
+					if value == SessionCapability {
+						break
+					}
 					bridges = append(bridges, Bridge{Token: x[1], Side: BridgeCallee})
 				}
 				return true
@@ -335,3 +393,71 @@ func (h *Holochain) GetBridges() (bridges []Bridge, err error) {
 	}
 	return
 }
+
+// GrantCapability registers a token that allows bridged calls to the given zome functions.
+// The token is only valid for the agents with the given b58 encoded public keys, or for
+// anyone if who is empty, and expires after ttl unless ttl is zero.
+func (h *Holochain) GrantCapability(functions BridgeSpec, who []string, ttl time.Duration) (token string, err error) {
+	if len(functions) == 0 {
+		err = errors.New("no functions to grant")
+		return
+	}
+	for zomeName, funcs := range functions {
+		var zome *Zome
+		zome, err = h.GetZome(zomeName)
+		if err != nil {
+			return
+		}
+		for f := range funcs {
+			_, err = zome.GetFunctionDef(f)
+			if err != nil {
+				return
+			}
+		}
+	}
+	for _, k := range who {
+		_, err = DecodePubKey(k)
+		if err != nil {
+			err = fmt.Errorf("bad public key %s: %v", k, err)
+			return
+		}
+	}
+	err = h.initBridgeDB()
+	if err != nil {
+		return
+	}
+	var spec []byte
+	spec, err = json.Marshal(functions)
+	if err != nil {
+		return
+	}
+	var c *Capability
+	c, err = NewExpiringCapability(h.bridgeDB, string(spec), who, ttl)
+	if err != nil {
+		return
+	}
+	token = c.Token
+	return
+}
+
+// ListCapabilities returns the capabilities registered on the holochain
+func (h *Holochain) ListCapabilities() (capabilities []CapabilityInfo, err error) {
+	err = h.initBridgeDB()
+	if err != nil {
+		return
+	}
+	capabilities, err = ListCapabilities(h.bridgeDB)
+	return
+}
+
+// RevokeCapability revokes a token for the agent with the given b58 encoded public key,
+// or for everyone if who is empty
+func (h *Holochain) RevokeCapability(token string, who string) (err error) {
+	err = h.initBridgeDB()
+	if err != nil {
+		return
+	}
+	c := Capability{Token: token, db: h.bridgeDB}
+	err = c.Revoke(who)
+	return
+}
@@ -6,6 +6,7 @@ import (
 	. "github.com/holochain/holochain-proto/hash"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 func TestBridgeCall(t *testing.T) {
@@ -15,7 +16,7 @@ func TestBridgeCall(t *testing.T) {
 	token := "bogus token"
 	var err error
 	Convey("it should fail calls to functions when there's no brided", t, func() {
-		_, err = h.BridgeCall("zySampleZome", "testStrFn1", "arg1 arg2", token)
+		_, err = h.BridgeCall("zySampleZome", "testStrFn1", "arg1 arg2", token, "")
 		So(err.Error(), ShouldEqual, "no active bridge")
 	})
 
@@ -50,19 +51,92 @@ func TestBridgeCall(t *testing.T) {
 
 	Convey("it should call the bridged function", t, func() {
 		var result interface{}
-		result, err = h.BridgeCall("zySampleZome", "testStrFn1", "arg1 arg2", token)
+		result, err = h.BridgeCall("zySampleZome", "testStrFn1", "arg1 arg2", token, "")
 		So(err, ShouldBeNil)
 		So(result.(string), ShouldEqual, "result: arg1 arg2")
 	})
 
 	Convey("it should fail calls to functions not included in the bridge", t, func() {
-		_, err = h.BridgeCall("zySampleZome", "testStrFn2", "arg1 arg2", token)
+		_, err = h.BridgeCall("zySampleZome", "testStrFn2", "arg1 arg2", token, "")
 		So(err, ShouldNotBeNil)
 		So(err.Error(), ShouldEqual, "function not bridged")
 	})
 
 }
 
+func TestBridgeGrantCapability(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	pk, _ := h.agent.EncodePubKey()
+	functions := BridgeSpec{"zySampleZome": {"testStrFn1": true}}
+
+	Convey("it should not grant unknown functions or to bad keys", t, func() {
+		_, err := h.GrantCapability(BridgeSpec{"zySampleZome": {"fooFn": true}}, nil, 0)
+		So(err.Error(), ShouldEqual, "unknown exposed function: fooFn")
+		_, err = h.GrantCapability(functions, []string{"foo"}, 0)
+		So(err, ShouldNotBeNil)
+		_, err = h.GrantCapability(BridgeSpec{}, nil, 0)
+		So(err.Error(), ShouldEqual, "no functions to grant")
+	})
+
+	var token string
+	var err error
+	Convey("it should grant a capability to an agent", t, func() {
+		token, err = h.GrantCapability(functions, []string{pk}, time.Hour)
+		So(err, ShouldBeNil)
+		caps, err := h.ListCapabilities()
+		So(err, ShouldBeNil)
+		So(len(caps), ShouldEqual, 1)
+		So(caps[0].Token, ShouldEqual, token)
+		So(caps[0].Capability, ShouldEqual, `{"zySampleZome":{"testStrFn1":true}}`)
+		So(caps[0].Who, ShouldResemble, []string{pk})
+		So(caps[0].Expires.After(time.Now()), ShouldBeTrue)
+	})
+
+	Convey("it should only allow the granted agent to make bridge calls", t, func() {
+		_, err = h.BridgeCall("zySampleZome", "testStrFn1", "arg1 arg2", token, "")
+		So(err.Error(), ShouldEqual, "bridging error: invalid capability")
+		result, err := h.BridgeCall("zySampleZome", "testStrFn1", "arg1 arg2", token, pk)
+		So(err, ShouldBeNil)
+		So(result.(string), ShouldEqual, "result: arg1 arg2")
+		_, err = h.BridgeCall("zySampleZome", "testStrFn2", "arg1 arg2", token, pk)
+		So(err.Error(), ShouldEqual, "function not bridged")
+	})
+
+	Convey("it should verify the signature of a bridge caller", t, func() {
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		sig, _ := h.Sign([]byte(bridgeCallData(token, "zySampleZome", "testStrFn1", now, "arg1 arg2")))
+		who, err := h.VerifyBridgeCaller(pk, sig.B58String(), token, "zySampleZome", "testStrFn1", now, "arg1 arg2")
+		So(err, ShouldBeNil)
+		So(who, ShouldEqual, pk)
+		_, err = h.VerifyBridgeCaller(pk, sig.B58String(), token, "zySampleZome", "testStrFn1", now, "other args")
+		So(err.Error(), ShouldEqual, "bridge caller signature doesn't match")
+	})
+
+	Convey("it should reject stale bridge caller signatures", t, func() {
+		then := time.Now().Add(-2 * BridgeCallMaxAge).UTC().Format(time.RFC3339Nano)
+		sig, _ := h.Sign([]byte(bridgeCallData(token, "zySampleZome", "testStrFn1", then, "arg1 arg2")))
+		_, err := h.VerifyBridgeCaller(pk, sig.B58String(), token, "zySampleZome", "testStrFn1", then, "arg1 arg2")
+		So(err, ShouldEqual, ErrBridgeCallStale)
+
+		// the timestamp is signed so it can't be refreshed by a replayer
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		_, err = h.VerifyBridgeCaller(pk, sig.B58String(), token, "zySampleZome", "testStrFn1", now, "arg1 arg2")
+		So(err.Error(), ShouldEqual, "bridge caller signature doesn't match")
+	})
+
+	Convey("it should revoke a capability", t, func() {
+		err = h.RevokeCapability(token, "")
+		So(err, ShouldBeNil)
+		_, err = h.BridgeCall("zySampleZome", "testStrFn1", "arg1 arg2", token, pk)
+		So(err.Error(), ShouldEqual, "bridging error: invalid capability")
+		caps, err := h.ListCapabilities()
+		So(err, ShouldBeNil)
+		So(len(caps), ShouldEqual, 0)
+	})
+}
+
 func TestBridgeSpec(t *testing.T) {
 	spec := BridgeSpec{
 		"bridgedZome": {"bridgedFunc": true},
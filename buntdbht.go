@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -29,6 +30,14 @@ type linkEvent struct {
 	Status     int
 	Source     string
 	LinksEntry string
+	Time       int64 `json:",omitempty"` // unix nano time of the message that caused the event
+}
+
+// NewBuntHT creates and opens a BuntHT stored in the given directory
+func NewBuntHT(dbPath string) (ht HashTable, err error) {
+	ht = &BuntHT{}
+	err = ht.Open(filepath.Join(dbPath, DHTStoreFileName))
+	return
 }
 
 func (ht *BuntHT) Open(options interface{}) (err error) {
@@ -42,6 +51,10 @@ func (ht *BuntHT) Open(options interface{}) (err error) {
 	db.CreateIndex("peer", "peer:*", buntdb.IndexString)
 	db.CreateIndex("list", "list:*", buntdb.IndexString)
 	db.CreateIndex("entry", "entry:*", buntdb.IndexString)
+	err = db.Update(buntIndexLinks)
+	if err != nil {
+		return
+	}
 
 	ht.db = db
 	return
@@ -118,7 +131,7 @@ func (ht *BuntHT) Mod(m *Message, key Hash, newkey Hash) (err error) {
 		err = _setStatus(tx, m, k, StatusModified)
 		if err == nil {
 			link := newkey.String()
-			err = _link(tx, k, link, SysTagReplacedBy, m.From, StatusLive, newkey)
+			err = _link(tx, k, link, SysTagReplacedBy, m, StatusLive, newkey)
 			if err == nil {
 				_, _, err = tx.Set("replacedBy:"+k, link, nil)
 				if err != nil {
@@ -246,11 +259,11 @@ func (ht *BuntHT) Get(key Hash, statusMask int, getMask int) (data []byte, entry
 
 // _link is a low level routine to add a link, also used by delLink
 // this ensure monotonic recording of linking attempts
-func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
+func _link(tx *buntdb.Tx, base string, link string, tag string, m *Message, status int, linkingEntryHash Hash) (err error) {
 	key := "link:" + base + ":" + link + ":" + tag
 	var val string
 	val, err = tx.Get(key)
-	source := peer.IDB58Encode(src)
+	source := peer.IDB58Encode(m.From)
 	lehStr := linkingEntryHash.String()
 	var records []linkEvent
 	if err == nil {
@@ -283,7 +296,14 @@ func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, sta
 	} else {
 		return
 	}
-	records = append(records, linkEvent{status, source, lehStr})
+	if len(records) > 0 {
+		k, _ := linkOrderKey(LinkOrderTime, link, tag, records[len(records)-1].Time)
+		_, err = tx.Delete("ltime:" + base + ":" + k)
+		if err != nil && err != buntdb.ErrNotFound {
+			return
+		}
+	}
+	records = append(records, linkEvent{status, source, lehStr, m.Time.UnixNano()})
 	var b []byte
 	b, err = json.Marshal(records)
 	if err != nil {
@@ -293,16 +313,96 @@ func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, sta
 	if err != nil {
 		return
 	}
+	err = buntIndexLink(tx, base, link, tag, m.Time.UnixNano())
 	return
 }
 
+// buntIndexLink adds a link to the tag and time ordered link indexes, whose keys sort in
+// those orders under the "ltag:" and "ltime:" prefixes
+func buntIndexLink(tx *buntdb.Tx, base string, link string, tag string, changed int64) (err error) {
+	k, _ := linkOrderKey(LinkOrderTag, link, tag, changed)
+	_, _, err = tx.Set("ltag:"+base+":"+k, "", nil)
+	if err != nil {
+		return
+	}
+	k, _ = linkOrderKey(LinkOrderTime, link, tag, changed)
+	_, _, err = tx.Set("ltime:"+base+":"+k, "", nil)
+	return
+}
+
+// buntIndexLinks builds the ordered link indexes for stores made before they were kept
+func buntIndexLinks(tx *buntdb.Tx) (err error) {
+	indexed := false
+	err = tx.AscendGreaterOrEqual("", "ltag:", func(key, value string) bool {
+		indexed = strings.HasPrefix(key, "ltag:")
+		return false
+	})
+	if err != nil || indexed {
+		return
+	}
+	type linkKey struct {
+		base, link, tag string
+		changed         int64
+	}
+	var links []linkKey
+	err = tx.Ascend("link", func(key, value string) bool {
+		x := strings.SplitN(key, ":", 4)
+		var records []linkEvent
+		json.Unmarshal([]byte(value), &records)
+		if len(x) == 4 && len(records) > 0 {
+			links = append(links, linkKey{x[1], x[2], x[3], records[len(records)-1].Time})
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	for _, l := range links {
+		err = buntIndexLink(tx, l.base, l.link, l.tag, l.changed)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// buntSeekLinks is the linkIndexSeeker for a buntdb transaction
+func buntSeekLinks(tx *buntdb.Tx) linkIndexSeeker {
+	return func(order string, base string, from string, descending bool, fn func(key string) bool) error {
+		prefix := "link:" + base + ":"
+		switch order {
+		case LinkOrderTag:
+			prefix = "ltag:" + base + ":"
+		case LinkOrderTime:
+			prefix = "ltime:" + base + ":"
+		}
+		pivot := prefix + from
+		iter := func(key, value string) bool {
+			if key == pivot && from != "" {
+				return true
+			}
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			return fn(key[len(prefix):])
+		}
+		if !descending {
+			return tx.AscendGreaterOrEqual("", pivot, iter)
+		}
+		if from == "" {
+			pivot = linkPrefixEnd(prefix)
+		}
+		return tx.DescendLessOrEqual("", pivot, iter)
+	}
+}
+
 func (ht *BuntHT) link(m *Message, base string, link string, tag string, status int) (err error) {
 	err = ht.db.Update(func(tx *buntdb.Tx) error {
 		_, err := _get(tx, base, StatusLive)
 		if err != nil {
 			return err
 		}
-		err = _link(tx, base, link, tag, m.From, status, m.Body.(HoldReq).EntryHash)
+		err = _link(tx, base, link, tag, m, status, m.Body.(HoldReq).EntryHash)
 		if err != nil {
 			return err
 		}
@@ -359,7 +459,7 @@ func (ht *BuntHT) GetLinks(base Hash, tag string, statusMask int) (results []Tag
 				if l > 0 {
 					entry := records[l-1]
 					if err == nil && (entry.Status&statusMask) > 0 {
-						th := TaggedHash{H: string(x[2]), Source: entry.Source}
+						th := TaggedHash{H: string(x[2]), Source: entry.Source, changed: entry.Time}
 						if tag == "" {
 							th.T = t
 						}
@@ -376,6 +476,24 @@ func (ht *BuntHT) GetLinks(base Hash, tag string, statusMask int) (results []Tag
 	return
 }
 
+// QueryLinks returns a page of the links on a base in the query's order
+func (ht *BuntHT) QueryLinks(query *LinkQuery) (results []TaggedHash, cursor string, err error) {
+	b := query.Base.String()
+	err = ht.db.View(func(tx *buntdb.Tx) (e error) {
+		_, e = _get(tx, b, StatusLive+StatusModified) //only get links on live and modified bases
+		if e != nil {
+			return
+		}
+		results, cursor, e = queryLinkIndex(query, buntSeekLinks(tx), func(base string, link string, tag string) (records []linkEvent, ok bool) {
+			val, e := tx.Get("link:" + base + ":" + link + ":" + tag)
+			ok = e == nil && json.Unmarshal([]byte(val), &records) == nil
+			return
+		})
+		return
+	})
+	return
+}
+
 // Close cleans up any resources used by the table
 func (ht *BuntHT) Close() {
 	ht.db.Close()
@@ -480,25 +598,7 @@ func (ht *BuntHT) GetIdxMessage(idx int) (msg Message, err error) {
 
 // DumpIdx converts message and data of a DHT change request to a string for human consumption
 func (ht *BuntHT) dumpIdx(idx int) (str string, err error) {
-	var msg Message
-	msg, err = ht.GetIdxMessage(idx)
-	if err != nil {
-		return
-	}
-	f, _ := msg.Fingerprint()
-	str = fmt.Sprintf("MSG (fingerprint %v):\n   %v\n", f, msg)
-	switch msg.Type {
-	case PUT_REQUEST:
-		key := msg.Body.(HoldReq).EntryHash
-		entry, entryType, _, _, e := ht.Get(key, StatusDefault, GetMaskAll)
-		if e != nil {
-			err = fmt.Errorf("couldn't get %v err:%v ", key, e)
-			return
-		} else {
-			str += fmt.Sprintf("DATA: type:%s entry: %v\n", entryType, entry)
-		}
-	}
-	return
+	return dumpIdx(ht, idx)
 }
 
 func statusValueToString(val string) string {
@@ -563,38 +663,7 @@ func (ht *BuntHT) String() (result string) {
 
 // DumpIdxJSON converts message and data of a DHT change request to a JSON string representation.
 func (ht *BuntHT) dumpIdxJSON(idx int) (str string, err error) {
-	var msg Message
-	var buffer bytes.Buffer
-	var msgField, dataField string
-	msg, err = ht.GetIdxMessage(idx)
-
-	if err != nil {
-		return "", err
-	}
-
-	f, _ := msg.Fingerprint()
-	buffer.WriteString(fmt.Sprintf("{ \"index\": %d,", idx))
-	msgField = fmt.Sprintf("\"message\": { \"fingerprint\": \"%v\", \"content\": \"%v\" },", f, msg)
-
-	switch msg.Type {
-	case PUT_REQUEST:
-		key := msg.Body.(HoldReq).EntryHash
-		entry, entryType, _, _, e := ht.Get(key, StatusAny, GetMaskAll)
-		if e != nil {
-			err = fmt.Errorf("couldn't get %v err:%v ", key, e)
-			return
-		}
-		dataField = fmt.Sprintf("\"data\": { \"type\": \"%s\", \"entry\": \"%v\" }", entryType, entry)
-	}
-
-	if len(dataField) > 0 {
-		buffer.WriteString(msgField)
-		buffer.WriteString(dataField)
-	} else {
-		buffer.WriteString(strings.TrimSuffix(msgField, ","))
-	}
-	buffer.WriteString("}")
-	return PrettyPrintJSON(buffer.Bytes())
+	return dumpIdxJSON(ht, idx)
 }
 
 // JSON converts the table into a JSON string representation.
@@ -677,3 +746,146 @@ func (ht *BuntHT) Iterate(fn HashTableIterateFn) {
 		return err
 	})
 }
+
+// GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
+func (ht *BuntHT) GetFingerprint(f Hash) (index int, err error) {
+	index = -1
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		idxStr, e := tx.Get("f:" + f.String())
+		if e == buntdb.ErrNotFound {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		index, e = strconv.Atoi(idxStr)
+		if e != nil {
+			return e
+		}
+		return nil
+	})
+	return
+}
+
+// GetGossiper returns the last known index of the gossiper, or 0 if it's not known
+func (ht *BuntHT) GetGossiper(id peer.ID) (idx int, err error) {
+	key := "peer:" + peer.IDB58Encode(id)
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		idx, e = getIntVal(key, tx)
+		if e != nil {
+			return e
+		}
+		return nil
+	})
+	return
+}
+
+// UpdateGossiper sets the last known index of a gossiper, adding it if it didn't exist before
+func (ht *BuntHT) UpdateGossiper(id peer.ID, newIdx int) (err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		key := "peer:" + peer.IDB58Encode(id)
+		idx, e := getIntVal(key, tx)
+		if e != nil {
+			return e
+		}
+		if newIdx < idx {
+			return nil
+		}
+		sidx := fmt.Sprintf("%d", newIdx)
+		_, _, err = tx.Set(key, sidx, nil)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// DeleteGossiper removes a gossiper from the table
+func (ht *BuntHT) DeleteGossiper(id peer.ID) (err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		key := "peer:" + peer.IDB58Encode(id)
+		_, e := tx.Delete(key)
+		if e == buntdb.ErrNotFound {
+			e = ErrGossiperNotFound
+		}
+		return e
+	})
+	return
+}
+
+// GetGossipers returns the ids of all the gossipers in the table
+func (ht *BuntHT) GetGossipers() (glist []peer.ID, err error) {
+	glist = make([]peer.ID, 0)
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		err = tx.Ascend("peer", func(key, value string) bool {
+			x := strings.Split(key, ":")
+			id, e := peer.IDB58Decode(x[1])
+			if e != nil {
+				return false
+			}
+			glist = append(glist, id)
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+// GetList returns the peer list of the given type
+func (ht *BuntHT) GetList(listType PeerListType) (result PeerList, err error) {
+	result.Type = listType
+	result.Records = make([]PeerRecord, 0)
+	err = ht.db.View(func(tx *buntdb.Tx) error {
+		err = tx.Ascend("list", func(key, value string) bool {
+			x := strings.Split(key, ":")
+
+			if x[1] == string(listType) {
+				pid, e := peer.IDB58Decode(x[2])
+				if e != nil {
+					return false
+				}
+				r := PeerRecord{ID: pid, Warrant: value}
+				result.Records = append(result.Records, r)
+			}
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+// AddToList adds the peers to a list
+func (ht *BuntHT) AddToList(m *Message, list PeerList) (err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		_, err = incIdx(tx, m)
+		if err != nil {
+			return err
+		}
+		for _, r := range list.Records {
+			k := peer.IDB58Encode(r.ID)
+			_, _, err = tx.Set("list:"+string(list.Type)+":"+k, r.Warrant, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return err
+	})
+	return
+}
+
+// RemoveFromList removes the peers from a list
+func (ht *BuntHT) RemoveFromList(list PeerList) (err error) {
+	err = ht.db.Update(func(tx *buntdb.Tx) error {
+		for _, r := range list.Records {
+			k := peer.IDB58Encode(r.ID)
+			_, err := tx.Delete("list:" + string(list.Type) + ":" + k)
+			if err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -8,62 +8,259 @@
 package holochain
 
 import (
+	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
+	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
 	"github.com/tidwall/buntdb"
-	"math/rand"
+	"strings"
+	"time"
 )
 
+// CapabilityTokenSize is the number of random bytes in a capability token
+const CapabilityTokenSize = 32
+
 type Capability struct {
 	Token string
 	db    *buntdb.DB
-	//Who list of public keys for whom this it valid
+}
+
+// CapabilityInfo describes a registered capability, as returned by ListCapabilities
+type CapabilityInfo struct {
+	Token      string
+	Capability string
+	Who        []string  // b58 encoded public keys of the agents it is valid for, or empty for anyone
+	Expires    time.Time // zero if it never expires
 }
 
 var CapabilityInvalidErr = errors.New("invalid capability")
 
-func makeToken(capability string) (token string) {
-	return fmt.Sprintf("%d", rand.Int63())
+// makeToken returns an unguessable token made from a cryptographic random source
+func makeToken() (token string, err error) {
+	b := make([]byte, CapabilityTokenSize)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	token = b58.Encode(b)
+	return
+}
+
+// capabilityWho converts the various ways of identifying who a capability is for to a list
+// of b58 encoded public keys.  who may be nil, a b58 encoded public key, a list of them,
+// or an ic.PubKey
+func capabilityWho(who interface{}) (keys []string, err error) {
+	switch t := who.(type) {
+	case nil:
+	case string:
+		if t != "" {
+			keys = []string{t}
+		}
+	case []string:
+		keys = t
+	case ic.PubKey:
+		var b []byte
+		b, err = ic.MarshalPublicKey(t)
+		if err != nil {
+			return
+		}
+		keys = []string{b58.Encode(b)}
+	default:
+		err = fmt.Errorf("unknown capability who type: %T", who)
+	}
+	return
+}
+
+func capabilityHasWho(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
 }
 
 // NewCapability returns and registers a capability of a type, for a specific or anyone if who is nil
 func NewCapability(db *buntdb.DB, capability string, who interface{}) (c *Capability, err error) {
+	c, err = NewExpiringCapability(db, capability, who, 0)
+	return
+}
+
+// NewExpiringCapability returns and registers a capability as NewCapability does, but which
+// stops validating after ttl.  A zero ttl never expires.
+func NewExpiringCapability(db *buntdb.DB, capability string, who interface{}, ttl time.Duration) (c *Capability, err error) {
+	var keys []string
+	keys, err = capabilityWho(who)
+	if err != nil {
+		return
+	}
 	c = &Capability{db: db}
-	c.Token = makeToken(capability)
+	c.Token, err = makeToken()
+	if err != nil {
+		return
+	}
+	var opts *buntdb.SetOptions
+	if ttl > 0 {
+		opts = &buntdb.SetOptions{Expires: true, TTL: ttl}
+	}
 	err = db.Update(func(tx *buntdb.Tx) error {
 		Debugf("NewCapability: save token:%s\n", c.Token)
-		_, _, err = tx.Set("tok:"+c.Token, capability, nil)
+		_, _, err = tx.Set("tok:"+c.Token, capability, opts)
 		if err != nil {
 			return err
 		}
+		if len(keys) > 0 {
+			var b []byte
+			b, err = json.Marshal(keys)
+			if err != nil {
+				return err
+			}
+			_, _, err = tx.Set("who:"+c.Token, string(b), opts)
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	return
 }
 
-// Validate checks to see if the token has been registered and returns the capability it represent
+// getWho returns the agents a capability is restricted to
+func (c *Capability) getWho(tx *buntdb.Tx) (keys []string, err error) {
+	var value string
+	value, err = tx.Get("who:" + c.Token)
+	if err == buntdb.ErrNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(value), &keys)
+	return
+}
+
+// Validate checks to see if the token has been registered and returns the capability it represent.
+// If the capability was registered for specific agents, who must be one of them.
 func (c *Capability) Validate(who interface{}) (capability string, err error) {
+	var keys []string
+	keys, err = capabilityWho(who)
+	if err != nil {
+		return
+	}
 	err = c.db.View(func(tx *buntdb.Tx) (e error) {
 		Debugf("Validate: get token:%s\n", c.Token)
 		capability, e = tx.Get("tok:" + c.Token)
 		if e == buntdb.ErrNotFound {
 			e = CapabilityInvalidErr
 		}
+		if e != nil {
+			return
+		}
+		var allowed []string
+		allowed, e = c.getWho(tx)
+		if e != nil || len(allowed) == 0 {
+			return
+		}
+		if len(keys) != 1 || !capabilityHasWho(allowed, keys[0]) {
+			e = CapabilityInvalidErr
+		}
 		return
 	})
+	if err != nil {
+		capability = ""
+	}
 	return
 }
 
-// Revoke unregisters the capability for a peer
+// Revoke unregisters the capability for a peer, or entirely if who is nil.  Revoking the
+// last of the agents a capability was registered for unregisters it entirely.
 func (c *Capability) Revoke(who interface{}) (err error) {
+	var keys []string
+	keys, err = capabilityWho(who)
+	if err != nil {
+		return
+	}
 	err = c.db.Update(func(tx *buntdb.Tx) (e error) {
 		_, e = tx.Get("tok:" + c.Token)
 		if e == buntdb.ErrNotFound {
-			e = CapabilityInvalidErr
-		} else if e == nil {
-			_, e = tx.Delete("tok:" + c.Token)
+			return CapabilityInvalidErr
+		}
+		if e != nil {
+			return
+		}
+		if len(keys) > 0 {
+			var allowed []string
+			allowed, e = c.getWho(tx)
+			if e != nil {
+				return
+			}
+			var remaining []string
+			for _, k := range allowed {
+				if !capabilityHasWho(keys, k) {
+					remaining = append(remaining, k)
+				}
+			}
+			if len(remaining) == len(allowed) {
+				return CapabilityInvalidErr
+			}
+			if len(remaining) > 0 {
+				var b []byte
+				b, e = json.Marshal(remaining)
+				if e != nil {
+					return
+				}
+				var opts *buntdb.SetOptions
+				var ttl time.Duration
+				ttl, e = tx.TTL("who:" + c.Token)
+				if e != nil {
+					return
+				}
+				if ttl > 0 {
+					opts = &buntdb.SetOptions{Expires: true, TTL: ttl}
+				}
+				_, _, e = tx.Set("who:"+c.Token, string(b), opts)
+				return
+			}
+		}
+		_, e = tx.Delete("tok:" + c.Token)
+		if e != nil {
+			return
+		}
+		_, e = tx.Delete("who:" + c.Token)
+		if e == buntdb.ErrNotFound {
+			e = nil
 		}
 		return e
 	})
 	return
 }
+
+// ListCapabilities returns all the currently valid capabilities registered in the db
+func ListCapabilities(db *buntdb.DB) (capabilities []CapabilityInfo, err error) {
+	err = db.View(func(tx *buntdb.Tx) (e error) {
+		now := time.Now()
+		e = tx.AscendKeys("tok:*", func(key, value string) bool {
+			info := CapabilityInfo{Token: strings.TrimPrefix(key, "tok:"), Capability: value}
+			c := Capability{Token: info.Token, db: db}
+			info.Who, e = c.getWho(tx)
+			if e != nil {
+				return false
+			}
+			var ttl time.Duration
+			ttl, e = tx.TTL(key)
+			if e != nil {
+				return false
+			}
+			if ttl > 0 {
+				info.Expires = now.Add(ttl)
+			}
+			capabilities = append(capabilities, info)
+			return true
+		})
+		return
+	})
+	return
+}
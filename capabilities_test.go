@@ -1,10 +1,12 @@
 package holochain
 
 import (
+	b58 "github.com/jbenet/go-base58"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/tidwall/buntdb"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestCapabilitiesGeneral(t *testing.T) {
@@ -50,4 +52,59 @@ func TestCapabilitiesGeneral(t *testing.T) {
 		So(err, ShouldEqual, CapabilityInvalidErr)
 	})
 
+	Convey("it should make unguessable tokens", t, func() {
+		So(len(b58.Decode(c.Token)), ShouldEqual, CapabilityTokenSize)
+	})
+
+	Convey("it should only validate a capability for the agents it was registered for", t, func() {
+		c, err := NewCapability(db, capabilityType, []string{"agent1", "agent2"})
+		So(err, ShouldBeNil)
+		_, err = c.Validate(nil)
+		So(err, ShouldEqual, CapabilityInvalidErr)
+		_, err = c.Validate("agent3")
+		So(err, ShouldEqual, CapabilityInvalidErr)
+		capType, err := c.Validate("agent2")
+		So(err, ShouldBeNil)
+		So(capType, ShouldEqual, capabilityType)
+
+		err = c.Revoke("agent3")
+		So(err, ShouldEqual, CapabilityInvalidErr)
+		err = c.Revoke("agent2")
+		So(err, ShouldBeNil)
+		_, err = c.Validate("agent2")
+		So(err, ShouldEqual, CapabilityInvalidErr)
+		_, err = c.Validate("agent1")
+		So(err, ShouldBeNil)
+		err = c.Revoke("agent1")
+		So(err, ShouldBeNil)
+		_, err = c.Validate("agent1")
+		So(err, ShouldEqual, CapabilityInvalidErr)
+	})
+
+	Convey("it should not validate an expired capability", t, func() {
+		c, err := NewExpiringCapability(db, capabilityType, "agent1", 10*time.Millisecond)
+		So(err, ShouldBeNil)
+		_, err = c.Validate("agent1")
+		So(err, ShouldBeNil)
+		time.Sleep(20 * time.Millisecond)
+		_, err = c.Validate("agent1")
+		So(err, ShouldEqual, CapabilityInvalidErr)
+	})
+
+	Convey("it should list the capabilities", t, func() {
+		c, err := NewExpiringCapability(db, "listed", []string{"agent1"}, time.Hour)
+		So(err, ShouldBeNil)
+		caps, err := ListCapabilities(db)
+		So(err, ShouldBeNil)
+		var found *CapabilityInfo
+		for i := range caps {
+			if caps[i].Token == c.Token {
+				found = &caps[i]
+			}
+		}
+		So(found, ShouldNotBeNil)
+		So(found.Capability, ShouldEqual, "listed")
+		So(found.Who, ShouldResemble, []string{"agent1"})
+		So(found.Expires.After(time.Now()), ShouldBeTrue)
+	})
 }
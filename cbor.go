@@ -0,0 +1,962 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements CBOR (RFC 7049) as a language neutral alternative to gob for encoding
+// messages, entries and chains
+
+package holochain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	// constants for the DHTConfig Encoding
+	EncodingGob  = "gob"
+	EncodingCBOR = "cbor"
+
+	// CBOREncodingVersion is the version of the layout of the items written by CBOREncoder
+	CBOREncodingVersion = 1
+
+	cborMajorUint   = 0
+	cborMajorNegInt = 1
+	cborMajorBytes  = 2
+	cborMajorText   = 3
+	cborMajorArray  = 4
+	cborMajorMap    = 5
+	cborMajorTag    = 6
+	cborMajorSimple = 7
+
+	cborFalse     = 20
+	cborTrue      = 21
+	cborNull      = 22
+	cborUndefined = 23
+	cborFloat16   = 25
+	cborFloat32   = 26
+	cborFloat64   = 27
+
+	cborIndefinite = 31 // additional info for items whose length isn't given up front
+
+	cborTagTime         = 0     // RFC 3339 time string
+	cborTagTypedValue   = 27    // [type name, value] for values of interface fields
+	cborTagSelfDescribe = 55799 // the magic tag every item written by CBOREncoder starts with
+
+	// cborMaxLength limits the lengths read from untrusted data
+	cborMaxLength = 128 * 1024 * 1024
+
+	// cborMaxDepth limits how deeply items read from untrusted data can nest
+	cborMaxDepth = 64
+
+	// cborChunkSize is how much of a long string is allocated at a time, so that what is
+	// allocated grows with the data actually read rather than the length its head claims
+	cborChunkSize = 64 * 1024
+)
+
+var ErrCBORVersion = errors.New("unsupported CBOR encoding version")
+var ErrNotCBOR = errors.New("not CBOR encoded")
+var ErrCBORTooDeep = errors.New("cbor: items nested too deeply")
+var ErrCBORIndefinite = errors.New("cbor: indefinite lengths are not supported")
+var ErrCBORNotMinimal = errors.New("cbor: integer not in its shortest form")
+
+// cborMagic is the self describe tag that starts each item written by CBOREncoder, which
+// can never start a gob stream or a binary chain pair
+var cborMagic = []byte{0xd9, 0xd9, 0xf7}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// cborBytesStrings are the string types that hold binary data, which are encoded as
+// byte strings
+var cborBytesStrings = map[reflect.Type]bool{
+	reflect.TypeOf(Hash("")):    true,
+	reflect.TypeOf(peer.ID("")): true,
+}
+
+var cborTypes = make(map[string]reflect.Type)
+var cborTypeNames = make(map[reflect.Type]string)
+var cborTypesLk sync.RWMutex
+
+var cborFields = make(map[reflect.Type]map[string]int)
+var cborFieldsLk sync.RWMutex
+
+func init() {
+	for name, v := range map[string]interface{}{
+		"int": int(0), "int8": int8(0), "int16": int16(0), "int32": int32(0),
+		"uint": uint(0), "uint8": uint8(0), "uint16": uint16(0), "uint32": uint32(0), "uint64": uint64(0),
+		"float32": float32(0), "string": "", "hash": Hash(""), "peerID": peer.ID(""), "time": time.Time{},
+		"stringList": []string{}, "stringMap": map[string]string{},
+	} {
+		registerCBORType(name, reflect.TypeOf(v))
+	}
+}
+
+// RegisterEncodedType registers a type whose values can be sent in interface fields of
+// messages and entries, both with gob and with CBOR, where they are tagged with name so
+// that implementations in other languages can decode them
+func RegisterEncodedType(name string, value interface{}) {
+	gob.Register(value)
+	registerCBORType(name, reflect.TypeOf(value))
+}
+
+func registerCBORType(name string, t reflect.Type) {
+	cborTypesLk.Lock()
+	defer cborTypesLk.Unlock()
+	if other, ok := cborTypes[name]; ok && other != t {
+		panic(fmt.Sprintf("cbor: registering duplicate types for %s: %v, %v", name, other, t))
+	}
+	cborTypes[name] = t
+	cborTypeNames[t] = name
+}
+
+// checkEncoding returns an error if the encoding isn't a known one
+func checkEncoding(encoding string) (err error) {
+	switch encoding {
+	case "", EncodingGob, EncodingCBOR:
+	default:
+		err = fmt.Errorf("Invalid encoding. Must be one of: %s, %s", EncodingGob, EncodingCBOR)
+	}
+	return
+}
+
+// encodeAs encodes anything with the given encoding, gob being the default
+func encodeAs(encoding string, data interface{}) (b []byte, err error) {
+	if encoding == EncodingCBOR {
+		b, err = CBOREncoder(data)
+	} else {
+		b, err = ByteEncoder(data)
+	}
+	return
+}
+
+// decodeAny decodes data encoded by encodeAs with either encoding
+func decodeAny(b []byte, to interface{}) (err error) {
+	if isCBOR(b) {
+		err = CBORDecoder(b, to)
+	} else {
+		err = ByteDecoder(b, to)
+	}
+	return
+}
+
+// isCBOR returns true if the data was encoded by CBOREncoder
+func isCBOR(b []byte) bool {
+	return bytes.HasPrefix(b, cborMagic)
+}
+
+// hasCBORPrefix returns true if the next item in the reader was encoded by CBOREncoder
+func hasCBORPrefix(r *bufio.Reader) bool {
+	b, _ := r.Peek(len(cborMagic))
+	return isCBOR(b)
+}
+
+// CBOREncoder encodes anything as a self describing CBOR item, which holds an array of
+// the encoding version and the value.  Structs are encoded as maps of their exported
+// fields, and values in interface fields whose types aren't native to CBOR are tagged
+// with the name of their type, which must have been registered with RegisterEncodedType.
+func CBOREncoder(data interface{}) (b []byte, err error) {
+	var e cborEncoder
+	e.buf.Write(cborMagic)
+	e.writeHead(cborMajorArray, 2)
+	e.writeHead(cborMajorUint, CBOREncodingVersion)
+	err = e.encode(reflect.ValueOf(data))
+	if err != nil {
+		return
+	}
+	b = e.buf.Bytes()
+	return
+}
+
+// CBORDecoder decodes data encoded by CBOREncoder
+func CBORDecoder(b []byte, to interface{}) (err error) {
+	err = newCBORDecoder(bytes.NewReader(b)).Decode(to)
+	return
+}
+
+type cborEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *cborEncoder) writeHead(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		e.buf.WriteByte(m | byte(n))
+	case n <= math.MaxUint8:
+		e.buf.Write([]byte{m | 24, byte(n)})
+	case n <= math.MaxUint16:
+		b := []byte{m | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		e.buf.Write(b)
+	case n <= math.MaxUint32:
+		b := []byte{m | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		e.buf.Write(b)
+	default:
+		b := []byte{m | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], n)
+		e.buf.Write(b)
+	}
+}
+
+func (e *cborEncoder) writeString(s string, asBytes bool) {
+	if asBytes || !utf8.ValidString(s) {
+		e.writeHead(cborMajorBytes, uint64(len(s)))
+	} else {
+		e.writeHead(cborMajorText, uint64(len(s)))
+	}
+	e.buf.WriteString(s)
+}
+
+func (e *cborEncoder) writeNull() {
+	e.buf.WriteByte(cborMajorSimple<<5 | cborNull)
+}
+
+func (e *cborEncoder) encode(v reflect.Value) (err error) {
+	if !v.IsValid() {
+		e.writeNull()
+		return
+	}
+	t := v.Type()
+	if t == timeType {
+		tm := v.Interface().(time.Time)
+		e.writeHead(cborMajorTag, cborTagTime)
+		e.writeString(exactTimeString(tm), false)
+		return
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf.WriteByte(cborMajorSimple<<5 | cborTrue)
+		} else {
+			e.buf.WriteByte(cborMajorSimple<<5 | cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if n >= 0 {
+			e.writeHead(cborMajorUint, uint64(n))
+		} else {
+			e.writeHead(cborMajorNegInt, uint64(-1-n))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeHead(cborMajorUint, v.Uint())
+	case reflect.Float32:
+		b := []byte{cborMajorSimple<<5 | cborFloat32, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], math.Float32bits(float32(v.Float())))
+		e.buf.Write(b)
+	case reflect.Float64:
+		b := []byte{cborMajorSimple<<5 | cborFloat64, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], math.Float64bits(v.Float()))
+		e.buf.Write(b)
+	case reflect.String:
+		e.writeString(v.String(), cborBytesStrings[t])
+	case reflect.Slice:
+		if v.IsNil() {
+			e.writeNull()
+			return
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.writeHead(cborMajorBytes, uint64(v.Len()))
+			e.buf.Write(v.Bytes())
+			return
+		}
+		err = e.encodeArray(v)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.writeHead(cborMajorBytes, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				e.buf.WriteByte(byte(v.Index(i).Uint()))
+			}
+			return
+		}
+		err = e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.writeNull()
+			return
+		}
+		err = e.encodeMap(v)
+	case reflect.Struct:
+		err = e.encodeStruct(v)
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.writeNull()
+			return
+		}
+		err = e.encode(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			e.writeNull()
+			return
+		}
+		err = e.encodeInterface(v.Elem())
+	default:
+		err = fmt.Errorf("cbor: can't encode %v", t)
+	}
+	return
+}
+
+func (e *cborEncoder) encodeArray(v reflect.Value) (err error) {
+	e.writeHead(cborMajorArray, uint64(v.Len()))
+	for i := 0; i < v.Len() && err == nil; i++ {
+		err = e.encode(v.Index(i))
+	}
+	return
+}
+
+// encodeMap writes the entries of a map sorted by their encoded keys, so that equal maps
+// always encode the same way
+func (e *cborEncoder) encodeMap(v reflect.Value) (err error) {
+	type item struct{ k, v []byte }
+	items := make([]item, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		var ke, ve cborEncoder
+		if err = ke.encode(key); err != nil {
+			return
+		}
+		if err = ve.encode(v.MapIndex(key)); err != nil {
+			return
+		}
+		items = append(items, item{k: ke.buf.Bytes(), v: ve.buf.Bytes()})
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i].k, items[j].k) < 0 })
+	e.writeHead(cborMajorMap, uint64(len(items)))
+	for _, i := range items {
+		e.buf.Write(i.k)
+		e.buf.Write(i.v)
+	}
+	return
+}
+
+func (e *cborEncoder) encodeStruct(v reflect.Value) (err error) {
+	t := v.Type()
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			fields = append(fields, i)
+		}
+	}
+	e.writeHead(cborMajorMap, uint64(len(fields)))
+	for _, i := range fields {
+		e.writeString(t.Field(i).Name, false)
+		if err = e.encode(v.Field(i)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// encodeInterface writes the value of an interface field.  Values of the types that
+// decode back to themselves without knowing their type are written as is, and the rest
+// are tagged with their registered type name.
+func (e *cborEncoder) encodeInterface(v reflect.Value) (err error) {
+	switch x := v.Interface().(type) {
+	case bool, int64, float64, []byte, map[string]interface{}, []interface{}:
+		err = e.encode(v)
+		return
+	case string:
+		if utf8.ValidString(x) {
+			e.writeString(x, false)
+			return
+		}
+	}
+	cborTypesLk.RLock()
+	name, ok := cborTypeNames[v.Type()]
+	cborTypesLk.RUnlock()
+	if !ok {
+		err = fmt.Errorf("cbor: type not registered: %v", v.Type())
+		return
+	}
+	e.writeHead(cborMajorTag, cborTagTypedValue)
+	e.writeHead(cborMajorArray, 2)
+	e.writeString(name, false)
+	err = e.encode(v)
+	return
+}
+
+type cborReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type cborDecoder struct {
+	r     cborReader
+	depth int
+}
+
+// cborHead is the initial byte of an item with its argument
+type cborHead struct {
+	major byte
+	info  byte
+	n     uint64
+}
+
+func newCBORDecoder(r cborReader) *cborDecoder {
+	return &cborDecoder{r: r}
+}
+
+// Decode reads an item written by CBOREncoder into the value pointed to by to
+func (d *cborDecoder) Decode(to interface{}) (err error) {
+	magic := make([]byte, len(cborMagic))
+	if _, err = io.ReadFull(d.r, magic); err != nil {
+		return
+	}
+	if !isCBOR(magic) {
+		err = ErrNotCBOR
+		return
+	}
+	var hd cborHead
+	if hd, err = d.readHead(); err != nil {
+		return
+	}
+	if hd.major != cborMajorArray || hd.n != 2 {
+		err = ErrNotCBOR
+		return
+	}
+	if hd, err = d.readHead(); err != nil {
+		return
+	}
+	if hd.major != cborMajorUint || hd.n == 0 || hd.n > CBOREncodingVersion {
+		err = ErrCBORVersion
+		return
+	}
+	v := reflect.ValueOf(to)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		err = errors.New("cbor: decode needs a non-nil pointer")
+		return
+	}
+	err = d.decode(v.Elem())
+	return
+}
+
+func (d *cborDecoder) readHead() (hd cborHead, err error) {
+	var b byte
+	if b, err = d.r.ReadByte(); err != nil {
+		return
+	}
+	hd.major = b >> 5
+	hd.info = b & 0x1f
+	var size int
+	switch {
+	case hd.info < 24:
+		hd.n = uint64(hd.info)
+		return
+	case hd.info <= 27:
+		size = 1 << (hd.info - 24)
+	case hd.info == cborIndefinite:
+		err = ErrCBORIndefinite
+		return
+	default:
+		err = fmt.Errorf("cbor: unsupported additional info %d", hd.info)
+		return
+	}
+	buf := make([]byte, 8)
+	if _, err = io.ReadFull(d.r, buf[8-size:]); err != nil {
+		return
+	}
+	hd.n = binary.BigEndian.Uint64(buf)
+	// CBOREncoder always uses the shortest form, so a longer one means the item was
+	// crafted, and accepting it would let the same item be sent as different bytes.
+	// Floats are exempt as their size is their precision.
+	if size == 1 && hd.n < 24 || size > 1 && hd.major != cborMajorSimple && hd.n < 1<<(uint(size)*4) {
+		err = ErrCBORNotMinimal
+	}
+	return
+}
+
+// remaining returns how many bytes are left to read, if the reader knows
+func (d *cborDecoder) remaining() (n uint64, ok bool) {
+	if l, isLen := d.r.(interface{ Len() int }); isLen {
+		n, ok = uint64(l.Len()), true
+	}
+	return
+}
+
+func (d *cborDecoder) readString(hd cborHead) (b []byte, err error) {
+	if hd.n > cborMaxLength {
+		err = errors.New("cbor: string too long")
+		return
+	}
+	if n, ok := d.remaining(); ok && hd.n > n {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if hd.n <= cborChunkSize {
+		b = make([]byte, hd.n)
+		_, err = io.ReadFull(d.r, b)
+		return
+	}
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, d.r, int64(hd.n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	b = buf.Bytes()
+	return
+}
+
+func (hd cborHead) isNull() bool {
+	return hd.major == cborMajorSimple && (hd.info == cborNull || hd.info == cborUndefined)
+}
+
+func (hd cborHead) float() (f float64, ok bool) {
+	if hd.major != cborMajorSimple {
+		return
+	}
+	ok = true
+	switch hd.info {
+	case cborFloat16:
+		f = halfToFloat(uint16(hd.n))
+	case cborFloat32:
+		f = float64(math.Float32frombits(uint32(hd.n)))
+	case cborFloat64:
+		f = math.Float64frombits(hd.n)
+	default:
+		ok = false
+	}
+	return
+}
+
+func halfToFloat(h uint16) (f float64) {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return
+}
+
+// enter is called on reading each item, returning an error if it is nested too deeply,
+// and the function it returns on leaving it
+func (d *cborDecoder) enter() (leave func(), err error) {
+	d.depth++
+	leave = func() { d.depth-- }
+	if d.depth > cborMaxDepth {
+		err = ErrCBORTooDeep
+	}
+	return
+}
+
+func (d *cborDecoder) decode(v reflect.Value) (err error) {
+	leave, err := d.enter()
+	defer leave()
+	if err != nil {
+		return
+	}
+	var hd cborHead
+	if hd, err = d.readHead(); err != nil {
+		return
+	}
+	err = d.decodeValue(v, hd)
+	return
+}
+
+func (d *cborDecoder) decodeValue(v reflect.Value, hd cborHead) (err error) {
+	t := v.Type()
+	if hd.isNull() {
+		v.Set(reflect.Zero(t))
+		return
+	}
+	if hd.major == cborMajorTag {
+		switch hd.n {
+		case cborTagTypedValue:
+			err = d.decodeTyped(v)
+			return
+		case cborTagTime:
+			if t == timeType || t.Kind() == reflect.Interface {
+				var tm time.Time
+				if tm, err = d.readTime(); err == nil {
+					err = setValue(v, reflect.ValueOf(tm))
+				}
+				return
+			}
+		}
+		// other tags are just decoded as the item they tag
+		err = d.decode(v)
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		err = d.decodeValue(v.Elem(), hd)
+		return
+	case reflect.Interface:
+		var x interface{}
+		if x, err = d.decodeGeneric(hd); err == nil {
+			err = setValue(v, reflect.ValueOf(x))
+		}
+		return
+	}
+	if t == timeType {
+		if hd.major != cborMajorText {
+			err = cborMismatch(hd, t)
+			return
+		}
+		var b []byte
+		if b, err = d.readString(hd); err != nil {
+			return
+		}
+		var tm time.Time
+		if tm, err = time.Parse(time.RFC3339Nano, string(b)); err == nil {
+			v.Set(reflect.ValueOf(tm))
+		}
+		return
+	}
+
+	mismatch := cborMismatch(hd, t)
+	switch hd.major {
+	case cborMajorUint, cborMajorNegInt:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if hd.n > math.MaxInt64 {
+				return errors.New("cbor: integer overflow")
+			}
+			n := int64(hd.n)
+			if hd.major == cborMajorNegInt {
+				n = -1 - n
+			}
+			if v.OverflowInt(n) {
+				return errors.New("cbor: integer overflow")
+			}
+			v.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if hd.major == cborMajorNegInt || v.OverflowUint(hd.n) {
+				return errors.New("cbor: integer overflow")
+			}
+			v.SetUint(hd.n)
+		case reflect.Float32, reflect.Float64:
+			f := float64(hd.n)
+			if hd.major == cborMajorNegInt {
+				f = -1 - f
+			}
+			v.SetFloat(f)
+		default:
+			err = mismatch
+		}
+	case cborMajorBytes, cborMajorText:
+		var b []byte
+		if b, err = d.readString(hd); err != nil {
+			return
+		}
+		switch {
+		case t.Kind() == reflect.String:
+			v.SetString(string(b))
+		case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+			v.SetBytes(b)
+		case t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8:
+			reflect.Copy(v, reflect.ValueOf(b))
+		default:
+			err = mismatch
+		}
+	case cborMajorArray:
+		err = d.decodeArray(v, hd)
+	case cborMajorMap:
+		switch t.Kind() {
+		case reflect.Map:
+			err = d.decodeMap(v, hd)
+		case reflect.Struct:
+			err = d.decodeStruct(v, hd)
+		default:
+			err = mismatch
+		}
+	case cborMajorSimple:
+		if f, ok := hd.float(); ok && (t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64) {
+			v.SetFloat(f)
+		} else if (hd.info == cborTrue || hd.info == cborFalse) && t.Kind() == reflect.Bool {
+			v.SetBool(hd.info == cborTrue)
+		} else {
+			err = mismatch
+		}
+	}
+	return
+}
+
+func cborMismatch(hd cborHead, t reflect.Type) error {
+	return fmt.Errorf("cbor: can't decode major type %d into %v", hd.major, t)
+}
+
+// setValue sets v to x, which must be assignable to v's type
+func setValue(v reflect.Value, x reflect.Value) (err error) {
+	if !x.Type().AssignableTo(v.Type()) {
+		err = fmt.Errorf("cbor: can't assign %v to %v", x.Type(), v.Type())
+		return
+	}
+	v.Set(x)
+	return
+}
+
+func (d *cborDecoder) readTime() (tm time.Time, err error) {
+	var hd cborHead
+	if hd, err = d.readHead(); err != nil {
+		return
+	}
+	if hd.major != cborMajorText {
+		err = errors.New("cbor: bad time")
+		return
+	}
+	var b []byte
+	if b, err = d.readString(hd); err != nil {
+		return
+	}
+	tm, err = time.Parse(time.RFC3339Nano, string(b))
+	return
+}
+
+// checkCount returns an error if an array or map has more items than could be read,
+// each item taking at least a byte
+func (d *cborDecoder) checkCount(hd cborHead) (err error) {
+	if hd.n > cborMaxLength {
+		err = errors.New("cbor: too many items")
+	} else if n, ok := d.remaining(); ok && hd.n > n {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func (d *cborDecoder) decodeArray(v reflect.Value, hd cborHead) (err error) {
+	if err = d.checkCount(hd); err != nil {
+		return
+	}
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(t, 0, 0)
+		for i := uint64(0); i < hd.n; i++ {
+			e := reflect.New(t.Elem()).Elem()
+			if err = d.decode(e); err != nil {
+				return
+			}
+			s = reflect.Append(s, e)
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := uint64(0); i < hd.n; i++ {
+			if i < uint64(v.Len()) {
+				err = d.decode(v.Index(int(i)))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return
+			}
+		}
+	default:
+		err = cborMismatch(hd, t)
+	}
+	return
+}
+
+func (d *cborDecoder) decodeMap(v reflect.Value, hd cborHead) (err error) {
+	if err = d.checkCount(hd); err != nil {
+		return
+	}
+	t := v.Type()
+	m := reflect.MakeMap(t)
+	for i := uint64(0); i < hd.n; i++ {
+		key := reflect.New(t.Key()).Elem()
+		if err = d.decode(key); err != nil {
+			return
+		}
+		val := reflect.New(t.Elem()).Elem()
+		if err = d.decode(val); err != nil {
+			return
+		}
+		m.SetMapIndex(key, val)
+	}
+	v.Set(m)
+	return
+}
+
+// structFields returns the indexes of the exported fields of a struct type by name
+func structFields(t reflect.Type) map[string]int {
+	cborFieldsLk.RLock()
+	fields, ok := cborFields[t]
+	cborFieldsLk.RUnlock()
+	if ok {
+		return fields
+	}
+	fields = make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			fields[t.Field(i).Name] = i
+		}
+	}
+	cborFieldsLk.Lock()
+	cborFields[t] = fields
+	cborFieldsLk.Unlock()
+	return fields
+}
+
+// decodeStruct decodes a map into the fields of a struct, skipping entries for fields it
+// doesn't have so that structs can gain and lose fields between versions
+func (d *cborDecoder) decodeStruct(v reflect.Value, hd cborHead) (err error) {
+	if err = d.checkCount(hd); err != nil {
+		return
+	}
+	fields := structFields(v.Type())
+	for i := uint64(0); i < hd.n; i++ {
+		var name string
+		if err = d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+			return
+		}
+		if f, ok := fields[name]; ok {
+			err = d.decode(v.Field(f))
+		} else {
+			err = d.skip()
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// decodeTyped decodes a value tagged with its type name
+func (d *cborDecoder) decodeTyped(v reflect.Value) (err error) {
+	var hd cborHead
+	if hd, err = d.readHead(); err != nil {
+		return
+	}
+	if hd.major != cborMajorArray || hd.n != 2 {
+		err = errors.New("cbor: bad typed value")
+		return
+	}
+	var name string
+	if err = d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+		return
+	}
+	if v.Kind() != reflect.Interface {
+		err = d.decode(v)
+		return
+	}
+	cborTypesLk.RLock()
+	t, ok := cborTypes[name]
+	cborTypesLk.RUnlock()
+	if !ok {
+		err = fmt.Errorf("cbor: type not registered: %s", name)
+		return
+	}
+	x := reflect.New(t).Elem()
+	if err = d.decode(x); err != nil {
+		return
+	}
+	err = setValue(v, x)
+	return
+}
+
+// decodeGeneric decodes an item without a type to decode it into
+func (d *cborDecoder) decodeGeneric(hd cborHead) (x interface{}, err error) {
+	switch hd.major {
+	case cborMajorUint:
+		if hd.n > math.MaxInt64 {
+			x = hd.n
+		} else {
+			x = int64(hd.n)
+		}
+	case cborMajorNegInt:
+		if hd.n > math.MaxInt64 {
+			err = errors.New("cbor: integer overflow")
+			return
+		}
+		x = -1 - int64(hd.n)
+	case cborMajorBytes:
+		x, err = d.readString(hd)
+	case cborMajorText:
+		var b []byte
+		b, err = d.readString(hd)
+		x = string(b)
+	case cborMajorArray:
+		var a []interface{}
+		err = d.decodeArray(reflect.ValueOf(&a).Elem(), hd)
+		x = a
+	case cborMajorMap:
+		var m map[string]interface{}
+		err = d.decodeMap(reflect.ValueOf(&m).Elem(), hd)
+		x = m
+	case cborMajorTag:
+		var i interface{}
+		err = d.decodeValue(reflect.ValueOf(&i).Elem(), hd)
+		x = i
+	case cborMajorSimple:
+		if f, ok := hd.float(); ok {
+			x = f
+			return
+		}
+		switch hd.info {
+		case cborTrue:
+			x = true
+		case cborFalse:
+			x = false
+		case cborNull, cborUndefined:
+		default:
+			err = fmt.Errorf("cbor: unsupported simple value %d", hd.info)
+		}
+	}
+	return
+}
+
+// skip reads past an item
+func (d *cborDecoder) skip() (err error) {
+	leave, err := d.enter()
+	defer leave()
+	if err != nil {
+		return
+	}
+	var hd cborHead
+	if hd, err = d.readHead(); err != nil {
+		return
+	}
+	switch hd.major {
+	case cborMajorBytes, cborMajorText:
+		if hd.n > cborMaxLength {
+			return errors.New("cbor: string too long")
+		}
+		_, err = io.CopyN(ioutil.Discard, d.r, int64(hd.n))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	case cborMajorArray, cborMajorMap:
+		if err = d.checkCount(hd); err != nil {
+			return
+		}
+		n := hd.n
+		if hd.major == cborMajorMap {
+			n *= 2
+		}
+		for i := uint64(0); i < n && err == nil; i++ {
+			err = d.skip()
+		}
+	case cborMajorTag:
+		err = d.skip()
+	}
+	return
+}
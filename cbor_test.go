@@ -0,0 +1,299 @@
+package holochain
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCBORCoding(t *testing.T) {
+	Convey("it should round trip basic values", t, func() {
+		for _, v := range []interface{}{true, int64(-1000), uint64(1 << 40), 3.25, "fish", []byte{0, 1, 2}} {
+			b, err := CBOREncoder(&v)
+			So(err, ShouldBeNil)
+			So(isCBOR(b), ShouldBeTrue)
+			var x interface{}
+			err = CBORDecoder(b, &x)
+			So(err, ShouldBeNil)
+			So(x, ShouldResemble, v)
+		}
+	})
+
+	Convey("it should tag the types of interface values", t, func() {
+		req := GetReq{H: NullHash(), StatusMask: StatusLive, GetMask: GetMaskEntry}
+		var v interface{} = []interface{}{req, 7, map[string]interface{}{"a": "b"}}
+		b, err := CBOREncoder(&v)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(b, []byte("GetReq")), ShouldBeTrue)
+		var x interface{}
+		err = CBORDecoder(b, &x)
+		So(err, ShouldBeNil)
+		So(x, ShouldResemble, v)
+	})
+
+	Convey("it should fail on interface values of unregistered types", t, func() {
+		type unregistered struct{ A int }
+		var v interface{} = unregistered{A: 1}
+		_, err := CBOREncoder(&v)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should skip fields the decoding struct doesn't have", t, func() {
+		type v1 struct {
+			A string
+			C int
+		}
+		type v2 struct {
+			A string
+			B []string
+			C int
+		}
+		b, err := CBOREncoder(v2{A: "a", B: []string{"x", "y"}, C: 3})
+		So(err, ShouldBeNil)
+		var x v1
+		err = CBORDecoder(b, &x)
+		So(err, ShouldBeNil)
+		So(x, ShouldResemble, v1{A: "a", C: 3})
+	})
+
+	Convey("it should reject items nested too deeply", t, func() {
+		nested := func(prefix ...byte) []byte {
+			b := append([]byte{}, cborMagic...)
+			b = append(b, cborMajorArray<<5|2, CBOREncodingVersion)
+			b = append(b, prefix...)
+			for i := 0; i < cborMaxDepth+1; i++ {
+				b = append(b, cborMajorArray<<5|1)
+			}
+			return append(b, 0)
+		}
+		var x interface{}
+		So(CBORDecoder(nested(), &x), ShouldEqual, ErrCBORTooDeep)
+
+		// including in fields that are skipped
+		var y struct{ A int }
+		So(CBORDecoder(nested(cborMajorMap<<5|1, cborMajorText<<5|1, 'B'), &y), ShouldEqual, ErrCBORTooDeep)
+	})
+
+	Convey("it should reject lengths longer than the data", t, func() {
+		b, _ := CBOREncoder("fish")
+		b = append(b[:len(b)-5], cborMajorText<<5|26, 0x00, 0xff, 0xff, 0xff, 'f')
+		var x interface{}
+		So(CBORDecoder(b, &x), ShouldEqual, io.ErrUnexpectedEOF)
+
+		b, _ = CBOREncoder([]int{1})
+		b = append(b[:len(b)-2], cborMajorArray<<5|26, 0x00, 0xff, 0xff, 0xff, 1)
+		So(CBORDecoder(b, &x), ShouldEqual, io.ErrUnexpectedEOF)
+
+		var s string
+		r := bufio.NewReader(bytes.NewReader(append(b[:len(b)-6], cborMajorText<<5|26, 0x00, 0x10, 0x00, 0x00, 'f')))
+		So(newCBORDecoder(r).Decode(&s), ShouldEqual, io.ErrUnexpectedEOF)
+	})
+
+	Convey("it should reject indefinite lengths", t, func() {
+		for _, major := range []byte{cborMajorBytes, cborMajorText, cborMajorArray, cborMajorMap} {
+			b, _ := CBOREncoder("fish")
+			b = append(b[:len(b)-5], major<<5|cborIndefinite, cborMajorSimple<<5|cborIndefinite)
+			var x interface{}
+			So(CBORDecoder(b, &x), ShouldEqual, ErrCBORIndefinite)
+		}
+	})
+
+	Convey("it should reject integers not in their shortest form", t, func() {
+		b, _ := CBOREncoder(uint64(5))
+		short := b[:len(b)-1]
+		for _, overlong := range [][]byte{
+			{cborMajorUint<<5 | 24, 5},
+			{cborMajorUint<<5 | 25, 0, 0xff},
+			{cborMajorNegInt<<5 | 26, 0, 0, 0xff, 0xff},
+			{cborMajorText<<5 | 27, 0, 0, 0, 0, 0, 0, 0, 1, 'f'},
+		} {
+			var x interface{}
+			So(CBORDecoder(append(append([]byte{}, short...), overlong...), &x), ShouldEqual, ErrCBORNotMinimal)
+		}
+		var x interface{}
+		So(CBORDecoder(append(append([]byte{}, short...), cborMajorUint<<5|24, 24), &x), ShouldBeNil)
+		So(x, ShouldEqual, int64(24))
+
+		// floats keep their size
+		f, _ := CBOREncoder(3.25)
+		So(CBORDecoder(f, &x), ShouldBeNil)
+	})
+
+	Convey("it should reject later versions", t, func() {
+		b, _ := CBOREncoder("fish")
+		b[len(cborMagic)+1] = CBOREncodingVersion + 1
+		var x interface{}
+		So(CBORDecoder(b, &x), ShouldEqual, ErrCBORVersion)
+	})
+
+	Convey("it should check the encoding", t, func() {
+		So(checkEncoding(""), ShouldBeNil)
+		So(checkEncoding(EncodingGob), ShouldBeNil)
+		So(checkEncoding(EncodingCBOR), ShouldBeNil)
+		So(checkEncoding("xml").Error(), ShouldEqual, "Invalid encoding. Must be one of: gob, cbor")
+	})
+}
+
+func TestCBORHeader(t *testing.T) {
+	hashSpec, key, now := chainTestSetup()
+	e := GobEntry{C: "some data"}
+	_, hd, err := newHeader(hashSpec, now, "entryTypeFoo", &e, key, NullHash(), NullHash(), NullHash())
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("headers should keep their hashes and signatures when sent as CBOR", t, func() {
+		tm := time.Now().Round(0)
+		for _, hd.Time = range []time.Time{now, tm, tm.UTC(), tm.In(time.FixedZone("", 3600))} {
+			b, err := CBOREncoder(hd)
+			So(err, ShouldBeNil)
+			var hd1 Header
+			err = CBORDecoder(b, &hd1)
+			So(err, ShouldBeNil)
+			m, _ := hd.Marshal()
+			m1, _ := hd1.Marshal()
+			So(bytes.Equal(m, m1), ShouldBeTrue)
+			matches, err := hd1.Verify(key.GetPublic())
+			So(err, ShouldBeNil)
+			So(matches, ShouldBeTrue)
+		}
+	})
+}
+
+func TestCBORMessages(t *testing.T) {
+	node, err := makeNode(1234, "node1")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	body := GetResp{EntryType: "foo"}
+	body.Entry = GobEntry{C: "3"}
+	m := node.NewMessage(OK_RESPONSE, body)
+
+	Convey("it should decode messages in either encoding", t, func() {
+		for _, encoding := range []string{EncodingGob, EncodingCBOR} {
+			d, err := m.EncodeAs(encoding)
+			So(err, ShouldBeNil)
+			So(isCBOR(d), ShouldEqual, encoding == EncodingCBOR)
+			var m2 Message
+			err = m2.DecodeAs(encoding, bytes.NewReader(d))
+			So(err, ShouldBeNil)
+			So(fmt.Sprintf("%v", &m2), ShouldEqual, fmt.Sprintf("%v", m))
+		}
+	})
+
+	Convey("it should only decode CBOR messages when asked to", t, func() {
+		d, err := m.EncodeAs(EncodingCBOR)
+		So(err, ShouldBeNil)
+		var m2 Message
+		So(m2.Decode(bytes.NewReader(d)), ShouldNotBeNil)
+		So(m2.DecodeAs(EncodingGob, bytes.NewReader(d)), ShouldNotBeNil)
+
+		d, err = m.EncodeAs(EncodingGob)
+		So(err, ShouldBeNil)
+		So(m2.DecodeAs(EncodingCBOR, bytes.NewReader(d)), ShouldEqual, ErrNotCBOR)
+	})
+}
+
+func TestCBOREntries(t *testing.T) {
+	hashSpec, _, _ := chainTestSetup()
+	e := GobEntry{C: "some data"}
+	hash, _ := e.Sum(hashSpec)
+
+	Convey("entries should unmarshal from either encoding", t, func() {
+		for _, encoding := range []string{EncodingGob, EncodingCBOR} {
+			b, err := marshalEntryAs(&e, encoding)
+			So(err, ShouldBeNil)
+			var e1 GobEntry
+			err = e1.Unmarshal(b)
+			So(err, ShouldBeNil)
+			So(e1.C, ShouldEqual, "some data")
+			h, _ := e1.Sum(hashSpec)
+			So(h.String(), ShouldEqual, hash.String())
+		}
+	})
+}
+
+func TestCBORChain(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+	path := filepath.Join(d, "chain.dat")
+
+	chain, err := NewChainFromFile(hashSpec, path)
+	if err != nil {
+		panic(err)
+	}
+	e := GobEntry{C: "fake DNA"}
+	chain.AddEntry(now, DNAEntryType, &e, key)
+	e = GobEntry{C: []byte{1, 2, 3}}
+	chain.AddEntry(now, "entryTypeFoo1", &e, key)
+	chain.encoding = EncodingCBOR
+	e = GobEntry{C: "some data"}
+	chain.AddEntry(now, "entryTypeFoo2", &e, key)
+	dump := chain.String()
+	chain.Close()
+
+	Convey("it should reload a chain file holding pairs in both encodings", t, func() {
+		chain, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(chain.String(), ShouldEqual, dump)
+		So(chain.Validate(false, key.GetPublic()), ShouldBeNil)
+		chain.Close()
+	})
+
+	Convey("it should marshal and unmarshal chains as CBOR", t, func() {
+		chain.encoding = EncodingCBOR
+		for _, flags := range []int64{ChainMarshalFlagsNone, ChainMarshalFlagsNoEntries, ChainMarshalFlagsNoHeaders} {
+			var b bytes.Buffer
+			err := chain.MarshalChain(&b, flags, nil, nil)
+			So(err, ShouldBeNil)
+			So(isCBOR(b.Bytes()), ShouldBeTrue)
+			f, c1, err := UnmarshalChain(hashSpec, &b)
+			So(err, ShouldBeNil)
+			So(f, ShouldEqual, flags)
+			if flags == ChainMarshalFlagsNone {
+				So(c1.String(), ShouldEqual, dump)
+				So(c1.Validate(false, key.GetPublic()), ShouldBeNil)
+			}
+			if flags == ChainMarshalFlagsNoEntries {
+				So(len(c1.Entries), ShouldEqual, 0)
+				So(len(c1.Headers), ShouldEqual, 3)
+			}
+			if flags == ChainMarshalFlagsNoHeaders {
+				So(len(c1.Headers), ShouldEqual, 0)
+				So(len(c1.Entries), ShouldEqual, 3)
+			}
+		}
+	})
+}
+
+func FuzzCBORDecode(f *testing.F) {
+	req := GetReq{H: NullHash(), StatusMask: StatusLive, GetMask: GetMaskEntry}
+	var v interface{} = []interface{}{req, 7, "fish", []byte{0, 1}, map[string]interface{}{"a": 3.25}}
+	for _, seed := range []interface{}{&v, req, "fish"} {
+		b, err := CBOREncoder(seed)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add(append(append([]byte{}, cborMagic...), cborMajorArray<<5|2, CBOREncodingVersion, cborMajorArray<<5|cborIndefinite))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// decoding untrusted data must fail cleanly rather than panic or run away
+		var x interface{}
+		CBORDecoder(b, &x)
+		var m Message
+		m.DecodeAs(EncodingCBOR, bytes.NewReader(b))
+		var hd Header
+		CBORDecoder(b, &hd)
+	})
+}
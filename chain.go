@@ -7,6 +7,7 @@
 package holochain
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
@@ -59,7 +60,10 @@ type Chain struct {
 
 	//---
 
-	s        *os.File // if this stream is not nil, new entries will get marshaled to it
+	s        *os.File      // if this stream is not nil, new entries will get marshaled to it
+	cipher   *storeCipher  // if not nil, entries marshaled to the stream are encrypted with it
+	encoding string        // encoding pairs are marshaled in, the binary format if not cbor
+	indexes  *chainIndexes // if not nil, secondary indexes on entry fields maintained as entries are added
 	hashSpec HashSpec
 	lk       sync.RWMutex
 	bundle   *Bundle // non-nil when this chain has a bundle in progress
@@ -84,6 +88,14 @@ func NewChain(hashSpec HashSpec) (chain *Chain) {
 // NewChainFromFile creates a chain from a file, loading any data there,
 // and setting it to be persisted to. If no file exists it will be created.
 func NewChainFromFile(spec HashSpec, path string) (c *Chain, err error) {
+	c, err = newChainFromFile(spec, path, nil)
+	return
+}
+
+// newChainFromFile creates a chain from a file as NewChainFromFile does, but if the
+// cipher is not nil the file is encrypted with it.  An unencrypted file is converted to
+// an encrypted one when loaded with a cipher.
+func newChainFromFile(spec HashSpec, path string, cipher *storeCipher) (c *Chain, err error) {
 	defer func() {
 		if err != nil {
 			Debugf("error loading chain :%s", err.Error())
@@ -97,11 +109,27 @@ func NewChainFromFile(spec HashSpec, path string) (c *Chain, err error) {
 		if err != nil {
 			return
 		}
+		var encrypted bool
+		encrypted, err = isEncryptedChainFile(f)
+		if err != nil {
+			f.Close()
+			return
+		}
+		if encrypted && cipher == nil {
+			f.Close()
+			err = ErrStoreEncrypted
+			return
+		}
+		r := bufio.NewReader(f)
 		var i int
 		for {
 			var header *Header
 			var e Entry
-			header, e, err = readPair(ChainMarshalFlagsNone, f)
+			if encrypted {
+				header, e, err = cipher.readSealedPair(r)
+			} else {
+				header, e, err = readPair(ChainMarshalFlagsNone, r)
+			}
 			if err != nil && err.Error() == "EOF" {
 				err = nil
 				break
@@ -137,6 +165,13 @@ func NewChainFromFile(spec HashSpec, path string) (c *Chain, err error) {
 			*/
 		}
 
+		if cipher != nil && !encrypted {
+			err = cipher.rewriteChainFile(c, path)
+			if err != nil {
+				return
+			}
+		}
+
 		f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
 		if err != nil {
 			return
@@ -146,8 +181,35 @@ func NewChainFromFile(spec HashSpec, path string) (c *Chain, err error) {
 		if err != nil {
 			return
 		}
+		if cipher != nil {
+			_, err = f.Write([]byte(storeChainMagic))
+			if err != nil {
+				f.Close()
+				return
+			}
+		}
 	}
 	c.s = f
+	c.cipher = cipher
+	return
+}
+
+// isEncryptedChainFile checks for the encrypted chain file marker, leaving the file positioned
+// at the first pair
+func isEncryptedChainFile(f *os.File) (encrypted bool, err error) {
+	magic := make([]byte, len(storeChainMagic))
+	var n int
+	n, err = io.ReadFull(f, magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	encrypted = n == len(magic) && string(magic) == storeChainMagic
+	if !encrypted {
+		_, err = f.Seek(0, io.SeekStart)
+	}
 	return
 }
 
@@ -273,8 +335,17 @@ func (c *Chain) addEntry(entryIdx int, hash Hash, header *Header, e Entry) (err
 	c.Emap[header.EntryLink] = entryIdx
 	c.Hmap[hash] = entryIdx
 
+	if c.indexes != nil {
+		c.indexes.add(header, &g, entryIdx)
+		c.indexes.Length = entryIdx + 1
+	}
+
 	if c.s != nil {
-		err = writePair(c.s, header, &g)
+		if c.cipher != nil {
+			err = c.cipher.writeSealedPair(c.s, header, &g, c.encoding)
+		} else {
+			err = writeEncodedPair(c.s, header, &g, c.encoding)
+		}
 	}
 
 	return
@@ -333,7 +404,63 @@ func writePair(writer io.Writer, header *Header, entry Entry) (err error) {
 	return
 }
 
-func readPair(flags int64, reader io.Reader) (header *Header, entry Entry, err error) {
+// chainPairCBOR is the layout of a header/entry pair in the CBOR encoding, where a
+// missing header or entry is null
+type chainPairCBOR struct {
+	Header *Header
+	Entry  *GobEntry
+}
+
+// chainCBOR is the layout of a chain marshaled in the CBOR encoding
+type chainCBOR struct {
+	Flags int64
+	Pairs []chainPairCBOR
+	Top   Hash
+}
+
+func newChainPairCBOR(header *Header, entry Entry) (p chainPairCBOR) {
+	p.Header = header
+	if entry != nil {
+		g, ok := entry.(*GobEntry)
+		if !ok {
+			g = &GobEntry{C: entry.Content()}
+		}
+		p.Entry = g
+	}
+	return
+}
+
+// writeEncodedPair writes a header/entry pair in the given encoding, the binary format
+// written by writePair being the default
+func writeEncodedPair(writer io.Writer, header *Header, entry Entry, encoding string) (err error) {
+	if encoding != EncodingCBOR {
+		err = writePair(writer, header, entry)
+		return
+	}
+	var b []byte
+	b, err = CBOREncoder(newChainPairCBOR(header, entry))
+	if err != nil {
+		return
+	}
+	_, err = writer.Write(b)
+	return
+}
+
+// readPair reads a header/entry pair in either the binary format or CBOR, so chain files
+// can hold pairs of both
+func readPair(flags int64, reader *bufio.Reader) (header *Header, entry Entry, err error) {
+	if hasCBORPrefix(reader) {
+		var p chainPairCBOR
+		err = newCBORDecoder(reader).Decode(&p)
+		if err != nil {
+			return
+		}
+		header = p.Header
+		if p.Entry != nil {
+			entry = p.Entry
+		}
+		return
+	}
 	if (flags & ChainMarshalFlagsNoHeaders) == 0 {
 		var hd Header
 		err = UnmarshalHeader(reader, &hd, 34)
@@ -384,11 +511,6 @@ func (c *Chain) MarshalChain(writer io.Writer, flags int64, whitelistTypes []str
 		return
 	}
 
-	err = binary.Write(writer, binary.LittleEndian, flags)
-	if err != nil {
-		return err
-	}
-
 	var pairsToWrite []ChainPair
 	var lastHeaderToWrite int
 
@@ -421,6 +543,20 @@ func (c *Chain) MarshalChain(writer io.Writer, flags int64, whitelistTypes []str
 		}
 	}
 
+	if c.encoding == EncodingCBOR {
+		var top Hash
+		if (flags & ChainMarshalFlagsNoHeaders) == 0 {
+			top = c.Hashes[lastHeaderToWrite]
+		}
+		err = marshalChainCBOR(writer, flags, pairsToWrite, top)
+		return
+	}
+
+	err = binary.Write(writer, binary.LittleEndian, flags)
+	if err != nil {
+		return err
+	}
+
 	err = binary.Write(writer, binary.LittleEndian, int64(len(pairsToWrite)))
 	if err != nil {
 		return err
@@ -440,6 +576,21 @@ func (c *Chain) MarshalChain(writer io.Writer, flags int64, whitelistTypes []str
 	return
 }
 
+// marshalChainCBOR writes out the pairs of a chain being marshaled as a single CBOR item
+func marshalChainCBOR(writer io.Writer, flags int64, pairs []ChainPair, top Hash) (err error) {
+	m := chainCBOR{Flags: flags, Pairs: make([]chainPairCBOR, len(pairs)), Top: top}
+	for i, pair := range pairs {
+		m.Pairs[i] = newChainPairCBOR(pair.Header, pair.Entry)
+	}
+	var b []byte
+	b, err = CBOREncoder(&m)
+	if err != nil {
+		return
+	}
+	_, err = writer.Write(b)
+	return
+}
+
 // addPair adds header and entry pairs to the chain during unmarshaling
 // This call assumes that Hashes array is one element behind the Headers and Entries
 // because for each pair (except the 0th) it adds the hash of the previous entry
@@ -468,19 +619,24 @@ func UnmarshalChain(hashSpec HashSpec, reader io.Reader) (flags int64, c *Chain,
 		}
 	}()
 	c = NewChain(hashSpec)
-	err = binary.Read(reader, binary.LittleEndian, &flags)
+	r := bufio.NewReader(reader)
+	if hasCBORPrefix(r) {
+		flags, err = c.unmarshalChainCBOR(r)
+		return
+	}
+	err = binary.Read(r, binary.LittleEndian, &flags)
 	if err != nil {
 		return
 	}
 	var l, i int64
-	err = binary.Read(reader, binary.LittleEndian, &l)
+	err = binary.Read(r, binary.LittleEndian, &l)
 	if err != nil {
 		return
 	}
 	for i = 0; i < l; i++ {
 		var header *Header
 		var e Entry
-		header, e, err = readPair(flags, reader)
+		header, e, err = readPair(flags, r)
 		if err != nil {
 			return
 		}
@@ -490,7 +646,7 @@ func UnmarshalChain(hashSpec HashSpec, reader io.Reader) (flags int64, c *Chain,
 	if (flags & ChainMarshalFlagsNoHeaders) == 0 {
 		// decode final hash
 		var h Hash
-		h, err = UnmarshalHash(reader)
+		h, err = UnmarshalHash(r)
 		if err != nil {
 			return
 		}
@@ -500,6 +656,28 @@ func UnmarshalChain(hashSpec HashSpec, reader io.Reader) (flags int64, c *Chain,
 	return
 }
 
+// unmarshalChainCBOR reads the pairs of a chain written by marshalChainCBOR
+func (c *Chain) unmarshalChainCBOR(reader *bufio.Reader) (flags int64, err error) {
+	var m chainCBOR
+	err = newCBORDecoder(reader).Decode(&m)
+	if err != nil {
+		return
+	}
+	flags = m.Flags
+	for i, pair := range m.Pairs {
+		var e Entry
+		if pair.Entry != nil {
+			e = pair.Entry
+		}
+		c.addPair(pair.Header, e, i)
+	}
+	if (flags & ChainMarshalFlagsNoHeaders) == 0 {
+		c.Hashes = append(c.Hashes, m.Top)
+		c.Hmap[m.Top] = len(m.Pairs) - 1
+	}
+	return
+}
+
 // Walk traverses chain from most recent to first entry calling fn on each one
 func (c *Chain) Walk(fn WalkerFn) (err error) {
 	l := len(c.Headers)
@@ -512,12 +690,19 @@ func (c *Chain) Walk(fn WalkerFn) (err error) {
 	return
 }
 
-// Validate traverses chain confirming the hashes
+// Validate traverses chain confirming the hashes and the header signatures.
+// Headers are checked against the key of the agent entry in effect at that point of
+// the chain, or against pubKey if the chain doesn't include its agent entries.  If
+// it does, pubKey (when given) must be the key of the chain's latest agent entry.
 // @TODO confirm that TypeLinks are also correct
-// @TODO confirm signatures
-func (c *Chain) Validate(skipEntries bool) (err error) {
+func (c *Chain) Validate(skipEntries bool, pubKey ic.PubKey) (err error) {
 	c.lk.RLock()
 	defer c.lk.RUnlock()
+	var keys []ic.PubKey
+	keys, err = c.signingKeys(skipEntries, pubKey)
+	if err != nil {
+		return
+	}
 	l := len(c.Headers)
 	for i := 0; i < l; i++ {
 		hd := c.Headers[i]
@@ -541,6 +726,20 @@ func (c *Chain) Validate(skipEntries bool) (err error) {
 			return
 		}
 
+		if keys[i] == nil {
+			err = ValidationFailed(fmt.Sprintf("no agent key to check header signature at link %d", i))
+			return
+		}
+		var matches bool
+		matches, err = hd.Verify(keys[i])
+		if err != nil {
+			return
+		}
+		if !matches {
+			err = ValidationFailed(fmt.Sprintf("%s at link %d", ValidationFailureBadHeaderSignature, i))
+			return
+		}
+
 		if !skipEntries {
 			var b []byte
 			b, err = c.Entries[i].Marshal()
@@ -561,6 +760,88 @@ func (c *Chain) Validate(skipEntries bool) (err error) {
 	return
 }
 
+// signingKeys returns the key each of the chain's headers should be signed with.  The
+// agent can change keys, so it's taken from the agent entries in the chain, with the
+// headers before the first agent entry (i.e. the DNA) signed by its key.  An agent entry
+// that changes the key must be signed by the key in force before it and carry that key's
+// revocation.  Entries aren't trusted if they aren't being validated, in which case pubKey
+// is used.
+func (c *Chain) signingKeys(skipEntries bool, pubKey ic.PubKey) (keys []ic.PubKey, err error) {
+	keys = make([]ic.PubKey, len(c.Headers))
+	key := pubKey
+	var found bool
+	for i, hd := range c.Headers {
+		keys[i] = key
+		if !skipEntries && hd.Type == AgentEntryType && i < len(c.Entries) && c.Entries[i] != nil {
+			j, ok := c.Entries[i].Content().(string)
+			if !ok {
+				err = ValidationFailed(fmt.Sprintf("bad agent entry at link %d", i))
+				return
+			}
+			var ae AgentEntry
+			ae, err = AgentEntryFromJSON(j)
+			if err != nil {
+				err = ValidationFailed(fmt.Sprintf("bad agent entry at link %d", i))
+				return
+			}
+			var newKey ic.PubKey
+			newKey, err = DecodePubKey(ae.PublicKey)
+			if err != nil {
+				err = ValidationFailed(fmt.Sprintf("%s at link %d", ValidationFailureBadPublicKeyFormat, i))
+				return
+			}
+			if !found {
+				for k := 0; k <= i; k++ {
+					keys[k] = newKey
+				}
+				found = true
+			} else if !newKey.Equals(key) {
+				err = checkKeyRevocation(ae.Revocation, key, newKey)
+				if err != nil {
+					err = ValidationFailed(fmt.Sprintf("%s at link %d: %v", ValidationFailureUnrevokedKeyChange, i, err))
+					return
+				}
+			}
+			key = newKey
+		}
+	}
+	if found && pubKey != nil && !key.Equals(pubKey) {
+		err = ValidationFailed("chain's agent key doesn't match the author's key")
+	}
+	return
+}
+
+// checkKeyRevocation returns an error if revocation isn't a valid self-revocation of
+// oldKey in favor of newKey
+func checkKeyRevocation(revocation string, oldKey, newKey ic.PubKey) (err error) {
+	if revocation == "" {
+		err = errors.New("missing revocation")
+		return
+	}
+	var r SelfRevocation
+	if err = r.Unmarshal(revocation); err != nil {
+		return
+	}
+	if len(r.Data) == 0 || 2*int(r.Data[0])+1 > len(r.Data) {
+		err = errors.New(ValidationFailureBadRevocationFormat)
+		return
+	}
+	if err = r.Verify(); err != nil {
+		return
+	}
+	var revoked, replacement ic.PubKey
+	if revoked, err = r.getOldKey(); err != nil {
+		return
+	}
+	if replacement, err = r.getNewKey(); err != nil {
+		return
+	}
+	if !revoked.Equals(oldKey) || !replacement.Equals(newKey) {
+		err = errors.New("revocation is for other keys")
+	}
+	return
+}
+
 // String converts a chain to a textual dump of the headers and entries
 func (c *Chain) String() string {
 	return c.Dump(0)
@@ -767,6 +1048,9 @@ func (c *Chain) CloseBundle(commit bool) (err error) {
 
 // Close the chain's file
 func (c *Chain) Close() {
+	if err := c.saveIndexes(); err != nil {
+		Debugf("error saving chain indexes: %v", err)
+	}
 	c.s.Close()
 	c.s = nil
 }
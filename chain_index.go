@@ -0,0 +1,288 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements secondary indexes on the JSON fields of local chain entries
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+)
+
+const chainIndexesVersion = 1
+
+// chainIndex maps the JSON encoded values of an entry field to the positions on the
+// chain of the entries having that value
+type chainIndex struct {
+	Positions map[string][]int
+
+	values map[string]interface{} // the decoded keys of Positions
+}
+
+// chainIndexes holds all the indexes of a chain, and is what gets persisted to the index file
+type chainIndexes struct {
+	Version int
+	Length  int                               // the number of chain entries that have been indexed
+	Top     string                            // the hash of the last indexed header
+	Fields  map[string][]string               // the indexed fields by entry type
+	Indexes map[string]map[string]*chainIndex // indexes by entry type and field
+
+	path string
+}
+
+// indexedFields returns the entry fields that the DNA declares indexes on, by entry type
+func (dna *DNA) indexedFields() (fields map[string][]string) {
+	fields = make(map[string][]string)
+	for _, z := range dna.Zomes {
+		for _, e := range z.Entries {
+			if len(e.Indexes) > 0 {
+				fields[e.Name] = e.Indexes
+			}
+		}
+	}
+	return
+}
+
+// checkIndexes returns an error if an entry definition declares indexes it can't have
+func (def *EntryDef) checkIndexes() (err error) {
+	if len(def.Indexes) > 0 && def.DataFormat != DataFormatJSON {
+		err = fmt.Errorf("entry type %s: indexes can only be declared on %s entries", def.Name, DataFormatJSON)
+	}
+	return
+}
+
+func newChainIndexes(fields map[string][]string, path string) (ci *chainIndexes) {
+	ci = &chainIndexes{
+		Version: chainIndexesVersion,
+		Fields:  fields,
+		Indexes: make(map[string]map[string]*chainIndex),
+		path:    path,
+	}
+	for entryType, fs := range fields {
+		ci.Indexes[entryType] = make(map[string]*chainIndex)
+		for _, f := range fs {
+			ci.Indexes[entryType][f] = &chainIndex{Positions: make(map[string][]int), values: make(map[string]interface{})}
+		}
+	}
+	return
+}
+
+// OpenIndexes sets up the secondary indexes on the given entry type fields.  The indexes are
+// loaded from the file at path if they were saved there and still match the chain, and any
+// entries added since they were saved are indexed.  The indexes are saved when the chain is closed.
+func (c *Chain) OpenIndexes(fields map[string][]string, path string) (err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.indexes = nil
+	if len(fields) == 0 {
+		return
+	}
+
+	ci := c.loadIndexes(fields, path)
+	if ci == nil {
+		ci = newChainIndexes(fields, path)
+	}
+	for i := ci.Length; i < len(c.Headers); i++ {
+		ci.add(c.Headers[i], c.Entries[i], i)
+	}
+	ci.Length = len(c.Headers)
+	c.indexes = ci
+	return
+}
+
+// loadIndexes reads the saved indexes, returning nil if they can't be used
+func (c *Chain) loadIndexes(fields map[string][]string, path string) (ci *chainIndexes) {
+	if !FileExists(path) {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err == nil && c.cipher != nil {
+		data, err = c.cipher.open(data)
+	}
+	if err != nil {
+		Debugf("unable to read chain indexes: %v", err)
+		return
+	}
+	var saved chainIndexes
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&saved)
+	if err != nil {
+		Debugf("unable to decode chain indexes: %v", err)
+		return
+	}
+	if saved.Version != chainIndexesVersion || saved.Length > len(c.Headers) || !reflect.DeepEqual(saved.Fields, fields) {
+		return
+	}
+	if saved.Length > 0 && c.Hashes[saved.Length-1].String() != saved.Top {
+		return
+	}
+	for _, indexes := range saved.Indexes {
+		for _, idx := range indexes {
+			idx.values = make(map[string]interface{})
+			if idx.Positions == nil {
+				idx.Positions = make(map[string][]int)
+			}
+			for k := range idx.Positions {
+				var v interface{}
+				if json.Unmarshal([]byte(k), &v) == nil {
+					idx.values[k] = v
+				}
+			}
+		}
+	}
+	saved.path = path
+	ci = &saved
+	return
+}
+
+// saveIndexes writes the indexes out to their file
+func (c *Chain) saveIndexes() (err error) {
+	ci := c.indexes
+	if ci == nil {
+		return
+	}
+	ci.Length = len(c.Headers)
+	ci.Top = ""
+	if ci.Length > 0 {
+		ci.Top = c.Hashes[ci.Length-1].String()
+	}
+	var b bytes.Buffer
+	if err = gob.NewEncoder(&b).Encode(ci); err != nil {
+		return
+	}
+	data := b.Bytes()
+	if c.cipher != nil {
+		if data, err = c.cipher.seal(data); err != nil {
+			return
+		}
+	}
+	tmp := ci.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	err = os.Rename(tmp, ci.path)
+	return
+}
+
+// add indexes the fields of an entry at the given chain position
+func (ci *chainIndexes) add(header *Header, entry Entry, pos int) {
+	indexes, ok := ci.Indexes[header.Type]
+	if !ok {
+		return
+	}
+	s, ok := entry.Content().(string)
+	if !ok {
+		return
+	}
+	var content interface{}
+	if err := json.Unmarshal([]byte(s), &content); err != nil {
+		return
+	}
+	for field, idx := range indexes {
+		v, ok := queryField(content, field)
+		if !ok {
+			continue
+		}
+		k, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		key := string(k)
+		idx.Positions[key] = append(idx.Positions[key], pos)
+		idx.values[key] = v
+	}
+}
+
+// candidates returns the positions of the entries of the given types that might satisfy
+// the condition, or false if the indexes can't narrow down the entries for the condition
+func (ci *chainIndexes) candidates(entryTypes []string, cond *QueryCondition) (positions []int, ok bool) {
+	found := make(map[int]bool)
+	for _, entryType := range entryTypes {
+		var p map[int]bool
+		p, ok = ci.lookup(entryType, cond)
+		if !ok {
+			return
+		}
+		for i := range p {
+			found[i] = true
+		}
+	}
+	positions = make([]int, 0, len(found))
+	for i := range found {
+		positions = append(positions, i)
+	}
+	sort.Ints(positions)
+	return
+}
+
+// lookup returns the positions of the entries of a type that might satisfy the condition
+func (ci *chainIndexes) lookup(entryType string, cond *QueryCondition) (positions map[int]bool, ok bool) {
+	switch {
+	case len(cond.And) > 0:
+		// the intersection of the conditions that can use an index
+		for i := range cond.And {
+			p, usable := ci.lookup(entryType, &cond.And[i])
+			if !usable {
+				continue
+			}
+			if !ok {
+				positions, ok = p, true
+				continue
+			}
+			for pos := range positions {
+				if !p[pos] {
+					delete(positions, pos)
+				}
+			}
+		}
+	case len(cond.Or) > 0:
+		// the union of the conditions, all of which must be able to use an index
+		positions = make(map[int]bool)
+		for i := range cond.Or {
+			var p map[int]bool
+			p, ok = ci.lookup(entryType, &cond.Or[i])
+			if !ok {
+				return
+			}
+			for pos := range p {
+				positions[pos] = true
+			}
+		}
+	case cond.Not != nil:
+		// entries missing the field satisfy a Not, and aren't in the index
+	default:
+		var idx *chainIndex
+		idx, ok = ci.Indexes[entryType][cond.Field]
+		if !ok {
+			return
+		}
+		positions = make(map[int]bool)
+		for k, v := range idx.values {
+			if cond.matchValue(v) {
+				for _, pos := range idx.Positions[k] {
+					positions[pos] = true
+				}
+			}
+		}
+	}
+	return
+}
+
+// indexCandidates returns the positions of the entries of the given types that might satisfy
+// the condition, or false if the chain's indexes can't be used for the query
+func (c *Chain) indexCandidates(entryTypes []string, cond *QueryCondition) (positions []int, ok bool) {
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+	if c.indexes == nil || len(entryTypes) == 0 || cond == nil {
+		return
+	}
+	positions, ok = c.indexes.candidates(entryTypes, cond)
+	return
+}
@@ -0,0 +1,91 @@
+package holochain
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChainIndexes(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+	path := filepath.Join(d, "chain.dat")
+	indexPath := filepath.Join(d, "chain.idx")
+	fields := map[string][]string{"profile": {"name", "age"}}
+
+	c, err := NewChainFromFile(hashSpec, path)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 5; i++ {
+		e := GobEntry{C: fmt.Sprintf(`{"name":"name%d","age":%d}`, i, 20+i)}
+		c.AddEntry(now, "profile", &e, key)
+	}
+	e := GobEntry{C: "some data"}
+	c.AddEntry(now, "other", &e, key)
+
+	Convey("it should index the existing entries when opened", t, func() {
+		err := c.OpenIndexes(fields, indexPath)
+		So(err, ShouldBeNil)
+		positions, ok := c.indexCandidates([]string{"profile"}, &QueryCondition{Field: "name", Op: QueryOpEquals, Value: "name2"})
+		So(ok, ShouldBeTrue)
+		So(positions, ShouldResemble, []int{2})
+	})
+
+	Convey("it should index entries as they are added", t, func() {
+		e := GobEntry{C: `{"name":"name5","age":25}`}
+		c.AddEntry(now, "profile", &e, key)
+		positions, ok := c.indexCandidates([]string{"profile"}, &QueryCondition{Field: "age", Op: QueryOpGTE, Value: 23})
+		So(ok, ShouldBeTrue)
+		So(positions, ShouldResemble, []int{3, 4, 6})
+	})
+
+	Convey("it should combine conditions", t, func() {
+		cond := QueryCondition{And: []QueryCondition{
+			{Field: "age", Op: QueryOpBetween, Value: []interface{}{21, 24}},
+			{Or: []QueryCondition{
+				{Field: "name", Op: QueryOpEquals, Value: "name1"},
+				{Field: "name", Op: QueryOpIn, Value: []interface{}{"name4", "name5"}},
+			}},
+			{Field: "unindexed", Op: QueryOpEquals, Value: 1},
+		}}
+		So(cond.prepare(), ShouldBeNil)
+		positions, ok := c.indexCandidates([]string{"profile"}, &cond)
+		So(ok, ShouldBeTrue)
+		So(positions, ShouldResemble, []int{1, 4})
+	})
+
+	Convey("it should not use the indexes when they can't narrow down the entries", t, func() {
+		_, ok := c.indexCandidates([]string{"profile"}, &QueryCondition{Field: "unindexed", Op: QueryOpEquals, Value: 1})
+		So(ok, ShouldBeFalse)
+		_, ok = c.indexCandidates([]string{"profile", "other"}, &QueryCondition{Field: "name", Op: QueryOpEquals, Value: "name1"})
+		So(ok, ShouldBeFalse)
+		_, ok = c.indexCandidates([]string{"profile"}, &QueryCondition{Not: &QueryCondition{Field: "name", Op: QueryOpEquals, Value: "name1"}})
+		So(ok, ShouldBeFalse)
+		_, ok = c.indexCandidates(nil, &QueryCondition{Field: "name", Op: QueryOpEquals, Value: "name1"})
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("it should save the indexes when closed and load them when reopened", t, func() {
+		c.Close()
+		So(FileExists(indexPath), ShouldBeTrue)
+		c, err = NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		ci := c.loadIndexes(fields, indexPath)
+		So(ci, ShouldNotBeNil)
+		So(ci.Length, ShouldEqual, 7)
+		So(ci.Indexes["profile"]["name"].values[`"name5"`], ShouldEqual, "name5")
+
+		So(c.loadIndexes(map[string][]string{"profile": {"name"}}, indexPath), ShouldBeNil)
+
+		err = c.OpenIndexes(fields, indexPath)
+		So(err, ShouldBeNil)
+		positions, ok := c.indexCandidates([]string{"profile"}, &QueryCondition{Field: "name", Op: QueryOpEquals, Value: "name5"})
+		So(ok, ShouldBeTrue)
+		So(positions, ShouldResemble, []int{6})
+		c.Close()
+	})
+}
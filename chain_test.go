@@ -358,40 +358,44 @@ func TestChainValidateChain(t *testing.T) {
 	e := GobEntry{C: "some data"}
 	c.AddEntry(now, DNAEntryType, &e, key)
 
-	e = GobEntry{C: "some other data"}
+	pk, _ := encodePubKey(key.GetPublic())
+	ae := AgentEntry{Identity: "agent id", PublicKey: pk}
+	j, _ := ae.ToJSON()
+	e = GobEntry{C: j}
 	c.AddEntry(now, AgentEntryType, &e, key)
 
 	e = GobEntry{C: "and more data"}
 	c.AddEntry(now, "entryTypeFoo1", &e, key)
 
 	Convey("it should validate", t, func() {
-		So(c.Validate(false), ShouldBeNil)
+		So(c.Validate(false, nil), ShouldBeNil)
+		So(c.Validate(false, key.GetPublic()), ShouldBeNil)
 	})
 
 	Convey("it should fail to validate if we diddle some bits", t, func() {
 		c.Entries[0].(*GobEntry).C = "fish" // tweak
-		So(c.Validate(false).Error(), ShouldEqual, "entry hash mismatch at link 0")
-		So(c.Validate(true), ShouldBeNil) // test skipping entry validation
+		So(c.Validate(false, nil).Error(), ShouldEqual, "entry hash mismatch at link 0")
+		So(c.Validate(true, key.GetPublic()), ShouldBeNil) // test skipping entry validation
 
 		c.Entries[0].(*GobEntry).C = "some data" //restore
 		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
 		c.Headers[1].TypeLink = hash // tweak
-		So(c.Validate(false).Error(), ShouldEqual, "header hash mismatch at link 1")
+		So(c.Validate(false, nil).Error(), ShouldEqual, "header hash mismatch at link 1")
 
 		c.Headers[1].TypeLink = NullHash() //restore
 		c.Headers[0].Type = "entryTypeBar" //tweak
-		err := c.Validate(false)
+		err := c.Validate(false, nil)
 		So(err.Error(), ShouldEqual, "header hash mismatch at link 0")
 
 		c.Headers[0].Type = DNAEntryType // restore
 		t := c.Headers[0].Time           // tweak
 		c.Headers[0].Time = time.Now()
-		err = c.Validate(false)
+		err = c.Validate(false, nil)
 		So(err.Error(), ShouldEqual, "header hash mismatch at link 0")
 
 		c.Headers[0].Time = t                            // restore
 		c.Headers[0].HeaderLink = c.Headers[0].EntryLink // tweak
-		err = c.Validate(false)
+		err = c.Validate(false, nil)
 		So(err.Error(), ShouldEqual, "header hash mismatch at link 0")
 
 		c.Headers[0].HeaderLink = NullHash() // restore
@@ -399,20 +403,74 @@ func TestChainValidateChain(t *testing.T) {
 		tweak := []byte(before)
 		tweak[5] = 3 // tweak
 		c.Headers[0].EntryLink = Hash(tweak)
-		err = c.Validate(false)
+		err = c.Validate(false, nil)
 		So(err.Error(), ShouldEqual, "header hash mismatch at link 0")
 
 		c.Headers[0].EntryLink = before // restore
 		val := c.Headers[0].Sig.S[0]
 		c.Headers[0].Sig.S[0] = 99 // tweak
-		err = c.Validate(false)
+		err = c.Validate(false, nil)
 		So(err.Error(), ShouldEqual, "header hash mismatch at link 0")
 
 		c.Headers[0].Sig.S[0] = val // restore
 		c.Headers[0].Change = "foo" // tweak
-		err = c.Validate(false)
+		err = c.Validate(false, nil)
 		So(err.Error(), ShouldEqual, "header hash mismatch at link 0")
+		c.Headers[0].Change = NullHash() // restore
+	})
+
+	Convey("it should check the header signatures", t, func() {
+		So(c.Validate(true, nil).Error(), ShouldEqual, ValidationFailedErrMsg+": no agent key to check header signature at link 0")
+
+		_, otherKey := makePeer("peer_bar")
+		So(c.Validate(true, otherKey.GetPublic()).Error(), ShouldEqual, ValidationFailedErrMsg+": "+ValidationFailureBadHeaderSignature+" at link 0")
+		So(c.Validate(false, otherKey.GetPublic()).Error(), ShouldEqual, ValidationFailedErrMsg+": chain's agent key doesn't match the author's key")
+
+		c1 := NewChain(c.hashSpec)
+		e := GobEntry{C: "some data"}
+		c1.AddEntry(now, DNAEntryType, &e, key)
+		e = GobEntry{C: j}
+		c1.AddEntry(now, AgentEntryType, &e, key)
+		e = GobEntry{C: "and more data"}
+		c1.AddEntry(now, "entryTypeFoo1", &e, otherKey)
+		So(c1.Validate(false, nil).Error(), ShouldEqual, ValidationFailedErrMsg+": "+ValidationFailureBadHeaderSignature+" at link 2")
+	})
+
+	Convey("a change of agent key should only validate when signed and revoked by the previous key", t, func() {
+		_, newKey := makePeer("peer_new")
+		newPk, _ := encodePubKey(newKey.GetPublic())
+		revocation, _ := NewSelfRevocation(key, newKey, []byte("rotating"))
+		rj, _ := revocation.Marshal()
+		rotate := func(revocation string, signer ic.PrivKey) *Chain {
+			c1 := NewChain(c.hashSpec)
+			e := GobEntry{C: "some data"}
+			c1.AddEntry(now, DNAEntryType, &e, key)
+			e = GobEntry{C: j}
+			c1.AddEntry(now, AgentEntryType, &e, key)
+			ae := AgentEntry{Identity: "agent id", PublicKey: newPk, Revocation: revocation}
+			nj, _ := ae.ToJSON()
+			e = GobEntry{C: nj}
+			c1.AddEntry(now, AgentEntryType, &e, signer)
+			e = GobEntry{C: "more data"}
+			c1.AddEntry(now, "entryTypeFoo1", &e, newKey)
+			return c1
+		}
+
+		So(rotate(rj, key).Validate(false, newKey.GetPublic()), ShouldBeNil)
+
+		// a forged agent entry signed by the new key itself
+		err := rotate(rj, newKey).Validate(false, newKey.GetPublic())
+		So(err.Error(), ShouldEqual, ValidationFailedErrMsg+": "+ValidationFailureBadHeaderSignature+" at link 2")
+
+		// a forged agent entry without the previous key's revocation
+		err = rotate("", key).Validate(false, newKey.GetPublic())
+		So(err.Error(), ShouldEqual, ValidationFailedErrMsg+": "+ValidationFailureUnrevokedKeyChange+" at link 2: missing revocation")
 
+		_, otherKey := makePeer("peer_other")
+		forged, _ := NewSelfRevocation(otherKey, newKey, []byte("rotating"))
+		fj, _ := forged.Marshal()
+		err = rotate(fj, key).Validate(false, newKey.GetPublic())
+		So(err.Error(), ShouldEqual, ValidationFailedErrMsg+": "+ValidationFailureUnrevokedKeyChange+" at link 2: revocation is for other keys")
 	})
 }
 
@@ -732,7 +790,7 @@ func TestChainBundle(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(c.BundleStarted(), ShouldBeNil)
 		So(c.Length(), ShouldEqual, 4)
-		So(c.Validate(false), ShouldBeNil)
+		So(c.Validate(false, key.GetPublic()), ShouldBeNil)
 
 		// makes sure type linking worked too
 		hash, _ := c.TopType("entryTypeFoo1")
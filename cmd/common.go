@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
 	"io"
 	"net"
 	"os"
@@ -24,6 +25,10 @@ import (
 )
 
 var ErrServiceUninitialized = errors.New("service not initialized, run 'hcadmin init'")
+var ErrPassphraseMismatch = errors.New("passphrases don't match")
+
+var agentPassphrase *string
+var storePassphrase *string
 
 func MakeErr(c *cli.Context, text string) error {
 	if c != nil {
@@ -43,6 +48,91 @@ func MakeErrFromErr(c *cli.Context, err error) error {
 	return MakeErr(c, err.Error())
 }
 
+// GetAgentPassphrase is a holo.AgentPassphraseFn that gets the agent key passphrase from
+// the environment or, failing that, prompts for it when run from a terminal.  A prompted
+// passphrase is asked for once and remembered for the rest of the command.
+func GetAgentPassphrase(newKey bool) (passphrase string, err error) {
+	passphrase, err = holo.AgentPassphraseFromEnv(newKey)
+	if err != nil || passphrase != "" {
+		return
+	}
+	if agentPassphrase != nil {
+		passphrase = *agentPassphrase
+		return
+	}
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		if newKey {
+			passphrase, err = readPassphrase("Passphrase to encrypt the agent key with (leave empty for none): ")
+			if err != nil {
+				return
+			}
+			if passphrase != "" {
+				var confirm string
+				confirm, err = readPassphrase("Confirm passphrase: ")
+				if err != nil {
+					return
+				}
+				if confirm != passphrase {
+					err = ErrPassphraseMismatch
+					return
+				}
+			}
+		} else {
+			passphrase, err = readPassphrase("Agent key passphrase: ")
+			if err != nil {
+				return
+			}
+		}
+		agentPassphrase = &passphrase
+	}
+	return
+}
+
+// GetStorePassphrase is a holo.StorePassphraseFn that gets the store passphrase from the
+// environment or, failing that, prompts for it when run from a terminal.  A prompted
+// passphrase is asked for once and remembered for the rest of the command.
+func GetStorePassphrase(newStore bool) (passphrase string, err error) {
+	passphrase, err = holo.StorePassphraseFromEnv(newStore)
+	if err != nil || passphrase != "" {
+		return
+	}
+	if storePassphrase != nil {
+		passphrase = *storePassphrase
+		return
+	}
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		passphrase, err = readPassphrase("Store encryption passphrase: ")
+		if err != nil {
+			return
+		}
+		if newStore {
+			var confirm string
+			confirm, err = readPassphrase("Confirm passphrase: ")
+			if err != nil {
+				return
+			}
+			if confirm != passphrase {
+				err = ErrPassphraseMismatch
+				return
+			}
+		}
+		storePassphrase = &passphrase
+	}
+	return
+}
+
+func readPassphrase(prompt string) (passphrase string, err error) {
+	fmt.Fprint(os.Stderr, prompt)
+	var b []byte
+	b, err = terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return
+	}
+	passphrase = string(b)
+	return
+}
+
 func GetCurrentDirectory() (dir string, err error) {
 	dir, err = os.Getwd()
 	return
@@ -10,15 +10,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	holo "github.com/holochain/holochain-proto"
 	"github.com/holochain/holochain-proto/cmd"
 	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/urfave/cli"
 )
 
 var debug bool
 var verbose bool
+var storePassphraseFile string
+var agentPassphraseFile string
 
 func setupApp() (app *cli.App) {
 	app = cli.NewApp()
@@ -31,6 +36,15 @@ func setupApp() (app *cli.App) {
 	var service *holo.Service
 	var bridgeCalleeAppData, bridgeCallerAppData, dumpFormat string
 	var start int
+	var capWho cli.StringSlice
+	var capTTL time.Duration
+	var capRevokeWho string
+	var blockReason string
+	var blockShare bool
+	var rotatePayload string
+	var initSigner string
+	var joinAgent string
+	var newAgentSigner string
 
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
@@ -48,6 +62,16 @@ func setupApp() (app *cli.App) {
 			Usage:       "verbose output",
 			Destination: &verbose,
 		},
+		cli.StringFlag{
+			Name:        "store-passphrase-file",
+			Usage:       "file containing the passphrase for the passphrase store encryption (default: $" + holo.StorePassphraseEnvar + " or prompt)",
+			Destination: &storePassphraseFile,
+		},
+		cli.StringFlag{
+			Name:        "agent-passphrase-file",
+			Usage:       "file containing the passphrase agent keys are encrypted with (default: $" + holo.AgentPassphraseEnvar + " or prompt)",
+			Destination: &agentPassphraseFile,
+		},
 	}
 
 	app.Commands = []cli.Command{
@@ -56,18 +80,42 @@ func setupApp() (app *cli.App) {
 			Aliases:   []string{"i"},
 			ArgsUsage: "agent-id",
 			Usage:     "setup the holochain service",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "signer",
+					Usage:       "socket of a signer (e.g. hcsigner) to sign with instead of generating a key",
+					Destination: &initSigner,
+				},
+			},
 			Action: func(c *cli.Context) error {
 				agent := c.Args().First()
 				if agent == "" {
 					return errors.New("missing required agent-id argument to init")
 				}
-				_, err := holo.Init(root, holo.AgentIdentity(agent), nil)
+				var err error
+				if initSigner != "" {
+					var a *holo.SignerAgent
+					a, err = holo.NewSignerAgent(holo.AgentIdentity(agent), initSigner)
+					if err != nil {
+						return err
+					}
+					_, err = holo.InitWithAgent(root, a)
+				} else {
+					_, err = holo.Init(root, holo.AgentIdentity(agent), nil)
+				}
 				if err == nil {
 					fmt.Println("Holochain service initialized")
 					if verbose {
 						fmt.Printf("    %s directory created\n", root)
 						fmt.Printf("    defaults stored to %s\n", holo.SysFileName)
-						fmt.Println("    key-pair generated")
+						if initSigner != "" {
+							fmt.Printf("    signing delegated to %s\n", initSigner)
+						} else {
+							fmt.Println("    key-pair generated")
+							if p, _ := holo.AgentPassphrase(true); p != "" {
+								fmt.Println("    private key encrypted with passphrase")
+							}
+						}
 						fmt.Printf("    default agent stored to %s\n", holo.AgentFileName)
 					}
 				}
@@ -165,6 +213,13 @@ func setupApp() (app *cli.App) {
 			Aliases:   []string{"j"},
 			ArgsUsage: "path holochain-name",
 			Usage:     "joins a holochain by installing an instance from an app package (or source directory) and generating genesis entries",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "agent",
+					Usage:       "name of the agent to join as (default: the service's default agent)",
+					Destination: &joinAgent,
+				},
+			},
 			Action: func(c *cli.Context) error {
 				srcPath := c.Args().First()
 				if srcPath == "" {
@@ -194,15 +249,25 @@ func setupApp() (app *cli.App) {
 						return fmt.Errorf("join: error initializing the app: %v", err)
 					}
 				} else {
-					agent, err := holo.LoadAgent(root)
+					agentName := joinAgent
+					if agentName == "" {
+						agentName = service.DefaultAgentName()
+					}
+					agent, err := service.LoadAgent(agentName)
 					if err != nil {
-						return fmt.Errorf("join: error loading agent (%s): %v", root, err)
+						return fmt.Errorf("join: error loading agent (%s): %v", agentName, err)
 					}
 					_, err = service.Clone(srcPath, filepath.Join(root, name), agent, holo.CloneWithSameUUID, holo.InitializeDB)
 					if err != nil {
 						return fmt.Errorf("join: error cloning from source directory %s: %v", srcPath, err)
 					}
 				}
+				if joinAgent != "" {
+					err = service.SetChainAgent(name, joinAgent)
+					if err != nil {
+						return fmt.Errorf("join: error binding agent %s: %v", joinAgent, err)
+					}
+				}
 				err = genChain(service, name)
 				if err != nil {
 					return fmt.Errorf("join: error in chain genesis: %v", err)
@@ -262,6 +327,399 @@ func setupApp() (app *cli.App) {
 				return err
 			},
 		},
+		{
+			Name:      "migrate",
+			ArgsUsage: "from-chain to-chain",
+			Usage:     "re-commits the entries of from-chain into to-chain, whose DNA must be BasedOn from-chain's DNA, running the DNA's migration functions",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("migrate: requires two arguments: from-chain to-chain")
+				}
+				fromChain := c.Args()[0]
+				toChain := c.Args()[1]
+
+				hFrom, err := cmd.GetHolochain(fromChain, service, "migrate")
+				if err != nil {
+					return err
+				}
+				hTo, err := cmd.GetHolochain(toChain, service, "migrate")
+				if err != nil {
+					return err
+				}
+
+				migration, hash, err := hTo.Migrate(hFrom)
+				if err != nil {
+					return err
+				}
+				if verbose {
+					fmt.Printf("migrated %d public entries from %s to %s (skipped %d)\n", len(migration.Entries), fromChain, toChain, len(migration.Skipped))
+					fmt.Printf("migration entry: %v\n", hash)
+				}
+				return nil
+			},
+		},
+		{
+			Name:    "capability",
+			Aliases: []string{"cap"},
+			Usage:   "manage the capability tokens that allow bridged calls into a chain",
+			Subcommands: []cli.Command{
+				{
+					Name:      "list",
+					ArgsUsage: "holochain-name",
+					Usage:     "list the capabilities granted on a chain",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return errors.New("capability list: requires one argument: holochain-name")
+						}
+						h, err := cmd.GetHolochain(c.Args().First(), service, "capability")
+						if err != nil {
+							return err
+						}
+						caps, err := h.ListCapabilities()
+						if err != nil {
+							return err
+						}
+						if len(caps) == 0 {
+							fmt.Println("no capabilities")
+						}
+						for _, cp := range caps {
+							fmt.Printf("%s\n    capability: %s\n", cp.Token, cp.Capability)
+							if len(cp.Who) == 0 {
+								fmt.Printf("    who: anyone\n")
+							} else {
+								fmt.Printf("    who: %s\n", strings.Join(cp.Who, ", "))
+							}
+							if !cp.Expires.IsZero() {
+								fmt.Printf("    expires: %v\n", cp.Expires.Format(time.RFC3339))
+							}
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "grant",
+					ArgsUsage: "holochain-name zome:function...",
+					Usage:     "grant a capability token for calling the given functions",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "who",
+							Usage: "public key of an agent the capability is granted to (repeatable, default: anyone)",
+							Value: &capWho,
+						},
+						cli.DurationFlag{
+							Name:        "ttl",
+							Usage:       "time until the capability expires (default: never)",
+							Destination: &capTTL,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) < 2 {
+							return errors.New("capability grant: requires at least two arguments: holochain-name zome:function...")
+						}
+						functions := make(holo.BridgeSpec)
+						for _, arg := range c.Args()[1:] {
+							x := strings.Split(arg, ":")
+							if len(x) != 2 || x[0] == "" || x[1] == "" {
+								return fmt.Errorf("capability grant: expected zome:function, got %s", arg)
+							}
+							if functions[x[0]] == nil {
+								functions[x[0]] = make(map[string]bool)
+							}
+							functions[x[0]][x[1]] = true
+						}
+						h, err := cmd.GetHolochain(c.Args().First(), service, "capability")
+						if err != nil {
+							return err
+						}
+						token, err := h.GrantCapability(functions, capWho, capTTL)
+						if err != nil {
+							return err
+						}
+						fmt.Println(token)
+						return nil
+					},
+				},
+				{
+					Name:      "revoke",
+					ArgsUsage: "holochain-name token",
+					Usage:     "revoke a capability token",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "who",
+							Usage:       "public key of an agent to revoke the capability for (default: everyone)",
+							Destination: &capRevokeWho,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 2 {
+							return errors.New("capability revoke: requires two arguments: holochain-name token")
+						}
+						h, err := cmd.GetHolochain(c.Args().First(), service, "capability")
+						if err != nil {
+							return err
+						}
+						err = h.RevokeCapability(c.Args()[1], capRevokeWho)
+						if err == nil && verbose {
+							fmt.Printf("revoked %s\n", c.Args()[1])
+						}
+						return err
+					},
+				},
+			},
+		},
+		{
+			Name:  "peers",
+			Usage: "manage the blocklist of peers a chain's node ignores",
+			Subcommands: []cli.Command{
+				{
+					Name:      "list",
+					ArgsUsage: "holochain-name",
+					Usage:     "list the blocked peers",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return errors.New("peers list: requires one argument: holochain-name")
+						}
+						h, err := cmd.GetHolochain(c.Args().First(), service, "peers")
+						if err != nil {
+							return err
+						}
+						list, err := h.BlockedPeers()
+						if err != nil {
+							return err
+						}
+						if len(list.Records) == 0 {
+							fmt.Println("no blocked peers")
+						}
+						for _, r := range list.Records {
+							if r.Warrant == "" {
+								fmt.Println(peer.IDB58Encode(r.ID))
+							} else {
+								fmt.Printf("%s\n    reason: %s\n", peer.IDB58Encode(r.ID), r.Warrant)
+							}
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "block",
+					ArgsUsage: "holochain-name peer-id",
+					Usage:     "add a peer to the blocklist",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "reason",
+							Usage:       "why the peer is being blocked",
+							Destination: &blockReason,
+						},
+						cli.BoolFlag{
+							Name:        "share",
+							Usage:       "send the block with a signed warrant to the peer's neighbors",
+							Destination: &blockShare,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 2 {
+							return errors.New("peers block: requires two arguments: holochain-name peer-id")
+						}
+						id, err := peer.IDB58Decode(c.Args()[1])
+						if err != nil {
+							return fmt.Errorf("peers block: invalid peer-id: %v", err)
+						}
+						h, err := cmd.GetHolochain(c.Args().First(), service, "peers")
+						if err != nil {
+							return err
+						}
+						err = h.BlockPeer(id, blockReason, blockShare)
+						if err == nil && verbose {
+							fmt.Printf("blocked %s\n", c.Args()[1])
+						}
+						return err
+					},
+				},
+				{
+					Name:      "unblock",
+					ArgsUsage: "holochain-name peer-id",
+					Usage:     "remove a peer from the blocklist",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 2 {
+							return errors.New("peers unblock: requires two arguments: holochain-name peer-id")
+						}
+						id, err := peer.IDB58Decode(c.Args()[1])
+						if err != nil {
+							return fmt.Errorf("peers unblock: invalid peer-id: %v", err)
+						}
+						h, err := cmd.GetHolochain(c.Args().First(), service, "peers")
+						if err != nil {
+							return err
+						}
+						err = h.UnblockPeer(id)
+						if err == nil && verbose {
+							fmt.Printf("unblocked %s\n", c.Args()[1])
+						}
+						return err
+					},
+				},
+			},
+		},
+		{
+			Name:  "agent",
+			Usage: "manage a chain's agent",
+			Subcommands: []cli.Command{
+				{
+					Name:      "rotate",
+					ArgsUsage: "holochain-name",
+					Usage:     "replace the agent's key with a new one, revoking the old key",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "payload",
+							Usage:       "data to include in the revocation (default: \"" + holo.DefaultRotationPayload + "\")",
+							Destination: &rotatePayload,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return errors.New("agent rotate: requires one argument: holochain-name")
+						}
+						h, err := cmd.GetHolochain(c.Args().First(), service, "agent")
+						if err != nil {
+							return err
+						}
+						oldID := h.NodeIDStr()
+						err = h.RotateAgentKey(rotatePayload)
+						if err != nil {
+							return err
+						}
+						if verbose {
+							fmt.Printf("revoked %s\n", oldID)
+						}
+						fmt.Println(h.NodeIDStr())
+						return nil
+					},
+				},
+				{
+					Name:  "list",
+					Usage: "list the service's agents, marking the default agent",
+					Action: func(c *cli.Context) error {
+						if service == nil {
+							return cmd.ErrServiceUninitialized
+						}
+						names, err := service.AgentNames()
+						if err != nil {
+							return err
+						}
+						for _, n := range names {
+							agent, err := service.LoadAgent(n)
+							var id string
+							if err != nil {
+								id = fmt.Sprintf("(%v)", err)
+							} else {
+								_, id, _ = agent.NodeID()
+								id = fmt.Sprintf("%s %s", id, agent.Identity())
+							}
+							mark := " "
+							if n == service.DefaultAgentName() {
+								mark = "*"
+							}
+							fmt.Printf("%s %s %s\n", mark, n, id)
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "new",
+					ArgsUsage: "name agent-id",
+					Usage:     "generate a new named agent",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:        "signer",
+							Usage:       "socket of a signer (e.g. hcsigner) for the agent to sign with instead of generating a key",
+							Destination: &newAgentSigner,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if service == nil {
+							return cmd.ErrServiceUninitialized
+						}
+						if len(c.Args()) != 2 {
+							return errors.New("agent new: requires two arguments: name agent-id")
+						}
+						name := c.Args()[0]
+						identity := holo.AgentIdentity(c.Args()[1])
+						var agent holo.Agent
+						var err error
+						if newAgentSigner != "" {
+							agent, err = holo.NewSignerAgent(identity, newAgentSigner)
+							if err != nil {
+								return err
+							}
+							err = service.AddAgent(name, agent)
+						} else {
+							agent, err = service.NewAgent(name, identity)
+						}
+						if err != nil {
+							return err
+						}
+						_, id, _ := agent.NodeID()
+						fmt.Println(id)
+						return nil
+					},
+				},
+				{
+					Name:      "default",
+					ArgsUsage: "[name]",
+					Usage:     "show or set the agent newly joined chains use",
+					Action: func(c *cli.Context) error {
+						if service == nil {
+							return cmd.ErrServiceUninitialized
+						}
+						if len(c.Args()) == 0 {
+							fmt.Println(service.DefaultAgentName())
+							return nil
+						}
+						if len(c.Args()) != 1 {
+							return errors.New("agent default: expected 0 or 1 argument")
+						}
+						return service.SetDefaultAgent(c.Args().First())
+					},
+				},
+				{
+					Name:      "encrypt",
+					ArgsUsage: "[holochain-name]",
+					Usage:     "encrypt an unencrypted agent key with a passphrase (the service's default agent if no holochain-name)",
+					Action: func(c *cli.Context) error {
+						if service == nil {
+							return cmd.ErrServiceUninitialized
+						}
+						if len(c.Args()) > 1 {
+							return errors.New("agent encrypt: expected 0 or 1 argument")
+						}
+						path := root
+						if len(c.Args()) == 1 {
+							name := c.Args().First()
+							if _, err := service.IsConfigured(name); err != nil {
+								return err
+							}
+							path = filepath.Join(root, name)
+							if !cmd.IsFile(path, holo.PrivKeyFileName) {
+								return fmt.Errorf("agent encrypt: %s uses the service's default agent", name)
+							}
+						}
+						passphrase, err := holo.AgentPassphrase(true)
+						if err != nil {
+							return err
+						}
+						if passphrase == "" {
+							return errors.New("agent encrypt: a passphrase is required")
+						}
+						err = holo.EncryptAgentKey(path, passphrase)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("agent key in %s encrypted\n", path)
+						return nil
+					},
+				},
+			},
+		},
 		{
 			Name:      "status",
 			Aliases:   []string{"s"},
@@ -284,6 +742,8 @@ func setupApp() (app *cli.App) {
 					fmt.Printf("ID Hash: %s\n", h.NodeIDStr())
 					idx, _ := h.DHT().GetIdx()
 					fmt.Printf("Current Put Index: %d\n", idx)
+					depths := h.DHT().QueueDepths()
+					fmt.Printf("Queue Depths: changes: %d retries: %d gossip puts: %d\n", depths.Changes, depths.Retries, depths.GossipPuts)
 					fmt.Printf("Gossipers:\n")
 					gossipers, err := h.DHT().GetGossipers()
 					if err != nil {
@@ -308,6 +768,14 @@ func setupApp() (app *cli.App) {
 		if verbose {
 			fmt.Printf("hcadmin version %s \n", app.Version)
 		}
+		if storePassphraseFile != "" {
+			os.Setenv(holo.StorePassphraseFileEnvar, storePassphraseFile)
+		}
+		if agentPassphraseFile != "" {
+			os.Setenv(holo.AgentPassphraseFileEnvar, agentPassphraseFile)
+		}
+		holo.StorePassphrase = cmd.GetStorePassphrase
+		holo.AgentPassphrase = cmd.GetAgentPassphrase
 		var err error
 		root, err = cmd.GetHolochainRoot(root)
 		if err != nil {
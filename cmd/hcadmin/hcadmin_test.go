@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -49,6 +50,34 @@ func TestInit(t *testing.T) {
 	})
 }
 
+func TestInitWithSigner(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+	keyDir := filepath.Join(d, "keys")
+	os.MkdirAll(keyDir, os.ModePerm)
+	key, _ := holo.NewAgent(holo.LibP2P, "test-identity", nil)
+	socket := filepath.Join(keyDir, holo.DefaultSignerSocket)
+	signer, err := holo.NewSigner(key.PrivKey(), socket, nil)
+	if err != nil {
+		panic(err)
+	}
+	go signer.Serve()
+	defer signer.Close()
+	root := filepath.Join(d, "service")
+
+	Convey("it should init the service with an agent that signs with the signer", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", root, "init", "-signer", socket, "test-identity"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "Holochain service initialized\n")
+		So(holo.FileExists(root, holo.PrivKeyFileName), ShouldBeFalse)
+		agent, err := holo.LoadAgent(root)
+		So(err, ShouldBeNil)
+		So(agent.AgentType(), ShouldEqual, holo.ExternalSigner)
+		So(agent.PubKey().Equals(key.PubKey()), ShouldBeTrue)
+	})
+}
+
 func TestJoinFromSourceDir(t *testing.T) {
 	d := holo.SetupTestDir()
 	defer os.RemoveAll(d)
@@ -289,6 +318,239 @@ func TestDumpChainAsJSON(t *testing.T) {
 	})
 }
 
+func TestCapability(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+
+	app := setupApp()
+	_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "init", "test-identity"})
+	if err != nil {
+		panic(err)
+	}
+	err = holo.WriteFile([]byte(holo.BasicTemplateAppPackage), d, "appPackage."+holo.BasicTemplateAppPackageFormat)
+	if err != nil {
+		panic(err)
+	}
+	app = setupApp()
+	_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "join", filepath.Join(d, "appPackage."+holo.BasicTemplateAppPackageFormat), "testApp"})
+	if err != nil {
+		panic(err)
+	}
+
+	var token string
+	Convey("it should grant a capability", t, func() {
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "capability", "grant", "testApp", "sampleZome"})
+		So(err.Error(), ShouldEqual, "capability grant: expected zome:function, got sampleZome")
+		app = setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "capability", "grant", "-ttl", "1h", "testApp", "sampleZome:sampleEntryRead"})
+		So(err, ShouldBeNil)
+		token = strings.TrimSpace(out)
+		So(token, ShouldNotEqual, "")
+	})
+
+	Convey("it should list the capabilities", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "capability", "list", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, token+"\n    capability: {\"sampleZome\":{\"sampleEntryRead\":true}}\n    who: anyone\n    expires: ")
+	})
+
+	Convey("it should revoke a capability", t, func() {
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "capability", "revoke", "testApp", token})
+		So(err, ShouldBeNil)
+		app = setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "capability", "list", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "no capabilities\n")
+	})
+}
+
+func TestPeers(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+
+	app := setupApp()
+	_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "init", "test-identity"})
+	if err != nil {
+		panic(err)
+	}
+	err = holo.WriteFile([]byte(holo.BasicTemplateAppPackage), d, "appPackage."+holo.BasicTemplateAppPackageFormat)
+	if err != nil {
+		panic(err)
+	}
+	app = setupApp()
+	_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "join", filepath.Join(d, "appPackage."+holo.BasicTemplateAppPackageFormat), "testApp"})
+	if err != nil {
+		panic(err)
+	}
+	peerID := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1"
+
+	Convey("it should start with no blocked peers", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "peers", "list", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "no blocked peers\n")
+	})
+
+	Convey("it should block a peer", t, func() {
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "peers", "block", "testApp", "not-a-peer"})
+		So(err.Error(), ShouldStartWith, "peers block: invalid peer-id")
+		app = setupApp()
+		_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "peers", "block", "-reason", "spamming", "testApp", peerID})
+		So(err, ShouldBeNil)
+		app = setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "peers", "list", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, peerID+"\n    reason: spamming\n")
+	})
+
+	Convey("it should unblock a peer", t, func() {
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "peers", "unblock", "testApp", peerID})
+		So(err, ShouldBeNil)
+		app = setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "peers", "list", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "no blocked peers\n")
+	})
+}
+
 func runAppWithStdoutCapture(app *cli.App, args []string) (out string, err error) {
 	return cmd.RunAppWithStdoutCapture(app, args, time.Second*5)
 }
+
+func TestAgentRotate(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+
+	app := setupApp()
+	_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "init", "test-identity"})
+	if err != nil {
+		panic(err)
+	}
+	err = holo.WriteFile([]byte(holo.BasicTemplateAppPackage), d, "appPackage."+holo.BasicTemplateAppPackageFormat)
+	if err != nil {
+		panic(err)
+	}
+	app = setupApp()
+	_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "join", filepath.Join(d, "appPackage."+holo.BasicTemplateAppPackageFormat), "testApp"})
+	if err != nil {
+		panic(err)
+	}
+	path := filepath.Join(d, "testApp")
+
+	Convey("it should rotate the agent's key", t, func() {
+		agent, err := holo.LoadAgent(path)
+		So(err, ShouldBeNil)
+		_, oldID, _ := agent.NodeID()
+
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "agent", "rotate", "testApp"})
+		So(err, ShouldBeNil)
+
+		agent, err = holo.LoadAgent(path)
+		So(err, ShouldBeNil)
+		_, newID, _ := agent.NodeID()
+		So(newID, ShouldNotEqual, oldID)
+		So(out, ShouldEqual, newID+"\n")
+		So(holo.FileExists(path, holo.RevokedKeysDir, oldID), ShouldBeTrue)
+	})
+}
+
+func TestAgentEncrypt(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+
+	app := setupApp()
+	_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "init", "test-identity"})
+	if err != nil {
+		panic(err)
+	}
+	passphraseFile := filepath.Join(d, "passphrase")
+	err = holo.WriteFile([]byte("secret"), passphraseFile)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should encrypt the service's agent key with the passphrase", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "-agent-passphrase-file", passphraseFile, "agent", "encrypt"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, fmt.Sprintf("agent key in %s encrypted\n", d))
+		os.Unsetenv(holo.AgentPassphraseFileEnvar)
+
+		_, err = holo.LoadAgent(d)
+		So(err, ShouldEqual, holo.ErrAgentPassphraseRequired)
+		os.Setenv(holo.AgentPassphraseFileEnvar, passphraseFile)
+		defer os.Unsetenv(holo.AgentPassphraseFileEnvar)
+		_, err = holo.LoadAgent(d)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should not encrypt an already encrypted key", t, func() {
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "-agent-passphrase-file", passphraseFile, "agent", "encrypt"})
+		So(err, ShouldEqual, holo.ErrAgentKeyEncrypted)
+		os.Unsetenv(holo.AgentPassphraseFileEnvar)
+	})
+}
+
+func TestAgents(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+
+	app := setupApp()
+	_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "init", "test-identity"})
+	if err != nil {
+		panic(err)
+	}
+	err = holo.WriteFile([]byte(holo.BasicTemplateAppPackage), d, "appPackage."+holo.BasicTemplateAppPackageFormat)
+	if err != nil {
+		panic(err)
+	}
+
+	var botID string
+	Convey("it should create a named agent", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "agent", "new", "bot", "ops-bot"})
+		So(err, ShouldBeNil)
+		agent, err := holo.LoadAgent(filepath.Join(d, holo.AgentsDir, "bot"))
+		So(err, ShouldBeNil)
+		_, botID, _ = agent.NodeID()
+		So(out, ShouldEqual, botID+"\n")
+	})
+
+	Convey("it should list the agents", t, func() {
+		app := setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "agent", "list"})
+		So(err, ShouldBeNil)
+		So(out, ShouldStartWith, "* service ")
+		So(out, ShouldContainSubstring, fmt.Sprintf("  bot %s ops-bot\n", botID))
+	})
+
+	Convey("it should set the default agent", t, func() {
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "agent", "default", "bot"})
+		So(err, ShouldBeNil)
+		app = setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "agent", "default"})
+		So(err, ShouldBeNil)
+		So(out, ShouldEqual, "bot\n")
+		app = setupApp()
+		_, err = runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "agent", "default", "service"})
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should join a chain as the given agent", t, func() {
+		app := setupApp()
+		_, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "join", "-agent", "bot", filepath.Join(d, "appPackage."+holo.BasicTemplateAppPackageFormat), "testApp"})
+		So(err, ShouldBeNil)
+		app = setupApp()
+		out, err := runAppWithStdoutCapture(app, []string{"hcadmin", "-path", d, "status", "testApp"})
+		So(err, ShouldBeNil)
+		So(out, ShouldContainSubstring, "ID Hash: "+botID+"\n")
+	})
+}
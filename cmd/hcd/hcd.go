@@ -6,20 +6,31 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	holo "github.com/holochain/holochain-proto"
 	"github.com/holochain/holochain-proto/cmd"
 	"github.com/holochain/holochain-proto/ui"
 	"github.com/urfave/cli"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 const (
 	defaultUIPort = "3141"
+
+	// loginSecretFileName is the file in the chain's directory a generated login secret is written to
+	loginSecretFileName = "login.secret"
 )
 
 var debug bool
 var verbose bool
+var storePassphraseFile string
+var agentPassphraseFile string
+var agentName string
+var loginSecret string
 
 func setupApp() (app *cli.App) {
 	app = cli.NewApp()
@@ -48,6 +59,27 @@ func setupApp() (app *cli.App) {
 			Usage:       "verbose output",
 			Destination: &verbose,
 		},
+		cli.StringFlag{
+			Name:        "login-secret",
+			Usage:       "secret for logging in to call authenticated functions (default: a random secret written to the chain's " + loginSecretFileName + " file)",
+			EnvVar:      "HCD_LOGIN_SECRET",
+			Destination: &loginSecret,
+		},
+		cli.StringFlag{
+			Name:        "store-passphrase-file",
+			Usage:       "file containing the passphrase for the passphrase store encryption (default: $" + holo.StorePassphraseEnvar + " or prompt)",
+			Destination: &storePassphraseFile,
+		},
+		cli.StringFlag{
+			Name:        "agent-passphrase-file",
+			Usage:       "file containing the passphrase agent keys are encrypted with (default: $" + holo.AgentPassphraseEnvar + " or prompt)",
+			Destination: &agentPassphraseFile,
+		},
+		cli.StringFlag{
+			Name:        "agent",
+			Usage:       "name of the service agent to bind the chain to before serving it",
+			Destination: &agentName,
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
@@ -58,6 +90,14 @@ func setupApp() (app *cli.App) {
 		if verbose {
 			fmt.Printf("hcd version %s \n", app.Version)
 		}
+		if storePassphraseFile != "" {
+			os.Setenv(holo.StorePassphraseFileEnvar, storePassphraseFile)
+		}
+		if agentPassphraseFile != "" {
+			os.Setenv(holo.AgentPassphraseFileEnvar, agentPassphraseFile)
+		}
+		holo.StorePassphrase = cmd.GetStorePassphrase
+		holo.AgentPassphrase = cmd.GetAgentPassphrase
 		var err error
 		root, err = cmd.GetHolochainRoot(root)
 		if err != nil {
@@ -73,6 +113,12 @@ func setupApp() (app *cli.App) {
 	app.Action = func(c *cli.Context) error {
 		args := len(c.Args())
 		if args == 1 || args == 2 {
+			if agentName != "" {
+				err := service.SetChainAgent(c.Args().First(), agentName)
+				if err != nil {
+					return err
+				}
+			}
 			h, err := cmd.GetHolochain(c.Args().First(), service, "serve")
 			if err != nil {
 				return err
@@ -97,6 +143,18 @@ func setupApp() (app *cli.App) {
 			fmt.Printf("Serving holochain with DNA hash:%v on port %s\n", h.DNAHash(), port)
 
 			ws := ui.NewWebServer(h, port)
+			if loginSecret == "" {
+				loginSecret, err = makeLoginSecret()
+				if err != nil {
+					return err
+				}
+				path := filepath.Join(h.RootPath(), loginSecretFileName)
+				if err = writeLoginSecret(path, loginSecret); err != nil {
+					return err
+				}
+				fmt.Printf("Login secret for authenticated functions written to: %s\n", path)
+			}
+			ws.SetLoginSecret(loginSecret)
 			ws.Start()
 			ws.Wait()
 			return err
@@ -110,6 +168,27 @@ func setupApp() (app *cli.App) {
 	return
 }
 
+// makeLoginSecret returns a random secret for logging in to the web server
+func makeLoginSecret() (secret string, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	secret = hex.EncodeToString(b)
+	return
+}
+
+// writeLoginSecret writes the secret to a file only its owner can read, rather than
+// printing it where it could end up in terminal scrollback or logs
+func writeLoginSecret(path string, secret string) (err error) {
+	// remove any old file so its permissions don't carry over
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	err = ioutil.WriteFile(path, []byte(secret+"\n"), 0600)
+	return
+}
+
 func main() {
 	app := setupApp()
 
@@ -65,3 +65,23 @@ func setupTestingApp(name string) (string, *holo.Service, *holo.Holochain, *cli.
 	app := setupApp()
 	return tmpTestDir, s, h, app
 }
+
+func TestWriteLoginSecret(t *testing.T) {
+	d, err := ioutil.TempDir("", "hcd")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(d)
+	path := filepath.Join(d, loginSecretFileName)
+
+	Convey("it should write the secret so only its owner can read it", t, func() {
+		So(ioutil.WriteFile(path, []byte("old"), 0644), ShouldBeNil)
+		So(writeLoginSecret(path, "sesame"), ShouldBeNil)
+		info, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, "sesame\n")
+	})
+}
@@ -37,6 +37,7 @@ var debug, appInitialized, verbose, keepalive bool
 var keepaliveCleanup func()
 var rootPath, devPath, name string
 var bridgeSpecsFile string
+var agentPassphraseFile string
 var scenarioConfig *holo.TestConfig
 
 // flags for holochain config generation
@@ -165,6 +166,11 @@ func setupApp() (app *cli.App) {
 			Usage:       "value to use for the agent identity (automatically set in scenario testing)",
 			Destination: &agentID,
 		},
+		cli.StringFlag{
+			Name:        "agent-passphrase-file",
+			Usage:       "file containing the passphrase agent keys are encrypted with (default: $" + holo.AgentPassphraseEnvar + ")",
+			Destination: &agentPassphraseFile,
+		},
 	}
 
 	var dumpChain, dumpDHT, initTest, fromDevelop, benchmarks, json bool
@@ -935,6 +941,18 @@ func setupApp() (app *cli.App) {
 			}
 		}
 
+		if agentPassphraseFile != "" {
+			os.Setenv(holo.AgentPassphraseFileEnvar, agentPassphraseFile)
+		}
+		// the keys hcdev generates are throw-away development keys so only
+		// prompt for a passphrase when loading a key that is already encrypted
+		holo.AgentPassphrase = func(newKey bool) (string, error) {
+			if newKey {
+				return holo.AgentPassphraseFromEnv(newKey)
+			}
+			return cmd.GetAgentPassphrase(newKey)
+		}
+
 		holo.Debugf("args:%v\n", c.Args())
 
 		// hcdev always enables the app debugging, and the -debug flag enables the holochain debugging
@@ -0,0 +1,146 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//---------------------------------------------------------------------------------------
+// reference signer that holds an agent's private key and signs for holochain nodes
+// over a unix socket
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	holo "github.com/holochain/holochain-proto"
+	"github.com/holochain/holochain-proto/cmd"
+	"github.com/urfave/cli"
+	"os"
+	"os/signal"
+	"path/filepath"
+)
+
+var debug bool
+var agentPassphraseFile string
+
+func setupApp() (app *cli.App) {
+	app = cli.NewApp()
+	app.Name = "hcsigner"
+	app.Usage = "holds an agent's private key and signs for holochain nodes over a unix socket"
+	app.Version = fmt.Sprintf("0.0.1 (holochain %s)", holo.VersionStr)
+
+	var socket string
+
+	app.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:        "debug",
+			Usage:       "log signing requests",
+			Destination: &debug,
+		},
+		cli.StringFlag{
+			Name:        "agent-passphrase-file",
+			Usage:       "file containing the passphrase the key is encrypted with (default: $" + holo.AgentPassphraseEnvar + " or prompt)",
+			Destination: &agentPassphraseFile,
+		},
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:      "init",
+			Aliases:   []string{"i"},
+			ArgsUsage: "key-dir agent-id",
+			Usage:     "generate a key for the signer to hold",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("init: requires two arguments: key-dir agent-id")
+				}
+				dir := c.Args()[0]
+				if holo.FileExists(dir, holo.PrivKeyFileName) {
+					return fmt.Errorf("init: %s already has a key", dir)
+				}
+				if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+					return err
+				}
+				agent, err := holo.NewAgent(holo.LibP2P, holo.AgentIdentity(c.Args()[1]), nil)
+				if err != nil {
+					return err
+				}
+				err = holo.SaveAgent(dir, agent)
+				if err != nil {
+					return err
+				}
+				_, nodeIDStr, err := agent.NodeID()
+				if err != nil {
+					return err
+				}
+				fmt.Println(nodeIDStr)
+				return nil
+			},
+		},
+		{
+			Name:      "serve",
+			Aliases:   []string{"s"},
+			ArgsUsage: "key-dir",
+			Usage:     "sign with the key in key-dir for nodes that connect to the socket",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "socket",
+					Usage:       "path of the socket to listen on (default: key-dir/" + holo.DefaultSignerSocket + ")",
+					Destination: &socket,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 1 {
+					return errors.New("serve: requires one argument: key-dir")
+				}
+				dir := c.Args().First()
+				agent, err := holo.LoadAgent(dir)
+				if err != nil {
+					return err
+				}
+				if socket == "" {
+					socket = filepath.Join(dir, holo.DefaultSignerSocket)
+				}
+
+				log := holo.Logger{Enabled: debug}
+				log.New(nil)
+				signer, err := holo.NewSigner(agent.PrivKey(), socket, &log)
+				if err != nil {
+					return err
+				}
+				interrupt := make(chan os.Signal, 1)
+				signal.Notify(interrupt, os.Interrupt)
+				go func() {
+					<-interrupt
+					signer.Close()
+				}()
+
+				_, nodeIDStr, err := agent.NodeID()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Signing for %s on %s\n", nodeIDStr, socket)
+				signer.Serve()
+				os.Remove(socket)
+				return nil
+			},
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		if agentPassphraseFile != "" {
+			os.Setenv(holo.AgentPassphraseFileEnvar, agentPassphraseFile)
+		}
+		holo.AgentPassphrase = cmd.GetAgentPassphrase
+		return nil
+	}
+
+	return
+}
+
+func main() {
+	app := setupApp()
+
+	err := app.Run(os.Args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	holo "github.com/holochain/holochain-proto"
+	"github.com/holochain/holochain-proto/cmd"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetupApp(t *testing.T) {
+	app := setupApp()
+	Convey("it should create the cli App", t, func() {
+		So(app.Name, ShouldEqual, "hcsigner")
+	})
+}
+
+func TestInit(t *testing.T) {
+	d := holo.SetupTestDir()
+	defer os.RemoveAll(d)
+
+	Convey("it should generate a key for the signer", t, func() {
+		app := setupApp()
+		out, err := cmd.RunAppWithStdoutCapture(app, []string{"hcsigner", "init", d, "test-identity"}, 5*time.Second)
+		So(err, ShouldBeNil)
+		agent, err := holo.LoadAgent(d)
+		So(err, ShouldBeNil)
+		_, nodeIDStr, _ := agent.NodeID()
+		So(out, ShouldEqual, nodeIDStr+"\n")
+	})
+
+	Convey("it should not replace an existing key", t, func() {
+		app := setupApp()
+		_, err := cmd.RunAppWithStdoutCapture(app, []string{"hcsigner", "init", d, "test-identity"}, 5*time.Second)
+		So(err, ShouldNotBeNil)
+	})
+}
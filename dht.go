@@ -8,11 +8,15 @@ package holochain
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"github.com/tidwall/buntdb"
 	"gopkg.in/mgo.v2/bson"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
@@ -26,19 +30,127 @@ type DHTConfig struct {
 	//RedundancyFactor(integer) Establishes minimum online redundancy targets for data, and size of peer sets for sync gossip. A redundancy factor ZERO means no sharding (every node syncs all data with every other node). ONE means you are running this as a centralized application and gossip is turned OFF. For most applications we recommend neighborhoods no smaller than 8 for nearness or 32 for hashmask sharding.
 	RedundancyFactor int
 
-	// ShardingMethod : Identifier for sharding method (none, XOR, hashmask, other nearness algorithms?, etc.)
+	// ShardingMethod : (string) Identifier for sharding method, one of XOR or hashmask. Empty means DefaultShardingMethod.
+	ShardingMethod string
 
-	// MaxLinkSets : (integer) Maximum number of results to return on a GetLinks query to keep computation and traffic to a reasonable size. You need to break these result sets into multiple "pages" of results retrieve more.
+	// GossipMethod : (string) How nodes find out about the changes their neighbors hold, one of puts or digest. With puts, a node is sent every change a neighbor has made since they last gossiped. With digest, nodes compare digests of the changes they hold over ranges of hash-space and fetch only the changes they are missing, which is much cheaper for new nodes and ones that have been offline. Empty means DefaultGossipMethod.
+	GossipMethod string
 
-	// ValidationTimeout : (integer) Time period in seconds, until data that needs to be validated against a source remains "alive" to keep trying to get validation from that source. If someone commits something and then goes offline, how long do they have to come back online before DHT sync requests consider that data invalid?
+	// MaxLinkSets : (integer) Maximum number of results to return on a GetLinks query to keep computation and traffic to a reasonable size. You need to break these result sets into multiple "pages" of results retrieve more. Zero means DefaultMaxLinkSets.
+	MaxLinkSets int
 
-	//PeerTimeout : (integer) Time period in seconds, until a node drops a peer from its neighborhood list for failing to respond to gossip requests.
+	// ValidationTimeout : (integer) Time period in seconds, until data that needs to be validated against a source remains "alive" to keep trying to get validation from that source. If someone commits something and then goes offline, how long do they have to come back online before the puts they sent of that data are dropped? Zero means DefaultValidationTimeout.
+	ValidationTimeout int
 
-	// WireEncryption : settings for point-to-point encryption of messages on the network (none, AES, what are the options?)
+	//PeerTimeout : (integer) Time period in seconds, until a node drops a peer from its neighborhood list for failing to respond to gossip requests. Zero means DefaultPeerTimeout.
+	PeerTimeout int
 
-	// DataEncryption : What are the options for encrypting data at rest in the dht.db that don't break db functionality? Is there really a point to trying to do this?
+	// WireEncryption : (string) settings for point-to-point encryption of messages on the network, one of none or AES-GCM. Empty means none. When set, nodes refuse streams from peers that can't negotiate it.
+	WireEncryption string
 
-	// MaxEntrySize : Sets the maximum allowable size of entries for this holochain
+	// Encoding : (string) encoding of messages sent on the network and of the entries and chains nodes store, one of gob or cbor. Empty means gob. Messages are only accepted in this encoding, but stored entries and chains are read in either.
+	Encoding string
+
+	// ReputationThreshold : (integer) Reputation score below which a peer is automatically added to the blocklist. Peers start at zero and lose points for sending data that fails validation, sending malformed gossip and not answering, and gain them for agreeing to hold data. Scores decay back towards zero over time. Must be negative, zero means DefaultReputationThreshold.
+	ReputationThreshold int
+
+	// MaxEntrySize : (integer) Sets the maximum allowable size in bytes of entries for this holochain. Zero means DefaultMaxEntrySize.
+	MaxEntrySize int
+
+	// BlobChunkSize : (integer) Size in bytes of the chunks that entries with the blob data format are split into, which are published and fetched separately. Zero means DefaultBlobChunkSize. Chunks are kept smaller than MaxEntrySize.
+	BlobChunkSize int
+
+	// BlockWarrantThreshold : (integer) Number of different peers in good standing that must send block warrants for a peer before it is blocked for a while. Block warrants only attest to the blocker's own decision so a single one never blocks a peer. Zero means DefaultBlockWarrantThreshold.
+	BlockWarrantThreshold int
+
+	// BlockWarrantWindow : (integer) Time period in seconds that block warrants count towards blocking a peer for. Older warrants, and ones from the future, are rejected. Zero means DefaultBlockWarrantWindow.
+	BlockWarrantWindow int
+}
+
+const (
+	DefaultMaxLinkSets       = 1000
+	DefaultValidationTimeout = 60 * 60 // one hour
+	DefaultPeerTimeout       = 60 * 10 // ten minutes
+	DefaultMaxEntrySize      = 10 * 1024 * 1024
+)
+
+// maxLinkSets returns the configured MaxLinkSets or the default
+func (c *DHTConfig) maxLinkSets() int {
+	if c.MaxLinkSets <= 0 {
+		return DefaultMaxLinkSets
+	}
+	return c.MaxLinkSets
+}
+
+// validationTimeout returns the configured ValidationTimeout or the default
+func (c *DHTConfig) validationTimeout() time.Duration {
+	if c.ValidationTimeout <= 0 {
+		return DefaultValidationTimeout * time.Second
+	}
+	return time.Duration(c.ValidationTimeout) * time.Second
+}
+
+// peerTimeout returns the configured PeerTimeout or the default
+func (c *DHTConfig) peerTimeout() time.Duration {
+	if c.PeerTimeout <= 0 {
+		return DefaultPeerTimeout * time.Second
+	}
+	return time.Duration(c.PeerTimeout) * time.Second
+}
+
+// maxEntrySize returns the configured MaxEntrySize or the default
+func (c *DHTConfig) maxEntrySize() int {
+	if c.MaxEntrySize <= 0 {
+		return DefaultMaxEntrySize
+	}
+	return c.MaxEntrySize
+}
+
+// failureTracker records when things (peers, hashes) started failing so they
+// can be given up on once they have been failing for longer than a timeout
+type failureTracker struct {
+	lk    sync.Mutex
+	since map[string]time.Time
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{since: make(map[string]time.Time)}
+}
+
+// failed records a failure of key and returns true if it has been failing for longer than timeout
+// in which case the key is forgotten
+func (f *failureTracker) failed(key string, timeout time.Duration) (expired bool) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	first, ok := f.since[key]
+	if !ok {
+		f.since[key] = time.Now()
+		return
+	}
+	expired = time.Since(first) > timeout
+	if expired {
+		delete(f.since, key)
+	}
+	return
+}
+
+// succeeded clears any failures recorded for key
+func (f *failureTracker) succeeded(key string) {
+	f.lk.Lock()
+	delete(f.since, key)
+	f.lk.Unlock()
+}
+
+// sweep forgets keys that started failing longer ago than age, for the ones that
+// were given up on without expiring
+func (f *failureTracker) sweep(age time.Duration) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	for key, first := range f.since {
+		if time.Since(first) > age {
+			delete(f.since, key)
+		}
+	}
 }
 
 type gossipWithReq struct {
@@ -51,26 +163,34 @@ type Channel chan interface{}
 type DHT struct {
 	h           *Holochain // pointer to the holochain this DHT is part of
 	ht          HashTable
-	retryQueue  chan *retry
-	changeQueue Channel
-	gossipPuts  Channel
+	retryQueue  *dhtQueue
+	changeQueue *dhtQueue
+	gossipPuts  *dhtQueue
+	queueDB     *buntdb.DB
 	glog        *Logger // the gossip logger
 	dlog        *Logger // the dht logger
 	gchan       Channel
 	config      *DHTConfig
+	sharding    ShardingMethod
 	glk         sync.RWMutex
+	digests     *gossipDigests // digests of the changes we hold for anti-entropy gossip
+
+	gossipFailures     *failureTracker // gossipers that aren't answering
+	validationFailures *failureTracker // entries whose source isn't answering validation requests
+	headers            *headerWatch    // headers agents have sent us, for spotting forked chains
 	//	sources      map[peer.ID]bool
 	//	fingerprints map[string]bool
 }
 
+// changeReq and retry are exported for gob so that they can be persisted in the DHT's queues
 type changeReq struct {
-	key Hash
-	msg Message
+	Key Hash
+	Msg Message
 }
 
 type retry struct {
-	msg     Message
-	retries int
+	Msg     Message
+	Retries int
 }
 
 const (
@@ -104,13 +224,24 @@ type GetResp struct {
 	FollowHash string // hash of new entry if the entry was modified and needs following
 }
 
+const (
+	// constants for the order of getLinks results
+
+	LinkOrderDefault = ""     // order by link hash then tag
+	LinkOrderTag     = "tag"  // order by tag then link hash
+	LinkOrderTime    = "time" // order by the time of the last change to the link
+)
+
 // LinkQuery holds a getLinks query
 type LinkQuery struct {
 	Base       Hash
 	T          string
 	StatusMask int
-	// order
-	// filter, etc
+	TagPrefix  string // only return links whose tag starts with this prefix (used when T is empty)
+	Order      string // one of the LinkOrder constants
+	Descending bool   // reverse the order of the results
+	Count      int    // maximum number of links to return, 0 means all of them (up to MaxLinkSets)
+	Cursor     string // cursor from a previous LinkQueryResp, to get the next page of results
 }
 
 // GetOptions options to holochain level Get functions
@@ -119,17 +250,24 @@ type GetOptions struct {
 	GetMask    int  // mask of what to include in the response
 	Local      bool // bool if get should happen from chain not DHT
 	Bundle     bool // bool if get should happen from bundle not DHT
+	History    bool // bool if get should return the entry's revision history instead of the entry
 }
 
 // GetLinksOptions options to holochain level GetLinks functions
 type GetLinksOptions struct {
-	Load       bool // indicates whether GetLinks should retrieve the entries of all links
-	StatusMask int  // mask of which status of links to return
+	Load       bool   // indicates whether GetLinks should retrieve the entries of all links
+	StatusMask int    // mask of which status of links to return
+	TagPrefix  string // only return links whose tag starts with this prefix
+	Order      string // order of the links returned, one of the LinkOrder constants
+	Descending bool   // reverse the order of the links returned
+	Count      int    // maximum number of links to return per page
+	Cursor     string // cursor returned with the previous page
 }
 
 // LinkQueryResp holds response to getLinks query
 type LinkQueryResp struct {
-	Links []TaggedHash
+	Links  []TaggedHash
+	Cursor string // set if there are more links to get, pass it back in LinkQuery for the next page
 }
 
 type ListAddReq struct {
@@ -145,15 +283,23 @@ var AlphaValue int = 3
 const (
 	GossipWithQueueSize = 10
 	GossipPutQueueSize  = 1000
+	ChangeQueueSize     = 100
+	RetryQueueSize      = 100
 )
 
 var ErrNotAcceptedByAnyRemoteNode = errors.New("Change not accepted by any remote node")
+var ErrLinkOrderUnknown = errors.New("unknown link order")
+var ErrLinkCursorInvalid = errors.New("invalid link cursor")
+var ErrLinksCapped = errors.New("more links than MaxLinkSets, use the Count and Cursor options to page through them")
 
 // NewDHT creates a new DHT structure
-func NewDHT(h *Holochain) *DHT {
+func NewDHT(h *Holochain) (*DHT, error) {
 	dht := DHT{}
-	dht.Open(h)
-	return &dht
+	err := dht.Open(h)
+	if err != nil {
+		return nil, err
+	}
+	return &dht, nil
 }
 
 // Open sets up the DHTs data structures and store
@@ -163,17 +309,31 @@ func (dht *DHT) Open(options interface{}) (err error) {
 	dht.glog = &h.Config.Loggers.Gossip
 	dht.dlog = &h.Config.Loggers.DHT
 	dht.config = &h.Nucleus().DNA().DHTConfig
+	dht.sharding, err = GetShardingMethod(dht.config.ShardingMethod)
+	if err != nil {
+		return
+	}
 
-	dht.ht = &BuntHT{}
-	dht.ht.Open(filepath.Join(h.DBPath(), DHTStoreFileName))
-	dht.retryQueue = make(chan *retry, 100)
-	dht.changeQueue = make(Channel, 100)
+	dht.ht, err = CreateHashTable(h.Config.DHTStore, h.DBPath())
+	if err != nil {
+		return
+	}
+	if h.storeCipher != nil {
+		dht.ht = newEncryptedHT(dht.ht, h.storeCipher.forStore(DHTStoreFileName, DHTBoltStoreFileName))
+	}
+	err = dht.openQueues(filepath.Join(h.DBPath(), DHTQueueFileName))
+	if err != nil {
+		return
+	}
 	//go dht.HandleChangeRequests()
 
 	//	dht.sources = make(map[peer.ID]bool)
 	//	dht.fingerprints = make(map[string]bool)
 	dht.gchan = make(Channel, GossipWithQueueSize)
-	dht.gossipPuts = make(Channel, GossipPutQueueSize)
+	dht.digests = newGossipDigests()
+	dht.gossipFailures = newFailureTracker()
+	dht.validationFailures = newFailureTracker()
+	dht.headers = newHeaderWatch()
 	return
 }
 
@@ -229,7 +389,7 @@ func (dht *DHT) SetupDHT() (err error) {
 	}
 
 	var b []byte
-	b, err = e.Marshal()
+	b, err = marshalEntryAs(e, dht.h.nucleus.dna.DHTConfig.Encoding)
 	if err != nil {
 		return
 	}
@@ -306,9 +466,45 @@ func (dht *DHT) GetLinks(base Hash, tag string, statusMask int) (results []Tagge
 	return
 }
 
+// QueryLinks retrieves the links of a LinkQuery, filtered by tag prefix, ordered and paged
+// returns a cursor for the next page if there are more links than the query's Count
+// the number of links returned is capped at the DNA's MaxLinkSets
+func (dht *DHT) QueryLinks(query *LinkQuery) (results []TaggedHash, cursor string, err error) {
+	lq := *query
+	max := dht.config.maxLinkSets()
+	if lq.Count <= 0 || lq.Count > max {
+		lq.Count = max
+	}
+	dht.dlog.Logf("queryLinks on %v of %s with mask %d", lq.Base, lq.T, lq.StatusMask)
+	results, cursor, err = dht.ht.QueryLinks(&lq)
+	return
+}
+
+// encodeLinkCursor makes an opaque cursor pointing after the link with the given order key
+func encodeLinkCursor(order string, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(order + ":" + key))
+}
+
+// decodeLinkCursor returns the order key of a cursor, checking that it was made for the given order
+func decodeLinkCursor(cursor string, order string) (key string, err error) {
+	var b []byte
+	b, err = base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		err = ErrLinkCursorInvalid
+		return
+	}
+	x := strings.SplitN(string(b), ":", 2)
+	if len(x) != 2 || x[0] != order {
+		err = ErrLinkCursorInvalid
+		return
+	}
+	key = x[1]
+	return
+}
+
 // HandleChangeRequests waits on a channel for dht change requests
 func (dht *DHT) HandleChangeRequests() (err error) {
-	err = dht.handleTillDone("HandleChangeRequests", dht.changeQueue, handleChangeRequests)
+	err = dht.handleQueueTillDone("HandleChangeRequests", dht.changeQueue, handleChangeRequests)
 	return
 }
 
@@ -334,6 +530,8 @@ func (dht *DHT) sendChange(p peer.ID, msg *Message) (held bool, err error) {
 			if t.Code == ReceiptRejected {
 				// TODO what else do we do if rejected?
 				dht.dlog.Logf("DHT send of %v failed to peer %v was rejected", msg, p)
+			} else {
+				dht.ratePeer(p, ReputationHoldReceipt)
 			}
 			held = true
 			// TODO check the signature on the receipt
@@ -355,8 +553,8 @@ func (dht *DHT) sendChange(p peer.ID, msg *Message) (held bool, err error) {
 }
 
 func (dht *DHT) change(req changeReq) (err error) {
-	key := req.key
-	msg := &req.msg
+	key := req.Key
+	msg := &req.Msg
 	node := dht.h.node
 	pchan, err := node.GetClosestPeers(node.ctx, key)
 	if err != nil {
@@ -405,7 +603,9 @@ func (dht *DHT) Change(key Hash, msgType MsgType, body interface{}) (err error)
 		dht.dlog.Logf("DHT send of %v to self failed with error: %s", msgType, err)
 		err = nil
 	}*/
-	dht.changeQueue <- changeReq{msg: *msg, key: key}
+	// wait for room in the queue so that committing slows down rather than fails
+	// when the network can't keep up
+	err = dht.changeQueue.push(changeReq{Msg: *msg, Key: key}, true)
 
 	return
 }
@@ -527,14 +727,12 @@ func (dht *DHT) JSON() (result string, err error) {
 
 // Close cleans up the DHT
 func (dht *DHT) Close() {
-	close(dht.changeQueue)
-	dht.changeQueue = nil
-	close(dht.retryQueue)
-	dht.retryQueue = nil
+	dht.changeQueue.close()
+	dht.retryQueue.close()
 	close(dht.gchan)
 	dht.gchan = nil
-	close(dht.gossipPuts)
-	dht.gossipPuts = nil
+	dht.gossipPuts.close()
+	dht.queueDB.Close()
 	dht.ht.Close()
 }
 
@@ -553,14 +751,29 @@ func (dht *DHT) GetIdxMessage(idx int) (msg Message, err error) {
 // RetryTask checks to see if there are any received puts that need retrying and does one if so
 func RetryTask(h *Holochain) {
 	dht := h.dht
-	if dht != nil && len(dht.retryQueue) > 0 {
-		r := <-dht.retryQueue
-		if r.retries > 0 {
-			resp, err := actionReceiver(dht.h, &r.msg, r.retries-1)
-			dht.dlog.Logf("retry %d of %v, response: %d error: %v", r.retries, r.msg, resp, err)
+	if dht == nil {
+		return
+	}
+	// forget sources whose puts were dropped without their failures expiring
+	dht.validationFailures.sweep(2 * dht.config.validationTimeout())
+	// the retry is only removed from the queue once it's done, and a retry that fails
+	// again queues a new retry itself, so the handler always succeeds
+	_, err := dht.retryQueue.handle(func(x interface{}) error {
+		r := x.(*retry)
+		if r.Retries > 0 {
+			resp, err := actionReceiver(dht.h, &r.Msg, r.Retries-1)
+			dht.dlog.Logf("retry %d of %v, response: %d error: %v", r.Retries, r.Msg, resp, err)
 		} else {
-			dht.dlog.Logf("max retries for %v, ignoring", r.msg)
+			dht.dlog.Logf("max retries for %v, ignoring", r.Msg)
+			if r.Msg.Type == PUT_REQUEST {
+				// the put is given up on so stop tracking its source's failures
+				dht.validationFailures.succeeded(validationFailureKey(r.Msg.Body.(HoldReq).EntryHash, r.Msg.From))
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		dht.dlog.Logf("error getting retry: %v", err)
 	}
 }
 
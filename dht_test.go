@@ -11,6 +11,7 @@ import (
 
 	. "github.com/holochain/holochain-proto/hash"
 	b58 "github.com/jbenet/go-base58"
+	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -22,11 +23,28 @@ func TestNewDHT(t *testing.T) {
 
 	Convey("It should initialize the DHT struct and data store", t, func() {
 		So(FileExists(h.DBPath(), DHTStoreFileName), ShouldBeFalse)
-		dht := NewDHT(h)
+		dht, err := NewDHT(h)
+		So(err, ShouldBeNil)
 		So(FileExists(h.DBPath(), DHTStoreFileName), ShouldBeTrue)
 		So(dht.h, ShouldEqual, h)
 		So(dht.config, ShouldEqual, &h.nucleus.dna.DHTConfig)
 	})
+
+	Convey("It should use the DHT store set in the config", t, func() {
+		h.Config.DHTStore = BoltHTType
+		So(FileExists(h.DBPath(), DHTBoltStoreFileName), ShouldBeFalse)
+		dht, err := NewDHT(h)
+		So(err, ShouldBeNil)
+		defer dht.ht.Close()
+		So(FileExists(h.DBPath(), DHTBoltStoreFileName), ShouldBeTrue)
+		_, ok := dht.ht.(*BoltHT)
+		So(ok, ShouldBeTrue)
+
+		h.Config.DHTStore = "foo"
+		_, err = NewDHT(h)
+		So(err.Error(), ShouldEqual, "Invalid DHT store type. Must be one of: bolt, buntdb")
+		h.Config.DHTStore = ""
+	})
 }
 
 func TestSetupDHT(t *testing.T) {
@@ -349,8 +367,32 @@ func TestActionReceiver(t *testing.T) {
 		r, err := ActionReceiver(h, m)
 		So(err, ShouldBeNil)
 		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
-		So(len(h.dht.retryQueue), ShouldEqual, 1)
-		<-h.dht.retryQueue // unload the queue
+		So(h.dht.retryQueue.Len(), ShouldEqual, 1)
+		h.dht.retryQueue.pop() // unload the queue
+	})
+
+	Convey("PUT_REQUEST whose source doesn't answer should be retried and then dropped", t, func() {
+		e := GobEntry{C: "326"}
+		hash, _ := e.Sum(h.hashSpec)
+		m := h.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash})
+		m.From, _ = makePeer("offlinePeer")
+		r, err := actionReceiver(h, m, 3)
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, DHTChangeValidationQueuedForRetry)
+		x, _, err := h.dht.retryQueue.pop()
+		So(err, ShouldBeNil)
+		So(x.(*retry).Retries, ShouldEqual, 3)
+
+		// another source failing to answer is tracked separately
+		key := validationFailureKey(hash, m.From)
+		other, _ := makePeer("otherOfflinePeer")
+		So(key, ShouldNotEqual, validationFailureKey(hash, other))
+
+		h.dht.validationFailures.since[key] = time.Now().Add(-2 * h.dht.config.validationTimeout())
+		_, err = actionReceiver(h, m, 2)
+		So(err, ShouldNotBeNil)
+		So(h.dht.retryQueue.Len(), ShouldEqual, 0)
+		So(h.dht.Exists(hash, StatusAny), ShouldEqual, ErrHashNotFound)
 	})
 
 	Convey("GETLINK_REQUEST should retrieve link values", t, func() {
@@ -385,6 +427,38 @@ func TestActionReceiver(t *testing.T) {
 		So(l4star.T, ShouldEqual, "4stars")
 	})
 
+	Convey("GETLINK_REQUEST with tag prefix should retrieve only matching linked values", t, func() {
+		mq := LinkQuery{Base: hash, TagPrefix: "3"}
+		m := h.node.NewMessage(GETLINK_REQUEST, mq)
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results := r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 1)
+		So(results.Links[0].H, ShouldEqual, h.agentHash.String())
+		So(results.Links[0].T, ShouldEqual, "3stars")
+		So(results.Cursor, ShouldEqual, "")
+	})
+
+	Convey("GETLINK_REQUEST results should be capped at MaxLinkSets", t, func() {
+		h.nucleus.dna.DHTConfig.MaxLinkSets = 1
+		defer func() { h.nucleus.dna.DHTConfig.MaxLinkSets = 0 }()
+		mq := LinkQuery{Base: hash, T: ""}
+		m := h.node.NewMessage(GETLINK_REQUEST, mq)
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results := r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 1)
+		So(results.Cursor, ShouldNotEqual, "")
+
+		mq.Cursor = results.Cursor
+		m = h.node.NewMessage(GETLINK_REQUEST, mq)
+		r, err = ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results = r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 1)
+		So(results.Cursor, ShouldEqual, "")
+	})
+
 	Convey("GOSSIP_REQUEST should request and advertise data by idx", t, func() {
 		g := GossipReq{MyIdx: 1, YourIdx: 2}
 		m := h.node.NewMessage(GOSSIP_REQUEST, g)
@@ -431,8 +505,8 @@ func TestActionReceiver(t *testing.T) {
 		r, err := ActionReceiver(h, m)
 		So(err, ShouldBeNil)
 		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
-		So(len(h.dht.retryQueue), ShouldEqual, 1)
-		<-h.dht.retryQueue // unload the queue
+		So(h.dht.retryQueue.Len(), ShouldEqual, 1)
+		h.dht.retryQueue.pop() // unload the queue
 	})
 
 	// put a second entry to DHT
@@ -480,7 +554,7 @@ func TestActionReceiver(t *testing.T) {
 		r, err := ActionReceiver(h, m)
 		So(err, ShouldBeNil)
 		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
-		So(len(h.dht.retryQueue), ShouldEqual, 1)
+		So(h.dht.retryQueue.Len(), ShouldEqual, 1)
 	})
 
 	Convey("LISTADD_REQUEST with bad warrant should return error", t, func() {
@@ -514,6 +588,77 @@ func TestActionReceiver(t *testing.T) {
 
 	})
 
+	Convey("LISTADD_REQUEST with a block warrant should only be accepted from the blocker", t, func() {
+		pid, _ := makePeer("blockedPeer")
+		w, err := NewBlockWarrant(h, []peer.ID{pid}, "spamming")
+		So(err, ShouldBeNil)
+		data, _ := w.Encode()
+		req := ListAddReq{
+			ListType:    BlockedList,
+			Peers:       []string{peer.IDB58Encode(pid)},
+			WarrantType: BlockType,
+			Warrant:     data,
+		}
+		m := h.node.NewMessage(LISTADD_REQUEST, req)
+		m.From, _ = makePeer("otherPeer")
+		_, err = ActionReceiver(h, m)
+		So(err.Error(), ShouldEqual, "List add request rejected on warrant failure: block warrant is not from the sender")
+		So(h.node.IsBlocked(pid), ShouldBeFalse)
+
+		other, _ := makePeer("otherBlockedPeer")
+		req.Peers = append(req.Peers, peer.IDB58Encode(other))
+		_, err = ActionReceiver(h, h.node.NewMessage(LISTADD_REQUEST, req))
+		So(err.Error(), ShouldEqual, fmt.Sprintf("List add request rejected on warrant failure: block warrant doesn't cover %s", peer.IDB58Encode(other)))
+
+		req.Peers = req.Peers[:1]
+		r, err := ActionReceiver(h, h.node.NewMessage(LISTADD_REQUEST, req))
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, DHTChangeOK)
+		So(h.node.IsBlocked(pid), ShouldBeFalse)
+		peerList, err := h.dht.getList(BlockedList)
+		So(err, ShouldBeNil)
+		So(len(peerList.Records), ShouldEqual, 0)
+	})
+
+	Convey("LISTADD_REQUEST with block warrants should only block once enough peers have sent them", t, func() {
+		pid, _ := makePeer("warrantedPeer")
+		send := func(name string, at time.Time) (interface{}, error) {
+			from, key := makePeer(name)
+			w := signedBlockWarrant(key, pid, at)
+			data, _ := w.Encode()
+			m := h.node.NewMessage(LISTADD_REQUEST, ListAddReq{
+				ListType:    BlockedList,
+				Peers:       []string{peer.IDB58Encode(pid)},
+				WarrantType: BlockType,
+				Warrant:     data,
+			})
+			m.From = from
+			return ActionReceiver(h, m)
+		}
+
+		_, err := send("blocker1", time.Now().Add(-2*DefaultBlockWarrantWindow*time.Second))
+		So(err.Error(), ShouldEqual, "List add request rejected on warrant failure: block warrant is too old or from the future")
+		_, err = send("blocker1", time.Now().Add(time.Hour))
+		So(err.Error(), ShouldEqual, "List add request rejected on warrant failure: block warrant is too old or from the future")
+
+		for i := 1; i < DefaultBlockWarrantThreshold; i++ {
+			r, err := send(fmt.Sprintf("blocker%d", i), time.Now())
+			So(err, ShouldBeNil)
+			So(r, ShouldEqual, DHTChangeOK)
+		}
+		// the same blocker warranting again doesn't count twice
+		_, err = send("blocker1", time.Now())
+		So(err, ShouldBeNil)
+		So(h.node.IsBlocked(pid), ShouldBeFalse)
+
+		_, err = send(fmt.Sprintf("blocker%d", DefaultBlockWarrantThreshold), time.Now())
+		So(err, ShouldBeNil)
+		So(h.node.IsBlocked(pid), ShouldBeTrue)
+		peerList, err := h.dht.getList(BlockedList)
+		So(err, ShouldBeNil)
+		So(len(peerList.Records), ShouldEqual, 0)
+	})
+
 	/*
 			getting a good warrant without also having already had the addToList happen is hard,
 			 so not quite sure how to test this
@@ -658,12 +803,12 @@ func TestDHTRetry(t *testing.T) {
 		r, err := ActionReceiver(h, m)
 		So(err, ShouldBeNil)
 		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
-		So(len(h.dht.retryQueue), ShouldEqual, 1)
+		So(h.dht.retryQueue.Len(), ShouldEqual, 1)
 
 		interval := time.Millisecond * 10
 		h.node.stoppers[RetryingStopper] = h.TaskTicker(interval, RetryTask)
 		time.Sleep(interval * (MaxRetries + 2))
-		So(len(h.dht.retryQueue), ShouldEqual, 0)
+		So(h.dht.retryQueue.Len(), ShouldEqual, 0)
 	})
 }
 
@@ -773,11 +918,75 @@ func TestDHTMakeReciept(t *testing.T) {
 }
 
 func processChangeRequestsInTesting(h *Holochain) {
-	for len(h.dht.changeQueue) > 0 {
-		req := <-h.dht.changeQueue
-		err := handleChangeRequests(h.dht, req)
+	for {
+		req, ok, err := h.dht.changeQueue.pop()
+		if err != nil {
+			panic(err)
+		}
+		if !ok {
+			break
+		}
+		err = handleChangeRequests(h.dht, req)
 		if err != nil {
 			panic(err)
 		}
 	}
 }
+
+func TestDHTConfigDefaults(t *testing.T) {
+	Convey("unset DHTConfig limits should fall back to the defaults", t, func() {
+		c := DHTConfig{}
+		So(c.maxLinkSets(), ShouldEqual, DefaultMaxLinkSets)
+		So(c.maxEntrySize(), ShouldEqual, DefaultMaxEntrySize)
+		So(c.peerTimeout(), ShouldEqual, DefaultPeerTimeout*time.Second)
+		So(c.validationTimeout(), ShouldEqual, DefaultValidationTimeout*time.Second)
+
+		c = DHTConfig{MaxLinkSets: 5, MaxEntrySize: 100, PeerTimeout: 2, ValidationTimeout: 3}
+		So(c.maxLinkSets(), ShouldEqual, 5)
+		So(c.maxEntrySize(), ShouldEqual, 100)
+		So(c.peerTimeout(), ShouldEqual, 2*time.Second)
+		So(c.validationTimeout(), ShouldEqual, 3*time.Second)
+	})
+}
+
+func TestFailureTracker(t *testing.T) {
+	Convey("it should only expire things that have been failing for longer than the timeout", t, func() {
+		f := newFailureTracker()
+		So(f.failed("x", time.Millisecond), ShouldBeFalse)
+		So(f.failed("x", time.Hour), ShouldBeFalse)
+		time.Sleep(2 * time.Millisecond)
+		So(f.failed("x", time.Millisecond), ShouldBeTrue)
+		// expiring forgets the failure
+		So(f.failed("x", time.Millisecond), ShouldBeFalse)
+
+		f.failed("y", time.Millisecond)
+		time.Sleep(2 * time.Millisecond)
+		f.succeeded("y")
+		So(f.failed("y", time.Millisecond), ShouldBeFalse)
+
+		f.failed("z", time.Hour)
+		time.Sleep(2 * time.Millisecond)
+		f.sweep(time.Hour)
+		So(len(f.since), ShouldEqual, 3)
+		f.sweep(time.Millisecond)
+		So(len(f.since), ShouldEqual, 0)
+	})
+}
+
+// signedBlockWarrant makes a block warrant for pid signed by key as of at
+func signedBlockWarrant(key ic.PrivKey, pid peer.ID, at time.Time) *BlockWarrant {
+	blocker, err := encodePubKey(key.GetPublic())
+	if err != nil {
+		panic(err)
+	}
+	w := &BlockWarrant{Blocker: blocker, Peers: []string{peer.IDB58Encode(pid)}, Reason: "spamming", Time: at.Round(0)}
+	data, err := w.signedData()
+	if err != nil {
+		panic(err)
+	}
+	w.Signature, err = key.Sign(data)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
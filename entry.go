@@ -25,6 +25,8 @@ const (
 	DataFormatString  = "string"
 	DataFormatRawJS   = "js"
 	DataFormatRawZygo = "zygo"
+	DataFormatBytes   = "bytes"
+	DataFormatBlob    = "blob"
 
 	// Entry sharing types
 
@@ -39,6 +41,8 @@ type EntryDef struct {
 	DataFormat string
 	Sharing    string
 	Schema     string
+	Indexes    []string // JSON field paths to maintain secondary indexes on for Query
+	Migration  string   // zome function transforming entries of this type from the BasedOn DNA
 	validator  SchemaValidator
 }
 
@@ -46,6 +50,10 @@ func (def EntryDef) isSharingPublic() bool {
 	return def.Sharing == Public || def.DataFormat == DataFormatLinks
 }
 
+func (def EntryDef) isSharingPartial() bool {
+	return def.Sharing == Partial && def.DataFormat != DataFormatLinks
+}
+
 // Entry describes serialization and deserialziation of entry data
 type Entry interface {
 	Marshal() ([]byte, error)
@@ -114,12 +122,26 @@ func UnmarshalEntry(reader io.Reader) (e Entry, err error) {
 
 // implementation of Entry interface with gobs
 
+// Marshal encodes the entry's content as a gob, which is always what entries are hashed
+// from, whatever encoding they are stored and sent in
 func (e *GobEntry) Marshal() (b []byte, err error) {
 	b, err = ByteEncoder(&e.C)
 	return
 }
+
+// Unmarshal decodes content encoded as a gob or CBOR
 func (e *GobEntry) Unmarshal(b []byte) (err error) {
-	err = ByteDecoder(b, &e.C)
+	err = decodeAny(b, &e.C)
+	return
+}
+
+// marshalEntryAs encodes an entry for storing with the given encoding
+func marshalEntryAs(e Entry, encoding string) (b []byte, err error) {
+	if g, ok := e.(*GobEntry); ok && encoding == EncodingCBOR {
+		b, err = CBOREncoder(&g.C)
+		return
+	}
+	b, err = e.Marshal()
 	return
 }
 
@@ -1,5 +1,13 @@
 package holochain
 
+import (
+	"encoding/json"
+	"fmt"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
 const (
   HeadersEntryType = SysEntryTypePrefix + "header"
 )
@@ -101,3 +109,53 @@ const (
 )
 
 var HeadersEntryDef = &EntryDef{Name: HeadersEntryType, DataFormat: DataFormatJSON, Sharing: Public, Schema: HeadersEntrySchema}
+
+// sourcedHeader is a header in a headers entry along with the node that it came from
+type sourcedHeader struct {
+	Source string
+	Header json.RawMessage
+}
+
+// checkHeadersSignatures confirms that each header in a headers entry was signed by its source
+func checkHeadersSignatures(h *Holochain, entry Entry) (err error) {
+	j, ok := entry.Content().(string)
+	if !ok {
+		err = ValidationFailed(ValidationFailureBadHeadersFormat)
+		return
+	}
+	var headers []sourcedHeader
+	if json.Unmarshal([]byte(j), &headers) != nil {
+		err = ValidationFailed(ValidationFailureBadHeadersFormat)
+		return
+	}
+	for _, sh := range headers {
+		var source peer.ID
+		source, err = peer.IDB58Decode(sh.Source)
+		if err != nil {
+			err = ValidationFailed(fmt.Sprintf("%s: bad source %s", ValidationFailureBadHeadersFormat, sh.Source))
+			return
+		}
+		var hd Header
+		hd, err = HeaderFromJSON(string(sh.Header))
+		if err != nil {
+			err = ValidationFailed(fmt.Sprintf("%s: bad header: %v", ValidationFailureBadHeadersFormat, err))
+			return
+		}
+
+		var pubKey ic.PubKey
+		pubKey, err = h.getSourcePubKey(source)
+		if err != nil {
+			return
+		}
+		var matches bool
+		matches, err = hd.Verify(pubKey)
+		if err != nil {
+			return
+		}
+		if !matches {
+			err = ValidationFailed(fmt.Sprintf("%s for entry %s from %s", ValidationFailureBadHeaderSignature, hd.EntryLink, sh.Source))
+			return
+		}
+	}
+	return
+}
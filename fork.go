@@ -0,0 +1,136 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements detecting agents that fork their chains
+
+package holochain
+
+import (
+	"sync"
+
+	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	// HeaderWatchSize is the number of each agent's most recent headers kept for spotting forks
+	HeaderWatchSize = 1000
+)
+
+// agentHeaders holds the headers seen from an agent by the header they follow
+type agentHeaders struct {
+	byLink map[string]Header
+	order  []string // the links in the order they were seen, for dropping the oldest
+}
+
+// headerWatch records the headers agents have sent us so that we can spot when an agent
+// has signed two different headers that follow the same header
+type headerWatch struct {
+	lk     sync.Mutex
+	agents map[peer.ID]*agentHeaders
+}
+
+func newHeaderWatch() *headerWatch {
+	return &headerWatch{agents: make(map[peer.ID]*agentHeaders)}
+}
+
+// see records a header from an agent, returning the header previously seen from the agent
+// that follows the same header if the two are different
+func (w *headerWatch) see(agent peer.ID, header *Header, hashSpec HashSpec) (forked *Header, err error) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	a, ok := w.agents[agent]
+	if !ok {
+		a = &agentHeaders{byLink: make(map[string]Header)}
+		w.agents[agent] = a
+	}
+	link := header.HeaderLink.String()
+	seen, ok := a.byLink[link]
+	if !ok {
+		a.byLink[link] = *header
+		a.order = append(a.order, link)
+		if len(a.order) > HeaderWatchSize {
+			delete(a.byLink, a.order[0])
+			a.order = a.order[1:]
+		}
+		return
+	}
+	var seenHash, hash Hash
+	if seenHash, _, err = seen.Sum(hashSpec); err != nil {
+		return
+	}
+	if hash, _, err = header.Sum(hashSpec); err != nil {
+		return
+	}
+	if !seenHash.Equal(hash) {
+		forked = &seen
+	}
+	return
+}
+
+// watchHeader records a header that an agent sent us and if the agent has forked its chain
+// publishes a ForkWarrant so that we and the nodes near the agent block it.  Only headers
+// the agent fully signed are watched, as only they prove which header they follow.
+func (dht *DHT) watchHeader(agent peer.ID, header *Header) {
+	if agent == dht.h.nodeID || dht.h.node.IsBlocked(agent) || !header.FullySigned() {
+		return
+	}
+	pubKey, err := dht.h.getSourcePubKey(agent)
+	if err != nil {
+		dht.dlog.Logf("unable to check header from %v for forks: %v", agent, err)
+		return
+	}
+	// don't let anyone else's headers stand in for the agent's
+	matches, err := header.Verify(pubKey)
+	if err != nil || !matches {
+		dht.dlog.Logf("not watching header from %v that it didn't sign", agent)
+		return
+	}
+	forked, err := dht.headers.see(agent, header, dht.h.hashSpec)
+	if err != nil {
+		dht.dlog.Logf("unable to check header from %v for forks: %v", agent, err)
+		return
+	}
+	if forked == nil {
+		return
+	}
+	dht.dlog.Logf("%v forked its chain after %v", agent, header.HeaderLink)
+	err = dht.publishFork(agent, forked, header)
+	if err != nil {
+		dht.dlog.Logf("unable to publish fork warrant for %v: %v", agent, err)
+	}
+}
+
+// publishFork sends a ForkWarrant for the agent to the nodes closest to it, including ourselves
+func (dht *DHT) publishFork(agent peer.ID, header1 *Header, header2 *Header) (err error) {
+	var pubKey ic.PubKey
+	pubKey, err = dht.h.getNodePubKey(agent)
+	if err != nil {
+		return
+	}
+	var w *ForkWarrant
+	w, err = NewForkWarrant(pubKey, header1, header2)
+	if err != nil {
+		return
+	}
+	// don't send a warrant that won't verify, i.e. because the headers weren't signed by the agent
+	err = w.Verify(dht.h)
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = w.Encode()
+	if err != nil {
+		return
+	}
+	err = dht.Change(HashFromPeerID(agent), LISTADD_REQUEST,
+		ListAddReq{
+			ListType:    BlockedList,
+			Peers:       []string{peer.IDB58Encode(agent)},
+			WarrantType: ForkType,
+			Warrant:     data,
+		})
+	return
+}
@@ -0,0 +1,180 @@
+package holochain
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHeaderWatch(t *testing.T) {
+	hashSpec, key, now := chainTestSetup()
+	agent, _ := makePeer("peer_foo")
+	prev, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	_, header1, _ := newHeader(hashSpec, now, "evenNumbers", &GobEntry{C: "2"}, key, prev, NullHash(), NullHash())
+	_, header2, _ := newHeader(hashSpec, now, "evenNumbers", &GobEntry{C: "4"}, key, prev, NullHash(), NullHash())
+	w := newHeaderWatch()
+
+	Convey("it should not report the same header as a fork", t, func() {
+		forked, err := w.see(agent, header1, hashSpec)
+		So(err, ShouldBeNil)
+		So(forked, ShouldBeNil)
+		forked, err = w.see(agent, header1, hashSpec)
+		So(err, ShouldBeNil)
+		So(forked, ShouldBeNil)
+	})
+
+	Convey("it should report a different header following the same header", t, func() {
+		other, _ := makePeer("peer_bar")
+		forked, err := w.see(other, header2, hashSpec)
+		So(err, ShouldBeNil)
+		So(forked, ShouldBeNil)
+		forked, err = w.see(agent, header2, hashSpec)
+		So(err, ShouldBeNil)
+		So(forked, ShouldNotBeNil)
+		So(fmt.Sprintf("%v", *forked), ShouldEqual, fmt.Sprintf("%v", *header1))
+	})
+
+	Convey("it should only keep the most recent headers", t, func() {
+		for i := 0; i < HeaderWatchSize; i++ {
+			link, _ := Sum(hashSpec, []byte(fmt.Sprintf("%d", i)))
+			_, hd, _ := newHeader(hashSpec, now, "evenNumbers", &GobEntry{C: "2"}, key, link, NullHash(), NullHash())
+			w.see(agent, hd, hashSpec)
+		}
+		So(len(w.agents[agent].byLink), ShouldEqual, HeaderWatchSize)
+		forked, err := w.see(agent, header2, hashSpec)
+		So(err, ShouldBeNil)
+		So(forked, ShouldBeNil)
+	})
+}
+
+func TestForkWarrant(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	agent, key := makePeer("peer_foo")
+	now := time.Now()
+	prev, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	_, header1, _ := newHeader(h.hashSpec, now, "evenNumbers", &GobEntry{C: "2"}, key, prev, NullHash(), NullHash())
+	_, header2, _ := newHeader(h.hashSpec, now, "evenNumbers", &GobEntry{C: "4"}, key, prev, NullHash(), NullHash())
+
+	w, err := NewForkWarrant(key.GetPublic(), header1, header2)
+
+	Convey("NewForkWarrant should create one", t, func() {
+		So(err, ShouldBeNil)
+		So(w.Type(), ShouldEqual, ForkType)
+		parties, err := w.Parties()
+		So(err, ShouldBeNil)
+		So(parties[0].String(), ShouldEqual, peer.IDB58Encode(agent))
+		a, err := w.Property("agent")
+		So(err, ShouldBeNil)
+		So(a, ShouldEqual, agent)
+		link, err := w.Property("headerLink")
+		So(err, ShouldBeNil)
+		So(link.(Hash).String(), ShouldEqual, prev.String())
+	})
+
+	Convey("it should verify", t, func() {
+		So(w.Verify(h), ShouldBeNil)
+	})
+
+	Convey("it should not verify headers that aren't a fork", t, func() {
+		w1, _ := NewForkWarrant(key.GetPublic(), header1, header1)
+		So(w1.Verify(h), ShouldEqual, ErrForkWarrantInvalid)
+		_, header3, _ := newHeader(h.hashSpec, now, "evenNumbers", &GobEntry{C: "4"}, key, NullHash(), NullHash(), NullHash())
+		w1, _ = NewForkWarrant(key.GetPublic(), header1, header3)
+		So(w1.Verify(h), ShouldEqual, ErrForkWarrantInvalid)
+	})
+
+	Convey("it should not verify headers whose links were changed to look like a fork", t, func() {
+		_, header3, _ := newHeader(h.hashSpec, now, "evenNumbers", &GobEntry{C: "4"}, key, NullHash(), NullHash(), NullHash())
+		header3.HeaderLink = prev
+		w1, _ := NewForkWarrant(key.GetPublic(), header1, header3)
+		So(w1.Verify(h).Error(), ShouldStartWith, "fork warrant header for")
+	})
+
+	Convey("it should not verify headers that only signed their entry links", t, func() {
+		legacy := func(hd Header) *Header {
+			hd.Version = 0
+			sig, _ := key.Sign([]byte(hd.EntryLink))
+			hd.Sig = Signature{S: sig}
+			return &hd
+		}
+		w1, _ := NewForkWarrant(key.GetPublic(), legacy(*header1), legacy(*header2))
+		So(w1.Verify(h), ShouldEqual, ErrForkWarrantNotFullySigned)
+	})
+
+	Convey("it should not verify headers signed by someone else", t, func() {
+		_, otherKey := makePeer("peer_bar")
+		w1, _ := NewForkWarrant(otherKey.GetPublic(), header1, header2)
+		So(w1.Verify(h).Error(), ShouldStartWith, "fork warrant header for")
+	})
+
+	Convey("it should encode and decode warrants", t, func() {
+		encoded, err := w.Encode()
+		So(err, ShouldBeNil)
+		w1, err := DecodeWarrant(ForkType, encoded)
+		So(err, ShouldBeNil)
+		So(w1.Verify(h), ShouldBeNil)
+		So(fmt.Sprintf("%v", w1), ShouldEqual, fmt.Sprintf("%v", w))
+	})
+
+	Convey("LISTADD_REQUEST with a fork warrant should only block the forking agent", t, func() {
+		data, _ := w.Encode()
+		other, _ := makePeer("peer_bar")
+		req := ListAddReq{
+			ListType:    BlockedList,
+			Peers:       []string{peer.IDB58Encode(other)},
+			WarrantType: ForkType,
+			Warrant:     data,
+		}
+		_, err := ActionReceiver(h, h.node.NewMessage(LISTADD_REQUEST, req))
+		So(err.Error(), ShouldEqual, "List add request rejected on warrant failure: fork warrant only covers the forking agent")
+		So(h.node.IsBlocked(other), ShouldBeFalse)
+
+		req.Peers = []string{peer.IDB58Encode(agent)}
+		r, err := ActionReceiver(h, h.node.NewMessage(LISTADD_REQUEST, req))
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, DHTChangeOK)
+		So(h.node.IsBlocked(agent), ShouldBeTrue)
+	})
+}
+
+func TestWatchHeader(t *testing.T) {
+	nodesCount := 2
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+
+	key := h1.agent.PrivKey()
+	now := time.Now()
+	prev, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	_, header1, _ := newHeader(h1.hashSpec, now, "evenNumbers", &GobEntry{C: "2"}, key, prev, NullHash(), NullHash())
+	_, header2, _ := newHeader(h1.hashSpec, now, "evenNumbers", &GobEntry{C: "4"}, key, prev, NullHash(), NullHash())
+
+	Convey("it should only watch headers the agent signed", t, func() {
+		_, header3, _ := newHeader(h1.hashSpec, now, "evenNumbers", &GobEntry{C: "4"}, key, NullHash(), NullHash(), NullHash())
+		header3.HeaderLink = prev
+		h0.dht.watchHeader(h1.nodeID, header1)
+		h0.dht.watchHeader(h1.nodeID, header3)
+		So(h0.node.IsBlocked(h1.nodeID), ShouldBeFalse)
+	})
+
+	Convey("it should block and publish a warrant for an agent that forks its chain", t, func() {
+		h0.dht.watchHeader(h1.nodeID, header1)
+		So(h0.node.IsBlocked(h1.nodeID), ShouldBeFalse)
+		queued := h0.dht.changeQueue.Len()
+		h0.dht.watchHeader(h1.nodeID, header2)
+		So(h0.node.IsBlocked(h1.nodeID), ShouldBeTrue)
+		So(h0.dht.changeQueue.Len(), ShouldEqual, queued+1)
+		list, err := h0.BlockedPeers()
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 1)
+		So(list.Records[0].ID, ShouldEqual, h1.nodeID)
+	})
+}
@@ -11,11 +11,6 @@ import (
 	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
-	"github.com/tidwall/buntdb"
-	"math/rand"
-	"sort"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -34,6 +29,7 @@ type Gossip struct {
 type GossipReq struct {
 	MyIdx   int
 	YourIdx int
+	MaxPuts int // the most puts the requester has room to queue, zero for no limit
 }
 
 // we also gossip about peers too, keeping lists of different peers e.g. blockedlist etc
@@ -68,63 +64,45 @@ func (dht *DHT) HaveFingerprint(f Hash) (result bool, err error) {
 
 // GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
 func (dht *DHT) GetFingerprint(f Hash) (index int, err error) {
-	index = -1
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		idxStr, e := tx.Get("f:" + f.String())
-		if e == buntdb.ErrNotFound {
-			return nil
-		}
-		if e != nil {
-			return e
-		}
-		index, e = strconv.Atoi(idxStr)
-		if e != nil {
-			return e
-		}
-		return nil
-	})
+	index, err = dht.ht.GetFingerprint(f)
 	return
 }
 
 // GetPuts returns a list of puts after the given index
 func (dht *DHT) GetPuts(since int) (puts []Put, err error) {
+	puts, err = dht.getPuts(since, 0)
+	return
+}
+
+// getPuts returns a list of at most max puts after the given index, or all of them if max is zero
+func (dht *DHT) getPuts(since int, max int) (puts []Put, err error) {
 	puts = make([]Put, 0)
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		err = tx.AscendGreaterOrEqual("idx", string(since), func(key, value string) bool {
-			x := strings.Split(key, ":")
-			idx, _ := strconv.Atoi(x[1])
-			if idx >= since {
-				p := Put{Idx: idx}
-				if value != "" {
-					err := ByteDecoder([]byte(value), &p.M)
-					if err != nil {
-						return false
-					}
-				}
-				puts = append(puts, p)
-			}
-			return true
-		})
-		sort.Slice(puts, func(i, j int) bool { return puts[i].Idx < puts[j].Idx })
-		return err
-	})
+	var idx int
+	idx, err = dht.ht.GetIdx()
+	if err != nil {
+		return
+	}
+	if since < 1 {
+		since = 1
+	}
+	for i := since; i <= idx && (max == 0 || len(puts) < max); i++ {
+		p := Put{Idx: i}
+		p.M, err = dht.ht.GetIdxMessage(i)
+		if err == ErrNoSuchIdx {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		puts = append(puts, p)
+	}
 	return
 }
 
 // GetGossiper loads returns last known index of the gossiper, and adds them if not didn't exist before
 func (dht *DHT) GetGossiper(id peer.ID) (idx int, err error) {
-	key := "peer:" + peer.IDB58Encode(id)
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		var e error
-		idx, e = getIntVal(key, tx)
-		if e != nil {
-			return e
-		}
-		return nil
-	})
+	idx, err = dht.ht.GetGossiper(id)
 	return
 }
 
@@ -161,51 +139,28 @@ func (dht *DHT) getGossipers() (glist []peer.ID, err error) {
 }
 
 func (dht *DHT) _getGossipers() (glist []peer.ID, err error) {
-	glist = make([]peer.ID, 0)
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		err = tx.Ascend("peer", func(key, value string) bool {
-			x := strings.Split(key, ":")
-			id, e := peer.IDB58Decode(x[1])
-			if e != nil {
-				return false
-			}
-			//			idx, _ := strconv.Atoi(value)
-			glist = append(glist, id)
-			return true
-		})
-		return nil
-	})
+	glist, err = dht.ht.GetGossipers()
+	if err != nil {
+		return
+	}
 	ns := dht.config.RedundancyFactor
 	if ns > 1 {
-		size := len(glist)
-		hlist := make([]Hash, size)
-		for i := 0; i < size; i++ {
-			h := HashFromPeerID(glist[i])
-			hlist[i] = h
-		}
-		me := HashFromPeerID(dht.h.nodeID)
-
-		hlist = SortByDistance(me, hlist)
-		glist = make([]peer.ID, len(hlist))
-		for i := 0; i < len(hlist); i++ {
-			glist[i] = PeerIDFromHash(hlist[i])
-		}
+		glist = dht.sharding.SortPeers(glist, HashFromPeerID(dht.h.nodeID))
 	}
 	return
 }
 
-// FindGossiper picks a random DHT node to gossip with
+// FindGossiper picks a random DHT node to gossip with, favouring those with a better reputation
 func (dht *DHT) FindGossiper() (g peer.ID, err error) {
 	var glist []peer.ID
 	glist, err = dht.getGossipers()
 	if err != nil {
 		return
 	}
-	if len(glist) == 0 {
+	var ok bool
+	g, ok = dht.h.node.pickReputed(glist)
+	if !ok {
 		err = ErrDHTErrNoGossipersAvailable
-	} else {
-		g = glist[rand.Intn(len(glist))]
 	}
 	return
 }
@@ -222,23 +177,7 @@ func (dht *DHT) AddGossiper(id peer.ID) (err error) {
 
 // internal update gossiper function, assumes all checks have been made
 func (dht *DHT) updateGossiper(id peer.ID, newIdx int) (err error) {
-	db := dht.ht.(*BuntHT).db
-	err = db.Update(func(tx *buntdb.Tx) error {
-		key := "peer:" + peer.IDB58Encode(id)
-		idx, e := getIntVal(key, tx)
-		if e != nil {
-			return e
-		}
-		if newIdx < idx {
-			return nil
-		}
-		sidx := fmt.Sprintf("%d", newIdx)
-		_, _, err = tx.Set(key, sidx, nil)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	err = dht.ht.UpdateGossiper(id, newIdx)
 	return
 }
 
@@ -257,12 +196,7 @@ func (dht *DHT) UpdateGossiper(id peer.ID, newIdx int) (err error) {
 // DeleteGossiper removes a gossiper from the database
 func (dht *DHT) DeleteGossiper(id peer.ID) (err error) {
 	dht.glog.Logf("deleting %v", id)
-	db := dht.ht.(*BuntHT).db
-	err = db.Update(func(tx *buntdb.Tx) error {
-		key := "peer:" + peer.IDB58Encode(id)
-		_, e := tx.Delete(key)
-		return e
-	})
+	err = dht.ht.DeleteGossiper(id)
 	return
 }
 
@@ -278,18 +212,20 @@ func GossipReceiver(h *Holochain, m *Message) (response interface{}, err error)
 		dht.glog.Logf("GossipReceiver got: %v", m)
 		switch t := m.Body.(type) {
 		case GossipReq:
-			dht.glog.Logf("%v wants my puts since %d and is at %d", m.From, t.YourIdx, t.MyIdx)
+			dht.glog.Logf("%v wants my puts since %d (at most %d) and is at %d", m.From, t.YourIdx, t.MaxPuts, t.MyIdx)
 
-			// give the gossiper what they want
+			// give the gossiper what they want, as much as they have room for
 			var puts []Put
-			puts, err = h.dht.GetPuts(t.YourIdx)
+			puts, err = h.dht.getPuts(t.YourIdx, t.MaxPuts)
 			g := Gossip{Puts: puts}
 			response = g
 
 			// check to see what we know they said, and if our record is less
 			// that where they are currently at, gossip back
 			idx, e := h.dht.GetGossiper(m.From)
-			if e == nil && idx < t.MyIdx {
+			if e == nil && idx < t.MyIdx && dht.gossipPuts.Room() == 0 {
+				dht.glog.Logf("we only have %d of %d from %v but our put queue is full so not gossiping back", idx, t.MyIdx, m.From)
+			} else if e == nil && idx < t.MyIdx {
 				dht.glog.Logf("we only have %d of %d from %v so gossiping back", idx, t.MyIdx, m.From)
 
 				pi := h.node.host.Peerstore().PeerInfo(m.From)
@@ -313,9 +249,29 @@ func GossipReceiver(h *Holochain, m *Message) (response interface{}, err error)
 
 		default:
 			err = ErrDHTExpectedGossipReqInBody
+			dht.ratePeer(m.From, ReputationInvalidGossip)
+		}
+	case GOSSIP_DIGEST_REQUEST:
+		switch t := m.Body.(type) {
+		case GossipDigestReq:
+			dht.glog.Logf("%v wants %d ranges compared", m.From, len(t.Ranges))
+			response, err = dht.compareDigests(t)
+		default:
+			err = ErrDHTExpectedGossipReqInBody
+			dht.ratePeer(m.From, ReputationInvalidGossip)
+		}
+	case GOSSIP_FETCH_REQUEST:
+		switch t := m.Body.(type) {
+		case GossipFetchReq:
+			dht.glog.Logf("%v wants %d puts", m.From, len(t.Fingerprints))
+			response, err = dht.fetchPuts(t)
+		default:
+			err = ErrDHTExpectedGossipReqInBody
+			dht.ratePeer(m.From, ReputationInvalidGossip)
 		}
 	default:
 		err = fmt.Errorf("message type %d not in holochain-gossip protocol", int(m.Type))
+		dht.ratePeer(m.From, ReputationInvalidGossip)
 	}
 	return
 }
@@ -331,6 +287,11 @@ func (dht *DHT) gossipWith(id peer.ID) (err error) {
 		dht.glog.Logf("finish gossipWith %v, err=%v", id, err)
 	}()
 
+	if dht.config.gossipMethod() == GossipMethodDigest {
+		err = dht.gossipWithDigests(id)
+		return
+	}
+
 	var myIdx, yourIdx int
 	myIdx, err = dht.GetIdx()
 	if err != nil {
@@ -342,14 +303,25 @@ func (dht *DHT) gossipWith(id peer.ID) (err error) {
 		return
 	}
 
+	// tell the gossiper how many puts we have room for so they don't send more than we can queue
+	room := dht.gossipPuts.Room()
+	if room == 0 {
+		dht.glog.Logf("put queue is full, not gossiping with %v", id)
+		return
+	}
+
 	var r interface{}
-	msg := dht.h.node.NewMessage(GOSSIP_REQUEST, GossipReq{MyIdx: myIdx, YourIdx: yourIdx + 1})
-	r, err = dht.h.Send(dht.h.node.ctx, GossipProtocol, id, msg, 0)
+	r, err = dht.gossipSend(id, GOSSIP_REQUEST, GossipReq{MyIdx: myIdx, YourIdx: yourIdx + 1, MaxPuts: room})
 	if err != nil {
 		return
 	}
 
-	gossip := r.(Gossip)
+	gossip, ok := r.(Gossip)
+	if !ok {
+		dht.ratePeer(id, ReputationInvalidGossip)
+		err = fmt.Errorf("expected gossip response from %v, got %T", id, r)
+		return
+	}
 	puts := gossip.Puts
 
 	// gossiper has more stuff that we new about before so update the gossipers status
@@ -357,19 +329,47 @@ func (dht *DHT) gossipWith(id peer.ID) (err error) {
 	count := len(puts)
 	if count > 0 {
 		dht.glog.Logf("queuing %d puts:\n%v", count, puts)
-		var idx int
-		for i, p := range puts {
-			idx = i + yourIdx + 1
+		idx := yourIdx
+		for _, p := range puts {
 			// put the message into the gossip put handling queue so we can return quickly
-			dht.gossipPuts <- p
+			if err = dht.gossipPuts.push(p, false); err != nil {
+				// we'll ask for the rest next time
+				break
+			}
+			idx = p.Idx
+		}
+		if idx > yourIdx {
+			e := dht.UpdateGossiper(id, idx)
+			if err == nil {
+				err = e
+			}
 		}
-		err = dht.UpdateGossiper(id, idx)
 	} else {
 		dht.glog.Log("no new puts received")
 	}
 	return
 }
 
+// gossipSend sends a gossip request to a peer, dropping peers that haven't answered
+// for longer than the DNA's PeerTimeout
+func (dht *DHT) gossipSend(id peer.ID, t MsgType, body interface{}) (response interface{}, err error) {
+	msg := dht.h.node.NewMessage(t, body)
+	response, err = dht.h.Send(dht.h.node.ctx, GossipProtocol, id, msg, 0)
+	if err != nil {
+		// other errors, like failing to dial, may well be our own network's doing
+		if err == SendTimeoutErr {
+			dht.ratePeer(id, ReputationTimeout)
+		}
+		if dht.gossipFailures.failed(string(id), dht.config.peerTimeout()) {
+			dht.glog.Logf("%v hasn't answered gossip for more than %v, dropping", id, dht.config.peerTimeout())
+			dht.DeleteGossiper(id) // ignore error
+		}
+		return
+	}
+	dht.gossipFailures.succeeded(string(id))
+	return
+}
+
 // gossipPut handles a given put
 func (dht *DHT) gossipPut(p Put) (err error) {
 	f, e := p.M.Fingerprint()
@@ -460,53 +460,21 @@ func (dht *DHT) HandleGossipWiths() (err error) {
 	return
 }
 
-// HandleGossipPuts waits on a channel for gossip changes
+// HandleGossipPuts waits on the gossip put queue for gossip changes
 func (dht *DHT) HandleGossipPuts() (err error) {
-	err = dht.handleTillDone("HandleGossipPuts", dht.gossipPuts, handleGossipPut)
+	err = dht.handleQueueTillDone("HandleGossipPuts", dht.gossipPuts, handleGossipPut)
 	return nil
 }
 
 // getList returns the peer list of the given type
 func (dht *DHT) getList(listType PeerListType) (result PeerList, err error) {
-	result.Type = listType
-	result.Records = make([]PeerRecord, 0)
-	db := dht.ht.(*BuntHT).db
-	err = db.View(func(tx *buntdb.Tx) error {
-		err = tx.Ascend("list", func(key, value string) bool {
-			x := strings.Split(key, ":")
-
-			if x[1] == string(listType) {
-				pid, e := peer.IDB58Decode(x[2])
-				if e != nil {
-					return false
-				}
-				r := PeerRecord{ID: pid, Warrant: value}
-				result.Records = append(result.Records, r)
-			}
-			return true
-		})
-		return nil
-	})
+	result, err = dht.ht.GetList(listType)
 	return
 }
 
 // addToList adds the peers to a list
 func (dht *DHT) addToList(m *Message, list PeerList) (err error) {
 	dht.dlog.Logf("addToList %s=>%v", list.Type, list.Records)
-	db := dht.ht.(*BuntHT).db
-	err = db.Update(func(tx *buntdb.Tx) error {
-		_, err = incIdx(tx, m)
-		if err != nil {
-			return err
-		}
-		for _, r := range list.Records {
-			k := peer.IDB58Encode(r.ID)
-			_, _, err = tx.Set("list:"+string(list.Type)+":"+k, r.Warrant, nil)
-			if err != nil {
-				return err
-			}
-		}
-		return err
-	})
+	err = dht.ht.AddToList(m, list)
 	return
 }
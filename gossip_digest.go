@@ -0,0 +1,474 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements anti-entropy gossip, where peers compare digests of the changes they hold
+// over ranges of hash-space and then fetch only the changes they are missing
+
+package holochain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	// constants for the DHTConfig GossipMethod
+
+	GossipMethodPuts   = "puts"   // replay the change log since the last index seen from a peer
+	GossipMethodDigest = "digest" // compare digests of hash ranges and fetch only missing changes
+
+	DefaultGossipMethod = GossipMethodPuts
+
+	// GossipDigestLeafSize is the number of changes in a hash range below which a peer
+	// answers a digest request with the range's fingerprints rather than its sub-ranges
+	GossipDigestLeafSize = 32
+
+	// GossipFetchBatchSize is the maximum number of changes asked for in one fetch request
+	GossipFetchBatchSize = 100
+
+	// GossipDigestMaxRanges is the maximum number of ranges compared in one digest request
+	GossipDigestMaxRanges = 64
+
+	// GossipMaxFetch is the maximum number of changes fetched from a peer in one round
+	// of gossip, the rest being found again and fetched in later rounds
+	GossipMaxFetch = 1000
+
+	gossipDigestFanout = 16
+)
+
+var ErrGossipRequestTooLarge = errors.New("gossip request too large")
+
+// GossipRangeDigest summarizes the changes a node holds whose fingerprint keys start with Prefix
+type GossipRangeDigest struct {
+	Prefix string // hex digits of the range of the key space
+	Count  int
+	Sum    []byte // XOR of the keys of the changes in the range
+}
+
+// GossipDigestReq holds the digests of the ranges a gossiper wants compared
+type GossipDigestReq struct {
+	Ranges []GossipRangeDigest
+}
+
+// GossipRangeDiff describes a range whose digest didn't match.  Small ranges are
+// described by the fingerprints of all their changes, and others by the digests of
+// their non-empty sub-ranges.
+type GossipRangeDiff struct {
+	Prefix       string
+	Fingerprints []Hash
+	Children     []GossipRangeDigest
+}
+
+// GossipDigestResp holds the ranges of a GossipDigestReq that didn't match
+type GossipDigestResp struct {
+	Diffs []GossipRangeDiff
+}
+
+// GossipFetchReq asks for the changes with the given fingerprints
+type GossipFetchReq struct {
+	Fingerprints []Hash
+}
+
+// checkGossipMethod returns an error if the method isn't a known gossip method
+func checkGossipMethod(method string) (err error) {
+	switch method {
+	case "", GossipMethodPuts, GossipMethodDigest:
+	default:
+		err = fmt.Errorf("Invalid gossip method. Must be one of: %s, %s", GossipMethodPuts, GossipMethodDigest)
+	}
+	return
+}
+
+// gossipMethod returns the configured GossipMethod or the default
+func (c *DHTConfig) gossipMethod() string {
+	if c.GossipMethod == "" {
+		return DefaultGossipMethod
+	}
+	return c.GossipMethod
+}
+
+// digestKey returns the key that places a fingerprint in the key space.  The fingerprint
+// is hashed again so keys are evenly spread whatever the fingerprint's hash type.
+func digestKey(f Hash) (key string, sum [sha256.Size]byte) {
+	sum = sha256.Sum256([]byte(f))
+	key = hex.EncodeToString(sum[:])
+	return
+}
+
+// gossipDigestNode is a range of the key space in a gossipDigests tree.  Leaves hold
+// the fingerprints in their range and split into gossipDigestFanout children when they
+// grow past GossipDigestLeafSize.
+type gossipDigestNode struct {
+	count        int
+	sum          [sha256.Size]byte
+	children     []*gossipDigestNode
+	fingerprints map[string]Hash // by key, only at leaves
+}
+
+// gossipDigests is the tree of digests of all the changes in a DHT's store
+type gossipDigests struct {
+	lk   sync.Mutex
+	idx  int // the last change index that has been added
+	root *gossipDigestNode
+}
+
+func newGossipDigests() *gossipDigests {
+	return &gossipDigests{root: &gossipDigestNode{fingerprints: make(map[string]Hash)}}
+}
+
+func xorSum(sum *[sha256.Size]byte, b []byte) {
+	for i := range sum {
+		sum[i] ^= b[i]
+	}
+}
+
+// validDigestPrefix returns true if a prefix received from a peer is a range of the key space
+func validDigestPrefix(prefix string) bool {
+	if len(prefix) > 2*sha256.Size {
+		return false
+	}
+	for _, c := range prefix {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func nibble(key string, depth int) int {
+	n := key[depth]
+	if n >= 'a' {
+		return int(n-'a') + 10
+	}
+	return int(n - '0')
+}
+
+// add puts a fingerprint into the tree, returning false if it was already there
+func (t *gossipDigests) add(f Hash) bool {
+	key, sum := digestKey(f)
+	path := []*gossipDigestNode{}
+	n := t.root
+	depth := 0
+	for n.children != nil {
+		path = append(path, n)
+		n = n.children[nibble(key, depth)]
+		depth++
+	}
+	if _, exists := n.fingerprints[key]; exists {
+		return false
+	}
+	n.fingerprints[key] = f
+	for _, p := range append(path, n) {
+		p.count++
+		xorSum(&p.sum, sum[:])
+	}
+	if len(n.fingerprints) > GossipDigestLeafSize && depth < len(key) {
+		n.split(depth)
+	}
+	return true
+}
+
+// split turns a leaf at depth into an internal node
+func (n *gossipDigestNode) split(depth int) {
+	n.children = make([]*gossipDigestNode, gossipDigestFanout)
+	for i := range n.children {
+		n.children[i] = &gossipDigestNode{fingerprints: make(map[string]Hash)}
+	}
+	for key, f := range n.fingerprints {
+		c := n.children[nibble(key, depth)]
+		c.fingerprints[key] = f
+		c.count++
+		sum, _ := hex.DecodeString(key)
+		xorSum(&c.sum, sum)
+	}
+	n.fingerprints = nil
+}
+
+// find returns the deepest node covering the prefix and its depth
+func (t *gossipDigests) find(prefix string) (n *gossipDigestNode, depth int) {
+	n = t.root
+	for depth < len(prefix) && n.children != nil {
+		n = n.children[nibble(prefix, depth)]
+		depth++
+	}
+	return
+}
+
+// digest returns the digest of the range of the key space starting with prefix
+func (t *gossipDigests) digest(prefix string) (d GossipRangeDigest) {
+	d.Prefix = prefix
+	n, depth := t.find(prefix)
+	if depth == len(prefix) {
+		d.Count = n.count
+		d.Sum = append([]byte{}, n.sum[:]...)
+		return
+	}
+	// the range is part of a leaf so total up the fingerprints in it
+	var sum [sha256.Size]byte
+	for key := range n.fingerprints {
+		if strings.HasPrefix(key, prefix) {
+			d.Count++
+			b, _ := hex.DecodeString(key)
+			xorSum(&sum, b)
+		}
+	}
+	d.Sum = sum[:]
+	return
+}
+
+// fingerprints returns the fingerprints in the range starting with prefix
+func (t *gossipDigests) fingerprints(prefix string) (fingerprints []Hash) {
+	n, _ := t.find(prefix)
+	var walk func(n *gossipDigestNode)
+	walk = func(n *gossipDigestNode) {
+		for _, c := range n.children {
+			walk(c)
+		}
+		for key, f := range n.fingerprints {
+			if strings.HasPrefix(key, prefix) {
+				fingerprints = append(fingerprints, f)
+			}
+		}
+	}
+	walk(n)
+	return
+}
+
+// has returns true if the fingerprint is in the tree
+func (t *gossipDigests) has(f Hash) bool {
+	key, _ := digestKey(f)
+	n, _ := t.find(key)
+	_, ok := n.fingerprints[key]
+	return ok
+}
+
+// compare returns how our range differs from the given digest, or nil if it doesn't
+func (t *gossipDigests) compare(d GossipRangeDigest) (diff *GossipRangeDiff) {
+	mine := t.digest(d.Prefix)
+	if mine.Count == d.Count && string(mine.Sum) == string(d.Sum) {
+		return
+	}
+	diff = &GossipRangeDiff{Prefix: d.Prefix}
+	if mine.Count <= GossipDigestLeafSize || len(d.Prefix) >= 2*sha256.Size {
+		diff.Fingerprints = t.fingerprints(d.Prefix)
+		return
+	}
+	for i := 0; i < gossipDigestFanout; i++ {
+		c := t.digest(d.Prefix + fmt.Sprintf("%x", i))
+		if c.Count > 0 {
+			diff.Children = append(diff.Children, c)
+		}
+	}
+	return
+}
+
+// getDigests returns the DHT's digest tree, locked and updated with any changes
+// made since it was last used.  The caller must unlock it.
+func (dht *DHT) getDigests() (t *gossipDigests, err error) {
+	t = dht.digests
+	t.lk.Lock()
+	var idx int
+	idx, err = dht.ht.GetIdx()
+	if err != nil {
+		t.lk.Unlock()
+		return
+	}
+	for i := t.idx + 1; i <= idx; i++ {
+		var msg Message
+		msg, err = dht.ht.GetIdxMessage(i)
+		if err == ErrNoSuchIdx {
+			err = nil
+			continue
+		}
+		if err != nil {
+			t.lk.Unlock()
+			return
+		}
+		var f Hash
+		f, err = msg.Fingerprint()
+		if err != nil {
+			t.lk.Unlock()
+			return
+		}
+		t.add(f)
+		t.idx = i
+	}
+	t.idx = idx
+	return
+}
+
+// compareDigests answers a GossipDigestReq
+func (dht *DHT) compareDigests(req GossipDigestReq) (resp GossipDigestResp, err error) {
+	if len(req.Ranges) > GossipDigestMaxRanges {
+		err = ErrGossipRequestTooLarge
+		return
+	}
+	var t *gossipDigests
+	t, err = dht.getDigests()
+	if err != nil {
+		return
+	}
+	defer t.lk.Unlock()
+	for _, d := range req.Ranges {
+		if !validDigestPrefix(d.Prefix) {
+			err = fmt.Errorf("invalid digest range: %s", d.Prefix)
+			return
+		}
+		if diff := t.compare(d); diff != nil {
+			resp.Diffs = append(resp.Diffs, *diff)
+		}
+	}
+	return
+}
+
+// fetchPuts answers a GossipFetchReq with the changes we have of those asked for
+func (dht *DHT) fetchPuts(req GossipFetchReq) (g Gossip, err error) {
+	if len(req.Fingerprints) > GossipFetchBatchSize {
+		err = ErrGossipRequestTooLarge
+		return
+	}
+	g.Puts = make([]Put, 0)
+	for _, f := range req.Fingerprints {
+		var idx int
+		idx, err = dht.GetFingerprint(f)
+		if err != nil {
+			return
+		}
+		if idx < 0 {
+			continue
+		}
+		p := Put{Idx: idx}
+		p.M, err = dht.ht.GetIdxMessage(idx)
+		if err == ErrNoSuchIdx {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		g.Puts = append(g.Puts, p)
+	}
+	return
+}
+
+// missingFingerprints compares the peer's digests with ours, working down the ranges
+// that differ, and returns the fingerprints of at most max of the changes the peer has
+// that we don't.  Ranges are compared GossipDigestMaxRanges at a time.
+func (dht *DHT) missingFingerprints(id peer.ID, max int) (missing []Hash, err error) {
+	var t *gossipDigests
+	t, err = dht.getDigests()
+	if err != nil {
+		return
+	}
+	pending := []GossipRangeDigest{t.digest("")}
+	t.lk.Unlock()
+
+	for len(pending) > 0 && len(missing) < max {
+		ranges := pending
+		if len(ranges) > GossipDigestMaxRanges {
+			ranges = ranges[:GossipDigestMaxRanges]
+		}
+		pending = pending[len(ranges):]
+
+		var r interface{}
+		r, err = dht.gossipSend(id, GOSSIP_DIGEST_REQUEST, GossipDigestReq{Ranges: ranges})
+		if err != nil {
+			return
+		}
+		resp, ok := r.(GossipDigestResp)
+		if !ok {
+			dht.ratePeer(id, ReputationInvalidGossip)
+			err = fmt.Errorf("expected digest response from %v, got %T", id, r)
+			return
+		}
+
+		t, err = dht.getDigests()
+		if err != nil {
+			return
+		}
+		malformed := false
+		for _, diff := range resp.Diffs {
+			for _, f := range diff.Fingerprints {
+				if !t.has(f) {
+					missing = append(missing, f)
+				}
+			}
+			for _, c := range diff.Children {
+				if len(c.Prefix) <= len(diff.Prefix) || !validDigestPrefix(c.Prefix) {
+					malformed = true
+					continue // ignore malformed ranges which could keep us looping
+				}
+				mine := t.digest(c.Prefix)
+				if mine.Count != c.Count || string(mine.Sum) != string(c.Sum) {
+					pending = append(pending, mine)
+				}
+			}
+		}
+		t.lk.Unlock()
+		if malformed {
+			dht.ratePeer(id, ReputationInvalidGossip)
+		}
+	}
+	if len(missing) > max {
+		missing = missing[:max]
+	}
+	return
+}
+
+// gossipWithDigests gossips with a peer by comparing digests and queuing the changes
+// we are missing for handling
+func (dht *DHT) gossipWithDigests(id peer.ID) (err error) {
+	// only fetch what we have room to queue, we'll find the rest next time
+	max := dht.gossipPuts.Room()
+	if max > GossipMaxFetch {
+		max = GossipMaxFetch
+	}
+	if max == 0 {
+		dht.glog.Log("put queue full, not gossiping")
+		return
+	}
+	var missing []Hash
+	missing, err = dht.missingFingerprints(id, max)
+	if err != nil {
+		return
+	}
+	if len(missing) == 0 {
+		dht.glog.Log("no new puts received")
+		return
+	}
+	dht.glog.Logf("fetching %d missing puts", len(missing))
+	for len(missing) > 0 {
+		batch := missing
+		if len(batch) > GossipFetchBatchSize {
+			batch = batch[:GossipFetchBatchSize]
+		}
+		missing = missing[len(batch):]
+
+		var r interface{}
+		r, err = dht.gossipSend(id, GOSSIP_FETCH_REQUEST, GossipFetchReq{Fingerprints: batch})
+		if err != nil {
+			return
+		}
+		gossip, ok := r.(Gossip)
+		if !ok {
+			dht.ratePeer(id, ReputationInvalidGossip)
+			err = fmt.Errorf("expected gossip response from %v, got %T", id, r)
+			return
+		}
+		dht.glog.Logf("queuing %d puts:\n%v", len(gossip.Puts), gossip.Puts)
+		for _, p := range gossip.Puts {
+			if err = dht.gossipPuts.push(p, false); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -256,6 +256,13 @@ func TestGossipData(t *testing.T) {
 		So(fmt.Sprintf("%v", puts[0].M), ShouldEqual, fmt.Sprintf("%v", *m2))
 		So(puts[0].Idx, ShouldEqual, 4)
 	})
+
+	Convey("getPuts should limit the number of puts", t, func() {
+		puts, err := dht.getPuts(2, 1)
+		So(err, ShouldBeNil)
+		So(len(puts), ShouldEqual, 1)
+		So(puts[0].Idx, ShouldEqual, 2)
+	})
 }
 
 func TestGossip(t *testing.T) {
@@ -300,6 +307,123 @@ func TestGossip(t *testing.T) {
 	})
 }
 
+func TestGossipDigests(t *testing.T) {
+	a := newGossipDigests()
+	b := newGossipDigests()
+	for i := 0; i < 1000; i++ {
+		f := Hash(fmt.Sprintf("change%d", i))
+		a.add(f)
+		b.add(f)
+	}
+	extra := Hash("extra change")
+	b.add(extra)
+
+	Convey("it should not add a fingerprint twice", t, func() {
+		So(a.add(Hash("change1")), ShouldBeFalse)
+		So(a.root.count, ShouldEqual, 1000)
+		So(a.has(Hash("change1")), ShouldBeTrue)
+		So(a.has(extra), ShouldBeFalse)
+	})
+
+	Convey("digests of ranges should match the fingerprints in them", t, func() {
+		d := a.digest("a")
+		So(d.Count, ShouldEqual, len(a.fingerprints("a")))
+		So(d.Count, ShouldBeGreaterThan, 0)
+		So(a.compare(b.digest("")), ShouldNotBeNil)
+	})
+
+	Convey("comparing down the ranges that differ should find the missing fingerprint", t, func() {
+		var missing []Hash
+		ranges := []GossipRangeDigest{a.digest("")}
+		for len(ranges) > 0 {
+			var next []GossipRangeDigest
+			for _, r := range ranges {
+				diff := b.compare(r)
+				if diff == nil {
+					continue
+				}
+				for _, f := range diff.Fingerprints {
+					if !a.has(f) {
+						missing = append(missing, f)
+					}
+				}
+				for _, c := range diff.Children {
+					if mine := a.digest(c.Prefix); mine.Count != c.Count || string(mine.Sum) != string(c.Sum) {
+						next = append(next, mine)
+					}
+				}
+			}
+			ranges = next
+		}
+		So(missing, ShouldResemble, []Hash{extra})
+		a.add(extra)
+		So(b.compare(a.digest("")), ShouldBeNil)
+	})
+
+	Convey("it should check the gossip method", t, func() {
+		So(checkGossipMethod(GossipMethodDigest), ShouldBeNil)
+		So(checkGossipMethod("foo").Error(), ShouldEqual, "Invalid gossip method. Must be one of: puts, digest")
+		So(validDigestPrefix("0af"), ShouldBeTrue)
+		So(validDigestPrefix("0ag"), ShouldBeFalse)
+	})
+}
+
+func TestGossipWithDigests(t *testing.T) {
+	nodesCount := 2
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+
+	h1 := nodes[0]
+	h2 := nodes[1]
+	h1.nucleus.dna.DHTConfig.GossipMethod = GossipMethodDigest
+	h2.nucleus.dna.DHTConfig.GossipMethod = GossipMethodDigest
+
+	commit(h1, "oddNumbers", "3")
+	commit(h1, "oddNumbers", "5")
+	commit(h1, "oddNumbers", "7")
+
+	ringConnect(t, mt.ctx, mt.nodes, nodesCount)
+	Convey("it should only find as many missing puts as asked for", t, func() {
+		missing, err := h2.dht.missingFingerprints(h1.nodeID, 2)
+		So(err, ShouldBeNil)
+		So(len(missing), ShouldEqual, 2)
+	})
+
+	Convey("it should refuse requests for too much", t, func() {
+		_, err := h1.dht.fetchPuts(GossipFetchReq{Fingerprints: make([]Hash, GossipFetchBatchSize+1)})
+		So(err, ShouldEqual, ErrGossipRequestTooLarge)
+		_, err = h1.dht.compareDigests(GossipDigestReq{Ranges: make([]GossipRangeDigest, GossipDigestMaxRanges+1)})
+		So(err, ShouldEqual, ErrGossipRequestTooLarge)
+	})
+
+	Convey("gossipWith should fetch only the missing puts", t, func() {
+		err := h2.dht.gossipWith(h1.nodeID)
+		So(err, ShouldBeNil)
+		So(h2.dht.gossipPuts.Len(), ShouldEqual, 5)
+		go h2.dht.HandleGossipPuts()
+		time.Sleep(time.Millisecond * 100)
+		puts2, _ := h2.dht.GetPuts(0)
+		So(len(puts2), ShouldEqual, 7)
+	})
+
+	commit(h1, "evenNumbers", "2")
+
+	Convey("gossipWith should fetch new puts", t, func() {
+		err := h2.dht.gossipWith(h1.nodeID)
+		So(err, ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		puts2, _ := h2.dht.GetPuts(0)
+		So(len(puts2), ShouldEqual, 8)
+	})
+
+	Convey("gossipWith should fetch nothing when in sync", t, func() {
+		err := h2.dht.gossipWith(h1.nodeID)
+		So(err, ShouldBeNil)
+		So(h2.dht.gossipPuts.Len(), ShouldEqual, 0)
+	})
+}
+
 func TestPeerLists(t *testing.T) {
 	d, _, h := PrepareTestChain("test")
 	defer CleanupTestChain(h, d)
@@ -349,14 +473,14 @@ func TestGossipCycle(t *testing.T) {
 	Convey("handling the gossipWith should result in getting puts, and a gossip back scheduled on receiving node after a delay", t, func() {
 
 		So(len(h1.dht.gchan), ShouldEqual, 0)
-		So(len(h0.dht.gossipPuts), ShouldEqual, 0)
+		So(h0.dht.gossipPuts.Len(), ShouldEqual, 0)
 
 		x, ok := <-h0.dht.gchan
 		So(ok, ShouldBeTrue)
 		err := handleGossipWith(h0.dht, x)
 		So(err, ShouldBeNil)
 		// we got receivers puts back and scheduled
-		So(len(h0.dht.gossipPuts), ShouldEqual, 2)
+		So(h0.dht.gossipPuts.Len(), ShouldEqual, 2)
 
 		So(len(h1.dht.gchan), ShouldEqual, 0)
 		time.Sleep(GossipBackPutDelay * 3)
@@ -392,16 +516,17 @@ func TestGossipErrorCases(t *testing.T) {
 		h1.dht.Put(h1.node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash}), "evenNumbers", hash, h0.nodeID, []byte("bad data"), StatusLive)
 		err := h0.dht.gossipWith(h1.nodeID)
 		So(err, ShouldBeNil)
-		So(len(h0.dht.gossipPuts), ShouldEqual, 3)
+		So(h0.dht.gossipPuts.Len(), ShouldEqual, 3)
 		for i := 0; i < 3; i++ {
-			x, ok := <-h0.dht.gossipPuts
+			x, ok, err := h0.dht.gossipPuts.pop()
+			So(err, ShouldBeNil)
 			So(ok, ShouldBeTrue)
-			err := handleGossipPut(h0.dht, x)
+			err = handleGossipPut(h0.dht, x)
 			So(err, ShouldBeNil)
 		}
 		err = h0.dht.gossipWith(h1.nodeID)
 		So(err, ShouldBeNil)
-		So(len(h0.dht.gossipPuts), ShouldEqual, 0)
+		So(h0.dht.gossipPuts.Len(), ShouldEqual, 0)
 	})
 }
 
@@ -9,6 +9,7 @@ package holochain
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
 	b58 "github.com/jbenet/go-base58"
@@ -17,6 +18,11 @@ import (
 	"time"
 )
 
+// HeaderVersion is the version of the headers that newHeader makes.  Version 0 headers,
+// from before headers had versions, only signed their entry link, whereas later versions
+// sign all their fields.
+const HeaderVersion = 1
+
 type Signature struct {
 	S []byte
 }
@@ -30,6 +36,7 @@ type Header struct {
 	TypeLink   Hash // link to header of previous header of this type
 	Sig        Signature
 	Change     Hash
+	Version    uint64
 }
 
 // newHeader makes Header object linked to a previous Header by hash
@@ -41,14 +48,18 @@ func newHeader(hashSpec HashSpec, now time.Time, t string, entry Entry, privKey
 	hd.HeaderLink = prev
 	hd.TypeLink = prevType
 	hd.Change = change
+	hd.Version = HeaderVersion
 
 	hd.EntryLink, err = entry.Sum(hashSpec)
 	if err != nil {
 		return
 	}
 
-	// sign the hash of the entry
-	sig, err := privKey.Sign([]byte(hd.EntryLink))
+	b, err := hd.signedBytes()
+	if err != nil {
+		return
+	}
+	sig, err := privKey.Sign(b)
 	if err != nil {
 		return
 	}
@@ -72,6 +83,36 @@ func (hd *Header) Sum(spec HashSpec) (hash Hash, b []byte, err error) {
 	return
 }
 
+// signedBytes returns what the header's signature signs, which is its binary marshaling
+// without the signature, or for version 0 headers just its entry link
+func (hd *Header) signedBytes() (b []byte, err error) {
+	if hd.Version == 0 {
+		b = []byte(hd.EntryLink)
+		return
+	}
+	unsigned := *hd
+	unsigned.Sig = Signature{}
+	b, err = unsigned.Marshal()
+	return
+}
+
+// FullySigned returns true if the header's signature covers all its fields and not just
+// its entry link
+func (hd *Header) FullySigned() bool {
+	return hd.Version > 0
+}
+
+// Verify confirms that the header's signature was made with pubKey
+func (hd *Header) Verify(pubKey ic.PubKey) (matches bool, err error) {
+	var b []byte
+	b, err = hd.signedBytes()
+	if err != nil {
+		return
+	}
+	matches, err = pubKey.Verify(b, hd.Sig.S)
+	return
+}
+
 // B58String encodes a signature as a b58string
 func (sig Signature) B58String() (result string) {
 	return b58.Encode(sig.S)
@@ -91,17 +132,61 @@ func SignatureFromB58String(encoded string) (sig Signature) {
 // ToJSON serializes a header to JSON
 func (hd *Header) ToJSON() (result string, err error) {
 	result = fmt.Sprintf(
-		`{"Type":"%s","Time":"%v","EntryLink":"%s","HeaderLink":"%s","TypeLink":"%s","Signature":"%s"}`,
+		`{"Type":"%s","Time":"%s","EntryLink":"%s","HeaderLink":"%s","TypeLink":"%s","Change":"%s","Version":%d,"Signature":"%s"}`,
 		jsSanitizeString(hd.Type),
-		hd.Time,
+		exactTimeString(hd.Time),
 		hd.EntryLink.String(),
 		hd.HeaderLink.String(),
 		hd.TypeLink.String(),
+		hd.Change.String(),
+		hd.Version,
 		hd.Sig.B58String(),
 	)
 	return
 }
 
+// HeaderFromJSON reads a header serialized by ToJSON
+func HeaderFromJSON(j string) (hd Header, err error) {
+	var jh struct {
+		Type       string
+		Time       string
+		EntryLink  string
+		HeaderLink string
+		TypeLink   string
+		Change     string
+		Version    uint64
+		Signature  string
+	}
+	err = json.Unmarshal([]byte(j), &jh)
+	if err != nil {
+		return
+	}
+	hd.Type = jh.Type
+	hd.Time, err = time.Parse(time.RFC3339Nano, jh.Time)
+	if err != nil {
+		if jh.Version > 0 {
+			return
+		}
+		// version 0 headers used to be serialized with an inexact time, which their
+		// signatures don't cover anyway
+		err = nil
+	}
+	links := []*Hash{&hd.EntryLink, &hd.HeaderLink, &hd.TypeLink, &hd.Change}
+	for i, l := range []string{jh.EntryLink, jh.HeaderLink, jh.TypeLink, jh.Change} {
+		// null hashes are written as empty strings
+		if l == "" {
+			continue
+		}
+		*links[i], err = NewHash(l)
+		if err != nil {
+			return
+		}
+	}
+	hd.Version = jh.Version
+	hd.Sig = SignatureFromB58String(jh.Signature)
+	return
+}
+
 // Marshal writes a header to bytes
 func (hd *Header) Marshal() (b []byte, err error) {
 	var s bytes.Buffer
@@ -162,9 +247,8 @@ func MarshalHeader(writer io.Writer, hd *Header) (err error) {
 		return
 	}
 
-	// write out 0 for future expansion (meta)
-	z := uint64(0)
-	err = binary.Write(writer, binary.LittleEndian, &z)
+	// the meta word, which was always 0 before headers had versions
+	err = binary.Write(writer, binary.LittleEndian, &hd.Version)
 	if err != nil {
 		return
 	}
@@ -234,8 +318,7 @@ func UnmarshalHeader(reader io.Reader, hd *Header, hashSize int) (err error) {
 		return
 	}
 
-	z := uint64(0)
-	err = binary.Read(reader, binary.LittleEndian, &z)
+	err = binary.Read(reader, binary.LittleEndian, &hd.Version)
 	if err != nil {
 		return
 	}
@@ -50,7 +50,63 @@ func TestHeaderToJSON(t *testing.T) {
 		j, err := hd.ToJSON()
 		So(err, ShouldBeNil)
 		So(j, ShouldStartWith, `{"Type":"evenNumbers","Time":"`)
-		So(j, ShouldEndWith, `","EntryLink":"QmNiCwBNA8MWDADTFVq1BonUEJbS2SvjAoNkZZrhEwcuU2","HeaderLink":"QmNiCwBNA8MWDADTFVq1BonUEJbS2SvjAoNkZZrhEwcuUi","TypeLink":"","Signature":"3eDinUfqsX4V2iuwFvFNSwyy4KEugYj6DPpssjrAsabkVvozBrWrLJRuA9AXhiN8R3MzZvyLfW2BV8zKDevSDiVR"}`)
+		So(j, ShouldEndWith, `","EntryLink":"QmNiCwBNA8MWDADTFVq1BonUEJbS2SvjAoNkZZrhEwcuU2","HeaderLink":"QmNiCwBNA8MWDADTFVq1BonUEJbS2SvjAoNkZZrhEwcuUi","TypeLink":"","Change":"","Version":0,"Signature":"3eDinUfqsX4V2iuwFvFNSwyy4KEugYj6DPpssjrAsabkVvozBrWrLJRuA9AXhiN8R3MzZvyLfW2BV8zKDevSDiVR"}`)
+	})
+
+	Convey("it should read back a header from JSON", t, func() {
+		modHash, _ := NewHash("QmP1DfoUjiWH2ZBo1PBH6FupdBucbDepx3HpWmEY6JMUpY")
+		for _, tm := range []time.Time{now, now.UTC()} {
+			_, hd, err := newHeader(h, tm, "evenNumbers", &GobEntry{C: "1234"}, key, modHash, NullHash(), modHash)
+			So(err, ShouldBeNil)
+			j, err := hd.ToJSON()
+			So(err, ShouldBeNil)
+			hd1, err := HeaderFromJSON(j)
+			So(err, ShouldBeNil)
+			b, _ := hd.Marshal()
+			b1, _ := hd1.Marshal()
+			So(bytes.Equal(b, b1), ShouldBeTrue)
+			matches, err := hd1.Verify(key.GetPublic())
+			So(err, ShouldBeNil)
+			So(matches, ShouldBeTrue)
+		}
+	})
+}
+
+func TestHeaderVerify(t *testing.T) {
+	h, key, now := chainTestSetup()
+	e := GobEntry{C: "some data"}
+	prev, _ := NewHash("QmP1DfoUjiWH2ZBo1PBH6FupdBucbDepx3HpWmEY6JMUpY")
+
+	Convey("it should sign all the fields of the header", t, func() {
+		_, hd, err := newHeader(h, now, "evenNumbers", &e, key, prev, NullHash(), NullHash())
+		So(err, ShouldBeNil)
+		So(hd.Version, ShouldEqual, HeaderVersion)
+		matches, err := hd.Verify(key.GetPublic())
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeTrue)
+
+		for _, tamper := range []func(hd *Header){
+			func(hd *Header) { hd.HeaderLink = NullHash() },
+			func(hd *Header) { hd.TypeLink = prev },
+			func(hd *Header) { hd.Change = prev },
+			func(hd *Header) { hd.Type = "oddNumbers" },
+			func(hd *Header) { hd.Time = hd.Time.Add(time.Second) },
+			func(hd *Header) { hd.Version = 0 },
+		} {
+			tampered := *hd
+			tamper(&tampered)
+			matches, err = tampered.Verify(key.GetPublic())
+			So(err, ShouldBeNil)
+			So(matches, ShouldBeFalse)
+		}
+	})
+
+	Convey("it should verify version 0 headers by their entry link", t, func() {
+		hd := testHeader(h, "evenNumbers", &e, key, now)
+		So(hd.FullySigned(), ShouldBeFalse)
+		matches, err := hd.Verify(key.GetPublic())
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeTrue)
 	})
 }
 
@@ -0,0 +1,310 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements getting the revision history of an entry by walking the graph of modifications
+// that replaced it and that it replaced
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// MaxHistoryRevisions is the most revisions of an entry a history walks, so that a long
+// or maliciously built chain of modifications can't make us walk forever
+const MaxHistoryRevisions = 1000
+
+var ErrHistoryLoop = errors.New("history loop detected")
+var ErrHistoryHeaderMismatch = errors.New("revision header doesn't match its entry")
+
+// Revision is one version of an entry in its history of modifications
+type Revision struct {
+	Hash       string
+	Header     Header
+	Author     string // node ID of the agent that committed the revision
+	Entry      GobEntry
+	EntryType  string
+	Replaces   string   // hash of the revision this one modified, empty for the original
+	ReplacedBy []string // hashes of the revisions that modified this one
+	NoHeader   bool     // the author couldn't be reached for the header, so Replaces isn't known
+}
+
+// HistoryResp holds the revisions of an entry, starting with the original and then in the
+// order they were found following the modifications that replaced them.  Heads are the
+// revisions that haven't been replaced, and Forks the revisions that were replaced by more
+// than one modification, i.e. where competing modifications were made.  Partial is set
+// when revisions may be missing, because a revision's header couldn't be got from its
+// author or because there were more than MaxHistoryRevisions of them.
+type HistoryResp struct {
+	Original  string
+	Revisions []Revision
+	Heads     []string
+	Forks     []string
+	Partial   bool
+}
+
+// Conflicted returns true if the history has competing modifications
+func (r *HistoryResp) Conflicted() bool {
+	return len(r.Forks) > 0
+}
+
+// getHistory walks back from an entry to the original it is a modification of, and from there
+// forward through every modification that replaced it, returning all the revisions found
+func (h *Holochain) getHistory(hash Hash) (resp HistoryResp, err error) {
+	revisions := make(map[string]*Revision)
+	var rev *Revision
+	for {
+		if _, seen := revisions[hash.String()]; seen {
+			err = ErrHistoryLoop
+			return
+		}
+		if len(revisions) == MaxHistoryRevisions {
+			// go forward from the oldest revision we got to
+			resp.Partial = true
+			break
+		}
+		rev, err = h.getRevision(hash)
+		if err != nil {
+			return
+		}
+		revisions[rev.Hash] = rev
+		if rev.NoHeader {
+			resp.Partial = true
+			break
+		}
+		if rev.Header.Change.IsNullHash() {
+			break
+		}
+		hash = rev.Header.Change
+		rev.Replaces = hash.String()
+	}
+	resp.Original = rev.Hash
+
+	queue := []*Revision{rev}
+	queued := map[string]bool{rev.Hash: true}
+	for len(queue) > 0 {
+		rev = queue[0]
+		queue = queue[1:]
+		rev.ReplacedBy, err = h.getReplacements(rev.Hash)
+		if err != nil {
+			return
+		}
+		// the DHT may not have the replacement link to a revision we walked back from yet
+		for _, r := range revisions {
+			if r.Replaces == rev.Hash && !contains(rev.ReplacedBy, r.Hash) {
+				rev.ReplacedBy = append(rev.ReplacedBy, r.Hash)
+			}
+		}
+		switch len(rev.ReplacedBy) {
+		case 0:
+			resp.Heads = append(resp.Heads, rev.Hash)
+		case 1:
+		default:
+			resp.Forks = append(resp.Forks, rev.Hash)
+		}
+		for _, r := range rev.ReplacedBy {
+			// a revision that is already queued is either a later replacement of
+			// one we've seen or an earlier revision, which would be a loop
+			if queued[r] {
+				continue
+			}
+			next, ok := revisions[r]
+			if !ok {
+				if len(revisions) >= MaxHistoryRevisions {
+					resp.Partial = true
+					continue
+				}
+				hash, err = NewHash(r)
+				if err != nil {
+					return
+				}
+				next, err = h.getRevision(hash)
+				if err != nil {
+					return
+				}
+				if next.NoHeader {
+					resp.Partial = true
+				}
+				revisions[r] = next
+			}
+			next.Replaces = rev.Hash
+			queued[r] = true
+			queue = append(queue, next)
+		}
+		resp.Revisions = append(resp.Revisions, *rev)
+	}
+	return
+}
+
+// getRevision gets an entry whatever its status along with its author and header, which
+// comes from our own chain or else from the author, whose signature on it is checked.
+// If the author can't be reached the revision is returned without its header.
+func (h *Holochain) getRevision(hash Hash) (rev *Revision, err error) {
+	req := GetReq{H: hash, StatusMask: StatusAny, GetMask: GetMaskEntry | GetMaskEntryType | GetMaskSources}
+	var r interface{}
+	r, err = callGet(h, req, &GetOptions{StatusMask: req.StatusMask, GetMask: req.GetMask})
+	if err != nil {
+		return
+	}
+	resp := r.(GetResp)
+	rev = &Revision{Hash: hash.String(), Entry: resp.Entry, EntryType: resp.EntryType}
+	if len(resp.Sources) > 0 {
+		rev.Author = resp.Sources[0]
+	}
+
+	hd, e := h.chain.GetEntryHeader(hash)
+	if e == nil {
+		rev.Header = *hd
+		if rev.Author == "" {
+			rev.Author = h.nodeIDStr
+		}
+		return
+	}
+	if rev.Author == "" {
+		err = fmt.Errorf("no author for revision %v", hash)
+		return
+	}
+	var author peer.ID
+	author, err = peer.IDB58Decode(rev.Author)
+	if err != nil {
+		return
+	}
+	msg := h.node.NewMessage(HEADER_REQUEST, ValidateQuery{H: hash})
+	r, err = h.Send(h.node.ctx, ValidateProtocol, author, msg, 0)
+	if err != nil {
+		h.dht.dlog.Logf("unable to get header of revision %v from %v: %v", hash, author, err)
+		rev.NoHeader = true
+		err = nil
+		return
+	}
+	header, ok := r.(Header)
+	if !ok {
+		err = fmt.Errorf("expected Header from author got %T", r)
+		return
+	}
+	if !header.EntryLink.Equal(hash) {
+		err = ErrHistoryHeaderMismatch
+		return
+	}
+	var pub ic.PubKey
+	pub, err = h.getSourcePubKey(author)
+	if err != nil {
+		return
+	}
+	var matches bool
+	matches, err = header.Verify(pub)
+	if err != nil {
+		return
+	}
+	if !matches {
+		err = ErrHistoryHeaderMismatch
+		return
+	}
+	rev.Header = header
+	return
+}
+
+// getRevisionHeader answers a HEADER_REQUEST with the header of an entry on our chain,
+// unless the entry is private
+func (h *Holochain) getRevisionHeader(hash Hash) (header Header, err error) {
+	var hd *Header
+	hd, err = h.chain.GetEntryHeader(hash)
+	if err != nil {
+		return
+	}
+	var def *EntryDef
+	_, def, err = h.GetEntryDef(hd.Type)
+	if err != nil {
+		return
+	}
+	if def.Sharing == Private {
+		err = ErrHashNotFound
+		return
+	}
+	header = *hd
+	return
+}
+
+// getReplacements returns the hashes of the modifications that replaced an entry
+func (h *Holochain) getReplacements(hash string) (replacements []string, err error) {
+	var base Hash
+	base, err = NewHash(hash)
+	if err != nil {
+		return
+	}
+	var r interface{}
+	r, err = h.dht.Query(base, GETLINK_REQUEST, LinkQuery{Base: base, T: SysTagReplacedBy, StatusMask: StatusLive})
+	if err != nil {
+		return
+	}
+	resp, ok := r.(*LinkQueryResp)
+	if !ok {
+		err = fmt.Errorf("unexpected response type from GETLINK_REQUEST: %T", r)
+		return
+	}
+	for _, l := range resp.Links {
+		replacements = append(replacements, l.H)
+	}
+	return
+}
+
+// historyJSON renders a history for the ribosomes, with the entries of revisions whose
+// type has the JSON data format included as JSON
+func (h *Holochain) historyJSON(resp *HistoryResp) (result string, err error) {
+	type revisionJSON struct {
+		Hash       string
+		Header     json.RawMessage
+		Author     string
+		EntryType  string
+		Entry      json.RawMessage
+		Replaces   string
+		ReplacedBy []string
+		NoHeader   bool
+	}
+	type historyJSON struct {
+		Original  string
+		Revisions []revisionJSON
+		Heads     []string
+		Forks     []string
+		Partial   bool
+	}
+	hj := historyJSON{Original: resp.Original, Heads: resp.Heads, Forks: resp.Forks, Partial: resp.Partial}
+	if hj.Forks == nil {
+		hj.Forks = []string{}
+	}
+	for i := range resp.Revisions {
+		rev := &resp.Revisions[i]
+		rj := revisionJSON{Hash: rev.Hash, Author: rev.Author, EntryType: rev.EntryType, Replaces: rev.Replaces, ReplacedBy: rev.ReplacedBy, NoHeader: rev.NoHeader}
+		if rj.ReplacedBy == nil {
+			rj.ReplacedBy = []string{}
+		}
+		var hd string
+		hd, err = rev.Header.ToJSON()
+		if err != nil {
+			return
+		}
+		rj.Header = json.RawMessage(hd)
+		content := entryContentString(&rev.Entry)
+		_, def, e := h.GetEntryDef(rev.EntryType)
+		if e == nil && def.DataFormat == DataFormatJSON && json.Valid([]byte(content)) {
+			rj.Entry = json.RawMessage(content)
+		} else {
+			rj.Entry, err = json.Marshal(content)
+			if err != nil {
+				return
+			}
+		}
+		hj.Revisions = append(hj.Revisions, rj)
+	}
+	var b []byte
+	b, err = json.Marshal(hj)
+	result = string(b)
+	return
+}
@@ -0,0 +1,119 @@
+package holochain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetHistory(t *testing.T) {
+	nodesCount := 3
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	h2 := nodes[2]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+
+	update := func(h *Holochain, content string, replaces Hash) Hash {
+		r, err := (&APIFnMod{action: *NewModAction("review", &GobEntry{C: content}, replaces)}).Call(h)
+		if err != nil {
+			panic(err)
+		}
+		return r.(Hash)
+	}
+	original := commit(h0, "review", "first take")
+	second := update(h0, "second take", original)
+	competing := update(h1, "competing take", original)
+	third := update(h0, "third take", second)
+	for _, h := range nodes {
+		processChangeRequestsInTesting(h)
+	}
+
+	var history HistoryResp
+	Convey("it should walk back to the original and forward through every revision", t, func() {
+		req := GetReq{H: second, StatusMask: StatusDefault, GetMask: GetMaskDefault}
+		r, err := callGet(h2, req, &GetOptions{History: true})
+		So(err, ShouldBeNil)
+		history = r.(HistoryResp)
+		So(history.Original, ShouldEqual, original.String())
+		So(len(history.Revisions), ShouldEqual, 4)
+		So(history.Revisions[0].Hash, ShouldEqual, original.String())
+		So(history.Revisions[0].Replaces, ShouldEqual, "")
+		So(len(history.Revisions[0].ReplacedBy), ShouldEqual, 2)
+		So(history.Revisions[3].Hash, ShouldEqual, third.String())
+		So(history.Revisions[3].Replaces, ShouldEqual, second.String())
+		So(history.Revisions[3].Entry.C, ShouldEqual, "third take")
+	})
+
+	Convey("it should report competing modifications", t, func() {
+		So(history.Conflicted(), ShouldBeTrue)
+		So(history.Forks, ShouldResemble, []string{original.String()})
+		So(len(history.Heads), ShouldEqual, 2)
+		So(history.Heads, ShouldContain, competing.String())
+		So(history.Heads, ShouldContain, third.String())
+	})
+
+	Convey("revisions should have their headers and authors", t, func() {
+		for _, rev := range history.Revisions {
+			So(rev.Header.EntryLink.String(), ShouldEqual, rev.Hash)
+			So(rev.Header.Change.String(), ShouldEqual, rev.Replaces)
+			if rev.Hash == competing.String() {
+				So(rev.Author, ShouldEqual, h1.nodeIDStr)
+			} else {
+				So(rev.Author, ShouldEqual, h0.nodeIDStr)
+			}
+		}
+	})
+
+	Convey("an unmodified entry should be its own history", t, func() {
+		hash := commit(h0, "review", "just the one")
+		for _, h := range nodes {
+			processChangeRequestsInTesting(h)
+		}
+		r, err := callGet(h0, GetReq{H: hash}, &GetOptions{History: true})
+		So(err, ShouldBeNil)
+		hr := r.(HistoryResp)
+		So(len(hr.Revisions), ShouldEqual, 1)
+		So(hr.Heads, ShouldResemble, []string{hash.String()})
+		So(hr.Conflicted(), ShouldBeFalse)
+	})
+
+	Convey("it should return a partial history when an author can't be reached", t, func() {
+		h2.node.BlockFor(h1.nodeID, time.Minute)
+		defer h2.node.Unblock(h1.nodeID)
+		r, err := callGet(h2, GetReq{H: second}, &GetOptions{History: true})
+		So(err, ShouldBeNil)
+		hr := r.(HistoryResp)
+		So(hr.Partial, ShouldBeTrue)
+		So(len(hr.Revisions), ShouldEqual, 4)
+		for _, rev := range hr.Revisions {
+			So(rev.NoHeader, ShouldEqual, rev.Hash == competing.String())
+		}
+		So(history.Partial, ShouldBeFalse)
+	})
+
+	Convey("authors should only give out the headers of entries that aren't private", t, func() {
+		hd, err := h1.getRevisionHeader(competing)
+		So(err, ShouldBeNil)
+		So(hd.EntryLink.String(), ShouldEqual, competing.String())
+
+		secret := commit(h1, "secret", "31415")
+		_, err = h1.getRevisionHeader(secret)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should render the history for the ribosomes", t, func() {
+		j, err := h2.historyJSON(&history)
+		So(err, ShouldBeNil)
+		var x map[string]interface{}
+		So(json.Unmarshal([]byte(j), &x), ShouldBeNil)
+		So(x["Original"], ShouldEqual, original.String())
+		So(len(x["Revisions"].([]interface{})), ShouldEqual, 4)
+		So(len(x["Forks"].([]interface{})), ShouldEqual, 1)
+	})
+}
@@ -9,7 +9,6 @@ package holochain
 import (
 	"bytes"
 	"context"
-	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -61,6 +60,9 @@ type Config struct {
 	EnableNATUPnP    bool
 	EnableWorldModel bool
 	BootstrapServer  string
+	DHTStore         string // the HashTable backend used to store the dht, i.e. buntdb or bolt
+	StoreEncryption  string // encryption of the chain and dht stores at rest, one of none, agent or passphrase
+	Agent            string // name of the service agent the chain runs as, empty for the service's agent
 	Loggers          Loggers
 
 	holdingCheckInterval     time.Duration
@@ -99,6 +101,7 @@ type Holochain struct {
 	gossipProtocol   *Protocol
 	actionProtocol   *Protocol
 	asyncSends       chan error
+	storeCipher      *storeCipher // non-nil when the chain and dht stores are encrypted at rest
 }
 
 func (h *Holochain) Nucleus() (n *Nucleus) {
@@ -171,31 +174,36 @@ var _holochainInitialized bool
 func InitializeHolochain() {
 	// this should only run once
 	if !_holochainInitialized {
-		gob.Register(Header{})
-		gob.Register(AgentEntry{})
-		gob.Register(HoldReq{})
-		gob.Register(HoldResp{})
-		gob.Register(GetReq{})
-		gob.Register(GetResp{})
-		gob.Register(LinkQuery{})
-		gob.Register(GossipReq{})
-		gob.Register(Gossip{})
-		gob.Register(ValidateQuery{})
-		gob.Register(ValidateResponse{})
-		gob.Register(Put{})
-		gob.Register(GobEntry{})
-		gob.Register(LinkQueryResp{})
-		gob.Register(TaggedHash{})
-		gob.Register(ErrorResponse{})
-		gob.Register(DelEntry{})
-		gob.Register(Package{})
-		gob.Register(AppMsg{})
-		gob.Register(ListAddReq{})
-		gob.Register(FindNodeReq{})
-		gob.Register(CloserPeersResp{})
-		gob.Register(PeerInfo{})
+		RegisterEncodedType("Header", Header{})
+		RegisterEncodedType("AgentEntry", AgentEntry{})
+		RegisterEncodedType("HoldReq", HoldReq{})
+		RegisterEncodedType("HoldResp", HoldResp{})
+		RegisterEncodedType("GetReq", GetReq{})
+		RegisterEncodedType("GetResp", GetResp{})
+		RegisterEncodedType("LinkQuery", LinkQuery{})
+		RegisterEncodedType("GossipReq", GossipReq{})
+		RegisterEncodedType("Gossip", Gossip{})
+		RegisterEncodedType("ValidateQuery", ValidateQuery{})
+		RegisterEncodedType("ValidateResponse", ValidateResponse{})
+		RegisterEncodedType("SealedEntry", SealedEntry{})
+		RegisterEncodedType("Put", Put{})
+		RegisterEncodedType("GobEntry", GobEntry{})
+		RegisterEncodedType("LinkQueryResp", LinkQueryResp{})
+		RegisterEncodedType("TaggedHash", TaggedHash{})
+		RegisterEncodedType("ErrorResponse", ErrorResponse{})
+		RegisterEncodedType("DelEntry", DelEntry{})
+		RegisterEncodedType("Package", Package{})
+		RegisterEncodedType("AppMsg", AppMsg{})
+		RegisterEncodedType("ListAddReq", ListAddReq{})
+		RegisterEncodedType("FindNodeReq", FindNodeReq{})
+		RegisterEncodedType("CloserPeersResp", CloserPeersResp{})
+		RegisterEncodedType("PeerInfo", PeerInfo{})
+		RegisterEncodedType("GossipDigestReq", GossipDigestReq{})
+		RegisterEncodedType("GossipDigestResp", GossipDigestResp{})
+		RegisterEncodedType("GossipFetchReq", GossipFetchReq{})
 
 		RegisterBultinRibosomes()
+		RegisterBuiltinHashTables()
 
 		infoLog.New(nil)
 		infoLog.Enabled = true
@@ -280,6 +288,12 @@ func (h *Holochain) PrepareHashType() (err error) {
 
 // createNode creates a network node based on the current agent and port data
 func (h *Holochain) createNode() (err error) {
+	h.node, err = h.newNode()
+	return
+}
+
+// newNode makes a network node for the agent's current key
+func (h *Holochain) newNode() (node *Node, err error) {
 	var ip string
 	if os.Getenv("_HCTEST") == "1" {
 		ip = "127.0.0.1"
@@ -287,7 +301,13 @@ func (h *Holochain) createNode() (err error) {
 		ip = "0.0.0.0"
 	}
 	listenaddr := fmt.Sprintf("/ip4/%s/tcp/%d", ip, h.Config.DHTPort)
-	h.node, err = NewNode(listenaddr, h.dnaHash.String(), h.Agent().(*LibP2PAgent), h.Config.EnableNATUPnP, &h.Config.Loggers.Debug)
+	node, err = NewNode(listenaddr, h.dnaHash.String(), h.Agent(), h.Config.EnableNATUPnP, &h.Config.Loggers.Debug)
+	if err != nil {
+		return
+	}
+	node.wireEncryption = h.nucleus.dna.DHTConfig.WireEncryption
+	node.encoding = h.nucleus.dna.DHTConfig.Encoding
+	node.reputation = newReputation(h.nucleus.dna.DHTConfig.reputationThreshold())
 	return
 }
 
@@ -312,11 +332,14 @@ func (h *Holochain) Prepare() (err error) {
 		return
 	}
 
-	h.dht = NewDHT(h)
+	h.dht, err = NewDHT(h)
+	if err != nil {
+		return
+	}
 	h.nucleus.h = h
 
 	if h.Config.EnableWorldModel {
-		h.world = NewWorld(h.node.HashAddr, h.dht, &h.Config.Loggers.World)
+		h.world = NewWorld(h.node.HashAddr, h.dht.ht, h.dht.sharding, &h.Config.Loggers.World)
 	}
 
 	var peerList PeerList
@@ -408,9 +431,16 @@ func (h *Holochain) Started() bool {
 
 // AddAgentEntry adds a new sys entry type setting the current agent data (identity and key)
 func (h *Holochain) AddAgentEntry(revocation Revocation) (headerHash, agentHash Hash, err error) {
+	headerHash, agentHash, err = h.addAgentEntry(h.agent, revocation)
+	return
+}
+
+// addAgentEntry adds an agent entry setting the data of agent, signed by the current agent
+// so that a change of key is signed by the key being revoked
+func (h *Holochain) addAgentEntry(agent Agent, revocation Revocation) (headerHash, agentHash Hash, err error) {
 	var entry AgentEntry
 
-	entry, err = h.agent.AgentEntry(revocation)
+	entry, err = agent.AgentEntry(revocation)
 	if err != nil {
 		return
 	}
@@ -537,6 +567,10 @@ func (config *Config) Setup() (err error) {
 		config.gossipInterval = DefaultGossipInterval
 	}
 
+	if err = checkStoreEncryption(config.StoreEncryption); err != nil {
+		return
+	}
+
 	config.bootstrapRefreshInterval = BootstrapTTL
 	config.routingRefreshInterval = DefaultRoutingRefreshInterval
 	config.retryInterval = DefaultRetryInterval
@@ -641,6 +675,12 @@ func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
 		d = HeadersEntryDef
 	case DelEntryType:
 		d = DelEntryDef
+	case ChunkEntryType:
+		d = ChunkEntryDef
+	case PrivateChunkEntryType:
+		d = PrivateChunkEntryDef
+	case MigrationEntryType:
+		d = MigrationEntryDef
 	default:
 		for _, z := range h.nucleus.dna.Zomes {
 			d, err = z.GetEntryDef(t)
@@ -654,7 +694,7 @@ func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
 }
 
 func (h *Holochain) GetPrivateEntryDefs() (privateDefs []EntryDef) {
-	privateDefs = make([]EntryDef, 0)
+	privateDefs = []EntryDef{*PrivateChunkEntryDef}
 	for _, z := range h.nucleus.dna.Zomes {
 		privateDefs = append(privateDefs, z.GetPrivateEntryDefs()...)
 	}
@@ -748,7 +788,12 @@ func (h *Holochain) Reset() (err error) {
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName))
+	h.chain, err = newChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName), h.storeCipher)
+	if err != nil {
+		return
+	}
+	h.chain.encoding = h.nucleus.dna.DHTConfig.Encoding
+	err = h.chain.OpenIndexes(h.nucleus.dna.indexedFields(), filepath.Join(h.DBPath(), StoreIndexFileName))
 	if err != nil {
 		return
 	}
@@ -872,6 +917,13 @@ func BootstrapRefreshTask(h *Holochain) {
 
 // Send builds a message and either delivers it locally or over the network via node.Send
 func (h *Holochain) Send(basectx context.Context, proto int, to peer.ID, message *Message, timeout time.Duration) (response interface{}, err error) {
+	response, _, err = h.send(basectx, proto, to, message, timeout)
+	return
+}
+
+// send is Send that also returns whether the message was answered, either with a response
+// or an error response, rather than failing to be sent or timing out
+func (h *Holochain) send(basectx context.Context, proto int, to peer.ID, message *Message, timeout time.Duration) (response interface{}, answered bool, err error) {
 	f, err := message.Fingerprint()
 	if err != nil {
 		panic(fmt.Sprintf("error calculating fingerprint when sending message %v", message))
@@ -882,12 +934,14 @@ func (h *Holochain) Send(basectx context.Context, proto int, to peer.ID, message
 	ctx, cancel := context.WithTimeout(basectx, timeout)
 	defer cancel()
 	sent := make(chan error, 1)
+	var replied bool
 	go func() {
 		// if we are sending to ourselves we should bypass the network mechanics and call
 		// the receiver directly
 		if to == h.node.HashAddr {
 			h.Debugf("Sending message (local):%v (fingerprint:%s)", message, f)
 			response, err = h.node.protocols[proto].Receiver(h, message)
+			replied = true
 			h.Debugf("send result (local): %v (fp:%s)error:%v", response, f, err)
 		} else {
 			h.Debugf("Sending message to %v (net):%v (fingerprint:%s)", to, message, f)
@@ -899,6 +953,7 @@ func (h *Holochain) Send(basectx context.Context, proto int, to peer.ID, message
 				sent <- err
 				return
 			}
+			replied = true
 			if r.Type == ERROR_RESPONSE {
 				errResp := r.Body.(ErrorResponse)
 				err = errResp.DecodeResponseError()
@@ -916,6 +971,7 @@ func (h *Holochain) Send(basectx context.Context, proto int, to peer.ID, message
 			err = SendTimeoutErr
 		}
 	case err = <-sent:
+		answered = replied
 	}
 	return
 }
@@ -941,6 +997,7 @@ type QueryReturn struct {
 	Hashes  bool
 	Entries bool
 	Headers bool
+	Fields  []string // if set, JSON entries are returned with only these field paths
 }
 
 type QueryConstrain struct {
@@ -948,6 +1005,7 @@ type QueryConstrain struct {
 	Contains   string
 	Equals     string
 	Matches    string
+	Where      *QueryCondition // composable constraints on entry and header fields
 	Count      int
 	Page       int
 }
@@ -992,11 +1050,28 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 	} else {
 		chain = h.chain
 	}
+	if options.Constrain.Where != nil {
+		if err = options.Constrain.Where.prepare(); err != nil {
+			return
+		}
+	}
 	var re *regexp.Regexp
 	var equalsMap, containsMap map[string]interface{}
 	var reMap map[string]*regexp.Regexp
 	defs := make(map[string]*EntryDef)
-	for i, header := range chain.Headers {
+
+	// use the chain's secondary indexes to limit the entries to check when we can
+	positions, indexed := chain.indexCandidates(options.Constrain.EntryTypes, options.Constrain.Where)
+	count := len(chain.Headers)
+	if indexed {
+		count = len(positions)
+	}
+	for p := 0; p < count; p++ {
+		i := p
+		if indexed {
+			i = positions[p]
+		}
+		header := chain.Headers[i]
 
 		var def *EntryDef
 		var ok bool
@@ -1065,7 +1140,9 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 					}
 					skip = true
 					for fieldName, fieldValue := range containsMap {
-						if strings.Index(contentMap[fieldName].(string), fieldValue.(string)) >= 0 {
+						fieldContent, ok1 := queryString(contentMap[fieldName])
+						contains, ok2 := queryString(fieldValue)
+						if ok1 && ok2 && strings.Index(fieldContent, contains) >= 0 {
 							skip = false
 							break
 						}
@@ -1086,7 +1163,12 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 						}
 						reMap = make(map[string]*regexp.Regexp)
 						for fieldName, fieldValue := range reMapStr {
-							reMap[fieldName], err = regexp.Compile(fieldValue.(string))
+							reStr, ok := fieldValue.(string)
+							if !ok {
+								err = fmt.Errorf("Matches constraint for %s must be a string", fieldName)
+								return
+							}
+							reMap[fieldName], err = regexp.Compile(reStr)
 							if err != nil {
 								return
 							}
@@ -1094,7 +1176,8 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 					}
 					skip = true
 					for fieldName, fieldRe := range reMap {
-						if fieldRe.Match([]byte(contentMap[fieldName].(string))) {
+						fieldContent, ok := queryString(contentMap[fieldName])
+						if ok && fieldRe.MatchString(fieldContent) {
 							skip = false
 							break
 						}
@@ -1116,12 +1199,32 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 			}
 		}
 
+		project := options.Return.Entries && len(options.Return.Fields) > 0 && def.DataFormat == DataFormatJSON
+		var item *queryItem
+		if !skip && (options.Constrain.Where != nil || project) {
+			item = &queryItem{header: header}
+			item.content, err = queryContent(def, chain.Entries[i])
+			if err != nil {
+				return
+			}
+			if options.Constrain.Where != nil {
+				skip = !options.Constrain.Where.match(item)
+			}
+		}
+
 		if !skip {
 			// we always need the header to be returned at this level.  The
 			// Return values gets limited down to the actual info in the Ribosomes
 			qr := QueryResult{Header: header}
 			if options.Return.Entries {
-				qr.Entry = chain.Entries[i]
+				if project {
+					qr.Entry, err = queryProject(item.content, options.Return.Fields)
+					if err != nil {
+						return
+					}
+				} else {
+					qr.Entry = chain.Entries[i]
+				}
 			}
 			if options.Order.Ascending {
 				results = append([]QueryResult{qr}, results...)
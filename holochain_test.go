@@ -281,10 +281,9 @@ func TestNewEntry(t *testing.T) {
 		So(headerHash.String(), ShouldEqual, hh.String())
 	})
 
-	Convey("it should have signed the entry with my key", t, func() {
-		sig := header.Sig
-		hash := header.EntryLink
-		valid, err := h.agent.PrivKey().GetPublic().Verify([]byte(hash), sig.S)
+	Convey("it should have signed the header with my key", t, func() {
+		So(header.FullySigned(), ShouldBeTrue)
+		valid, err := header.Verify(h.agent.PrivKey().GetPublic())
 		So(err, ShouldBeNil)
 		So(valid, ShouldBeTrue)
 	})
@@ -720,6 +719,74 @@ func TestQuery(t *testing.T) {
 		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Pebbles","lastName":"Flintstone"}`)
 		So(results[1].Entry.Content(), ShouldEqual, `{"firstName":"Zerbina","lastName":"Pinhead"}`)
 	})
+	Convey("query with where conditions should combine them", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Constrain.Where = &QueryCondition{And: []QueryCondition{
+			{Field: "lastName", Op: QueryOpEquals, Value: "Pinhead"},
+			{Not: &QueryCondition{Field: "firstName", Op: QueryOpMatches, Value: "^Zi"}},
+		}}
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 1)
+		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Zerbina","lastName":"Pinhead"}`)
+
+		q.Constrain.Where = &QueryCondition{Or: []QueryCondition{
+			{Field: "firstName", Op: QueryOpIn, Value: []interface{}{"Pebbles", "Zippy"}},
+			{Field: "lastName", Op: QueryOpContains, Value: "stone"},
+		}}
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 2)
+		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Pebbles","lastName":"Flintstone"}`)
+		So(results[1].Entry.Content(), ShouldEqual, `{"firstName":"Zippy","lastName":"Pinhead"}`)
+	})
+	Convey("query with where conditions on header fields", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.Where = &QueryCondition{Field: "Header.Type", Op: QueryOpEquals, Value: "oddNumbers"}
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 2)
+
+		q.Constrain.Where = &QueryCondition{Field: "Header.Time", Op: QueryOpLT, Value: "2000-01-01T00:00:00Z"}
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 0)
+
+		q.Constrain.Where = &QueryCondition{Field: "Header.Time", Op: QueryOpBetween, Value: []interface{}{"2000-01-01T00:00:00Z", time.Now().Add(time.Hour)}}
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 10)
+	})
+	Convey("query with where conditions should give the same results using indexes", t, func() {
+		err := h.chain.OpenIndexes(map[string][]string{"profile": {"firstName", "lastName"}}, filepath.Join(h.DBPath(), StoreIndexFileName))
+		So(err, ShouldBeNil)
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Constrain.Where = &QueryCondition{And: []QueryCondition{
+			{Field: "lastName", Op: QueryOpEquals, Value: "Pinhead"},
+			{Not: &QueryCondition{Field: "firstName", Op: QueryOpMatches, Value: "^Zi"}},
+		}}
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 1)
+		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Zerbina","lastName":"Pinhead"}`)
+	})
+	Convey("query with an invalid where condition should fail", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.Where = &QueryCondition{Field: "firstName", Op: "~"}
+		_, err := h.Query(q)
+		So(err.Error(), ShouldEqual, "invalid query condition: unknown operator ~")
+	})
+	Convey("query with return fields should project JSON entries", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Return.Fields = []string{"firstName"}
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 3)
+		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Pebbles"}`)
+	})
 	Convey("query from bundle", t, func() {
 		q := &QueryOptions{Bundle: true}
 		_, err := h.Query(q)
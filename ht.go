@@ -8,9 +8,13 @@
 package holochain
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
+	"sort"
+	"strings"
 )
 
 const (
@@ -62,6 +66,15 @@ const (
 	ReceiptRejected
 )
 
+const (
+	// constants for the built in HashTable storage backends
+
+	BuntHTType = "buntdb"
+	BoltHTType = "bolt"
+
+	DefaultHashTableType = BuntHTType
+)
+
 // TaggedHash holds associated entries for the LinkQueryResponse
 type TaggedHash struct {
 	H         string // the hash of the link; gets filled by dht base node when answering get link request
@@ -69,6 +82,7 @@ type TaggedHash struct {
 	EntryType string // the entry type of the link, gets filled if options set Load to true
 	T         string // the tag of the link, gets filled only if a tag wasn't specified and all tags are being returns
 	Source    string // the statuses on the link, gets filled if options set Load to true
+	changed   int64  // unix nano time of the last change to the link, used for ordering results
 }
 
 var ErrLinkNotFound = errors.New("link not found")
@@ -77,6 +91,7 @@ var ErrHashDeleted = errors.New("hash deleted")
 var ErrHashModified = errors.New("hash modified")
 var ErrHashRejected = errors.New("hash rejected")
 var ErrEntryTypeMismatch = errors.New("entry type mismatch")
+var ErrGossiperNotFound = errors.New("not found")
 
 type HashTableIterateFn func(hash Hash) (stop bool)
 
@@ -116,6 +131,10 @@ type HashTable interface {
 	// GetLinks retrieves meta value associated with a base
 	GetLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error)
 
+	// QueryLinks returns a page of the links on a base in the query's order, seeking to the
+	// query's cursor in an index kept for that order rather than loading all the base's links
+	QueryLinks(query *LinkQuery) (results []TaggedHash, cursor string, err error)
+
 	// GetIdx returns the current index of changes to the HashTable
 	GetIdx() (idx int, err error)
 
@@ -131,6 +150,233 @@ type HashTable interface {
 	// Iterate call fn on all the hashes in the table
 	Iterate(fn HashTableIterateFn)
 
+	// GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
+	GetFingerprint(f Hash) (index int, err error)
+
+	// GetGossiper returns the last known index of the gossiper, or 0 if it's not known
+	GetGossiper(id peer.ID) (idx int, err error)
+
+	// UpdateGossiper sets the last known index of a gossiper, adding it if it didn't exist before
+	// N.B. the index is never moved backwards
+	UpdateGossiper(id peer.ID, newIdx int) (err error)
+
+	// DeleteGossiper removes a gossiper from the table
+	DeleteGossiper(id peer.ID) (err error)
+
+	// GetGossipers returns the ids of all the gossipers in the table
+	GetGossipers() (glist []peer.ID, err error)
+
+	// GetList returns the peer list of the given type
+	GetList(listType PeerListType) (result PeerList, err error)
+
+	// AddToList adds the peers to a list
+	AddToList(m *Message, list PeerList) (err error)
+
+	// RemoveFromList removes the peers from a list
+	RemoveFromList(list PeerList) (err error)
+
 	// GetReceipts returns a list of receipts that were generated regarding a hash
 	//GetReceipts()
 }
+
+// linkOrderKey builds the key a link is indexed under, after its base, for the given order
+// the key is unique per link so that cursors are stable across pages
+func linkOrderKey(order string, link string, tag string, changed int64) (key string, err error) {
+	switch order {
+	case LinkOrderDefault:
+		key = link + ":" + tag
+	case LinkOrderTag:
+		key = tag + "\x00" + link
+	case LinkOrderTime:
+		key = fmt.Sprintf("%020d\x00%s\x00%s", changed, link, tag)
+	default:
+		err = ErrLinkOrderUnknown
+	}
+	return
+}
+
+// parseLinkOrderKey returns the link and tag of a key built by linkOrderKey
+func parseLinkOrderKey(order string, key string) (link string, tag string, ok bool) {
+	switch order {
+	case LinkOrderDefault:
+		x := strings.SplitN(key, ":", 2)
+		if len(x) == 2 {
+			link, tag, ok = x[0], x[1], true
+		}
+	case LinkOrderTag:
+		i := strings.LastIndex(key, "\x00")
+		if i >= 0 {
+			link, tag, ok = key[i+1:], key[:i], true
+		}
+	case LinkOrderTime:
+		x := strings.SplitN(key, "\x00", 3)
+		if len(x) == 3 {
+			link, tag, ok = x[1], x[2], true
+		}
+	}
+	return
+}
+
+// linkPrefixEnd returns the smallest key that sorts after every key starting with prefix
+func linkPrefixEnd(prefix string) string {
+	return prefix[:len(prefix)-1] + string(prefix[len(prefix)-1]+1)
+}
+
+// linkIndexSeeker walks the keys of a base's links in the index for an order, starting after
+// (or before when descending) the key from, calling fn with each key until it returns false
+type linkIndexSeeker func(order string, base string, from string, descending bool, fn func(key string) bool) error
+
+// linkRecordGetter returns the events recorded for a link
+type linkRecordGetter func(base string, link string, tag string) (records []linkEvent, ok bool)
+
+// queryLinkIndex does the work of HashTable.QueryLinks for a backend that provides a seeker
+// over its link indexes and a getter for its link records, reading no further into the index
+// than it needs to fill the page and know whether there is another one
+func queryLinkIndex(lq *LinkQuery, seek linkIndexSeeker, get linkRecordGetter) (results []TaggedHash, cursor string, err error) {
+	if _, err = linkOrderKey(lq.Order, "", "", 0); err != nil {
+		return
+	}
+	var from string
+	if lq.Cursor != "" {
+		from, err = decodeLinkCursor(lq.Cursor, lq.Order)
+		if err != nil {
+			return
+		}
+	}
+	statusMask := lq.StatusMask
+	if statusMask == StatusDefault {
+		statusMask = StatusLive
+	}
+	base := lq.Base.String()
+	results = make([]TaggedHash, 0)
+	var last string
+	err = seek(lq.Order, base, from, lq.Descending, func(key string) bool {
+		link, tag, ok := parseLinkOrderKey(lq.Order, key)
+		if !ok {
+			return true
+		}
+		if (lq.T != "" && tag != lq.T) || (lq.T == "" && !strings.HasPrefix(tag, lq.TagPrefix)) {
+			return true
+		}
+		records, ok := get(base, link, tag)
+		if !ok || len(records) == 0 {
+			return true
+		}
+		entry := records[len(records)-1]
+		if (entry.Status & statusMask) == 0 {
+			return true
+		}
+		if lq.Count > 0 && len(results) == lq.Count {
+			cursor = encodeLinkCursor(lq.Order, last)
+			return false
+		}
+		th := TaggedHash{H: link, Source: entry.Source, changed: entry.Time}
+		if lq.T == "" {
+			th.T = tag
+		}
+		results = append(results, th)
+		last = key
+		return true
+	})
+	return
+}
+
+// HashTableFactory creates and opens a HashTable that stores its data in the given directory
+type HashTableFactory func(dbPath string) (HashTable, error)
+
+var hashTableFactories = make(map[string]HashTableFactory)
+
+// RegisterHashTable sets up a HashTable backend to be used by the CreateHashTable function
+func RegisterHashTable(name string, factory HashTableFactory) {
+	if factory == nil {
+		panic(fmt.Sprintf("HashTable factory for type %s does not exist.", name))
+	}
+	_, registered := hashTableFactories[name]
+	if registered {
+		panic(fmt.Sprintf("HashTable factory for type %s already registered. ", name))
+	}
+	hashTableFactories[name] = factory
+}
+
+// RegisterBuiltinHashTables adds the built in HashTable backends to the factory hash
+func RegisterBuiltinHashTables() {
+	RegisterHashTable(BuntHTType, NewBuntHT)
+	RegisterHashTable(BoltHTType, NewBoltHT)
+}
+
+// CreateHashTable returns a new opened HashTable of the given type
+// An empty type creates the DefaultHashTableType
+func CreateHashTable(htType string, dbPath string) (HashTable, error) {
+	if htType == "" {
+		htType = DefaultHashTableType
+	}
+	factory, ok := hashTableFactories[htType]
+	if !ok {
+		var available []string
+		for k := range hashTableFactories {
+			available = append(available, k)
+		}
+		sort.Strings(available)
+		return nil, fmt.Errorf("Invalid DHT store type. Must be one of: %s", strings.Join(available, ", "))
+	}
+	return factory(dbPath)
+}
+
+// dumpIdx converts message and data of a DHT change request to a string for human consumption
+func dumpIdx(ht HashTable, idx int) (str string, err error) {
+	var msg Message
+	msg, err = ht.GetIdxMessage(idx)
+	if err != nil {
+		return
+	}
+	f, _ := msg.Fingerprint()
+	str = fmt.Sprintf("MSG (fingerprint %v):\n   %v\n", f, msg)
+	switch msg.Type {
+	case PUT_REQUEST:
+		key := msg.Body.(HoldReq).EntryHash
+		entry, entryType, _, _, e := ht.Get(key, StatusDefault, GetMaskAll)
+		if e != nil {
+			err = fmt.Errorf("couldn't get %v err:%v ", key, e)
+			return
+		} else {
+			str += fmt.Sprintf("DATA: type:%s entry: %v\n", entryType, entry)
+		}
+	}
+	return
+}
+
+// dumpIdxJSON converts message and data of a DHT change request to a JSON string representation.
+func dumpIdxJSON(ht HashTable, idx int) (str string, err error) {
+	var msg Message
+	var buffer bytes.Buffer
+	var msgField, dataField string
+	msg, err = ht.GetIdxMessage(idx)
+
+	if err != nil {
+		return "", err
+	}
+
+	f, _ := msg.Fingerprint()
+	buffer.WriteString(fmt.Sprintf("{ \"index\": %d,", idx))
+	msgField = fmt.Sprintf("\"message\": { \"fingerprint\": \"%v\", \"content\": \"%v\" },", f, msg)
+
+	switch msg.Type {
+	case PUT_REQUEST:
+		key := msg.Body.(HoldReq).EntryHash
+		entry, entryType, _, _, e := ht.Get(key, StatusAny, GetMaskAll)
+		if e != nil {
+			err = fmt.Errorf("couldn't get %v err:%v ", key, e)
+			return
+		}
+		dataField = fmt.Sprintf("\"data\": { \"type\": \"%s\", \"entry\": \"%v\" }", entryType, entry)
+	}
+
+	if len(dataField) > 0 {
+		buffer.WriteString(msgField)
+		buffer.WriteString(dataField)
+	} else {
+		buffer.WriteString(strings.TrimSuffix(msgField, ","))
+	}
+	buffer.WriteString("}")
+	return PrettyPrintJSON(buffer.Bytes())
+}
@@ -1,12 +1,504 @@
 package holochain
 
 import (
-	//	. "github.com/holochain/holochain-proto/hash"
+	"fmt"
+	. "github.com/holochain/holochain-proto/hash"
 	. "github.com/smartystreets/goconvey/convey"
+	"sort"
 	"testing"
+	"time"
 )
 
-func TestHT(t *testing.T) {
-	Convey("", t, func() {
+// testHashTables runs the test function against a freshly opened instance of every registered HashTable backend
+func testHashTables(t *testing.T, fn func(htType string, ht HashTable)) {
+	var types []string
+	for k := range hashTableFactories {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	for _, htType := range types {
+		d := SetupTestDir()
+		ht, err := CreateHashTable(htType, d)
+		if err != nil {
+			panic(err)
+		}
+		fn(htType, ht)
+		ht.Close()
+		CleanupTestDir(d)
+	}
+}
+
+func TestCreateHashTable(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+
+	Convey("it should create the default HashTable type if none given", t, func() {
+		ht, err := CreateHashTable("", d)
+		So(err, ShouldBeNil)
+		defer ht.Close()
+		_, ok := ht.(*BuntHT)
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("it should create the given HashTable type", t, func() {
+		ht, err := CreateHashTable(BoltHTType, d)
+		So(err, ShouldBeNil)
+		defer ht.Close()
+		_, ok := ht.(*BoltHT)
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("it should fail on unknown HashTable types", t, func() {
+		_, err := CreateHashTable("foo", d)
+		So(err.Error(), ShouldEqual, "Invalid DHT store type. Must be one of: bolt, buntdb")
+	})
+}
+
+func TestHTPutGetModDel(t *testing.T) {
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	var id = node.HashAddr
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+
+	testHashTables(t, func(htType string, ht HashTable) {
+		var idx int
+		Convey(fmt.Sprintf("%s: It should store and retrieve", htType), t, func() {
+			err := ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash}), "someType", hash, id, []byte("some value"), StatusLive)
+			So(err, ShouldBeNil)
+			idx, _ = ht.GetIdx()
+
+			data, entryType, sources, status, err := ht.Get(hash, StatusLive, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some value")
+			So(entryType, ShouldEqual, "someType")
+			So(status, ShouldEqual, StatusLive)
+			So(sources[0], ShouldEqual, id.Pretty())
+
+			badhash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+			data, entryType, _, _, err = ht.Get(badhash, StatusLive, GetMaskDefault)
+			So(entryType, ShouldEqual, "")
+			So(err, ShouldEqual, ErrHashNotFound)
+
+			src, err := ht.Source(hash)
+			So(err, ShouldBeNil)
+			So(src, ShouldEqual, id)
+			So(ht.Exists(hash, StatusLive), ShouldBeNil)
+			So(ht.Exists(badhash, StatusLive), ShouldEqual, ErrHashNotFound)
+		})
+
+		Convey(fmt.Sprintf("%s: It should iterate", htType), t, func() {
+			hlist := make([]Hash, 0)
+			ht.Iterate(func(hsh Hash) bool {
+				hlist = append(hlist, hsh)
+				return true
+			})
+			So(len(hlist), ShouldEqual, 1)
+			So(hlist[0].String(), ShouldEqual, hash.String())
+		})
+
+		Convey(fmt.Sprintf("%s: mod should move the hash to the modified status and record replacedBy link", htType), t, func() {
+			newhashStr := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4"
+			newhash, _ := NewHash(newhashStr)
+
+			m := node.NewMessage(MOD_REQUEST, HoldReq{RelatedHash: hash, EntryHash: newhash})
+
+			err := ht.Mod(m, hash, newhash)
+			So(err, ShouldBeNil)
+			data, entryType, _, status, err := ht.Get(hash, StatusAny, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some value")
+			So(entryType, ShouldEqual, "someType")
+			So(status, ShouldEqual, StatusModified)
+
+			afterIdx, _ := ht.GetIdx()
+
+			So(afterIdx-idx, ShouldEqual, 1)
+
+			data, entryType, _, status, err = ht.Get(hash, StatusLive, GetMaskDefault)
+			So(err, ShouldEqual, ErrHashNotFound)
+
+			data, entryType, _, status, err = ht.Get(hash, StatusDefault, GetMaskDefault)
+			So(err, ShouldEqual, ErrHashModified)
+			// replaced by link gets returned in the data!!
+			So(string(data), ShouldEqual, newhashStr)
+
+			links, err := ht.GetLinks(hash, SysTagReplacedBy, StatusLive)
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 1)
+			So(links[0].H, ShouldEqual, newhashStr)
+		})
+
+		Convey(fmt.Sprintf("%s: del should move the hash to the deleted status", htType), t, func() {
+			m := node.NewMessage(DEL_REQUEST, HoldReq{RelatedHash: hash})
+
+			err := ht.Del(m, hash)
+			So(err, ShouldBeNil)
+
+			data, entryType, _, status, err := ht.Get(hash, StatusAny, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some value")
+			So(entryType, ShouldEqual, "someType")
+			So(status, ShouldEqual, StatusDeleted)
+
+			afterIdx, _ := ht.GetIdx()
+
+			So(afterIdx-idx, ShouldEqual, 2)
+
+			data, entryType, _, status, err = ht.Get(hash, StatusLive, GetMaskDefault)
+			So(err, ShouldEqual, ErrHashNotFound)
+
+			data, entryType, _, status, err = ht.Get(hash, StatusDefault, GetMaskDefault)
+			So(err, ShouldEqual, ErrHashDeleted)
+		})
+
+		Convey(fmt.Sprintf("%s: it should return the messages that made changes by index", htType), t, func() {
+			msg, err := ht.GetIdxMessage(idx)
+			So(err, ShouldBeNil)
+			So(msg.Type, ShouldEqual, PUT_REQUEST)
+			f, _ := msg.Fingerprint()
+			i, err := ht.GetFingerprint(f)
+			So(err, ShouldBeNil)
+			So(i, ShouldEqual, idx)
+
+			_, err = ht.GetIdxMessage(99)
+			So(err, ShouldEqual, ErrNoSuchIdx)
+			i, err = ht.GetFingerprint(NullHash())
+			So(err, ShouldBeNil)
+			So(i, ShouldEqual, -1)
+		})
+
+		Convey(fmt.Sprintf("%s: it should dump the table", htType), t, func() {
+			So(ht.String(), ShouldContainSubstring, "DHT changes: 3")
+			So(ht.String(), ShouldContainSubstring, fmt.Sprintf("Hash--%s (status 4)", hash))
+			_, err := ht.JSON()
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestHTLinking(t *testing.T) {
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	var id = node.HashAddr
+
+	baseStr := "QmZcUPvPhD1Xvk6mwijYF8AfR3mG31S1YsEfHG4khrFPRr"
+	base, err := NewHash(baseStr)
+	if err != nil {
+		panic(err)
+	}
+	linkingEntryHashStr := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3"
+	linkingEntryHash, _ := NewHash(linkingEntryHashStr)
+	linkHash1Str := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1"
+	linkHash1, _ := NewHash(linkHash1Str)
+	linkHash2Str := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"
+
+	// the message doesn't actually matter for this test because it only gets used later in gossiping
+	fakeMsg := node.NewMessage(LINK_REQUEST, HoldReq{RelatedHash: linkHash1, EntryHash: linkingEntryHash})
+
+	testHashTables(t, func(htType string, ht HashTable) {
+		Convey(fmt.Sprintf("%s: It should fail if hash doesn't exist", htType), t, func() {
+			err := ht.PutLink(nil, baseStr, linkHash1Str, "tag foo")
+			So(err, ShouldEqual, ErrHashNotFound)
+
+			v, err := ht.GetLinks(base, "tag foo", StatusLive)
+			So(v, ShouldBeNil)
+			So(err, ShouldEqual, ErrHashNotFound)
+		})
+
+		err = ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: base}), "someType", base, id, []byte("some value"), StatusLive)
+		if err != nil {
+			panic(err)
+		}
+
+		Convey(fmt.Sprintf("%s: It should store and retrieve links values on a base", htType), t, func() {
+			data, err := ht.GetLinks(base, "tag foo", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 0)
+
+			err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag foo")
+			So(err, ShouldBeNil)
+
+			err = ht.PutLink(fakeMsg, baseStr, linkHash2Str, "tag foo")
+			So(err, ShouldBeNil)
+
+			err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag bar")
+			So(err, ShouldBeNil)
+
+			data, err = ht.GetLinks(base, "tag foo", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 2)
+			m := data[0]
+
+			So(m.H, ShouldEqual, linkHash1Str)
+			m = data[1]
+			So(m.H, ShouldEqual, linkHash2Str)
+
+			data, err = ht.GetLinks(base, "tag bar", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 1)
+			So(data[0].H, ShouldEqual, linkHash1Str)
+
+			data, err = ht.GetLinks(base, "", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 3)
+		})
+
+		Convey(fmt.Sprintf("%s: It should store and retrieve a links source", htType), t, func() {
+			err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag source")
+			So(err, ShouldBeNil)
+
+			data, err := ht.GetLinks(base, "tag source", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 1)
+			So(data[0].Source, ShouldEqual, id.Pretty())
+		})
+
+		Convey(fmt.Sprintf("%s: It should work to put a link a second time", htType), t, func() {
+			err = ht.PutLink(fakeMsg, baseStr, linkHash1Str, "tag foo")
+			So(err, ShouldBeNil)
+		})
+
+		Convey(fmt.Sprintf("%s: It should fail delete links non existent links bases and tags", htType), t, func() {
+			badHashStr := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqhX"
+
+			err := ht.DelLink(fakeMsg, badHashStr, linkHash1Str, "tag foo")
+			So(err, ShouldEqual, ErrHashNotFound)
+			err = ht.DelLink(fakeMsg, baseStr, badHashStr, "tag foo")
+			So(err, ShouldEqual, ErrLinkNotFound)
+			err = ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag baz")
+			So(err, ShouldEqual, ErrLinkNotFound)
+		})
+
+		Convey(fmt.Sprintf("%s: It should delete links", htType), t, func() {
+			err := ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag bar")
+			So(err, ShouldBeNil)
+			data, err := ht.GetLinks(base, "tag bar", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 0)
+
+			err = ht.DelLink(fakeMsg, baseStr, linkHash1Str, "tag foo")
+			So(err, ShouldBeNil)
+			data, err = ht.GetLinks(base, "tag foo", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 1)
+
+			err = ht.DelLink(fakeMsg, baseStr, linkHash2Str, "tag foo")
+			So(err, ShouldBeNil)
+			data, err = ht.GetLinks(base, "tag foo", StatusLive)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 0)
+
+			data, err = ht.GetLinks(base, "tag foo", StatusDeleted)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestHTQueryLinks(t *testing.T) {
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	baseStr := "QmZcUPvPhD1Xvk6mwijYF8AfR3mG31S1YsEfHG4khrFPRr"
+	base, _ := NewHash(baseStr)
+	linkingEntryHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	links := []TaggedHash{
+		{H: "QmB", T: "tag 2", changed: 3},
+		{H: "QmA", T: "tag 3", changed: 2},
+		{H: "QmC", T: "tag 1", changed: 1},
+	}
+	linkAt := func(ht HashTable, l TaggedHash, changed int64, del bool) {
+		m := node.NewMessage(LINK_REQUEST, HoldReq{EntryHash: linkingEntryHash})
+		m.Time = time.Unix(0, changed)
+		var err error
+		if del {
+			err = ht.DelLink(m, baseStr, l.H, l.T)
+		} else {
+			err = ht.PutLink(m, baseStr, l.H, l.T)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	hashes := func(page []TaggedHash) (h []string) {
+		for _, l := range page {
+			h = append(h, l.H)
+		}
+		return
+	}
+
+	testHashTables(t, func(htType string, ht HashTable) {
+		err := ht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: base}), "someType", base, node.HashAddr, []byte("some value"), StatusLive)
+		if err != nil {
+			panic(err)
+		}
+		for _, l := range links {
+			linkAt(ht, l, l.changed, false)
+		}
+
+		Convey(fmt.Sprintf("%s: it should order links by hash, tag or time", htType), t, func() {
+			page, cursor, err := ht.QueryLinks(&LinkQuery{Base: base})
+			So(err, ShouldBeNil)
+			So(cursor, ShouldEqual, "")
+			So(hashes(page), ShouldResemble, []string{"QmA", "QmB", "QmC"})
+			So(page[0].T, ShouldEqual, "tag 3")
+
+			page, _, err = ht.QueryLinks(&LinkQuery{Base: base, Order: LinkOrderTag})
+			So(err, ShouldBeNil)
+			So(hashes(page), ShouldResemble, []string{"QmC", "QmB", "QmA"})
+
+			page, _, err = ht.QueryLinks(&LinkQuery{Base: base, Order: LinkOrderTime, Descending: true})
+			So(err, ShouldBeNil)
+			So(hashes(page), ShouldResemble, []string{"QmB", "QmA", "QmC"})
+
+			page, _, err = ht.QueryLinks(&LinkQuery{Base: base, Descending: true})
+			So(err, ShouldBeNil)
+			So(hashes(page), ShouldResemble, []string{"QmC", "QmB", "QmA"})
+
+			_, _, err = ht.QueryLinks(&LinkQuery{Base: base, Order: "bogus"})
+			So(err, ShouldEqual, ErrLinkOrderUnknown)
+		})
+
+		Convey(fmt.Sprintf("%s: it should filter by tag", htType), t, func() {
+			page, _, err := ht.QueryLinks(&LinkQuery{Base: base, T: "tag 2"})
+			So(err, ShouldBeNil)
+			So(hashes(page), ShouldResemble, []string{"QmB"})
+			So(page[0].T, ShouldEqual, "")
+
+			page, _, err = ht.QueryLinks(&LinkQuery{Base: base, TagPrefix: "tag", Order: LinkOrderTag, Descending: true})
+			So(err, ShouldBeNil)
+			So(hashes(page), ShouldResemble, []string{"QmA", "QmB", "QmC"})
+		})
+
+		Convey(fmt.Sprintf("%s: it should page through links with a cursor", htType), t, func() {
+			for _, descending := range []bool{false, true} {
+				lq := LinkQuery{Base: base, Order: LinkOrderTime, Count: 2, Descending: descending}
+				page, cursor, err := ht.QueryLinks(&lq)
+				So(err, ShouldBeNil)
+				So(cursor, ShouldNotEqual, "")
+				lq.Cursor = cursor
+				rest, cursor, err := ht.QueryLinks(&lq)
+				So(err, ShouldBeNil)
+				So(cursor, ShouldEqual, "")
+				if descending {
+					So(hashes(append(page, rest...)), ShouldResemble, []string{"QmB", "QmA", "QmC"})
+				} else {
+					So(hashes(append(page, rest...)), ShouldResemble, []string{"QmC", "QmA", "QmB"})
+				}
+
+				lq.Order = LinkOrderTag
+				_, _, err = ht.QueryLinks(&lq)
+				So(err, ShouldEqual, ErrLinkCursorInvalid)
+			}
+		})
+
+		Convey(fmt.Sprintf("%s: it should keep the time order as links change", htType), t, func() {
+			linkAt(ht, links[2], 4, true)
+			page, _, err := ht.QueryLinks(&LinkQuery{Base: base, Order: LinkOrderTime})
+			So(err, ShouldBeNil)
+			So(hashes(page), ShouldResemble, []string{"QmA", "QmB"})
+
+			page, _, err = ht.QueryLinks(&LinkQuery{Base: base, Order: LinkOrderTime, StatusMask: StatusAny})
+			So(err, ShouldBeNil)
+			So(hashes(page), ShouldResemble, []string{"QmA", "QmB", "QmC"})
+		})
+	})
+}
+
+func TestHTGossipersAndLists(t *testing.T) {
+	node, err := makeNode(1234, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+	pid1, _ := makePeer("peer_foo")
+	pid2, _ := makePeer("peer_bar")
+
+	testHashTables(t, func(htType string, ht HashTable) {
+		Convey(fmt.Sprintf("%s: it should start with no gossipers", htType), t, func() {
+			glist, err := ht.GetGossipers()
+			So(err, ShouldBeNil)
+			So(len(glist), ShouldEqual, 0)
+			idx, err := ht.GetGossiper(pid1)
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, 0)
+		})
+
+		Convey(fmt.Sprintf("%s: it should add and update gossipers", htType), t, func() {
+			err := ht.UpdateGossiper(pid1, 0)
+			So(err, ShouldBeNil)
+			err = ht.UpdateGossiper(pid2, 5)
+			So(err, ShouldBeNil)
+			glist, err := ht.GetGossipers()
+			So(err, ShouldBeNil)
+			So(len(glist), ShouldEqual, 2)
+
+			err = ht.UpdateGossiper(pid2, 7)
+			So(err, ShouldBeNil)
+			idx, _ := ht.GetGossiper(pid2)
+			So(idx, ShouldEqual, 7)
+
+			// indexes never go backwards
+			err = ht.UpdateGossiper(pid2, 3)
+			So(err, ShouldBeNil)
+			idx, _ = ht.GetGossiper(pid2)
+			So(idx, ShouldEqual, 7)
+		})
+
+		Convey(fmt.Sprintf("%s: it should delete gossipers", htType), t, func() {
+			err := ht.DeleteGossiper(pid1)
+			So(err, ShouldBeNil)
+			glist, _ := ht.GetGossipers()
+			So(len(glist), ShouldEqual, 1)
+			So(glist[0], ShouldEqual, pid2)
+			err = ht.DeleteGossiper(pid1)
+			So(err, ShouldEqual, ErrGossiperNotFound)
+		})
+
+		Convey(fmt.Sprintf("%s: it should add peers to lists", htType), t, func() {
+			peerList, err := ht.GetList(BlockedList)
+			So(err, ShouldBeNil)
+			So(len(peerList.Records), ShouldEqual, 0)
+
+			m := node.NewMessage(LISTADD_REQUEST, ListAddReq{ListType: BlockedList})
+			err = ht.AddToList(m, PeerList{BlockedList, []PeerRecord{{ID: pid1, Warrant: "some warrant"}}})
+			So(err, ShouldBeNil)
+			peerList, err = ht.GetList(BlockedList)
+			So(err, ShouldBeNil)
+			So(len(peerList.Records), ShouldEqual, 1)
+			So(peerList.Records[0].ID, ShouldEqual, pid1)
+			So(peerList.Records[0].Warrant, ShouldEqual, "some warrant")
+
+			idx, _ := ht.GetIdx()
+			So(idx, ShouldEqual, 1)
+		})
+
+		Convey(fmt.Sprintf("%s: it should remove peers from lists", htType), t, func() {
+			err := ht.AddToList(nil, PeerList{BlockedList, []PeerRecord{{ID: pid2}}})
+			So(err, ShouldBeNil)
+			idx, _ := ht.GetIdx()
+			So(idx, ShouldEqual, 1)
+
+			err = ht.RemoveFromList(PeerList{BlockedList, []PeerRecord{{ID: pid1}}})
+			So(err, ShouldBeNil)
+			peerList, err := ht.GetList(BlockedList)
+			So(err, ShouldBeNil)
+			So(len(peerList.Records), ShouldEqual, 1)
+			So(peerList.Records[0].ID, ShouldEqual, pid2)
+
+			err = ht.RemoveFromList(PeerList{BlockedList, []PeerRecord{{ID: pid1}}})
+			So(err, ShouldBeNil)
+		})
 	})
 }
@@ -139,12 +139,17 @@ func (jsr *JSRibosome) ValidatePackagingRequest(action ValidatingAction, def *En
 }
 
 func prepareJSEntryArgs(def *EntryDef, entry Entry, header *Header) (args string, err error) {
-	entryStr := entry.Content().(string)
+	entryStr := entryContentString(entry)
 	switch def.DataFormat {
 	case DataFormatRawJS:
 		args = entryStr
+	case DataFormatBytes:
+		fallthrough
 	case DataFormatString:
 		args = "\"" + jsSanitizeString(entryStr) + "\""
+	case DataFormatBlob:
+		// blob entries are validated by their manifest
+		fallthrough
 	case DataFormatLinks:
 		fallthrough
 	case DataFormatJSON:
@@ -237,12 +242,16 @@ func mkJSSources(sources []string) (srcs string) {
 }
 
 func (jsr *JSRibosome) prepareJSValidateEntryArgs(def *EntryDef, entry Entry, sources []string) (e string, srcs string, err error) {
-	c := entry.Content().(string)
+	c := entryContentString(entry)
 	switch def.DataFormat {
 	case DataFormatRawJS:
 		e = c
+	case DataFormatBytes:
+		fallthrough
 	case DataFormatString:
 		e = "\"" + jsSanitizeString(c) + "\""
+	case DataFormatBlob:
+		fallthrough
 	case DataFormatLinks:
 		fallthrough
 	case DataFormatJSON:
@@ -330,6 +339,7 @@ const (
 		`,All:` + GetMaskAllStr +
 		"}" +
 		`,LinkAction:{Add:"` + AddLinkAction + `",Del:"` + DelLinkAction + `"}` +
+		`,LinkOrder:{Default:"` + LinkOrderDefault + `",Tag:"` + LinkOrderTag + `",Time:"` + LinkOrderTime + `"}` +
 		`,PkgReq:{Chain:"` + PkgReqChain + `"` +
 		`,ChainOpt:{None:` + PkgReqChainOptNoneStr +
 		`,Headers:` + PkgReqChainOptHeadersStr +
@@ -484,6 +494,11 @@ func jsProcessArgs(jsr *JSRibosome, args []Arg, oArgs []otto.Value) (err error)
 				fallthrough
 			case DataFormatRawZygo:
 				fallthrough
+			case DataFormatBytes:
+				fallthrough
+			case DataFormatBlob:
+				// binary entries are passed base64 encoded
+				fallthrough
 			case DataFormatString:
 				if !arg.IsString() {
 					return argErr("string", i+1, args[i])
@@ -580,7 +595,7 @@ func makeOttoObjectFromGetResp(h *Holochain, jsr *JSRibosome, getResp *GetResp)
 		code := `(` + json + `)`
 		result, err = jsr.vm.Object(code)
 	} else {
-		result = getResp.Entry.Content().(string)
+		result = entryContentString(&getResp.Entry)
 	}
 	return
 }
@@ -821,6 +836,19 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 				entry := GobEntry{C: entryStr}
 				f.action.entryType = entryType
 				f.action.entry = &entry
+				if len(call.ArgumentList) == 3 {
+					options := CommitOptions{}
+					var j []byte
+					j, err = json.Marshal(args[2].value)
+					if err != nil {
+						return
+					}
+					err = json.Unmarshal(j, &options)
+					if err != nil {
+						return
+					}
+					f.action.recipients = options.Recipients
+				}
 				r, err = f.Call(h)
 				if err != nil {
 					return
@@ -895,8 +923,13 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 						switch def.DataFormat {
 						case DataFormatRawJS:
 							entryCode = r.(string)
+						case DataFormatBytes:
+							entryCode = fmt.Sprintf(`"%s"`, entryContentString(qresult.Entry))
 						case DataFormatString:
 							entryCode = fmt.Sprintf(`"%s"`, jsSanitizeString(r.(string)))
+						case DataFormatBlob:
+							// query returns the manifests of blobs from the chain
+							fallthrough
 						case DataFormatLinks:
 							fallthrough
 						case DataFormatJSON:
@@ -968,12 +1001,25 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 						if ok {
 							options.Local = local.(bool)
 						}
+						history, ok := opts["History"]
+						if ok {
+							options.History = history.(bool)
+						}
 					}
 				}
 				req := GetReq{H: args[0].value.(Hash), StatusMask: options.StatusMask, GetMask: options.GetMask}
 				var r interface{}
 				f.action = ActionGet{req: req, options: &options}
 				r, err = f.Call(h)
+				if options.History && err == nil {
+					historyResp := r.(HistoryResp)
+					var j string
+					j, err = h.historyJSON(&historyResp)
+					if err == nil {
+						result, err = jsr.vm.Object(`(` + j + `)`)
+					}
+					return
+				}
 				mask := options.GetMask
 				if mask == GetMaskDefault {
 					mask = GetMaskEntry
@@ -1158,11 +1204,54 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 							}
 							options.StatusMask = int(maskval)
 						}
+						prefix, ok := opts["TagPrefix"]
+						if ok {
+							options.TagPrefix, ok = prefix.(string)
+							if !ok {
+								err = errors.New(fmt.Sprintf("expecting string TagPrefix attribute in object, got %T", prefix))
+								return
+							}
+						}
+						order, ok := opts["Order"]
+						if ok {
+							options.Order, ok = order.(string)
+							if !ok {
+								err = errors.New(fmt.Sprintf("expecting string Order attribute in object, got %T", order))
+								return
+							}
+						}
+						desc, ok := opts["Descending"]
+						if ok {
+							options.Descending, ok = desc.(bool)
+							if !ok {
+								err = errors.New(fmt.Sprintf("expecting boolean Descending attribute in object, got %T", desc))
+								return
+							}
+						}
+						count, ok := opts["Count"]
+						if ok {
+							countval, ok := numInterfaceToInt(count)
+							if !ok {
+								err = errors.New(fmt.Sprintf("expecting int Count attribute in object, got %T", count))
+								return
+							}
+							options.Count = int(countval)
+						}
+						cursor, ok := opts["Cursor"]
+						if ok {
+							options.Cursor, ok = cursor.(string)
+							if !ok {
+								err = errors.New(fmt.Sprintf("expecting string Cursor attribute in object, got %T", cursor))
+								return
+							}
+						}
 					}
 				}
+				// when paging the result is an object holding the links and the cursor for the next page
+				paged := options.Count > 0 || options.Cursor != ""
 				var response interface{}
 				f := _f.(*APIFnGetLinks)
-				f.action = *NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask}, &options)
+				f.action = *NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask, TagPrefix: options.TagPrefix, Order: options.Order, Descending: options.Descending, Count: options.Count, Cursor: options.Cursor}, &options)
 				response, err = f.Call(h)
 
 				if err == nil {
@@ -1191,6 +1280,10 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 								entry = th.E
 							case DataFormatRawZygo:
 								fallthrough
+							case DataFormatBytes:
+								fallthrough
+							case DataFormatBlob:
+								fallthrough
 							case DataFormatSysKey:
 								// key is a b58 encoded public key so the entry is just the string value
 								fallthrough
@@ -1214,6 +1307,9 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 					}
 					if err == nil {
 						js = `[` + js + `]`
+						if paged {
+							js = `{Links:` + js + `,Cursor:"` + jsSanitizeString(lqr.Cursor) + `"}`
+						}
 						var obj *otto.Object
 						jsr.h.Debugf("getLinks code:\n%s", js)
 						obj, err = jsr.vm.Object(js)
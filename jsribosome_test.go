@@ -456,6 +456,15 @@ func TestJSQuery(t *testing.T) {
 			So(err, ShouldBeNil)
 		}, `[{"Identity":"Herbert \u003ch@bert.com\u003e","PublicKey":"4XTTM8sJEQD5zMLT1gtu2ogshwg5AdUPNhJRbLvs77gsVtQQi","Revocation":""}]`)
 
+		ShouldLog(h.nucleus.alog, func() {
+			_, err := z.Run(`debug(query({Return:{Fields:["lastName"]},Constrain:{EntryTypes:["profile"],Where:{Or:[{Field:"firstName",Op:"=",Value:"Zippy"},{Field:"firstName",Op:"in",Value:["Zerbina"]}]}}}))`)
+			So(err, ShouldBeNil)
+		}, `[{"lastName":"Pinhead"}]`)
+		ShouldLog(h.nucleus.alog, func() {
+			_, err := z.Run(`debug(query({Constrain:{Where:{Field:"Header.Time",Op:"<",Value:"2000-01-01T00:00:00Z"}}}))`)
+			So(err, ShouldBeNil)
+		}, `[]`)
+
 		_, err := z.Run(`debug(query({Constrain:{EntryTypes:["%dna"]}}))`)
 		So(err.Error(), ShouldEqual, `{"errorMessage":"data format not implemented: _DNA","function":"query","name":"HolochainError","source":{}}`)
 
@@ -550,7 +559,7 @@ func TestJSbuildValidate(t *testing.T) {
 	Convey("it should build put", t, func() {
 		a := NewPutAction("evenNumbers", &e, &header)
 		pkg, _ := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptFull)})
-		vpkg, _ := MakeValidationPackage(h, &pkg)
+		vpkg, _ := MakeValidationPackage(h, &pkg, h.nodeID)
 		_, err := buildJSValidateAction(a, &def, vpkg, []string{"fake_src_hash"})
 		So(err, ShouldBeNil)
 		//	So(code, ShouldEqual, `validatePut("evenNumbers","2",{"EntryLink":"","Type":"","Time":"0001-01-01T00:00:00Z"},pgk,["fake_src_hash"])`)
@@ -568,7 +577,7 @@ func TestJSValidateCommit(t *testing.T) {
 	h.Config.Loggers.App.New(nil)
 	hdr := mkTestHeader("evenNumbers")
 	pkg, _ := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptFull)})
-	vpkg, _ := MakeValidationPackage(h, &pkg)
+	vpkg, _ := MakeValidationPackage(h, &pkg, h.nodeID)
 
 	Convey("it should be passing in the correct values", t, func() {
 		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: `function validateCommit(name,entry,header,pkg,sources) {debug(name);debug(entry);debug(JSON.stringify(header));debug(JSON.stringify(sources));debug(JSON.stringify(pkg));return true};`})
@@ -849,6 +858,30 @@ func TestJSDHT(t *testing.T) {
 
 	})
 
+	Convey("getLinks with Count option should return pages of Links with a Cursor", t, func() {
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`var p1=getLinks("%s","4stars",{Count:1});var p2=getLinks("%s","4stars",{Count:1,Cursor:p1.Cursor});[p1.Links.length,p1.Links[0].Hash,p1.Cursor!="",p2.Links.length,p2.Links[0].Hash,p2.Cursor]`, hash.String(), hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		result, _ := z.lastResult.Export()
+		first, second := profileHash.String(), reviewHash.String()
+		if second < first {
+			first, second = second, first
+		}
+		So(fmt.Sprintf("%v", result), ShouldEqual, fmt.Sprintf("[1 %s true 1 %s ]", first, second))
+	})
+
+	Convey("getLinks without paging should fail rather than truncate when there are more links than MaxLinkSets", t, func() {
+		h.nucleus.dna.DHTConfig.MaxLinkSets = 1
+		defer func() { h.nucleus.dna.DHTConfig.MaxLinkSets = 0 }()
+		_, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars");`, hash.String())})
+		So(err.Error(), ShouldEqual, `{"errorMessage":"more links than MaxLinkSets, use the Count and Cursor options to page through them","function":"getLinks","name":"HolochainError","source":{}}`)
+
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`var p=getLinks("%s","4stars",{Count:5});[p.Links.length,p.Cursor!=""]`, hash.String())})
+		So(err, ShouldBeNil)
+		result, _ := v.(*JSRibosome).lastResult.Export()
+		So(fmt.Sprintf("%v", result), ShouldEqual, "[1 true]")
+	})
+
 	Convey("getLinks with load option should return the Links and entries", t, func() {
 		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars",{Load:true});`, hash.String())})
 		So(err, ShouldBeNil)
@@ -1029,6 +1062,9 @@ func TestJSDHT(t *testing.T) {
 		So(string(payload.([]byte)), ShouldEqual, "some revocation data")
 		So(fmt.Sprintf("%v", a.PublicKey), ShouldEqual, fmt.Sprintf("%v", newPubKey))
 
+		// the new agent entry is signed by the revoked key so the chain still validates
+		So(h.chain.Validate(false, h.agent.PubKey()), ShouldBeNil)
+
 		// the new Key should be available on the DHT
 		newKey, _ := NewHash(h.nodeIDStr)
 		data, _, _, _, err := h.dht.Get(newKey, StatusDefault, GetMaskDefault)
@@ -26,6 +26,21 @@ import (
 
 var maxQueryConcurrency = AlphaValue
 
+// isPeerTimeout reports whether a dial error means the peer didn't answer in time,
+// rather than the dial failing on our side or the query itself being cancelled
+func isPeerTimeout(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err == context.DeadlineExceeded || err == SendTimeoutErr {
+		return true
+	}
+	t, ok := err.(interface {
+		Timeout() bool
+	})
+	return ok && t.Timeout()
+}
+
 type dhtQuery struct {
 	node        *Node
 	key         Hash      // the key we're querying for
@@ -179,6 +194,13 @@ func (r *dhtQueryRunner) addPeerToQuery(next peer.ID) {
 		return
 	}
 
+	// peers are queried closest first, reputation only keeps us from asking the
+	// ones that have been misbehaving
+	if r.query.node.IsBlocked(next) || r.query.node.reputation.rank(next) < 0 {
+		r.query.log.Logf("addPeerToQuery skip poorly reputed %v", next)
+		return
+	}
+
 	/*
 		notif.PublishQueryEvent(r.runCtx, &notif.QueryEvent{
 			Type: notif.AddingPeer,
@@ -255,6 +277,9 @@ func (r *dhtQueryRunner) queryPeer(proc process.Process, p peer.ID) {
 
 		if err := r.query.node.host.Connect(ctx, pi); err != nil {
 			r.query.log.Logf("Error connecting: %s", err)
+			if isPeerTimeout(ctx, err) {
+				r.query.node.Rate(p, ReputationTimeout)
+			}
 
 			/*
 				notif.PublishQueryEvent(r.runCtx, &notif.QueryEvent{
@@ -0,0 +1,375 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements migrating an agent's chain into a new DNA that is based on the DNA of the chain,
+// transforming the entries with the migration functions the new DNA declares
+
+package holochain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/holochain/holochain-proto/hash"
+)
+
+const (
+	MigrationEntryType = SysEntryTypePrefix + "migration"
+
+	ValidationFailureBadMigrationEntry = "bad migration entry"
+)
+
+var MigrationEntryDef = &EntryDef{Name: MigrationEntryType, DataFormat: DataFormatJSON, Sharing: Public}
+
+var ErrMigrationNotBasedOn = errors.New("chain's DNA isn't based on the DNA being migrated from")
+var ErrMigrationAgentMismatch = errors.New("chains being migrated between must have the same agent")
+var ErrChainAlreadyMigrated = errors.New("chain has already been migrated from that DNA")
+
+// MigrationEntry is committed at the end of a migration, linking each migrated entry back
+// to the entry on the old chain it was migrated from
+type MigrationEntry struct {
+	DNAHash string          // hash of the DNA the entries were migrated from
+	Top     string          // hash of the top header of the chain they were migrated from
+	Entries []MigratedEntry // only entries of public types are listed
+	Skipped []string        // hashes of public entries whose types aren't in the new DNA
+}
+
+// MigratedEntry maps an entry on the old chain to the entry it became on the new one
+type MigratedEntry struct {
+	Type string
+	From string
+	To   string
+}
+
+// MigrationEntryFromJSON unmarshals and checks a migration entry
+func MigrationEntryFromJSON(j string) (entry MigrationEntry, err error) {
+	err = json.Unmarshal([]byte(j), &entry)
+	if err != nil {
+		return
+	}
+	if _, err = NewHash(entry.DNAHash); err != nil {
+		return
+	}
+	for _, e := range entry.Entries {
+		if _, err = NewHash(e.From); err != nil {
+			return
+		}
+		if _, err = NewHash(e.To); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ToJSON marshals a migration entry
+func (e *MigrationEntry) ToJSON() (encodedEntry string, err error) {
+	var j []byte
+	j, err = json.Marshal(e)
+	encodedEntry = string(j)
+	return
+}
+
+// checkMigration returns an error if an entry definition declares a migration function
+// the zome doesn't have, or the DNA isn't based on another one to migrate from
+func (z *Zome) checkMigration(dna *DNA, def *EntryDef) (err error) {
+	if def.Migration == "" {
+		return
+	}
+	if dna.BasedOn.IsNullHash() {
+		err = fmt.Errorf("entry type %s: migrations can only be declared by DNA that is BasedOn another", def.Name)
+		return
+	}
+	if _, e := z.GetFunctionDef(def.Migration); e != nil {
+		err = fmt.Errorf("entry type %s: migration function %s not found in zome %s", def.Name, def.Migration, z.Name)
+	}
+	return
+}
+
+// Migrate re-commits the entries of from's chain onto this chain, whose DNA must be based on
+// from's DNA.  Entries of types declared in this DNA are transformed by their type's migration
+// function, or carried over unchanged if it doesn't have one, and updates, deletes and links
+// are made against the migrated entries.  The entries are committed as a bundle, ending with
+// a migration entry recording where each one came from.
+func (h *Holochain) Migrate(from *Holochain) (migration MigrationEntry, hash Hash, err error) {
+	if !h.nucleus.dna.BasedOn.Equal(from.dnaHash) {
+		err = ErrMigrationNotBasedOn
+		return
+	}
+	if !h.agent.PubKey().Equals(from.agent.PubKey()) {
+		err = ErrMigrationAgentMismatch
+		return
+	}
+	var done bool
+	done, err = h.migratedFrom(from.dnaHash)
+	if err != nil {
+		return
+	}
+	if done {
+		err = ErrChainAlreadyMigrated
+		return
+	}
+
+	migration.DNAHash = from.dnaHash.String()
+	migration.Entries = make([]MigratedEntry, 0)
+	if top := from.chain.Top(); top != nil {
+		var topHash Hash
+		topHash, _, err = top.Sum(h.hashSpec)
+		if err != nil {
+			return
+		}
+		migration.Top = topHash.String()
+	}
+
+	err = h.chain.StartBundle("migration from " + migration.DNAHash)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			h.chain.CloseBundle(false)
+		}
+	}()
+
+	migrated := make(map[string]string)
+	for i, header := range from.chain.Headers {
+		if strings.HasPrefix(header.Type, SysEntryTypePrefix) && header.Type != DelEntryType {
+			continue
+		}
+		original := header.EntryLink.String()
+		// the migration entry is public, so it only lists public entries as otherwise
+		// it would reveal that the others exist and tie them together across the DNAs
+		listed := from.isPublicEntryType(header.Type)
+		var newHash Hash
+		var ok bool
+		newHash, ok, err = h.migrateEntry(from, header, from.chain.Entries[i], migrated)
+		if err != nil {
+			err = fmt.Errorf("migrating %s entry %s: %v", header.Type, original, err)
+			return
+		}
+		if !ok {
+			if listed {
+				migration.Skipped = append(migration.Skipped, original)
+			}
+			continue
+		}
+		migrated[original] = newHash.String()
+		if listed && h.isPublicEntryType(header.Type) {
+			migration.Entries = append(migration.Entries, MigratedEntry{Type: header.Type, From: original, To: newHash.String()})
+		}
+	}
+
+	var j string
+	j, err = migration.ToJSON()
+	if err != nil {
+		return
+	}
+	var response interface{}
+	response, err = h.commitAndShare(NewCommitAction(MigrationEntryType, &GobEntry{C: j}), NullHash())
+	if err != nil {
+		return
+	}
+	hash = response.(Hash)
+	_, err = (&APIFnCloseBundle{commit: true}).Call(h)
+	return
+}
+
+// migrateEntry commits an entry from the old chain onto this one, returning false if its type
+// isn't in this DNA or it deletes an entry that wasn't migrated
+func (h *Holochain) migrateEntry(from *Holochain, header *Header, entry Entry, migrated map[string]string) (hash Hash, ok bool, err error) {
+	if header.Type == DelEntryType {
+		var del DelEntry
+		del, err = DelEntryFromJSON(entry.Content().(string))
+		if err != nil {
+			return
+		}
+		target, found := migrated[del.Hash.String()]
+		if !found {
+			return
+		}
+		del.Hash, err = NewHash(target)
+		if err != nil {
+			return
+		}
+		var r interface{}
+		r, err = (&APIFnDel{action: *NewDelAction(del)}).Call(h)
+		if err == nil {
+			hash, ok = r.(Hash), true
+		}
+		return
+	}
+
+	z, def, e := h.GetEntryDef(header.Type)
+	if e != nil || z == nil {
+		// the type was dropped from the new DNA
+		return
+	}
+	_, oldDef, e := from.GetEntryDef(header.Type)
+	if e != nil {
+		err = e
+		return
+	}
+
+	var content string
+	content, err = from.migrationContent(oldDef, entry, migrated)
+	if err != nil {
+		return
+	}
+	if def.Migration != "" {
+		var result interface{}
+		result, err = h.Call(z.Name, def.Migration, content, ZOME_EXPOSURE)
+		if err != nil {
+			return
+		}
+		switch r := result.(type) {
+		case string:
+			content = r
+		case []byte:
+			content = string(r)
+		default:
+			err = fmt.Errorf("migration function %s returned %T", def.Migration, result)
+			return
+		}
+	}
+
+	var r interface{}
+	newEntry := &GobEntry{C: content}
+	replaces, replacing := migrated[header.Change.String()]
+	if !header.Change.IsNullHash() && replacing {
+		var replacesHash Hash
+		replacesHash, err = NewHash(replaces)
+		if err != nil {
+			return
+		}
+		r, err = (&APIFnMod{action: *NewModAction(header.Type, newEntry, replacesHash)}).Call(h)
+	} else {
+		a := NewCommitAction(header.Type, newEntry)
+		if def.isSharingPartial() {
+			var recipients []string
+			recipients, err = from.sealedRecipients(header.EntryLink)
+			if err != nil {
+				return
+			}
+			a.SetRecipients(recipients)
+		}
+		r, err = (&APIFnCommit{action: *a}).Call(h)
+	}
+	if err == nil {
+		hash, ok = r.(Hash), true
+	}
+	return
+}
+
+// migrationContent returns the content of an entry as its migration function gets it, which
+// is the base64 encoded data of binary entries and blobs, and for links entries has the links
+// to entries that have already been migrated pointing to the migrated entries
+func (h *Holochain) migrationContent(def *EntryDef, entry Entry, migrated map[string]string) (content string, err error) {
+	switch def.DataFormat {
+	case DataFormatBlob:
+		var manifest BlobManifest
+		manifest, err = blobManifestFromJSON(entry.Content().(string), &h.nucleus.dna.DHTConfig)
+		if err != nil {
+			return
+		}
+		var data []byte
+		data, err = assembleBlob(h.hashSpec, manifest, chainChunkFetcher(h.chain))
+		if err != nil {
+			return
+		}
+		content = base64.StdEncoding.EncodeToString(data)
+	case DataFormatLinks:
+		var links LinksEntry
+		links, err = LinksEntryFromJSON(entry.Content().(string))
+		if err != nil {
+			return
+		}
+		for i, l := range links.Links {
+			if m, ok := migrated[l.Base]; ok {
+				links.Links[i].Base = m
+			}
+			if m, ok := migrated[l.Link]; ok {
+				links.Links[i].Link = m
+			}
+		}
+		content, err = links.ToJSON()
+	default:
+		content = entryContentString(entry)
+	}
+	return
+}
+
+// isPublicEntryType returns true if entries of the type are shared publicly
+func (h *Holochain) isPublicEntryType(entryType string) bool {
+	_, def, err := h.GetEntryDef(entryType)
+	return err == nil && def.Sharing == Public
+}
+
+// migrationEntries returns the migration entries on the chain
+func (h *Holochain) migrationEntries() (migrations []MigrationEntry, err error) {
+	for i, header := range h.chain.Headers {
+		if header.Type != MigrationEntryType {
+			continue
+		}
+		var m MigrationEntry
+		m, err = MigrationEntryFromJSON(h.chain.Entries[i].Content().(string))
+		if err != nil {
+			return
+		}
+		migrations = append(migrations, m)
+	}
+	return
+}
+
+// migratedFrom returns true if the chain has already been migrated from the given DNA
+func (h *Holochain) migratedFrom(dnaHash Hash) (done bool, err error) {
+	var migrations []MigrationEntry
+	migrations, err = h.migrationEntries()
+	if err != nil {
+		return
+	}
+	for _, m := range migrations {
+		if m.DNAHash == dnaHash.String() {
+			done = true
+		}
+	}
+	return
+}
+
+// MigrationOrigin returns the DNA and hash of the original entry that an entry on the chain was
+// migrated from
+func (h *Holochain) MigrationOrigin(hash Hash) (dnaHash Hash, original Hash, err error) {
+	var migrations []MigrationEntry
+	migrations, err = h.migrationEntries()
+	if err != nil {
+		return
+	}
+	for _, m := range migrations {
+		for _, e := range m.Entries {
+			if e.To == hash.String() {
+				if dnaHash, err = NewHash(m.DNAHash); err != nil {
+					return
+				}
+				original, err = NewHash(e.From)
+				return
+			}
+		}
+	}
+	err = ErrHashNotFound
+	return
+}
+
+// sysValidateMigrationEntry checks the structure of a migration entry
+func sysValidateMigrationEntry(entry Entry) (err error) {
+	j, ok := entry.Content().(string)
+	if !ok {
+		err = ValidationFailed(ValidationFailureBadMigrationEntry)
+		return
+	}
+	if _, e := MigrationEntryFromJSON(j); e != nil {
+		err = ValidationFailed(fmt.Sprintf("%s: %v", ValidationFailureBadMigrationEntry, e))
+	}
+	return
+}
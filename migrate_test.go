@@ -0,0 +1,135 @@
+package holochain
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrationEntry(t *testing.T) {
+	hash := "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1"
+	Convey("it should round trip migration entries", t, func() {
+		m := MigrationEntry{DNAHash: hash, Top: hash, Entries: []MigratedEntry{{Type: "review", From: hash, To: hash}}}
+		j, err := m.ToJSON()
+		So(err, ShouldBeNil)
+		m1, err := MigrationEntryFromJSON(j)
+		So(err, ShouldBeNil)
+		So(m1, ShouldResemble, m)
+	})
+
+	Convey("it should check the hashes", t, func() {
+		_, err := MigrationEntryFromJSON(`{"DNAHash":"fish"}`)
+		So(err, ShouldNotBeNil)
+		_, err = MigrationEntryFromJSON(`{"DNAHash":"` + hash + `","Entries":[{"Type":"review","From":"` + hash + `","To":"fish"}]}`)
+		So(err, ShouldNotBeNil)
+		So(sysValidateMigrationEntry(&GobEntry{C: `{"DNAHash":"fish"}`}), ShouldNotBeNil)
+	})
+}
+
+func TestCheckMigration(t *testing.T) {
+	d, _, h := SetupTestChain("test")
+	defer CleanupTestChain(h, d)
+	dna := h.nucleus.dna
+	z, _ := h.GetZome("jsSampleZome")
+
+	Convey("migrations should only be declared by DNA based on another", t, func() {
+		err := z.checkMigration(dna, &EntryDef{Name: "review", Migration: "testStrFn1"})
+		So(err.Error(), ShouldEqual, "entry type review: migrations can only be declared by DNA that is BasedOn another")
+	})
+
+	Convey("migration functions should be in the zome", t, func() {
+		dna.BasedOn, _ = NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+		defer func() { dna.BasedOn = NullHash() }()
+		So(z.checkMigration(dna, &EntryDef{Name: "review", Migration: "testStrFn1"}), ShouldBeNil)
+		err := z.checkMigration(dna, &EntryDef{Name: "review", Migration: "fooFn"})
+		So(err.Error(), ShouldEqual, "entry type review: migration function fooFn not found in zome jsSampleZome")
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	d, s, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	review := commit(h, "review", "pretty good")
+	rating := commit(h, "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"stars"}]}`, review.String(), review.String()))
+	r, err := (&APIFnMod{action: *NewModAction("review", &GobEntry{C: "really good"}, review)}).Call(h)
+	if err != nil {
+		panic(err)
+	}
+	update := r.(Hash)
+	secret := commit(h, "secret", "not carried over")
+
+	Convey("it should only migrate into DNA based on the chain's DNA", t, func() {
+		_, _, err := h.Migrate(h)
+		So(err, ShouldEqual, ErrMigrationNotBasedOn)
+	})
+
+	h2 := setupTestChain("test2", 0, s)
+	h2.nucleus.dna.BasedOn = h.dnaHash
+	changeEntryDef([]*Holochain{h2}, "review", func(def *EntryDef) { def.Migration = "testStrFn1" })
+	changeEntryDef([]*Holochain{h2}, "secret", func(def *EntryDef) { def.Name = "newSecret" })
+	prepareTestChain(h2)
+	defer h2.Close()
+
+	var migration MigrationEntry
+	var migrationHash Hash
+	Convey("it should re-commit the entries through the migration functions", t, func() {
+		migration, migrationHash, err = h2.Migrate(h)
+		So(err, ShouldBeNil)
+		So(len(migration.Entries), ShouldEqual, 3)
+		So(len(migration.Skipped), ShouldEqual, 0)
+		So(migration.DNAHash, ShouldEqual, h.dnaHash.String())
+
+		So(migration.Entries[0].From, ShouldEqual, review.String())
+		newReview, _ := NewHash(migration.Entries[0].To)
+		entry, _, err := h2.chain.GetEntry(newReview)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "result: pretty good")
+
+		entry, _, err = h2.chain.GetEntry(migrationHash)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldContainSubstring, rating.String())
+	})
+
+	Convey("the migration entry should not mention private entries", t, func() {
+		entry, _, err := h2.chain.GetEntry(migrationHash)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldNotContainSubstring, secret.String())
+	})
+
+	Convey("links and updates should be made against the migrated entries", t, func() {
+		newReview := migration.Entries[0].To
+		So(migration.Entries[1].From, ShouldEqual, rating.String())
+		newRating, _ := NewHash(migration.Entries[1].To)
+		entry, _, err := h2.chain.GetEntry(newRating)
+		So(err, ShouldBeNil)
+		links, err := LinksEntryFromJSON(entry.Content().(string))
+		So(err, ShouldBeNil)
+		So(links.Links[0].Base, ShouldEqual, newReview)
+		So(links.Links[0].Link, ShouldEqual, newReview)
+
+		So(migration.Entries[2].From, ShouldEqual, update.String())
+		newUpdate, _ := NewHash(migration.Entries[2].To)
+		hd, err := h2.chain.GetEntryHeader(newUpdate)
+		So(err, ShouldBeNil)
+		So(hd.Change.String(), ShouldEqual, newReview)
+	})
+
+	Convey("it should find the originals of migrated entries", t, func() {
+		newUpdate, _ := NewHash(migration.Entries[2].To)
+		dnaHash, original, err := h2.MigrationOrigin(newUpdate)
+		So(err, ShouldBeNil)
+		So(dnaHash.String(), ShouldEqual, h.dnaHash.String())
+		So(original.String(), ShouldEqual, update.String())
+
+		_, _, err = h2.MigrationOrigin(update)
+		So(err, ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("it should not migrate a chain twice", t, func() {
+		_, _, err := h2.Migrate(h)
+		So(err, ShouldEqual, ErrChainAlreadyMigrated)
+	})
+}
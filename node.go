@@ -7,6 +7,7 @@
 package holochain
 
 import (
+	"bufio"
 	"context"
 	//	host "github.com/libp2p/go-libp2p-host"
 	"encoding/gob"
@@ -81,6 +82,15 @@ const (
 	// Kademlia messages
 
 	FIND_NODE_REQUEST
+
+	// Anti-entropy gossip messages
+
+	GOSSIP_DIGEST_REQUEST
+	GOSSIP_FETCH_REQUEST
+
+	// History messages
+
+	HEADER_REQUEST
 )
 
 func (msgType MsgType) String() string {
@@ -100,7 +110,10 @@ func (msgType MsgType) String() string {
 		"VALIDATE_MOD_REQUEST",
 		"APP_MESSAGE",
 		"LISTADD_REQUEST",
-		"FIND_NODE_REQUEST"}[msgType]
+		"FIND_NODE_REQUEST",
+		"GOSSIP_DIGEST_REQUEST",
+		"GOSSIP_FETCH_REQUEST",
+		"HEADER_REQUEST"}[msgType]
 }
 
 var ErrBlockedListed = errors.New("node blockedlisted")
@@ -136,12 +149,22 @@ type Node struct {
 	host         *rhost.RoutedHost
 	mdnsSvc      discovery.Service
 	blockedlist  map[peer.ID]bool
+	blockedUntil map[peer.ID]time.Time // blocks that lapse, which are only kept in memory
+	blk          sync.RWMutex
+	reputation   *reputation
 	protocols    [_protocolCount]*Protocol
+	started      [_protocolCount]bool
 	peerstore    pstore.Peerstore
 	routingTable *RoutingTable
 	nat          *nat.NAT
 	log          *Logger
 
+	// wire encryption method from the DNA's DHTConfig that all streams must use
+	wireEncryption string
+
+	// encoding from the DNA's DHTConfig that messages are sent in
+	encoding string
+
 	// ticker task stoppers
 	stoppers []chan bool
 
@@ -208,6 +231,23 @@ func (h *Holochain) getNodePubKey(ID peer.ID) (pubKey ic.PubKey, err error) {
 	return
 }
 
+// getSourcePubKey returns the public key of a node we have received data from, using
+// the key in the peerstore if we have connected to it, otherwise getting it from the DHT
+func (h *Holochain) getSourcePubKey(ID peer.ID) (pubKey ic.PubKey, err error) {
+	if ID == h.nodeID {
+		pubKey = h.agent.PubKey()
+		return
+	}
+	if h.node != nil {
+		pubKey = h.node.peerstore.PubKey(ID)
+		if pubKey != nil && ID.MatchesPublicKey(pubKey) {
+			return
+		}
+	}
+	pubKey, err = h.getNodePubKey(ID)
+	return
+}
+
 func (h *Holochain) addPeer(pi pstore.PeerInfo, confirm bool) (err error) {
 	// add the peer into the peerstore
 	h.node.peerstore.AddAddrs(pi.ID, pi.Addrs, PeerTTL)
@@ -379,9 +419,10 @@ func (node *Node) discoverAndHandleNat(listenPort int) {
 }
 
 // NewNode creates a new node with given multiAddress listener string and identity
-func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUPnP bool, log *Logger) (node *Node, err error) {
+func NewNode(listenAddr string, protoMux string, agent Agent, enableNATUPnP bool, log *Logger) (node *Node, err error) {
 	var n Node
 	n.log = log
+	n.reputation = newReputation(DefaultReputationThreshold)
 	n.log.Logf("Creating new node with protoMux: %s\n", protoMux)
 	nodeID, _, err := agent.NodeID()
 	if err != nil {
@@ -465,9 +506,14 @@ func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUP
 }
 
 // Encode codes a message to gob format
-// @TODO generalize for other message encoding formats
 func (m *Message) Encode() (data []byte, err error) {
-	data, err = ByteEncoder(m)
+	data, err = m.EncodeAs(EncodingGob)
+	return
+}
+
+// EncodeAs codes a message to the given encoding format
+func (m *Message) EncodeAs(encoding string) (data []byte, err error) {
+	data, err = encodeAs(encoding, m)
 	if err != nil {
 		return
 	}
@@ -475,10 +521,21 @@ func (m *Message) Encode() (data []byte, err error) {
 }
 
 // Decode converts a message from gob format
-// @TODO generalize for other message encoding formats
 func (m *Message) Decode(r io.Reader) (err error) {
-	dec := gob.NewDecoder(r)
-	err = dec.Decode(m)
+	err = m.DecodeAs(EncodingGob, r)
+	return
+}
+
+// DecodeAs converts a message from the given encoding format, so that peers can only send
+// CBOR to nodes whose DNA selects it
+func (m *Message) DecodeAs(encoding string, r io.Reader) (err error) {
+	br := bufio.NewReader(r)
+	if encoding == EncodingCBOR {
+		err = newCBORDecoder(br).Decode(m)
+	} else {
+		dec := gob.NewDecoder(br)
+		err = dec.Decode(m)
+	}
 	return
 }
 
@@ -508,7 +565,8 @@ func (m Message) String() string {
 }
 
 // respondWith writes a message either error or otherwise, to the stream
-func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
+// if wc is not nil the message is encrypted with it
+func (node *Node) respondWith(s net.Stream, wc *wireConn, err error, body interface{}) {
 	var m *Message
 	if err != nil {
 		errResp := NewErrorResponse(err)
@@ -518,14 +576,21 @@ func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
 		m = node.NewMessage(OK_RESPONSE, body)
 	}
 
-	data, err := m.Encode()
-	if err != nil {
-		Infof("Response failed: unable to encode message: %v", m)
-	}
 	var n int
-	n, err = s.Write(data)
-	if err != nil {
-		Infof("Response failed: write returned error: %v", err)
+	if wc != nil {
+		n, err = wc.WriteMessage(m)
+		if err != nil {
+			Infof("Response failed: unable to write encrypted message: %v", err)
+		}
+	} else {
+		data, err := m.EncodeAs(node.encoding)
+		if err != nil {
+			Infof("Response failed: unable to encode message: %v", m)
+		}
+		n, err = s.Write(data)
+		if err != nil {
+			Infof("Response failed: write returned error: %v", err)
+		}
 	}
 	if BytesSentChan != nil {
 		b := BytesSent{Bytes: int64(n), MsgType: m.Type}
@@ -535,9 +600,23 @@ func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
 
 // StartProtocol initiates listening for a protocol on the node
 func (node *Node) StartProtocol(h *Holochain, proto int) (err error) {
+	node.started[proto] = true
 	node.host.SetStreamHandler(node.protocols[proto].ID, func(s net.Stream) {
 		var m Message
-		err := m.Decode(s)
+		var wc *wireConn
+		var err error
+		if isWireEncrypted(node.wireEncryption) {
+			// refuse peers that can't negotiate the DNA's wire encryption
+			wc, err = node.wireAccept(s)
+			if err != nil {
+				node.log.Logf("refusing stream from %v: %v", s.Conn().RemotePeer(), err)
+				node.respondWith(s, nil, ErrWireEncryptionRequired, nil)
+				return
+			}
+			err = wc.ReadMessage(&m)
+		} else {
+			err = m.DecodeAs(node.encoding, s)
+		}
 		var response interface{}
 		if m.From == "" {
 			// @todo other sanity checks on From?
@@ -551,7 +630,7 @@ func (node *Node) StartProtocol(h *Holochain, proto int) (err error) {
 				response, err = node.protocols[proto].Receiver(h, &m)
 			}
 		}
-		node.respondWith(s, err, response)
+		node.respondWith(s, wc, err, response)
 	})
 	return
 }
@@ -582,18 +661,35 @@ func (node *Node) Send(ctx context.Context, proto int, addr peer.ID, m *Message)
 	}
 	defer s.Close()
 
-	// encode the message and send it
-	data, err := m.Encode()
-	if err != nil {
-		return
+	var wc *wireConn
+	if isWireEncrypted(node.wireEncryption) {
+		wc, err = node.wireDial(s)
+		if err != nil {
+			return
+		}
 	}
 
-	n, err := s.Write(data)
-	if err != nil {
-		return
-	}
-	if n != len(data) {
-		err = errors.New("unable to send all data")
+	// encode the message and send it
+	var n int
+	if wc != nil {
+		n, err = wc.WriteMessage(m)
+		if err != nil {
+			return
+		}
+	} else {
+		var data []byte
+		data, err = m.EncodeAs(node.encoding)
+		if err != nil {
+			return
+		}
+
+		n, err = s.Write(data)
+		if err != nil {
+			return
+		}
+		if n != len(data) {
+			err = errors.New("unable to send all data")
+		}
 	}
 	if BytesSentChan != nil {
 		b := BytesSent{Bytes: int64(n), MsgType: m.Type}
@@ -601,7 +697,11 @@ func (node *Node) Send(ctx context.Context, proto int, addr peer.ID, m *Message)
 	}
 
 	// decode the response
-	err = response.Decode(s)
+	if wc != nil {
+		err = wc.ReadMessage(&response)
+	} else {
+		err = response.DecodeAs(node.encoding, s)
+	}
 	if err != nil {
 		node.log.Logf("failed to decode with err:%v ", err)
 		return
@@ -618,13 +718,21 @@ func (node *Node) NewMessage(t MsgType, body interface{}) (msg *Message) {
 
 // IsBlockedListed checks to see if a node is on the blockedlist
 func (node *Node) IsBlocked(addr peer.ID) (ok bool) {
+	node.blk.RLock()
 	ok = node.blockedlist[addr]
+	if !ok {
+		until, found := node.blockedUntil[addr]
+		ok = found && time.Now().Before(until)
+	}
+	node.blk.RUnlock()
 	return
 }
 
 // InitBlockedList sets up the blockedlist from a PeerList
 func (node *Node) InitBlockedList(list PeerList) {
+	node.blk.Lock()
 	node.blockedlist = make(map[peer.ID]bool)
+	node.blk.Unlock()
 	for _, r := range list.Records {
 		node.Block(r.ID)
 	}
@@ -632,17 +740,39 @@ func (node *Node) InitBlockedList(list PeerList) {
 
 // Block adds a peer to the blocklist
 func (node *Node) Block(addr peer.ID) {
+	node.blk.Lock()
+	defer node.blk.Unlock()
 	if node.blockedlist == nil {
 		node.blockedlist = make(map[peer.ID]bool)
 	}
 	node.blockedlist[addr] = true
 }
 
-// Unblock removes a peer from the blocklist
+// BlockFor blocks a peer for a while without adding it to the blocklist
+func (node *Node) BlockFor(addr peer.ID, d time.Duration) {
+	node.blk.Lock()
+	defer node.blk.Unlock()
+	now := time.Now()
+	if node.blockedUntil == nil {
+		node.blockedUntil = make(map[peer.ID]time.Time)
+	}
+	for id, until := range node.blockedUntil {
+		if now.After(until) {
+			delete(node.blockedUntil, id)
+		}
+	}
+	node.blockedUntil[addr] = now.Add(d)
+}
+
+// Unblock removes a peer from the blocklist, giving it a fresh reputation
 func (node *Node) Unblock(addr peer.ID) {
+	node.blk.Lock()
 	if node.blockedlist != nil {
 		delete(node.blockedlist, addr)
 	}
+	delete(node.blockedUntil, addr)
+	node.blk.Unlock()
+	node.reputation.forget(addr)
 }
 
 type ErrorResponse struct {
@@ -660,6 +790,7 @@ const (
 	ErrLinkNotFoundCode
 	ErrEntryTypeMismatchCode
 	ErrBlockedListedCode
+	ErrWireEncryptionRequiredCode
 )
 
 // NewErrorResponse encodes standard errors for transmitting
@@ -679,6 +810,8 @@ func NewErrorResponse(err error) (errResp ErrorResponse) {
 		errResp.Code = ErrEntryTypeMismatchCode
 	case ErrBlockedListed:
 		errResp.Code = ErrBlockedListedCode
+	case ErrWireEncryptionRequired:
+		errResp.Code = ErrWireEncryptionRequiredCode
 	default:
 		errResp.Message = err.Error() //Code will be set to ErrUnknown by default cus it's 0
 	}
@@ -702,6 +835,8 @@ func (errResp ErrorResponse) DecodeResponseError() (err error) {
 		err = ErrEntryTypeMismatch
 	case ErrBlockedListedCode:
 		err = ErrBlockedListed
+	case ErrWireEncryptionRequiredCode:
+		err = ErrWireEncryptionRequired
 	default:
 		err = errors.New(errResp.Message)
 	}
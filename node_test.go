@@ -158,7 +158,10 @@ func TestNodeSend(t *testing.T) {
 	defer node2.Close()
 	h2.node = node2
 	os.Remove(filepath.Join(h2.DBPath(), DHTStoreFileName))
-	h2.dht = NewDHT(h2)
+	h2.dht, err = NewDHT(h2)
+	if err != nil {
+		panic(err)
+	}
 
 	h.Activate()
 
@@ -28,6 +28,37 @@ type DNA struct {
 func (dna *DNA) check() (err error) {
 	if dna.RequiresVersion > Version {
 		err = fmt.Errorf("Chain requires Holochain version %d", dna.RequiresVersion)
+		return
+	}
+	_, err = GetShardingMethod(dna.DHTConfig.ShardingMethod)
+	if err != nil {
+		return
+	}
+	err = checkWireEncryption(dna.DHTConfig.WireEncryption)
+	if err != nil {
+		return
+	}
+	err = checkEncoding(dna.DHTConfig.Encoding)
+	if err != nil {
+		return
+	}
+	err = checkGossipMethod(dna.DHTConfig.GossipMethod)
+	if err != nil {
+		return
+	}
+	if dna.DHTConfig.ReputationThreshold > 0 {
+		err = ErrReputationThresholdInvalid
+		return
+	}
+	for _, z := range dna.Zomes {
+		for i := range z.Entries {
+			if err = z.Entries[i].checkIndexes(); err != nil {
+				return
+			}
+			if err = z.checkMigration(dna, &z.Entries[i]); err != nil {
+				return
+			}
+		}
 	}
 	return
 }
@@ -114,10 +145,11 @@ func actionReceiver(h *Holochain, msg *Message, retries int) (response interface
 			if err != nil {
 				if err == ErrHashNotFound {
 					dht.dlog.Logf("don't yet have %s, trying again later", t.RelatedHash)
-					retry := &retry{msg: *msg, retries: retries}
-					dht.retryQueue <- retry
-					response = DHTChangeUnknownHashQueuedForRetry
-					err = nil
+					// if the retry queue is full the sender will have to send it again
+					err = dht.retryQueue.push(&retry{Msg: *msg, Retries: retries}, false)
+					if err == nil {
+						response = DHTChangeUnknownHashQueuedForRetry
+					}
 				}
 			}
 
@@ -130,6 +162,16 @@ func actionReceiver(h *Holochain, msg *Message, retries int) (response interface
 		// The Receive functions understand this and use the values from the message body
 		// TODO, this indicates an architectural error, so fix!
 		response, err = a.Receive(dht, msg)
+		if err == ErrValidationUnanswered {
+			// the retry carries the retries remaining for the message, and if the retry
+			// queue is full the sender will have to send it again
+			err = dht.retryQueue.push(&retry{Msg: *msg, Retries: retries}, false)
+			if err == nil {
+				response = DHTChangeValidationQueuedForRetry
+			} else {
+				err = ErrValidationUnanswered
+			}
+		}
 	}
 	return
 }
@@ -67,6 +67,36 @@ func TestQueue(t *testing.T) {
 	}
 }
 
+func TestScoreQueue(t *testing.T) {
+	h1, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	h2, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	h3, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	h4, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+
+	p1 := PeerIDFromHash(h1)
+	p2 := PeerIDFromHash(h2)
+	p3 := PeerIDFromHash(h3)
+	p4 := PeerIDFromHash(h4)
+
+	scores := map[peer.ID]int{p1: -1, p2: 0, p3: 5, p4: 0}
+	pq := NewScorePQ(h1, func(p peer.ID) int { return scores[p] })
+	pq.Enqueue(p1)
+	pq.Enqueue(p4)
+	pq.Enqueue(p2)
+	pq.Enqueue(p3)
+
+	if pq.Len() != 4 {
+		t.Error("wrong length")
+	}
+
+	// should come out as: p3, then p2 and p4 by distance, then p1
+	for _, expected := range []peer.ID{p3, p2, p4, p1} {
+		if d := pq.Dequeue(); d != expected {
+			t.Error("ordering failed")
+		}
+	}
+}
+
 func newPeerTime(t time.Time) peer.ID {
 	s := fmt.Sprintf("hmmm time: %v", t)
 	h, _ := mh.Sum([]byte(s), mh.SHA2_256, -1)
@@ -0,0 +1,101 @@
+package peerqueue
+
+import (
+	"container/heap"
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"math/big"
+	"sync"
+)
+
+// scoredPeer tracks a peer, its score and its distance to something else.
+type scoredPeer struct {
+	peer     peer.ID
+	score    int
+	distance *big.Int
+}
+
+// scoredPeerHeap implements a heap of scoredPeers, highest score first
+type scoredPeerHeap []*scoredPeer
+
+func (ph scoredPeerHeap) Len() int {
+	return len(ph)
+}
+
+func (ph scoredPeerHeap) Less(i, j int) bool {
+	if ph[i].score != ph[j].score {
+		return ph[i].score > ph[j].score
+	}
+	return -1 == ph[i].distance.Cmp(ph[j].distance)
+}
+
+func (ph scoredPeerHeap) Swap(i, j int) {
+	ph[i], ph[j] = ph[j], ph[i]
+}
+
+func (ph *scoredPeerHeap) Push(x interface{}) {
+	item := x.(*scoredPeer)
+	*ph = append(*ph, item)
+}
+
+func (ph *scoredPeerHeap) Pop() interface{} {
+	old := *ph
+	n := len(old)
+	item := old[n-1]
+	*ph = old[0 : n-1]
+	return item
+}
+
+// scorePQ implements heap.Interface and PeerQueue
+type scorePQ struct {
+	// from is the Key this PQ measures distances against to break ties
+	from Hash
+
+	// score returns the score of a peer when it is enqueued
+	score func(peer.ID) int
+
+	heap scoredPeerHeap
+
+	sync.RWMutex
+}
+
+func (pq *scorePQ) Len() int {
+	pq.Lock()
+	defer pq.Unlock()
+	return len(pq.heap)
+}
+
+func (pq *scorePQ) Enqueue(p peer.ID) {
+	pq.Lock()
+	defer pq.Unlock()
+
+	heap.Push(&pq.heap, &scoredPeer{
+		peer:     p,
+		score:    pq.score(p),
+		distance: HashXORDistance(HashFromPeerID(p), pq.from),
+	})
+}
+
+func (pq *scorePQ) Dequeue() peer.ID {
+	pq.Lock()
+	defer pq.Unlock()
+
+	if len(pq.heap) < 1 {
+		panic("called Dequeue on an empty PeerQueue")
+	}
+
+	o := heap.Pop(&pq.heap)
+	p := o.(*scoredPeer)
+	return p.peer
+}
+
+// NewScorePQ returns a PeerQueue which maintains its peers sorted by a score
+// such as their reputation, highest first.  Peers with the same score are
+// sorted by their XOR distance to from.
+func NewScorePQ(from Hash, score func(peer.ID) int) PeerQueue {
+	return &scorePQ{
+		from:  from,
+		score: score,
+		heap:  scoredPeerHeap{},
+	}
+}
@@ -0,0 +1,409 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the constraint expressions and field projection used by Holochain.Query
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// operators for a QueryCondition
+
+	QueryOpEquals    = "="
+	QueryOpNotEquals = "!="
+	QueryOpGT        = ">"
+	QueryOpGTE       = ">="
+	QueryOpLT        = "<"
+	QueryOpLTE       = "<="
+	QueryOpBetween   = "between"
+	QueryOpIn        = "in"
+	QueryOpContains  = "contains"
+	QueryOpMatches   = "matches"
+
+	// QueryHeaderFieldPrefix selects header fields rather than entry fields, i.e. Header.Time
+	QueryHeaderFieldPrefix = "Header."
+)
+
+var ErrQueryConditionInvalid = errors.New("invalid query condition")
+
+// QueryCondition is one node of a Query's Where expression.  A node either combines
+// other conditions with And, Or or Not, or it compares the value found at Field with
+// Value using Op.
+//
+// Field is a dotted path into a JSON entry (i.e. "address.city" or "tags.0"), a header
+// field prefixed with "Header." (Time, Type, EntryLink, HeaderLink or TypeLink), or empty
+// for the whole entry.  Numbers compare numerically, and times (time values, RFC3339 strings,
+// or numbers as unix seconds when compared to a time) compare chronologically.
+// The between operator takes a two element [low, high] Value, inclusive, and the in
+// operator takes a list of values.  Conditions on fields that don't exist never match.
+type QueryCondition struct {
+	And   []QueryCondition `json:",omitempty"`
+	Or    []QueryCondition `json:",omitempty"`
+	Not   *QueryCondition  `json:",omitempty"`
+	Field string           `json:",omitempty"`
+	Op    string           `json:",omitempty"`
+	Value interface{}      `json:",omitempty"`
+
+	re *regexp.Regexp
+}
+
+// queryItem holds the header and decoded entry content being checked against a condition
+type queryItem struct {
+	header  *Header
+	content interface{}
+}
+
+// prepare checks that the condition is well formed and compiles any regular expressions
+func (c *QueryCondition) prepare() (err error) {
+	combinators := 0
+	if len(c.And) > 0 {
+		combinators++
+	}
+	if len(c.Or) > 0 {
+		combinators++
+	}
+	if c.Not != nil {
+		combinators++
+	}
+	if combinators > 1 || (combinators == 1 && c.Op != "") {
+		err = fmt.Errorf("%v: only one of And, Or, Not or Op may be given", ErrQueryConditionInvalid)
+		return
+	}
+	for i := range c.And {
+		if err = c.And[i].prepare(); err != nil {
+			return
+		}
+	}
+	for i := range c.Or {
+		if err = c.Or[i].prepare(); err != nil {
+			return
+		}
+	}
+	if c.Not != nil {
+		err = c.Not.prepare()
+		return
+	}
+	if combinators > 0 {
+		return
+	}
+
+	switch c.Op {
+	case QueryOpEquals, QueryOpNotEquals, QueryOpGT, QueryOpGTE, QueryOpLT, QueryOpLTE, QueryOpContains:
+	case QueryOpBetween:
+		l, ok := c.Value.([]interface{})
+		if !ok || len(l) != 2 {
+			err = fmt.Errorf("%v: between requires a [low, high] value", ErrQueryConditionInvalid)
+		}
+	case QueryOpIn:
+		if _, ok := c.Value.([]interface{}); !ok {
+			err = fmt.Errorf("%v: in requires a list value", ErrQueryConditionInvalid)
+		}
+	case QueryOpMatches:
+		s, ok := c.Value.(string)
+		if !ok {
+			err = fmt.Errorf("%v: matches requires a regular expression value", ErrQueryConditionInvalid)
+			return
+		}
+		c.re, err = regexp.Compile(s)
+	default:
+		err = fmt.Errorf("%v: unknown operator %s", ErrQueryConditionInvalid, c.Op)
+	}
+	return
+}
+
+// match returns true if the item satisfies the condition
+func (c *QueryCondition) match(item *queryItem) bool {
+	if len(c.And) > 0 {
+		for i := range c.And {
+			if !c.And[i].match(item) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(c.Or) > 0 {
+		for i := range c.Or {
+			if c.Or[i].match(item) {
+				return true
+			}
+		}
+		return false
+	}
+	if c.Not != nil {
+		return !c.Not.match(item)
+	}
+
+	v, ok := item.field(c.Field)
+	if !ok {
+		return false
+	}
+	return c.matchValue(v)
+}
+
+// matchValue returns true if the value of a field satisfies a comparison condition
+func (c *QueryCondition) matchValue(v interface{}) bool {
+	switch c.Op {
+	case QueryOpEquals:
+		return queryEqual(v, c.Value)
+	case QueryOpNotEquals:
+		return !queryEqual(v, c.Value)
+	case QueryOpGT:
+		cmp, ok := queryCompare(v, c.Value)
+		return ok && cmp > 0
+	case QueryOpGTE:
+		cmp, ok := queryCompare(v, c.Value)
+		return ok && cmp >= 0
+	case QueryOpLT:
+		cmp, ok := queryCompare(v, c.Value)
+		return ok && cmp < 0
+	case QueryOpLTE:
+		cmp, ok := queryCompare(v, c.Value)
+		return ok && cmp <= 0
+	case QueryOpBetween:
+		l := c.Value.([]interface{})
+		low, ok1 := queryCompare(v, l[0])
+		high, ok2 := queryCompare(v, l[1])
+		return ok1 && ok2 && low >= 0 && high <= 0
+	case QueryOpIn:
+		for _, x := range c.Value.([]interface{}) {
+			if queryEqual(v, x) {
+				return true
+			}
+		}
+		return false
+	case QueryOpContains:
+		if l, ok := v.([]interface{}); ok {
+			for _, x := range l {
+				if queryEqual(x, c.Value) {
+					return true
+				}
+			}
+			return false
+		}
+		s, ok1 := queryString(v)
+		sub, ok2 := queryString(c.Value)
+		return ok1 && ok2 && strings.Contains(s, sub)
+	case QueryOpMatches:
+		s, ok := queryString(v)
+		return ok && c.re.MatchString(s)
+	}
+	return false
+}
+
+// field returns the value at a field path of the item
+func (item *queryItem) field(path string) (v interface{}, ok bool) {
+	if strings.HasPrefix(path, QueryHeaderFieldPrefix) {
+		ok = true
+		switch strings.TrimPrefix(path, QueryHeaderFieldPrefix) {
+		case "Time":
+			v = item.header.Time
+		case "Type":
+			v = item.header.Type
+		case "EntryLink":
+			v = item.header.EntryLink.String()
+		case "HeaderLink":
+			v = item.header.HeaderLink.String()
+		case "TypeLink":
+			v = item.header.TypeLink.String()
+		default:
+			ok = false
+		}
+		return
+	}
+	v, ok = queryField(item.content, path)
+	return
+}
+
+// queryField walks a dotted path into decoded JSON content
+func queryField(content interface{}, path string) (v interface{}, ok bool) {
+	v = content
+	if path == "" {
+		ok = v != nil
+		return
+	}
+	for _, part := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v, ok = t[part]
+			if !ok {
+				return
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(t) {
+				ok = false
+				return
+			}
+			v = t[i]
+		default:
+			ok = false
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// queryContent decodes an entry's content so that its fields can be queried
+func queryContent(def *EntryDef, entry Entry) (content interface{}, err error) {
+	content = entry.Content()
+	if def.DataFormat != DataFormatJSON {
+		return
+	}
+	s, ok := content.(string)
+	if !ok {
+		var b []byte
+		b, err = json.Marshal(content)
+		if err != nil {
+			return
+		}
+		s = string(b)
+	}
+	content = nil
+	err = json.Unmarshal([]byte(s), &content)
+	return
+}
+
+// queryProject returns a JSON entry holding only the given fields of the content,
+// keeping their nesting
+func queryProject(content interface{}, fields []string) (e Entry, err error) {
+	projected := make(map[string]interface{})
+	for _, f := range fields {
+		v, ok := queryField(content, f)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(f, ".")
+		m := projected
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := m[part].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				m[part] = next
+			}
+			m = next
+		}
+		m[parts[len(parts)-1]] = v
+	}
+	var b []byte
+	b, err = json.Marshal(projected)
+	if err != nil {
+		return
+	}
+	e = &GobEntry{C: string(b)}
+	return
+}
+
+// queryString returns strings, numbers and booleans as strings for string comparisons
+func queryString(v interface{}) (s string, ok bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case bool:
+		return strconv.FormatBool(t), true
+	}
+	if f, ok := queryNumber(v); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64), true
+	}
+	return
+}
+
+// queryNumber converts any numeric type to a float64
+func queryNumber(v interface{}) (f float64, ok bool) {
+	if v == nil {
+		return
+	}
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(r.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(r.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return r.Float(), true
+	}
+	return
+}
+
+// queryTime converts time values, RFC3339 strings and, when numbers are allowed, unix seconds to a time
+func queryTime(v interface{}, numbers bool) (t time.Time, ok bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case string:
+		var err error
+		t, err = time.Parse(time.RFC3339Nano, x)
+		return t, err == nil
+	}
+	if numbers {
+		if f, isNum := queryNumber(v); isNum {
+			return time.Unix(0, int64(f*float64(time.Second))), true
+		}
+	}
+	return
+}
+
+// queryCompare orders two values, returning false if they can't be compared
+func queryCompare(a interface{}, b interface{}) (cmp int, ok bool) {
+	_, aIsTime := a.(time.Time)
+	_, bIsTime := b.(time.Time)
+	if aIsTime || bIsTime {
+		at, aok := queryTime(a, true)
+		bt, bok := queryTime(b, true)
+		if !aok || !bok {
+			return
+		}
+		return compareTimes(at, bt), true
+	}
+	af, aok := queryNumber(a)
+	bf, bok := queryNumber(b)
+	if aok && bok {
+		ok = true
+		if af < bf {
+			cmp = -1
+		} else if af > bf {
+			cmp = 1
+		}
+		return
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		at, aok := queryTime(as, false)
+		bt, bok := queryTime(bs, false)
+		if aok && bok {
+			return compareTimes(at, bt), true
+		}
+		return strings.Compare(as, bs), true
+	}
+	return
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	if a.Before(b) {
+		return -1
+	}
+	if a.After(b) {
+		return 1
+	}
+	return 0
+}
+
+// queryEqual returns true if the values are equal, comparing numbers and times by value
+func queryEqual(a interface{}, b interface{}) bool {
+	if cmp, ok := queryCompare(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
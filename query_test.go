@@ -0,0 +1,97 @@
+package holochain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testQueryItem(j string) *queryItem {
+	item := &queryItem{header: &Header{Type: "someType", Time: time.Unix(1500000000, 0)}}
+	if err := json.Unmarshal([]byte(j), &item.content); err != nil {
+		panic(err)
+	}
+	return item
+}
+
+func testQueryMatch(c QueryCondition, item *queryItem) bool {
+	if err := c.prepare(); err != nil {
+		panic(err)
+	}
+	return c.match(item)
+}
+
+func TestQueryConditionPrepare(t *testing.T) {
+	Convey("it should reject malformed conditions", t, func() {
+		c := QueryCondition{Field: "x", Op: "~"}
+		So(c.prepare().Error(), ShouldEqual, "invalid query condition: unknown operator ~")
+		c = QueryCondition{Field: "x", Op: QueryOpBetween, Value: []interface{}{1}}
+		So(c.prepare().Error(), ShouldEqual, "invalid query condition: between requires a [low, high] value")
+		c = QueryCondition{Field: "x", Op: QueryOpIn, Value: 1}
+		So(c.prepare().Error(), ShouldEqual, "invalid query condition: in requires a list value")
+		c = QueryCondition{Field: "x", Op: QueryOpMatches, Value: 1}
+		So(c.prepare().Error(), ShouldEqual, "invalid query condition: matches requires a regular expression value")
+		c = QueryCondition{Or: []QueryCondition{{Field: "x", Op: QueryOpEquals}}, Op: QueryOpEquals}
+		So(c.prepare().Error(), ShouldEqual, "invalid query condition: only one of And, Or, Not or Op may be given")
+		c = QueryCondition{And: []QueryCondition{{Field: "x", Op: "~"}}}
+		So(c.prepare(), ShouldNotBeNil)
+	})
+}
+
+func TestQueryConditionMatch(t *testing.T) {
+	item := testQueryItem(`{"name":"Zippy","age":42,"born":"1970-01-02T00:00:00Z","address":{"city":"Chicago"},"tags":["a","b"]}`)
+
+	Convey("it should compare numbers numerically", t, func() {
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpEquals, Value: 42}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpGT, Value: 9}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpLT, Value: 9.5}, item), ShouldBeFalse)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpGTE, Value: 42}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpLTE, Value: 41}, item), ShouldBeFalse)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpBetween, Value: []interface{}{40, 50}}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpBetween, Value: []interface{}{43, 50}}, item), ShouldBeFalse)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpGT, Value: "9"}, item), ShouldBeFalse)
+	})
+
+	Convey("it should compare times chronologically", t, func() {
+		So(testQueryMatch(QueryCondition{Field: "born", Op: QueryOpLT, Value: "1970-01-10T00:00:00Z"}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "born", Op: QueryOpGT, Value: time.Unix(0, 0)}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "Header.Time", Op: QueryOpEquals, Value: 1500000000}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "Header.Time", Op: QueryOpBetween, Value: []interface{}{"2017-01-01T00:00:00Z", "2018-01-01T00:00:00Z"}}, item), ShouldBeTrue)
+	})
+
+	Convey("it should follow nested field paths", t, func() {
+		So(testQueryMatch(QueryCondition{Field: "address.city", Op: QueryOpEquals, Value: "Chicago"}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "tags.1", Op: QueryOpEquals, Value: "b"}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "tags.2", Op: QueryOpEquals, Value: "b"}, item), ShouldBeFalse)
+		So(testQueryMatch(QueryCondition{Field: "address.zip", Op: QueryOpNotEquals, Value: "b"}, item), ShouldBeFalse)
+	})
+
+	Convey("it should handle in, contains and matches on any type", t, func() {
+		So(testQueryMatch(QueryCondition{Field: "name", Op: QueryOpIn, Value: []interface{}{"Pebbles", "Zippy"}}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpIn, Value: []interface{}{1, 2}}, item), ShouldBeFalse)
+		So(testQueryMatch(QueryCondition{Field: "tags", Op: QueryOpContains, Value: "a"}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpContains, Value: "4"}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "age", Op: QueryOpMatches, Value: "^4[0-9]$"}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Field: "address", Op: QueryOpMatches, Value: "Chicago"}, item), ShouldBeFalse)
+	})
+
+	Convey("it should combine conditions", t, func() {
+		yes := QueryCondition{Field: "name", Op: QueryOpEquals, Value: "Zippy"}
+		no := QueryCondition{Field: "name", Op: QueryOpEquals, Value: "Pebbles"}
+		So(testQueryMatch(QueryCondition{And: []QueryCondition{yes, no}}, item), ShouldBeFalse)
+		So(testQueryMatch(QueryCondition{Or: []QueryCondition{yes, no}}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{Not: &no}, item), ShouldBeTrue)
+		So(testQueryMatch(QueryCondition{And: []QueryCondition{yes, {Not: &no}}}, item), ShouldBeTrue)
+	})
+}
+
+func TestQueryProject(t *testing.T) {
+	item := testQueryItem(`{"name":"Zippy","age":42,"address":{"city":"Chicago","zip":"60601"}}`)
+	Convey("it should keep only the selected fields", t, func() {
+		e, err := queryProject(item.content, []string{"name", "address.city", "missing"})
+		So(err, ShouldBeNil)
+		So(e.Content(), ShouldEqual, `{"address":{"city":"Chicago"},"name":"Zippy"}`)
+	})
+}
@@ -0,0 +1,391 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements durable, bounded queues for the DHT's background work
+
+package holochain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// names of the DHT's work queues
+
+	ChangeQueueName    = "change"
+	RetryQueueName     = "retry"
+	GossipPutQueueName = "gossipPut"
+)
+
+const (
+	// QueueItemAttempts is how many times a queue item's handler is tried before the item is dropped
+	QueueItemAttempts = 3
+
+	// QueueRetryDelay is how long to wait before trying a queue item whose handler failed again
+	QueueRetryDelay = time.Second
+)
+
+var ErrQueueFull = errors.New("queue full")
+var ErrQueueClosed = errors.New("queue closed")
+
+// dhtQueue is a first in, first out queue that is kept in the node's queue database so
+// that pending work survives restarts.  Items live only in the database, which holds at
+// most max items, and pushing to a full queue fails with ErrQueueFull unless the caller
+// asks to wait for room.
+type dhtQueue struct {
+	name   string
+	db     *buntdb.DB
+	max    int
+	cipher *storeCipher
+	decode func([]byte) (interface{}, error)
+
+	lk       sync.Mutex
+	head     uint64 // sequence number of the next item to pop
+	tail     uint64 // sequence number of the next item to push
+	closed   bool
+	attempts int           // failed attempts at handling the item at the front
+	ready    chan struct{} // signalled when an item is pushed
+	room     chan struct{} // signalled when an item is removed
+}
+
+func queueKey(name string, seq uint64) string {
+	// zero padded so the keys sort in sequence order
+	return fmt.Sprintf("q:%s:%020d", name, seq)
+}
+
+// openDHTQueue opens a queue in the db, picking up any items left from a previous run
+func openDHTQueue(db *buntdb.DB, name string, max int, cipher *storeCipher, decode func([]byte) (interface{}, error)) (q *dhtQueue, err error) {
+	q = &dhtQueue{
+		name:   name,
+		db:     db,
+		max:    max,
+		cipher: cipher,
+		decode: decode,
+		ready:  make(chan struct{}, 1),
+		room:   make(chan struct{}, 1),
+	}
+	prefix := "q:" + name + ":"
+	first := true
+	err = db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(prefix+"*", func(key, value string) bool {
+			seq, e := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 64)
+			if e != nil {
+				return true
+			}
+			if first {
+				q.head = seq
+				first = false
+			}
+			q.tail = seq + 1
+			return true
+		})
+	})
+	if err != nil {
+		return
+	}
+	if q.tail > q.head {
+		q.ready <- struct{}{}
+	}
+	return
+}
+
+// Len returns the number of items waiting in the queue
+func (q *dhtQueue) Len() int {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return int(q.tail - q.head)
+}
+
+// Room returns the number of items that can be pushed before the queue is full
+func (q *dhtQueue) Room() int {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	room := q.max - int(q.tail-q.head)
+	if room < 0 {
+		room = 0
+	}
+	return room
+}
+
+// push adds an item to the end of the queue.  If the queue is full it returns ErrQueueFull,
+// or if wait is true, blocks until there is room.
+func (q *dhtQueue) push(item interface{}, wait bool) (err error) {
+	var data []byte
+	data, err = ByteEncoder(item)
+	if err != nil {
+		return
+	}
+	if q.cipher != nil {
+		if data, err = q.cipher.seal(data); err != nil {
+			return
+		}
+	}
+	q.lk.Lock()
+	for !q.closed && int(q.tail-q.head) >= q.max {
+		if !wait {
+			q.lk.Unlock()
+			return ErrQueueFull
+		}
+		q.lk.Unlock()
+		<-q.room
+		q.lk.Lock()
+	}
+	if q.closed {
+		q.lk.Unlock()
+		return ErrQueueClosed
+	}
+	err = q.db.Update(func(tx *buntdb.Tx) error {
+		_, _, e := tx.Set(queueKey(q.name, q.tail), string(data), nil)
+		return e
+	})
+	if err == nil {
+		q.tail++
+		signal(q.ready)
+	}
+	q.lk.Unlock()
+	return
+}
+
+// signal wakes up a waiter without blocking, and must be called with the queue locked
+// so that it can't race with close
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// peek returns the item at the front of the queue without removing it, or false if the
+// queue is empty.  The item stays queued until it is acked, so work that is interrupted
+// is done again rather than lost.
+func (q *dhtQueue) peek() (item interface{}, ok bool, err error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	// the db may be closed along with the queue, and room mustn't be signaled once closed
+	if q.closed {
+		return
+	}
+	for q.head < q.tail {
+		var value string
+		seq := q.head
+		err = q.db.View(func(tx *buntdb.Tx) (e error) {
+			value, e = tx.Get(queueKey(q.name, seq))
+			return
+		})
+		if err == buntdb.ErrNotFound {
+			// skip holes so a lost item can't block the queue
+			err = nil
+			q.removed()
+			continue
+		}
+		if err != nil {
+			return
+		}
+		data := []byte(value)
+		if q.cipher != nil {
+			data, err = q.cipher.open(data)
+		}
+		if err == nil {
+			item, err = q.decode(data)
+		}
+		if err != nil {
+			// an item we can't decode would block the queue forever so drop it
+			Debugf("dropping undecodable %s queue item: %v", q.name, err)
+			if err = q.remove(seq); err != nil {
+				return
+			}
+			continue
+		}
+		ok = true
+		return
+	}
+	return
+}
+
+// ack removes the item at the front of the queue once it has been handled
+func (q *dhtQueue) ack() (err error) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if q.head < q.tail {
+		err = q.remove(q.head)
+	}
+	return
+}
+
+// pop removes and returns the item at the front of the queue, or false if the queue is empty
+func (q *dhtQueue) pop() (item interface{}, ok bool, err error) {
+	item, ok, err = q.peek()
+	if ok {
+		err = q.ack()
+	}
+	return
+}
+
+// handle calls the handler on the item at the front of the queue, removing the item only
+// once the handler succeeds.  An item whose handler keeps failing is tried again after
+// QueueRetryDelay, and dropped after QueueItemAttempts so it can't block the queue.
+// Returns false if the queue is empty.
+func (q *dhtQueue) handle(handlerFn func(interface{}) error) (ok bool, err error) {
+	var item interface{}
+	item, ok, err = q.peek()
+	if !ok {
+		return
+	}
+	err = handlerFn(item)
+	if err == nil {
+		err = q.ack()
+		return
+	}
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	q.attempts++
+	if q.attempts < QueueItemAttempts {
+		time.AfterFunc(QueueRetryDelay, func() {
+			q.lk.Lock()
+			if !q.closed {
+				signal(q.ready)
+			}
+			q.lk.Unlock()
+		})
+		return
+	}
+	Debugf("dropping %s queue item after %d failed attempts: %v", q.name, q.attempts, err)
+	if !q.closed && q.remove(q.head) == nil {
+		signal(q.ready)
+	}
+	return
+}
+
+// remove deletes an item from the db, which must be the one at the front of the queue,
+// and must be called with the queue locked
+func (q *dhtQueue) remove(seq uint64) (err error) {
+	err = q.db.Update(func(tx *buntdb.Tx) (e error) {
+		_, e = tx.Delete(queueKey(q.name, seq))
+		return
+	})
+	if err == buntdb.ErrNotFound {
+		err = nil
+	}
+	if err == nil {
+		q.removed()
+	}
+	return
+}
+
+// removed moves the front of the queue on past an item that is gone, and must be called
+// with the queue locked
+func (q *dhtQueue) removed() {
+	q.head++
+	q.attempts = 0
+	signal(q.room)
+}
+
+// wait blocks until an item may have been pushed, returning false once the queue is closed
+func (q *dhtQueue) wait() bool {
+	<-q.ready
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return !q.closed
+}
+
+// close stops the queue, waking up its consumer and any blocked pushes
+func (q *dhtQueue) close() {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.ready)
+	close(q.room)
+}
+
+func decodePut(data []byte) (item interface{}, err error) {
+	var p Put
+	err = ByteDecoder(data, &p)
+	item = p
+	return
+}
+
+func decodeRetry(data []byte) (item interface{}, err error) {
+	var r retry
+	err = ByteDecoder(data, &r)
+	item = &r
+	return
+}
+
+func decodeChangeReq(data []byte) (item interface{}, err error) {
+	var req changeReq
+	err = ByteDecoder(data, &req)
+	item = req
+	return
+}
+
+// QueueDepths holds the number of items waiting in each of the DHT's work queues
+type QueueDepths struct {
+	Changes    int
+	Retries    int
+	GossipPuts int
+}
+
+// QueueDepths returns the number of items waiting in each of the DHT's work queues
+func (dht *DHT) QueueDepths() (depths QueueDepths) {
+	depths.Changes = dht.changeQueue.Len()
+	depths.Retries = dht.retryQueue.Len()
+	depths.GossipPuts = dht.gossipPuts.Len()
+	return
+}
+
+// openQueues opens the DHT's work queues in the queue database
+func (dht *DHT) openQueues(path string) (err error) {
+	dht.queueDB, err = buntdb.Open(path)
+	if err != nil {
+		return
+	}
+	cipher := dht.h.storeCipher
+	if cipher != nil {
+		cipher = cipher.forStore(DHTQueueFileName)
+	}
+	if dht.changeQueue, err = openDHTQueue(dht.queueDB, ChangeQueueName, ChangeQueueSize, cipher, decodeChangeReq); err != nil {
+		return
+	}
+	if dht.retryQueue, err = openDHTQueue(dht.queueDB, RetryQueueName, RetryQueueSize, cipher, decodeRetry); err != nil {
+		return
+	}
+	dht.gossipPuts, err = openDHTQueue(dht.queueDB, GossipPutQueueName, GossipPutQueueSize, cipher, decodePut)
+	return
+}
+
+// handleQueueTillDone calls the handler on each item of the queue as it arrives until the queue is closed
+func (dht *DHT) handleQueueTillDone(errtext string, q *dhtQueue, handlerFn func(*DHT, interface{}) error) (err error) {
+	for {
+		dht.glog.Logf("%s: waiting for request", errtext)
+		if !q.wait() {
+			break
+		}
+		for {
+			ok, e := q.handle(func(x interface{}) error { return handlerFn(dht, x) })
+			if e != nil {
+				// a failed item stays queued to be tried again later
+				dht.glog.Logf("%s: got err: %v", errtext, e)
+				break
+			}
+			if !ok {
+				break
+			}
+		}
+	}
+	dht.glog.Logf("%s: queue closed, stopping", errtext)
+	return nil
+}
@@ -0,0 +1,123 @@
+package holochain
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tidwall/buntdb"
+)
+
+func TestDHTQueue(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	path := filepath.Join(d, DHTQueueFileName)
+
+	db, err := buntdb.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	q, err := openDHTQueue(db, GossipPutQueueName, 2, nil, decodePut)
+	if err != nil {
+		panic(err)
+	}
+
+	Convey("it should queue items in order up to its size", t, func() {
+		So(q.Len(), ShouldEqual, 0)
+		So(q.Room(), ShouldEqual, 2)
+		So(q.push(Put{Idx: 1}, false), ShouldBeNil)
+		So(q.push(Put{Idx: 2}, false), ShouldBeNil)
+		So(q.push(Put{Idx: 3}, false), ShouldEqual, ErrQueueFull)
+		So(q.Len(), ShouldEqual, 2)
+		So(q.Room(), ShouldEqual, 0)
+	})
+
+	Convey("it should keep the items when reopened", t, func() {
+		db.Close()
+		db, err = buntdb.Open(path)
+		So(err, ShouldBeNil)
+		q, err = openDHTQueue(db, GossipPutQueueName, 2, nil, decodePut)
+		So(err, ShouldBeNil)
+		So(q.Len(), ShouldEqual, 2)
+		So(q.wait(), ShouldBeTrue)
+		x, ok, err := q.pop()
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(x.(Put).Idx, ShouldEqual, 1)
+	})
+
+	Convey("a waiting push should block until there is room", t, func() {
+		So(q.push(Put{Idx: 3}, false), ShouldBeNil)
+		pushed := make(chan error)
+		go func() { pushed <- q.push(Put{Idx: 4}, true) }()
+		select {
+		case <-pushed:
+			t.Error("push should have waited")
+		case <-time.After(50 * time.Millisecond):
+		}
+		x, _, _ := q.pop()
+		So(x.(Put).Idx, ShouldEqual, 2)
+		So(<-pushed, ShouldBeNil)
+		x, _, _ = q.pop()
+		So(x.(Put).Idx, ShouldEqual, 3)
+		x, _, _ = q.pop()
+		So(x.(Put).Idx, ShouldEqual, 4)
+		_, ok, err := q.pop()
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("an item should stay queued until its handler succeeds", t, func() {
+		So(q.push(Put{Idx: 7}, false), ShouldBeNil)
+		failed := errors.New("handler failed")
+		ok, err := q.handle(func(x interface{}) error { return failed })
+		So(ok, ShouldBeTrue)
+		So(err, ShouldEqual, failed)
+		So(q.Len(), ShouldEqual, 1)
+
+		// and be handled again on the next try
+		So(q.wait(), ShouldBeTrue)
+		var handled interface{}
+		ok, err = q.handle(func(x interface{}) error { handled = x; return nil })
+		So(ok, ShouldBeTrue)
+		So(err, ShouldBeNil)
+		So(handled.(Put).Idx, ShouldEqual, 7)
+		So(q.Len(), ShouldEqual, 0)
+	})
+
+	Convey("an item whose handler keeps failing should be dropped", t, func() {
+		So(q.push(Put{Idx: 8}, false), ShouldBeNil)
+		So(q.push(Put{Idx: 9}, false), ShouldBeNil)
+		for i := 0; i < QueueItemAttempts; i++ {
+			_, err := q.handle(func(x interface{}) error { return errors.New("handler failed") })
+			So(err, ShouldNotBeNil)
+		}
+		So(q.Len(), ShouldEqual, 1)
+		x, ok, err := q.pop()
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(x.(Put).Idx, ShouldEqual, 9)
+	})
+
+	Convey("popping a closed queue should leave its items", t, func() {
+		q2, err := openDHTQueue(db, "closed", 2, nil, decodePut)
+		So(err, ShouldBeNil)
+		So(q2.push(Put{Idx: 6}, false), ShouldBeNil)
+		q2.close()
+		_, ok, err := q2.pop()
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+		So(q2.Len(), ShouldEqual, 1)
+	})
+
+	Convey("closing should stop waiters and pushes", t, func() {
+		waited := make(chan bool)
+		go func() { waited <- q.wait() }()
+		q.close()
+		So(<-waited, ShouldBeFalse)
+		So(q.push(Put{Idx: 5}, true), ShouldEqual, ErrQueueClosed)
+		db.Close()
+	})
+}
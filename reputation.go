@@ -0,0 +1,260 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements peer reputation scoring from the behaviour we see of peers
+
+package holochain
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ReputationEvent is a kind of peer behaviour that changes the peer's reputation score
+type ReputationEvent int
+
+const (
+	ReputationValidationFailed ReputationEvent = iota // the peer sent us data that failed validation
+	ReputationTimeout                                 // the peer didn't answer a request
+	ReputationInvalidGossip                           // the peer sent us malformed gossip
+	ReputationHoldReceipt                             // the peer agreed to hold data for us
+)
+
+const (
+	DefaultReputationThreshold = -50
+
+	// MaxReputationScore bounds scores in both directions so a peer can't bank
+	// enough good behaviour to get away with misbehaving
+	MaxReputationScore = 100
+
+	// ReputationHalfLife is how long it takes a score to decay half way back to zero, so
+	// that peers are judged on their recent behaviour
+	ReputationHalfLife = time.Hour
+
+	// ReputationRankStep is the score difference that makes one peer rank above another
+	ReputationRankStep = 10
+
+	// ReputationBlockDuration is how long a peer whose score falls below the threshold is
+	// blocked for.  These blocks are only kept in memory, the blocklist being for blocks
+	// made by the operator or backed by warrants.
+	ReputationBlockDuration = 24 * time.Hour
+
+	// ReputationTimeoutInterval is how often a peer can be penalized for timing out, as a
+	// peer that is slow or unreachable for a while fails every request we send it and that
+	// says more about the network than about how the peer behaves
+	ReputationTimeoutInterval = time.Minute
+)
+
+var ErrReputationThresholdInvalid = errors.New("ReputationThreshold must be negative")
+
+var reputationWeights = map[ReputationEvent]float64{
+	ReputationValidationFailed: -20,
+	ReputationTimeout:          -2,
+	ReputationInvalidGossip:    -10,
+	ReputationHoldReceipt:      1,
+}
+
+func (e ReputationEvent) String() string {
+	switch e {
+	case ReputationValidationFailed:
+		return "failed validation"
+	case ReputationTimeout:
+		return "timeout"
+	case ReputationInvalidGossip:
+		return "invalid gossip"
+	case ReputationHoldReceipt:
+		return "hold receipt"
+	}
+	return "unknown"
+}
+
+// reputationThreshold returns the configured ReputationThreshold or the default
+func (c *DHTConfig) reputationThreshold() int {
+	if c.ReputationThreshold == 0 {
+		return DefaultReputationThreshold
+	}
+	return c.ReputationThreshold
+}
+
+type peerScore struct {
+	score   float64
+	at      time.Time // when score was last updated
+	timeout time.Time // when the peer was last penalized for timing out
+}
+
+// reputation keeps the reputation scores of the peers we have dealt with
+type reputation struct {
+	lk        sync.Mutex
+	threshold float64
+	scores    map[peer.ID]*peerScore
+
+	// warrants holds when each blocker last sent a block warrant for a peer, by peer
+	warrants map[peer.ID]map[peer.ID]time.Time
+}
+
+func newReputation(threshold int) *reputation {
+	return &reputation{
+		threshold: float64(threshold),
+		scores:    make(map[peer.ID]*peerScore),
+		warrants:  make(map[peer.ID]map[peer.ID]time.Time),
+	}
+}
+
+// decayed returns the score as of now
+func (s *peerScore) decayed(now time.Time) float64 {
+	halves := float64(now.Sub(s.at)) / float64(ReputationHalfLife)
+	return s.score * math.Pow(0.5, halves)
+}
+
+// score returns a peer's current score
+func (r *reputation) score(id peer.ID) float64 {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	s, ok := r.scores[id]
+	if !ok {
+		return 0
+	}
+	return s.decayed(time.Now())
+}
+
+// rank returns a peer's score in steps of ReputationRankStep, so that small
+// differences in score don't change how peers are ordered
+func (r *reputation) rank(id peer.ID) int {
+	return int(r.score(id) / ReputationRankStep)
+}
+
+// forget clears a peer's score
+func (r *reputation) forget(id peer.ID) {
+	r.lk.Lock()
+	delete(r.scores, id)
+	r.lk.Unlock()
+}
+
+// record adjusts a peer's score for an event, and returns the new score and
+// whether it is below the threshold.  Timeouts only count once per
+// ReputationTimeoutInterval.
+func (r *reputation) record(id peer.ID, event ReputationEvent) (score float64, below bool) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	now := time.Now()
+	s, ok := r.scores[id]
+	if !ok {
+		s = &peerScore{}
+		r.scores[id] = s
+	}
+	if event == ReputationTimeout {
+		if now.Sub(s.timeout) < ReputationTimeoutInterval {
+			score = s.decayed(now)
+			return
+		}
+		s.timeout = now
+	}
+	score = s.decayed(now) + reputationWeights[event]
+	score = math.Max(-MaxReputationScore, math.Min(MaxReputationScore, score))
+	s.score = score
+	s.at = now
+	below = score < r.threshold
+	return
+}
+
+// warrant records that blocker sent a block warrant for the peers, and returns the peers
+// that have now had warrants from at least threshold different blockers within the window.
+// Warrants older than the window are dropped.
+func (r *reputation) warrant(blocker peer.ID, peers []peer.ID, window time.Duration, threshold int) (warranted []peer.ID) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	now := time.Now()
+	for id, blockers := range r.warrants {
+		for b, at := range blockers {
+			if now.Sub(at) > window {
+				delete(blockers, b)
+			}
+		}
+		if len(blockers) == 0 {
+			delete(r.warrants, id)
+		}
+	}
+	for _, id := range peers {
+		blockers, ok := r.warrants[id]
+		if !ok {
+			blockers = make(map[peer.ID]time.Time)
+			r.warrants[id] = blockers
+		}
+		blockers[blocker] = now
+		if len(blockers) >= threshold {
+			warranted = append(warranted, id)
+			delete(r.warrants, id)
+		}
+	}
+	return
+}
+
+// ReputationScore returns the reputation score of a peer, which starts at zero
+func (node *Node) ReputationScore(id peer.ID) float64 {
+	return node.reputation.score(id)
+}
+
+// Rate records a peer's behaviour in its reputation score, blocking the peer for
+// ReputationBlockDuration if its score falls below the reputation threshold.  Returns
+// true if the peer was blocked.
+func (node *Node) Rate(id peer.ID, event ReputationEvent) (blocked bool) {
+	if id == node.HashAddr || node.IsBlocked(id) {
+		return
+	}
+	score, below := node.reputation.record(id, event)
+	node.log.Logf("%v reputation %.1f after %v", id, score, event)
+	if below {
+		node.log.Logf("%v reputation below threshold, blocking for %v", id, ReputationBlockDuration)
+		node.BlockFor(id, ReputationBlockDuration)
+		blocked = true
+	}
+	return
+}
+
+// pickReputed picks one of the unblocked peers of the list at random, weighting the
+// choice by reputation score.  Well behaved peers are picked more often, but peers with
+// a poor score still get picked now and then, so their scores can recover.
+func (node *Node) pickReputed(peers []peer.ID) (picked peer.ID, ok bool) {
+	var candidates []peer.ID
+	var weights []float64
+	var total float64
+	for _, p := range peers {
+		if node.IsBlocked(p) {
+			continue
+		}
+		w := math.Max(1, node.reputation.score(p)-node.reputation.threshold+1)
+		candidates = append(candidates, p)
+		weights = append(weights, w)
+		total += w
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	ok = true
+	x := rand.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			picked = candidates[i]
+			return
+		}
+		x -= w
+	}
+	picked = candidates[len(candidates)-1]
+	return
+}
+
+// ratePeer records a peer's behaviour, no longer gossiping with the peer if it gets blocked
+func (dht *DHT) ratePeer(id peer.ID, event ReputationEvent) {
+	if dht.h.node == nil {
+		return
+	}
+	if dht.h.node.Rate(id, event) {
+		dht.DeleteGossiper(id) // ignore error
+	}
+}
@@ -0,0 +1,141 @@
+package holochain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReputationScores(t *testing.T) {
+	r := newReputation(DefaultReputationThreshold)
+	p, _ := makePeer("peer_foo")
+
+	Convey("peers should start with a zero score", t, func() {
+		So(r.score(p), ShouldEqual, 0)
+		So(r.rank(p), ShouldEqual, 0)
+	})
+
+	Convey("events should change the score until it falls below the threshold", t, func() {
+		score, below := r.record(p, ReputationHoldReceipt)
+		So(score, ShouldAlmostEqual, 1, 0.01)
+		So(below, ShouldBeFalse)
+		score, below = r.record(p, ReputationValidationFailed)
+		So(score, ShouldAlmostEqual, -19, 0.01)
+		So(below, ShouldBeFalse)
+		So(r.rank(p), ShouldEqual, -1)
+		r.record(p, ReputationInvalidGossip)
+		r.record(p, ReputationValidationFailed)
+		score, below = r.record(p, ReputationTimeout)
+		So(score, ShouldAlmostEqual, -51, 0.01)
+		So(below, ShouldBeTrue)
+	})
+
+	Convey("timeouts should only be penalized once an interval", t, func() {
+		q, _ := makePeer("peer_slow")
+		score, _ := r.record(q, ReputationTimeout)
+		So(score, ShouldAlmostEqual, -2, 0.01)
+		score, _ = r.record(q, ReputationTimeout)
+		So(score, ShouldAlmostEqual, -2, 0.01)
+		r.scores[q].timeout = time.Now().Add(-ReputationTimeoutInterval)
+		score, _ = r.record(q, ReputationTimeout)
+		So(score, ShouldAlmostEqual, -4, 0.01)
+	})
+
+	Convey("scores should be bounded", t, func() {
+		for i := 0; i < 10; i++ {
+			r.record(p, ReputationValidationFailed)
+		}
+		So(r.score(p), ShouldAlmostEqual, -MaxReputationScore, 0.01)
+	})
+
+	Convey("scores should decay back towards zero", t, func() {
+		r.scores[p].at = time.Now().Add(-ReputationHalfLife)
+		So(r.score(p), ShouldAlmostEqual, -MaxReputationScore/2, 0.01)
+	})
+}
+
+func TestNodeRate(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	node := h.node
+	good, _ := makePeer("peer_good")
+	bad, _ := makePeer("peer_bad")
+	other, _ := makePeer("peer_other")
+
+	Convey("it should not rate ourselves", t, func() {
+		So(node.Rate(node.HashAddr, ReputationValidationFailed), ShouldBeFalse)
+		So(node.ReputationScore(node.HashAddr), ShouldEqual, 0)
+	})
+
+	Convey("it should prefer peers with a better reputation but still pick the others", t, func() {
+		for i := 0; i < ReputationRankStep; i++ {
+			node.Rate(good, ReputationHoldReceipt)
+		}
+		node.Rate(bad, ReputationInvalidGossip)
+		picks := make(map[peer.ID]int)
+		for i := 0; i < 1000; i++ {
+			p, ok := node.pickReputed([]peer.ID{bad, other, good})
+			So(ok, ShouldBeTrue)
+			picks[p]++
+		}
+		So(picks[good], ShouldBeGreaterThan, picks[other])
+		So(picks[other], ShouldBeGreaterThan, picks[bad])
+		So(picks[bad], ShouldBeGreaterThan, 0)
+	})
+
+	Convey("it should block peers that fall below the threshold", t, func() {
+		err := h.dht.AddGossiper(bad)
+		So(err, ShouldBeNil)
+		h.dht.ratePeer(bad, ReputationValidationFailed)
+		h.dht.ratePeer(bad, ReputationValidationFailed)
+		So(node.IsBlocked(bad), ShouldBeFalse)
+		h.dht.ratePeer(bad, ReputationValidationFailed)
+		So(node.IsBlocked(bad), ShouldBeTrue)
+		glist, err := h.dht.getGossipers()
+		So(err, ShouldBeNil)
+		So(len(glist), ShouldEqual, 0)
+		_, ok := node.pickReputed([]peer.ID{bad})
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("reputation blocks should lapse and not be added to the blocklist", t, func() {
+		list, err := h.BlockedPeers()
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 0)
+		node.blockedUntil[bad] = time.Now().Add(-time.Second)
+		So(node.IsBlocked(bad), ShouldBeFalse)
+	})
+
+	Convey("the DNA's reputation threshold should be checked", t, func() {
+		dna := h.nucleus.dna
+		dna.DHTConfig.ReputationThreshold = 10
+		So(dna.check(), ShouldEqual, ErrReputationThresholdInvalid)
+		dna.DHTConfig.ReputationThreshold = 0
+		So(dna.check(), ShouldBeNil)
+	})
+}
+
+type timeoutErr struct{}
+
+func (e timeoutErr) Error() string { return "i/o timeout" }
+func (e timeoutErr) Timeout() bool { return true }
+
+func TestIsPeerTimeout(t *testing.T) {
+	Convey("only dials the peer didn't answer in time should count as timeouts", t, func() {
+		ctx := context.Background()
+		So(isPeerTimeout(ctx, context.DeadlineExceeded), ShouldBeTrue)
+		So(isPeerTimeout(ctx, SendTimeoutErr), ShouldBeTrue)
+		So(isPeerTimeout(ctx, timeoutErr{}), ShouldBeTrue)
+		So(isPeerTimeout(ctx, errors.New("no addresses")), ShouldBeFalse)
+	})
+
+	Convey("dials cut short by the query ending should not count as timeouts", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		So(isPeerTimeout(ctx, context.DeadlineExceeded), ShouldBeFalse)
+	})
+}
@@ -27,7 +27,8 @@ const (
 
 	// ZOME_EXPOSURE is the default and means the function is only exposed for use by other zomes in the app
 	ZOME_EXPOSURE = ""
-	// AUTHENTICATED_EXPOSURE means that the function is only available after authentication (TODO)
+	// AUTHENTICATED_EXPOSURE means that the function is only available after authentication,
+	// i.e. to web server calls that present a valid session token
 	AUTHENTICATED_EXPOSURE = "auth"
 	// PUBLIC_EXPOSURE means that the function is callable by anyone
 	PUBLIC_EXPOSURE = "public"
@@ -0,0 +1,117 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements rotating the agent's key
+
+package holochain
+
+import (
+	"fmt"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+const DefaultRotationPayload = "key rotation"
+
+// RotateAgentKey replaces the agent's key with a newly generated one.  The new key is
+// staged on disk first so it can't be lost, then a new agent entry carrying a
+// SelfRevocation of the old key is committed, the new key is put to the DHT, the node is
+// re-keyed and the revocation warrant is published so that peers block the old node ID.
+// Under the agent store encryption the store key is resealed for the new key.  Only then
+// is the old key archived in the chain's directory, where it can't be loaded as the
+// agent's key again, and the new key put in its place.
+func (h *Holochain) RotateAgentKey(payload string) (err error) {
+	if payload == "" {
+		payload = DefaultRotationPayload
+	}
+	oldAgent := h.agent
+	var newAgent Agent
+	newAgent, err = copyAgent(oldAgent)
+	if err != nil {
+		return
+	}
+	err = newAgent.GenKeys(nil)
+	if err != nil {
+		return
+	}
+	err = stageAgentKey(h.rootPath, newAgent)
+	if err != nil {
+		return
+	}
+	fn := &APIFnModAgent{Revocation: payload, agent: newAgent}
+	_, err = fn.Call(h)
+	if err != nil {
+		return
+	}
+	err = h.resealStoreKey(oldAgent)
+	if err != nil {
+		return
+	}
+	err = archiveAgentKey(h.rootPath, oldAgent)
+	if err != nil {
+		return
+	}
+	err = installStagedAgentKey(h.rootPath)
+	return
+}
+
+// copyAgent returns a copy of the agent that can be changed without changing it
+func copyAgent(agent Agent) (newAgent Agent, err error) {
+	switch a := agent.(type) {
+	case *LibP2PAgent:
+		na := *a
+		newAgent = &na
+	case *SignerAgent:
+		na := *a
+		newAgent = &na
+	default:
+		err = fmt.Errorf("unknown agent type: %d", agent.AgentType())
+	}
+	return
+}
+
+// rekeyNode replaces the network node with one for the agent's current key.  The new
+// node takes over the old node's protocols, background tasks, blocklist and peers.  The
+// new node is made before the old one is closed so that if that fails the old one keeps
+// running.
+func (h *Holochain) rekeyNode() (err error) {
+	old := h.node
+	var node *Node
+	node, err = h.newNode()
+	if err != nil {
+		return
+	}
+	var peers []pstore.PeerInfo
+	for _, id := range old.routingTable.ListPeers() {
+		peers = append(peers, old.peerstore.PeerInfo(id))
+	}
+	// the tickers run tasks on whatever node h has, so hand them over rather than stop them
+	stoppers := old.stoppers
+	old.stoppers = nil
+	// TODO currently ignoring the error from node.Close() is this OK?
+	old.Close()
+
+	h.node = node
+	h.node.stoppers = stoppers
+	h.node.reputation = old.reputation
+	old.blk.RLock()
+	for id := range old.blockedlist {
+		h.node.Block(id)
+	}
+	old.blk.RUnlock()
+
+	for proto, started := range old.started {
+		if started {
+			if err = h.node.StartProtocol(h, proto); err != nil {
+				return
+			}
+		}
+	}
+	for _, pi := range peers {
+		if e := h.AddPeer(pi); e != nil {
+			h.dht.dlog.Logf("error when re-adding peer: %v, %v", pi, e)
+		}
+	}
+	return
+}
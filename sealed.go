@@ -0,0 +1,541 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements sealing partially shared entries so that only the recipients named when
+// they were committed can read them from the DHT
+
+package holochain
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	// SealedDir is the directory in the chain's data directory that holds the sealed
+	// copies of the chain's partially shared entries
+	SealedDir string = "sealed"
+
+	sealedKeyInfo = "holochain sealed entry"
+
+	ValidationFailurePartialNotSealed = "partial entry not sealed"
+	ValidationFailureBadSealedEntry   = "bad sealed entry"
+)
+
+var ErrNotSealedRecipient = errors.New("not a recipient of the sealed entry")
+var ErrSealedEntryHashMismatch = errors.New("sealed entry doesn't match its hash")
+var ErrNotEd25519Key = errors.New("not an ed25519 key")
+
+// SealedKey is the content key of a sealed entry sealed for one recipient
+type SealedKey struct {
+	Recipient string // the recipient's node ID
+	Key       []byte
+}
+
+// SealedEntry is the form a partially shared entry is published to the DHT in.  The entry
+// is sealed with a random content key, which is sealed for each recipient with a key
+// agreed between an ephemeral X25519 key and the recipient's agent key.
+type SealedEntry struct {
+	Ephemeral []byte
+	Keys      []SealedKey
+	Entry     []byte
+}
+
+// CommitOptions are the options for committing an entry
+type CommitOptions struct {
+	Recipients []string // public keys or node IDs of the agents a partial entry is shared with
+}
+
+// isSealedEntry returns true if the entry holds a SealedEntry
+func isSealedEntry(entry Entry) bool {
+	if entry == nil {
+		return false
+	}
+	_, ok := entry.Content().(SealedEntry)
+	return ok
+}
+
+// rawEd25519Key returns the raw key bytes from a marshaled libp2p ed25519 key, which is
+// a protobuf holding the key type (1 for ed25519) and the key data
+func rawEd25519Key(marshaled []byte, size int) (raw []byte, err error) {
+	prefix := []byte{0x08, 0x01, 0x12, byte(size)}
+	if len(marshaled) != len(prefix)+size || !bytes.HasPrefix(marshaled, prefix) {
+		err = ErrNotEd25519Key
+		return
+	}
+	raw = marshaled[len(prefix):]
+	return
+}
+
+var curve25519P, _ = new(big.Int).SetString("57896044618658097711785492504343953926634992332820282019728792003956564819949", 10)
+
+// x25519PublicKey converts an ed25519 public key to the X25519 public key of the same
+// key pair, using the birational map u = (1+y)/(1-y) from the edwards to the
+// montgomery form of the curve
+func x25519PublicKey(pub ic.PubKey) (point [32]byte, err error) {
+	var b, raw []byte
+	b, err = pub.Bytes()
+	if err != nil {
+		return
+	}
+	raw, err = rawEd25519Key(b, 32)
+	if err != nil {
+		return
+	}
+	// y is little-endian with the x sign in the top bit
+	le := make([]byte, 32)
+	for i := range raw {
+		le[31-i] = raw[i]
+	}
+	le[0] &= 0x7f
+	y := new(big.Int).SetBytes(le)
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		err = ErrNotEd25519Key
+		return
+	}
+	den.ModInverse(den, curve25519P)
+	u := num.Mul(num, den)
+	u.Mod(u, curve25519P)
+	ub := u.Bytes()
+	for i := range ub {
+		point[i] = ub[len(ub)-1-i]
+	}
+	return
+}
+
+// ed25519Seed returns the seed of a marshaled ed25519 private key, which is its first 32
+// bytes whether the key was marshaled with just its 64 bytes or, as some versions of the
+// crypto library do, followed by a redundant copy of the public key
+func ed25519Seed(marshaled []byte) (seed []byte, err error) {
+	var raw []byte
+	raw, err = rawEd25519Key(marshaled, 64)
+	if err == ErrNotEd25519Key {
+		raw, err = rawEd25519Key(marshaled, 96)
+	}
+	if err != nil {
+		return
+	}
+	seed = raw[:32]
+	return
+}
+
+// x25519Scalar converts an ed25519 private key to the X25519 private key of the same
+// key pair, which is the clamped first half of the SHA-512 of the key's seed
+func x25519Scalar(priv ic.PrivKey) (scalar [32]byte, err error) {
+	var b, seed []byte
+	b, err = priv.Bytes()
+	if err != nil {
+		return
+	}
+	seed, err = ed25519Seed(b)
+	if err != nil {
+		return
+	}
+	digest := sha512.Sum512(seed)
+	copy(scalar[:], digest[:32])
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+	return
+}
+
+// x25519Shared returns the secret a private key shares with an X25519 public key
+func x25519Shared(priv ic.PrivKey, point [32]byte) (shared [32]byte, err error) {
+	var scalar [32]byte
+	scalar, err = x25519Scalar(priv)
+	if err != nil {
+		return
+	}
+	curve25519.ScalarMult(&shared, &scalar, &point)
+	return
+}
+
+// agentShared returns the secret the agent's key shares with an X25519 public key,
+// asking the signer for it if the agent's key is held by a signer
+func agentShared(agent Agent, point [32]byte) (shared [32]byte, err error) {
+	if a, ok := agent.(*SignerAgent); ok {
+		var resp SignerResponse
+		resp, err = a.call(SignerRequest{Method: SignerSharedMethod, Data: point[:]})
+		if err != nil {
+			return
+		}
+		if len(resp.Secret) != len(shared) {
+			err = errors.New("signer: bad shared secret")
+			return
+		}
+		copy(shared[:], resp.Secret)
+		return
+	}
+	shared, err = x25519Shared(agent.PrivKey(), point)
+	return
+}
+
+// sealedKeyCipher returns the cipher that seals the content key for a recipient
+func sealedKeyCipher(shared [32]byte, ephemeral []byte, recipient [32]byte) (c *storeCipher, err error) {
+	mac := hmac.New(sha256.New, shared[:])
+	mac.Write([]byte(sealedKeyInfo))
+	mac.Write(ephemeral)
+	mac.Write(recipient[:])
+	c, err = newStoreCipher(mac.Sum(nil))
+	return
+}
+
+// sealEntry seals an entry so that only the given recipients can open it
+func sealEntry(entry Entry, recipients []ic.PubKey) (sealed SealedEntry, err error) {
+	var m []byte
+	m, err = entry.Marshal()
+	if err != nil {
+		return
+	}
+	contentKey := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, contentKey); err != nil {
+		return
+	}
+	var c *storeCipher
+	c, err = newStoreCipher(contentKey)
+	if err != nil {
+		return
+	}
+	sealed.Entry, err = c.seal(m)
+	if err != nil {
+		return
+	}
+
+	var ephemeral, ephemeralPub [32]byte
+	if _, err = io.ReadFull(rand.Reader, ephemeral[:]); err != nil {
+		return
+	}
+	curve25519.ScalarBaseMult(&ephemeralPub, &ephemeral)
+	sealed.Ephemeral = ephemeralPub[:]
+
+	seen := make(map[peer.ID]bool)
+	for _, pub := range recipients {
+		var id peer.ID
+		id, err = peer.IDFromPublicKey(pub)
+		if err != nil {
+			return
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		var point, shared [32]byte
+		point, err = x25519PublicKey(pub)
+		if err != nil {
+			return
+		}
+		curve25519.ScalarMult(&shared, &ephemeral, &point)
+		var kc *storeCipher
+		kc, err = sealedKeyCipher(shared, sealed.Ephemeral, point)
+		if err != nil {
+			return
+		}
+		var key []byte
+		key, err = kc.seal(contentKey)
+		if err != nil {
+			return
+		}
+		sealed.Keys = append(sealed.Keys, SealedKey{Recipient: peer.IDB58Encode(id), Key: key})
+	}
+	return
+}
+
+// openSealedEntry opens a sealed entry with the agent's key, checking that the entry
+// matches the hash it was published under
+func openSealedEntry(agent Agent, hashSpec HashSpec, hash Hash, sealed SealedEntry) (entry *GobEntry, err error) {
+	var nodeIDStr string
+	_, nodeIDStr, err = agent.NodeID()
+	if err != nil {
+		return
+	}
+	var sealedKey []byte
+	for _, k := range sealed.Keys {
+		if k.Recipient == nodeIDStr {
+			sealedKey = k.Key
+			break
+		}
+	}
+	if sealedKey == nil {
+		err = ErrNotSealedRecipient
+		return
+	}
+	var ephemeral, point, shared [32]byte
+	if len(sealed.Ephemeral) != len(ephemeral) {
+		err = ErrNotSealedRecipient
+		return
+	}
+	copy(ephemeral[:], sealed.Ephemeral)
+	shared, err = agentShared(agent, ephemeral)
+	if err != nil {
+		return
+	}
+	point, err = x25519PublicKey(agent.PubKey())
+	if err != nil {
+		return
+	}
+	var kc *storeCipher
+	kc, err = sealedKeyCipher(shared, sealed.Ephemeral, point)
+	if err != nil {
+		return
+	}
+	var contentKey []byte
+	contentKey, err = kc.open(sealedKey)
+	if err != nil {
+		return
+	}
+	var c *storeCipher
+	c, err = newStoreCipher(contentKey)
+	if err != nil {
+		return
+	}
+	var m []byte
+	m, err = c.open(sealed.Entry)
+	if err != nil {
+		return
+	}
+	var e GobEntry
+	err = e.Unmarshal(m)
+	if err != nil {
+		return
+	}
+	var sum Hash
+	sum, err = e.Sum(hashSpec)
+	if err != nil {
+		return
+	}
+	if !sum.Equal(hash) {
+		err = ErrSealedEntryHashMismatch
+		return
+	}
+	entry = &e
+	return
+}
+
+// recipientPubKey returns the public key of a recipient named by its public key or by
+// its node ID, in which case the key is looked up in the DHT
+func (h *Holochain) recipientPubKey(recipient string) (pub ic.PubKey, err error) {
+	if isValidPubKey(recipient) {
+		pub, err = DecodePubKey(recipient)
+		return
+	}
+	var id peer.ID
+	id, err = peer.IDB58Decode(recipient)
+	if err != nil {
+		err = fmt.Errorf("recipient %s isn't a public key or node ID", recipient)
+		return
+	}
+	if id == h.nodeID {
+		pub = h.agent.PubKey()
+		return
+	}
+	var hash Hash
+	hash, err = NewHash(recipient)
+	if err != nil {
+		return
+	}
+	var r interface{}
+	r, err = callGet(h, GetReq{H: hash, StatusMask: StatusLive, GetMask: GetMaskEntry}, &GetOptions{StatusMask: StatusLive, GetMask: GetMaskEntry})
+	if err != nil {
+		err = fmt.Errorf("unable to get key of recipient %s: %v", recipient, err)
+		return
+	}
+	b58pk, ok := r.(GetResp).Entry.C.(string)
+	if !ok {
+		err = fmt.Errorf("bad key for recipient %s", recipient)
+		return
+	}
+	pub, err = DecodePubKey(b58pk)
+	if err != nil {
+		return
+	}
+	if !id.MatchesPublicKey(pub) {
+		err = fmt.Errorf("key for recipient %s doesn't match its node ID", recipient)
+	}
+	return
+}
+
+// sealedPath returns the file a sealed copy of one of the chain's entries is kept in
+func (h *Holochain) sealedPath(hash Hash) string {
+	return filepath.Join(h.DBPath(), SealedDir, hash.String())
+}
+
+// sealingAction is implemented by the committing actions that can commit partially shared
+// entries, to seal them for their recipients
+type sealingAction interface {
+	seal(h *Holochain) (err error)
+}
+
+// sealPartialEntry seals a partially shared entry for the recipients and the agent and
+// keeps the sealed copy to answer the DHT's validation requests with
+func (h *Holochain) sealPartialEntry(hash Hash, entry Entry, recipients []string) (err error) {
+	keys := []ic.PubKey{h.agent.PubKey()}
+	for _, r := range recipients {
+		var pub ic.PubKey
+		pub, err = h.recipientPubKey(r)
+		if err != nil {
+			return
+		}
+		keys = append(keys, pub)
+	}
+	var sealed SealedEntry
+	sealed, err = sealEntry(entry, keys)
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = ByteEncoder(&sealed)
+	if err != nil {
+		return
+	}
+	dir := filepath.Join(h.DBPath(), SealedDir)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return
+	}
+	p := h.sealedPath(hash)
+	if FileExists(p) {
+		// the same entry committed again replaces its sealed copy
+		if err = os.Remove(p); err != nil {
+			return
+		}
+	}
+	err = WriteFile(b, p)
+	return
+}
+
+// getSealedEntry returns the sealed copy of one of the chain's partially shared entries
+func (h *Holochain) getSealedEntry(hash Hash) (sealed SealedEntry, err error) {
+	var b []byte
+	b, err = ReadFile(h.sealedPath(hash))
+	if err != nil {
+		return
+	}
+	err = ByteDecoder(b, &sealed)
+	return
+}
+
+// sealedRecipients returns the node IDs of the other recipients of one of the chain's
+// sealed entries, so that updates to it can be sealed for the same recipients
+func (h *Holochain) sealedRecipients(hash Hash) (recipients []string, err error) {
+	if !FileExists(h.sealedPath(hash)) {
+		// partially shared entries committed before sharing them was supported were
+		// never sealed
+		return
+	}
+	var sealed SealedEntry
+	sealed, err = h.getSealedEntry(hash)
+	if err != nil {
+		return
+	}
+	for _, k := range sealed.Keys {
+		if k.Recipient != h.nodeIDStr {
+			recipients = append(recipients, k.Recipient)
+		}
+	}
+	return
+}
+
+// openGetResp replaces a sealed entry in a get response with the entry if we are one of
+// its recipients, or with the sealed entry's JSON if we aren't
+func (h *Holochain) openGetResp(hash Hash, resp *GetResp) (err error) {
+	sealed, ok := resp.Entry.C.(SealedEntry)
+	if !ok {
+		return
+	}
+	var entry *GobEntry
+	entry, err = openSealedEntry(h.agent, h.hashSpec, hash, sealed)
+	if err == nil {
+		resp.Entry = *entry
+		return
+	}
+	if err != ErrNotSealedRecipient {
+		return
+	}
+	var j []byte
+	j, err = json.Marshal(sealed)
+	if err != nil {
+		return
+	}
+	resp.Entry = GobEntry{C: string(j)}
+	return
+}
+
+// sysValidateSealed does the system level validation of a sealed entry that a DHT node
+// can do without being able to open it, which is that it's of a partially shared type,
+// is sealed for at least one recipient, isn't too large and that its header is for the
+// entry type and hash being put and was signed by the source.  It returns true if the
+// entry is sealed.
+func sysValidateSealed(h *Holochain, def *EntryDef, entryType string, hash Hash, entry Entry, header *Header, sources []peer.ID) (sealed bool, err error) {
+	if !isSealedEntry(entry) {
+		return
+	}
+	sealed = true
+	s := entry.Content().(SealedEntry)
+	if !def.isSharingPartial() || len(s.Ephemeral) != 32 || len(s.Keys) == 0 || len(s.Entry) == 0 {
+		err = ValidationFailed(ValidationFailureBadSealedEntry)
+		return
+	}
+	if header == nil || len(sources) == 0 {
+		err = ValidationFailed(ValidationFailureBadSealedEntry)
+		return
+	}
+	// the header is all that ties the sealed entry to what's being put
+	if header.Type != entryType || !header.EntryLink.Equal(hash) {
+		err = ValidationFailed(ValidationFailureBadSealedEntry)
+		return
+	}
+	var b []byte
+	b, err = entry.Marshal()
+	if err != nil {
+		return
+	}
+	if len(b) > h.nucleus.dna.DHTConfig.maxEntrySize() {
+		err = ValidationFailed(ValidationFailureEntryTooLarge)
+		return
+	}
+	var pub ic.PubKey
+	pub, err = h.getSourcePubKey(sources[0])
+	if err != nil {
+		return
+	}
+	var matches bool
+	matches, err = header.Verify(pub)
+	if err != nil {
+		return
+	}
+	if !matches {
+		err = ValidationFailed(ValidationFailureBadHeaderSignature)
+	}
+	return
+}
+
+// isSealedAction returns true if the action puts or modifies with a sealed entry, which
+// can't be validated by the app
+func isSealedAction(a ValidatingAction) bool {
+	switch t := a.(type) {
+	case *ActionPut:
+		return isSealedEntry(t.entry)
+	case *ActionMod:
+		return isSealedEntry(t.entry)
+	}
+	return false
+}
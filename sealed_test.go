@@ -0,0 +1,219 @@
+package holochain
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestX25519Keys(t *testing.T) {
+	_, priv := makePeer("peer_foo")
+	Convey("the converted public key should match the converted private key", t, func() {
+		point, err := x25519PublicKey(priv.GetPublic())
+		So(err, ShouldBeNil)
+		scalar, err := x25519Scalar(priv)
+		So(err, ShouldBeNil)
+		var expected [32]byte
+		curve25519.ScalarBaseMult(&expected, &scalar)
+		So(point, ShouldResemble, expected)
+	})
+
+	Convey("it should take the seed from private keys marshaled with or without the public key", t, func() {
+		b, _ := priv.Bytes()
+		seed, err := ed25519Seed(b)
+		So(err, ShouldBeNil)
+		pub, _ := priv.GetPublic().Bytes()
+		raw, _ := rawEd25519Key(b, 64)
+		long := append([]byte{0x08, 0x01, 0x12, 96}, raw...)
+		long = append(long, pub[4:]...)
+		seed2, err := ed25519Seed(long)
+		So(err, ShouldBeNil)
+		So(seed2, ShouldResemble, seed)
+		_, err = ed25519Seed(b[:len(b)-1])
+		So(err, ShouldEqual, ErrNotEd25519Key)
+	})
+
+	Convey("both sides should agree on the shared secret", t, func() {
+		_, other := makePeer("peer_bar")
+		p1, _ := x25519PublicKey(priv.GetPublic())
+		p2, _ := x25519PublicKey(other.GetPublic())
+		s1, err := x25519Shared(priv, p2)
+		So(err, ShouldBeNil)
+		s2, err := x25519Shared(other, p1)
+		So(err, ShouldBeNil)
+		So(s1, ShouldResemble, s2)
+	})
+}
+
+func TestSealEntry(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	a1, _ := NewAgent(LibP2P, "zippy@someemail.com", MakeTestSeed("zippy"))
+	a2, _ := NewAgent(LibP2P, "bob@someemail.com", MakeTestSeed("bob"))
+	a3, _ := NewAgent(LibP2P, "eve@someemail.com", MakeTestSeed("eve"))
+	hashSpec, _, _ := chainTestSetup()
+	entry := GobEntry{C: "some secret"}
+	hash, _ := entry.Sum(hashSpec)
+
+	sealed, err := sealEntry(&entry, []ic.PubKey{a1.PubKey(), a2.PubKey(), a1.PubKey()})
+	Convey("it should seal an entry once for each recipient", t, func() {
+		So(err, ShouldBeNil)
+		So(len(sealed.Keys), ShouldEqual, 2)
+		So(len(sealed.Ephemeral), ShouldEqual, 32)
+		So(string(sealed.Entry), ShouldNotContainSubstring, "some secret")
+	})
+
+	Convey("recipients should be able to open it", t, func() {
+		e, err := openSealedEntry(a1, hashSpec, hash, sealed)
+		So(err, ShouldBeNil)
+		So(e.C, ShouldEqual, "some secret")
+		e, err = openSealedEntry(a2, hashSpec, hash, sealed)
+		So(err, ShouldBeNil)
+		So(e.C, ShouldEqual, "some secret")
+	})
+
+	Convey("recipients should be able to open it after saving and loading their key", t, func() {
+		path := filepath.Join(d, "agent")
+		So(os.MkdirAll(path, 0700), ShouldBeNil)
+		So(SaveAgent(path, a2), ShouldBeNil)
+		loaded, err := LoadAgent(path)
+		So(err, ShouldBeNil)
+		s1, _ := x25519Scalar(a2.PrivKey())
+		s2, err := x25519Scalar(loaded.PrivKey())
+		So(err, ShouldBeNil)
+		So(s2, ShouldResemble, s1)
+		e, err := openSealedEntry(loaded, hashSpec, hash, sealed)
+		So(err, ShouldBeNil)
+		So(e.C, ShouldEqual, "some secret")
+	})
+
+	Convey("others should not be able to open it", t, func() {
+		_, err := openSealedEntry(a3, hashSpec, hash, sealed)
+		So(err, ShouldEqual, ErrNotSealedRecipient)
+	})
+
+	Convey("it should not open an entry under the wrong hash", t, func() {
+		other, _ := (&GobEntry{C: "something else"}).Sum(hashSpec)
+		_, err := openSealedEntry(a1, hashSpec, other, sealed)
+		So(err, ShouldEqual, ErrSealedEntryHashMismatch)
+	})
+
+	Convey("it should open entries with a signer agent", t, func() {
+		socket := filepath.Join(d, DefaultSignerSocket)
+		s, err := NewSigner(a2.PrivKey(), socket, nil)
+		So(err, ShouldBeNil)
+		go s.Serve()
+		defer s.Close()
+		sa, err := NewSignerAgent(a2.Identity(), socket)
+		So(err, ShouldBeNil)
+		e, err := openSealedEntry(sa, hashSpec, hash, sealed)
+		So(err, ShouldBeNil)
+		So(e.C, ShouldEqual, "some secret")
+	})
+}
+
+// changeEntryDef changes the definition of an entry type in the nodes' DNA
+func changeEntryDef(nodes []*Holochain, entryType string, change func(def *EntryDef)) {
+	for _, h := range nodes {
+		for i := range h.nucleus.dna.Zomes {
+			for j := range h.nucleus.dna.Zomes[i].Entries {
+				if h.nucleus.dna.Zomes[i].Entries[j].Name == entryType {
+					change(&h.nucleus.dna.Zomes[i].Entries[j])
+				}
+			}
+		}
+	}
+}
+
+func TestPartialSharing(t *testing.T) {
+	nodesCount := 3
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	h2 := nodes[2]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+	changeEntryDef(nodes, "profile", func(def *EntryDef) { def.Sharing = Partial })
+
+	pk1, _ := h1.agent.EncodePubKey()
+	a := NewCommitAction("profile", &GobEntry{C: `{"firstName":"Zippy","lastName":"Pinhead"}`})
+	a.SetRecipients([]string{pk1})
+	response, err := h0.commitAndShare(a, NullHash())
+	if err != nil {
+		panic(err)
+	}
+	hash := response.(Hash)
+	for _, h := range nodes {
+		processChangeRequestsInTesting(h)
+	}
+
+	Convey("the author should keep a sealed copy of the entry", t, func() {
+		sealed, err := h0.getSealedEntry(hash)
+		So(err, ShouldBeNil)
+		So(len(sealed.Keys), ShouldEqual, 2)
+	})
+
+	Convey("recipients should get the entry", t, func() {
+		req := GetReq{H: hash, GetMask: GetMaskEntry}
+		r, err := callGet(h1, req, &GetOptions{GetMask: req.GetMask})
+		So(err, ShouldBeNil)
+		So(r.(GetResp).Entry.C, ShouldEqual, `{"firstName":"Zippy","lastName":"Pinhead"}`)
+	})
+
+	Convey("others should only get the sealed entry", t, func() {
+		req := GetReq{H: hash, GetMask: GetMaskEntry}
+		r, err := callGet(h2, req, &GetOptions{GetMask: req.GetMask})
+		So(err, ShouldBeNil)
+		j := r.(GetResp).Entry.C.(string)
+		So(j, ShouldNotContainSubstring, "Zippy")
+		var sealed SealedEntry
+		So(json.Unmarshal([]byte(j), &sealed), ShouldBeNil)
+		So(len(sealed.Keys), ShouldEqual, 2)
+	})
+
+	Convey("a commit whose recipients can't be resolved should not be added", t, func() {
+		count := len(h0.chain.Headers)
+		a := NewCommitAction("profile", &GobEntry{C: `{"firstName":"Pinhead","lastName":"Zippy"}`})
+		a.SetRecipients([]string{"not a recipient"})
+		_, err := h0.commitAndShare(a, NullHash())
+		So(err.Error(), ShouldEqual, "recipient not a recipient isn't a public key or node ID")
+		So(len(h0.chain.Headers), ShouldEqual, count)
+	})
+
+	Convey("puts of unsealed partial entries should be rejected", t, func() {
+		_, def, _ := h1.GetEntryDef("profile")
+		hd := h0.chain.Headers[len(h0.chain.Headers)-1]
+		put := NewPutAction("profile", &GobEntry{C: `{"firstName":"Zippy","lastName":"Pinhead"}`}, hd)
+		err := put.SysValidation(h1, def, nil, []peer.ID{h0.nodeID})
+		So(err.Error(), ShouldEqual, ValidationFailed(ValidationFailurePartialNotSealed).Error())
+	})
+
+	Convey("sealed puts should only validate for the hash and type of their header", t, func() {
+		_, def, _ := h1.GetEntryDef("profile")
+		hd := h0.chain.Headers[len(h0.chain.Headers)-1]
+		sealed, err := h0.getSealedEntry(hash)
+		So(err, ShouldBeNil)
+		put := NewPutAction("profile", &GobEntry{C: sealed}, hd)
+		put.hash = hash
+		So(put.SysValidation(h1, def, nil, []peer.ID{h0.nodeID}), ShouldBeNil)
+
+		other, _ := (&GobEntry{C: "something else"}).Sum(h1.hashSpec)
+		put.hash = other
+		err = put.SysValidation(h1, def, nil, []peer.ID{h0.nodeID})
+		So(err.Error(), ShouldEqual, ValidationFailed(ValidationFailureBadSealedEntry).Error())
+
+		put = NewPutAction("profile", &GobEntry{C: sealed}, hd)
+		put.hash = hash
+		put.entryType = "rating"
+		err = put.SysValidation(h1, def, nil, []peer.ID{h0.nodeID})
+		So(err.Error(), ShouldEqual, ValidationFailed(ValidationFailureBadSealedEntry).Error())
+	})
+}
@@ -41,7 +41,14 @@ const (
 	StoreFileName        string = "chain.db"    // Filename for local data store
 	DNAHashFileName      string = "dna.hash"    // Filename for storing the hash of the holochain
 	DHTStoreFileName     string = "dht.db"      // Filname for storing the dht
+	DHTBoltStoreFileName string = "dht.bolt"    // Filname for storing the dht when using the bolt store
 	BridgeDBFileName     string = "bridge.db"   // Filname for storing bridge keys
+	DHTQueueFileName     string = "queue.db"    // Filename for storing the DHT's pending work queues
+	StoreSaltFileName    string = "store.salt"  // Filename for storing the salt of the store encryption key
+	StoreKeyFileName     string = "store.key"   // Filename for storing the store encryption key sealed with the agent's key
+	StoreCheckFileName   string = "store.check" // Filename for storing the value that checks the store encryption key
+	StoreIndexFileName   string = "chain.idx"   // Filename for storing the secondary indexes of the local data store
+	RevokedKeysDir       string = "revoked"     // Sub-directory for archiving the agent keys that have been rotated out
 
 	TestConfigFileName string = "_config.json"
 
@@ -74,6 +81,7 @@ type ServiceConfig struct {
 	DefaultBootstrapServer string
 	DefaultEnableMDNS      bool
 	DefaultEnableNATUPnP   bool
+	DefaultAgentName       string // agent newly joined chains are bound to, empty for the service's agent
 }
 
 // A Service is a Holochain service data structure
@@ -89,6 +97,8 @@ type EntryDefFile struct {
 	Schema     string
 	SchemaFile string // file name of schema or language schema directive
 	Sharing    string
+	Indexes    []string // JSON field paths to maintain secondary indexes on for Query
+	Migration  string   // zome function transforming entries of this type from the BasedOn DNA
 }
 
 type ZomeFile struct {
@@ -161,6 +171,17 @@ func IsInitialized(root string) bool {
 // and writes them out to configuration files in the root path (making the
 // directory if necessary)
 func Init(root string, identity AgentIdentity, seed io.Reader) (service *Service, err error) {
+	a, err := NewAgent(LibP2P, identity, seed)
+	if err != nil {
+		return
+	}
+	service, err = InitWithAgent(root, a)
+	return
+}
+
+// InitWithAgent initializes service defaults like Init but with the given agent as the
+// default agent, e.g. a SignerAgent whose key is held by a signer process
+func InitWithAgent(root string, a Agent) (service *Service, err error) {
 	//TODO this is in the wrong place it should be where HeadersEntryDef gets initialized
 	if HeadersEntryDef.validator == nil {
 		err = HeadersEntryDef.BuildJSONSchemaValidatorFromString(HeadersEntryDef.Schema)
@@ -215,10 +236,6 @@ func Init(root string, identity AgentIdentity, seed io.Reader) (service *Service
 		return
 	}
 
-	a, err := NewAgent(LibP2P, identity, seed)
-	if err != nil {
-		return
-	}
 	err = SaveAgent(root, a)
 	if err != nil {
 		return
@@ -250,6 +267,13 @@ func LoadService(path string) (service *Service, err error) {
 		return
 	}
 
+	if s.Settings.DefaultAgentName != "" {
+		s.DefaultAgent, err = s.LoadAgent(s.Settings.DefaultAgentName)
+		if err != nil {
+			return
+		}
+	}
+
 	service = &s
 	return
 }
@@ -416,6 +440,8 @@ func (s *Service) loadDNA(path string, filename string, format string) (dnaP *DN
 			dna.Zomes[i].Entries[j].DataFormat = entry.DataFormat
 			dna.Zomes[i].Entries[j].Sharing = entry.Sharing
 			dna.Zomes[i].Entries[j].Schema = entry.Schema
+			dna.Zomes[i].Entries[j].Indexes = entry.Indexes
+			dna.Zomes[i].Entries[j].Migration = entry.Migration
 			if entry.Schema == "" && entry.SchemaFile != "" {
 				schemaFilePath := filepath.Join(zomePath, entry.SchemaFile)
 				if !FileExists(schemaFilePath) {
@@ -471,10 +497,13 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 	h.nucleus = NewNucleus(&h, dna)
 
 	// try and get the holochain-specific agent info
-	agent, err := LoadAgent(root)
-	if err != nil {
-		// if not specified for this app, get the default from the Agent.txt file for all apps
-		agent, err = LoadAgent(filepath.Dir(root))
+	var agent Agent
+	if FileExists(root, PrivKeyFileName) || FileExists(root, SignerFileName) {
+		agent, err = LoadAgent(root)
+	} else {
+		// if not specified for this app, get the agent the app is bound to from the
+		// service's agents, which is the service's own agent if it's not bound
+		agent, err = s.LoadAgent(h.Config.Agent)
 	}
 
 	// TODO verify Agent identity against schema
@@ -493,7 +522,17 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName))
+	h.storeCipher, err = h.openStoreCipher()
+	if err != nil {
+		return
+	}
+
+	h.chain, err = newChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName), h.storeCipher)
+	if err != nil {
+		return
+	}
+	h.chain.encoding = dna.DHTConfig.Encoding
+	err = h.chain.OpenIndexes(dna.indexedFields(), filepath.Join(h.DBPath(), StoreIndexFileName))
 	if err != nil {
 		return
 	}
@@ -577,6 +616,8 @@ func _makeConfig(s *Service) (config Config, err error) {
 		BootstrapServer: s.Settings.DefaultBootstrapServer,
 		EnableNATUPnP:   s.Settings.DefaultEnableNATUPnP,
 		EnableMDNS:      s.Settings.DefaultEnableMDNS,
+		DHTStore:        DefaultHashTableType,
+		Agent:           s.Settings.DefaultAgentName,
 		Loggers: Loggers{
 			Debug:      Logger{Name: "Debug", Format: "HC: %{file}.%{line}: %{message}", Enabled: false},
 			App:        Logger{Name: "App", Format: "%{color:cyan}%{message}", Enabled: false},
@@ -620,6 +661,12 @@ func _makeConfig(s *Service) (config Config, err error) {
 		Debugf("makeConfig: using environment variable to set enableNATUPnP to: %s", val)
 		config.EnableNATUPnP = val == "true"
 	}
+
+	val = os.Getenv("HOLOCHAINCONFIG_DHTSTORE")
+	if val != "" {
+		Debugf("makeConfig: using environment variable to set DHTStore to: %s", val)
+		config.DHTStore = val
+	}
 	return
 }
 
@@ -952,6 +999,8 @@ func (s *Service) saveDNAFile(root string, dna *DNA, encodingFormat string, over
 				Name:       e.Name,
 				DataFormat: e.DataFormat,
 				Sharing:    e.Sharing,
+				Indexes:    e.Indexes,
+				Migration:  e.Migration,
 			}
 			if e.DataFormat == DataFormatJSON && e.Schema != "" {
 				entryDefFile.SchemaFile = e.Name + ".json"
@@ -0,0 +1,72 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements session tokens for calling functions with AUTHENTICATED_EXPOSURE
+
+package holochain
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// SessionCapability is the capability that session tokens are registered as
+	SessionCapability = "session"
+
+	DefaultSessionTTL = time.Hour
+	MaxSessionTTL     = 24 * time.Hour
+)
+
+var ErrSessionInvalid = errors.New("invalid or expired session")
+
+// NewSession issues a token for an authenticated session which expires after ttl.
+// A zero ttl means DefaultSessionTTL, and ttls are capped at MaxSessionTTL.
+// Sessions are stored as capabilities along with the bridge capabilities.
+func (h *Holochain) NewSession(ttl time.Duration) (token string, expires time.Time, err error) {
+	err = h.initBridgeDB()
+	if err != nil {
+		return
+	}
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	} else if ttl > MaxSessionTTL {
+		ttl = MaxSessionTTL
+	}
+	var c *Capability
+	c, err = NewExpiringCapability(h.bridgeDB, SessionCapability, nil, ttl)
+	if err != nil {
+		return
+	}
+	token = c.Token
+	expires = time.Now().Add(ttl)
+	return
+}
+
+// ValidateSession returns ErrSessionInvalid if the token isn't for a current session
+func (h *Holochain) ValidateSession(token string) (err error) {
+	if h.bridgeDB == nil || token == "" {
+		err = ErrSessionInvalid
+		return
+	}
+	c := Capability{Token: token, db: h.bridgeDB}
+	capability, err := c.Validate(nil)
+	if err == CapabilityInvalidErr || (err == nil && capability != SessionCapability) {
+		err = ErrSessionInvalid
+	}
+	return
+}
+
+// RevokeSession ends a session so its token no longer validates
+func (h *Holochain) RevokeSession(token string) (err error) {
+	if err = h.ValidateSession(token); err != nil {
+		return
+	}
+	c := Capability{Token: token, db: h.bridgeDB}
+	err = c.Revoke(nil)
+	if err == CapabilityInvalidErr {
+		err = ErrSessionInvalid
+	}
+	return
+}
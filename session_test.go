@@ -0,0 +1,44 @@
+package holochain
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessions(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	Convey("it should issue sessions that validate until revoked", t, func() {
+		token, expires, err := h.NewSession(0)
+		So(err, ShouldBeNil)
+		So(expires.After(time.Now().Add(DefaultSessionTTL-time.Minute)), ShouldBeTrue)
+		So(h.ValidateSession(token), ShouldBeNil)
+		So(h.RevokeSession(token), ShouldBeNil)
+		So(h.ValidateSession(token), ShouldEqual, ErrSessionInvalid)
+		So(h.RevokeSession(token), ShouldEqual, ErrSessionInvalid)
+	})
+
+	Convey("it should cap the session length", t, func() {
+		_, expires, err := h.NewSession(MaxSessionTTL * 2)
+		So(err, ShouldBeNil)
+		So(expires.Before(time.Now().Add(MaxSessionTTL+time.Minute)), ShouldBeTrue)
+	})
+
+	Convey("sessions should expire", t, func() {
+		c, err := NewExpiringCapability(h.bridgeDB, SessionCapability, nil, time.Millisecond*10)
+		So(err, ShouldBeNil)
+		So(h.ValidateSession(c.Token), ShouldBeNil)
+		time.Sleep(time.Millisecond * 20)
+		So(h.ValidateSession(c.Token), ShouldEqual, ErrSessionInvalid)
+	})
+
+	Convey("other capabilities should not validate as sessions", t, func() {
+		c, err := NewCapability(h.bridgeDB, "*", nil)
+		So(err, ShouldBeNil)
+		So(h.ValidateSession(c.Token), ShouldEqual, ErrSessionInvalid)
+		So(h.ValidateSession(""), ShouldEqual, ErrSessionInvalid)
+	})
+}
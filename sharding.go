@@ -0,0 +1,171 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the sharding methods that decide which nodes are responsible for which hashes
+
+package holochain
+
+import (
+	"fmt"
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	mh "github.com/multiformats/go-multihash"
+	"sort"
+	"strings"
+)
+
+const (
+	// constants for the DHTConfig ShardingMethod
+
+	ShardingXOR      = "XOR"
+	ShardingHashmask = "hashmask"
+
+	DefaultShardingMethod = ShardingXOR
+)
+
+// ShardingMethod decides how near peers are to hashes, and so which peers are
+// responsible for holding a hash and which peers are our gossip neighbors
+type ShardingMethod interface {
+
+	// Name returns the name used to select the method in the DNA's DHTConfig
+	Name() string
+
+	// SortPeers returns the peers ordered by nearness to the hash, nearest first
+	SortPeers(peers []peer.ID, hash Hash) []peer.ID
+
+	// Responsible returns the peers that should hold the hash, given peers already sorted by SortPeers
+	Responsible(sorted []peer.ID, hash Hash, redundancy int) []peer.ID
+}
+
+var shardingMethods = map[string]ShardingMethod{
+	ShardingXOR:      &XORSharding{},
+	ShardingHashmask: &HashmaskSharding{},
+}
+
+// GetShardingMethod returns the sharding method with the given name
+// An empty name returns the DefaultShardingMethod
+func GetShardingMethod(name string) (method ShardingMethod, err error) {
+	if name == "" {
+		name = DefaultShardingMethod
+	}
+	method, ok := shardingMethods[name]
+	if !ok {
+		var available []string
+		for k := range shardingMethods {
+			available = append(available, k)
+		}
+		sort.Strings(available)
+		err = fmt.Errorf("Invalid sharding method. Must be one of: %s", strings.Join(available, ", "))
+	}
+	return
+}
+
+// XORSharding makes the redundancy-factor peers with the smallest XOR distance
+// to a hash responsible for it
+type XORSharding struct {
+}
+
+func (s *XORSharding) Name() string {
+	return ShardingXOR
+}
+
+func (s *XORSharding) SortPeers(peers []peer.ID, hash Hash) []peer.ID {
+	return SortClosestPeers(peers, hash)
+}
+
+func (s *XORSharding) Responsible(sorted []peer.ID, hash Hash, redundancy int) []peer.ID {
+	if redundancy > len(sorted) {
+		redundancy = len(sorted)
+	}
+	return sorted[:redundancy]
+}
+
+// HashmaskSharding splits the hash space into shards by the leading bits of the hash
+// and makes all the peers whose IDs share those leading bits responsible for it.
+// The number of bits in the mask is chosen so that the shards hold about
+// redundancy-factor peers each.
+type HashmaskSharding struct {
+}
+
+func (s *HashmaskSharding) Name() string {
+	return ShardingHashmask
+}
+
+// digest returns the digest part of a multihash so that the hash type header isn't
+// counted as part of the shared prefix
+func digest(h Hash) []byte {
+	d, err := mh.Decode([]byte(h))
+	if err != nil {
+		return []byte(h)
+	}
+	return d.Digest
+}
+
+// maskPrefixLen returns the number of leading bits that a peer has in common with the hash
+func maskPrefixLen(p peer.ID, hash Hash) int {
+	a := digest(HashFromPeerID(p))
+	b := digest(hash)
+	if len(a) > len(b) {
+		a = a[:len(b)]
+	} else {
+		b = b[:len(a)]
+	}
+	return ZeroPrefixLen(XOR(a, b))
+}
+
+// maskBits returns the number of bits in the mask for the given number of peers
+func maskBits(peers int, redundancy int) (bits int) {
+	if redundancy <= 0 {
+		return
+	}
+	for (peers >> uint(bits+1)) >= redundancy {
+		bits++
+	}
+	return
+}
+
+// peersByPrefix sorts peers by the length of their shared prefix with a hash, longest first
+type peersByPrefix struct {
+	peers  []peer.ID
+	prefix []int
+}
+
+func (p peersByPrefix) Len() int { return len(p.peers) }
+func (p peersByPrefix) Less(i, j int) bool {
+	if p.prefix[i] != p.prefix[j] {
+		return p.prefix[i] > p.prefix[j]
+	}
+	return p.peers[i] < p.peers[j]
+}
+func (p peersByPrefix) Swap(i, j int) {
+	p.peers[i], p.peers[j] = p.peers[j], p.peers[i]
+	p.prefix[i], p.prefix[j] = p.prefix[j], p.prefix[i]
+}
+
+func (s *HashmaskSharding) SortPeers(peers []peer.ID, hash Hash) []peer.ID {
+	p := peersByPrefix{peers: make([]peer.ID, len(peers)), prefix: make([]int, len(peers))}
+	copy(p.peers, peers)
+	for i := range p.peers {
+		p.prefix[i] = maskPrefixLen(p.peers[i], hash)
+	}
+	sort.Sort(p)
+	return p.peers
+}
+
+func (s *HashmaskSharding) Responsible(sorted []peer.ID, hash Hash, redundancy int) []peer.ID {
+	bits := maskBits(len(sorted), redundancy)
+	i := 0
+	for i < len(sorted) && maskPrefixLen(sorted[i], hash) >= bits {
+		i++
+	}
+	// peers aren't spread evenly, so when the hash's shard is short of the redundancy
+	// fall back to the peers nearest the hash by prefix
+	if i < redundancy {
+		i = redundancy
+		if i > len(sorted) {
+			i = len(sorted)
+		}
+	}
+	return sorted[:i]
+}
@@ -0,0 +1,115 @@
+package holochain
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	mh "github.com/multiformats/go-multihash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testShardingHashSpec = HashSpec{Code: mh.SHA2_256, Length: -1}
+
+func TestGetShardingMethod(t *testing.T) {
+	Convey("it should return the default method for an empty name", t, func() {
+		m, err := GetShardingMethod("")
+		So(err, ShouldBeNil)
+		So(m.Name(), ShouldEqual, DefaultShardingMethod)
+	})
+
+	Convey("it should return the named methods", t, func() {
+		m, err := GetShardingMethod(ShardingXOR)
+		So(err, ShouldBeNil)
+		So(m.Name(), ShouldEqual, ShardingXOR)
+		m, err = GetShardingMethod(ShardingHashmask)
+		So(err, ShouldBeNil)
+		So(m.Name(), ShouldEqual, ShardingHashmask)
+	})
+
+	Convey("it should fail on unknown methods", t, func() {
+		_, err := GetShardingMethod("bogus")
+		So(err.Error(), ShouldEqual, "Invalid sharding method. Must be one of: XOR, hashmask")
+	})
+}
+
+func testShardingPeers(count int) (peers []peer.ID) {
+	for i := 0; i < count; i++ {
+		e := GobEntry{C: fmt.Sprintf("peer %d", i)}
+		h, _ := e.Sum(testShardingHashSpec)
+		peers = append(peers, PeerIDFromHash(h))
+	}
+	return
+}
+
+func TestXORSharding(t *testing.T) {
+	s := &XORSharding{}
+	peers := testShardingPeers(10)
+	e := GobEntry{C: "some data"}
+	hash, _ := e.Sum(testShardingHashSpec)
+
+	Convey("it should sort peers by XOR distance", t, func() {
+		So(s.SortPeers(peers, hash), ShouldResemble, SortClosestPeers(peers, hash))
+	})
+
+	Convey("it should make the closest redundancy peers responsible", t, func() {
+		sorted := s.SortPeers(peers, hash)
+		So(s.Responsible(sorted, hash, 3), ShouldResemble, sorted[:3])
+		So(len(s.Responsible(sorted, hash, 20)), ShouldEqual, 10)
+	})
+}
+
+func TestHashmaskSharding(t *testing.T) {
+	s := &HashmaskSharding{}
+	peers := testShardingPeers(64)
+	e := GobEntry{C: "some data"}
+	hash, _ := e.Sum(testShardingHashSpec)
+
+	Convey("the mask should size shards to the redundancy", t, func() {
+		So(maskBits(64, 8), ShouldEqual, 3)
+		So(maskBits(63, 8), ShouldEqual, 2)
+		So(maskBits(7, 8), ShouldEqual, 0)
+		So(maskBits(64, 0), ShouldEqual, 0)
+	})
+
+	Convey("it should sort peers by shared prefix with the hash", t, func() {
+		sorted := s.SortPeers(peers, hash)
+		So(len(sorted), ShouldEqual, len(peers))
+		for i := 1; i < len(sorted); i++ {
+			So(maskPrefixLen(sorted[i-1], hash), ShouldBeGreaterThanOrEqualTo, maskPrefixLen(sorted[i], hash))
+		}
+	})
+
+	Convey("it should make all the peers in the hash's shard responsible", t, func() {
+		sorted := s.SortPeers(peers, hash)
+		responsible := s.Responsible(sorted, hash, 8)
+		bits := maskBits(len(peers), 8)
+		count := 0
+		for _, p := range peers {
+			if maskPrefixLen(p, hash) >= bits {
+				count++
+			}
+		}
+		if count < 8 {
+			count = 8
+		}
+		So(len(responsible), ShouldEqual, count)
+		So(responsible, ShouldResemble, sorted[:count])
+
+		So(len(s.Responsible(sorted, hash, 100)), ShouldEqual, len(peers))
+	})
+
+	Convey("it should fall back to the nearest peers when the hash's shard is short", t, func() {
+		// none of these peers share the hash's first bit so its shard is empty
+		var far []peer.ID
+		for _, p := range peers {
+			if maskPrefixLen(p, hash) == 0 && len(far) < 16 {
+				far = append(far, p)
+			}
+		}
+		So(len(far), ShouldEqual, 16)
+		sorted := s.SortPeers(far, hash)
+		So(s.Responsible(sorted, hash, 2), ShouldResemble, sorted[:2])
+	})
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements agents whose signing is delegated to a separate signer process over a
+// unix socket, so that the agent's private key never has to be loaded by the node
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	// SignerFileName is the file that holds a signer agent's socket path in place of
+	// a private key file
+	SignerFileName string = "signer.txt"
+
+	// DefaultSignerSocket is the socket name hcsigner listens on in its key directory
+	DefaultSignerSocket string = "signer.sock"
+
+	SignerPubKeyMethod = "pubkey"
+	SignerSignMethod   = "sign"
+	SignerSharedMethod = "shared"
+
+	SignerTimeout = 10 * time.Second
+)
+
+var ErrSignerGenKeys = errors.New("signer agent keys are generated and rotated by the signer")
+var ErrSignerPrivKey = errors.New("signer agent private keys can't leave the signer")
+var ErrSignerUnknownMethod = errors.New("unknown signer method")
+
+// SignerRequest is a request to a signer process
+type SignerRequest struct {
+	Method string
+	Data   []byte
+}
+
+// SignerResponse is a signer process's response to a request
+type SignerResponse struct {
+	PubKey []byte
+	Sig    []byte
+	Secret []byte // the X25519 secret shared with the requested point, for opening sealed entries
+	Err    string
+}
+
+// SignerAgent is an agent whose private key is held by a signer process that is
+// asked to sign over a unix socket
+type SignerAgent struct {
+	identity AgentIdentity
+	socket   string
+	pub      ic.PubKey
+}
+
+// signerPrivKey implements ic.PrivKey by asking the agent's signer to sign
+type signerPrivKey struct {
+	agent *SignerAgent
+}
+
+// NewSignerAgent creates an agent that signs with the signer listening on socket
+func NewSignerAgent(identity AgentIdentity, socket string) (agent *SignerAgent, err error) {
+	a := SignerAgent{identity: identity, socket: socket}
+	var resp SignerResponse
+	resp, err = a.call(SignerRequest{Method: SignerPubKeyMethod})
+	if err != nil {
+		return
+	}
+	a.pub, err = ic.UnmarshalPublicKey(resp.PubKey)
+	if err != nil {
+		return
+	}
+	agent = &a
+	return
+}
+
+// call sends a request to the signer and returns its response
+func (a *SignerAgent) call(req SignerRequest) (resp SignerResponse, err error) {
+	var conn net.Conn
+	conn, err = net.DialTimeout("unix", a.socket, SignerTimeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(SignerTimeout))
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return
+	}
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return
+	}
+	if resp.Err != "" {
+		err = fmt.Errorf("signer: %s", resp.Err)
+	}
+	return
+}
+
+func (a *SignerAgent) Identity() AgentIdentity {
+	return a.identity
+}
+func (a *SignerAgent) SetIdentity(id AgentIdentity) {
+	a.identity = id
+}
+
+func (a *SignerAgent) AgentType() AgentType {
+	return ExternalSigner
+}
+
+// Socket returns the path of the socket the agent's signer listens on
+func (a *SignerAgent) Socket() string {
+	return a.socket
+}
+
+func (a *SignerAgent) GenKeys(seed io.Reader) error {
+	return ErrSignerGenKeys
+}
+
+// PrivKey returns a private key that signs by asking the signer, it can't be marshaled
+func (a *SignerAgent) PrivKey() ic.PrivKey {
+	return &signerPrivKey{agent: a}
+}
+
+func (a *SignerAgent) PubKey() ic.PubKey {
+	return a.pub
+}
+
+func (a *SignerAgent) EncodePubKey() (b58pk string, err error) {
+	b58pk, err = encodePubKey(a.pub)
+	return
+}
+
+func (a *SignerAgent) NodeID() (nodeID peer.ID, nodeIDStr string, err error) {
+	nodeID, err = peer.IDFromPublicKey(a.pub)
+	if err == nil {
+		nodeIDStr = peer.IDB58Encode(nodeID)
+	}
+	return
+}
+
+func (a *SignerAgent) AgentEntry(revocation Revocation) (entry AgentEntry, err error) {
+	entry, err = agentEntry(a, revocation)
+	return
+}
+
+func (k *signerPrivKey) Bytes() ([]byte, error) {
+	return nil, ErrSignerPrivKey
+}
+
+func (k *signerPrivKey) Equals(o ic.Key) bool {
+	sk, ok := o.(*signerPrivKey)
+	return ok && k.agent.pub.Equals(sk.agent.pub)
+}
+
+func (k *signerPrivKey) Sign(data []byte) (sig []byte, err error) {
+	var resp SignerResponse
+	resp, err = k.agent.call(SignerRequest{Method: SignerSignMethod, Data: data})
+	if err != nil {
+		return
+	}
+	sig = resp.Sig
+	return
+}
+
+func (k *signerPrivKey) GetPublic() ic.PubKey {
+	return k.agent.pub
+}
+
+// saveSigner saves the socket path of a signer agent to the given directory
+func saveSigner(path string, agent *SignerAgent) (err error) {
+	if FileExists(path, SignerFileName) {
+		return errors.New("signer already exists")
+	}
+	err = WriteFile([]byte(agent.socket), path, SignerFileName)
+	return
+}
+
+// loadSigner creates a signer agent from the socket path saved in the given directory
+func loadSigner(path string, identity AgentIdentity) (agent *SignerAgent, err error) {
+	var socket []byte
+	socket, err = ReadFile(path, SignerFileName)
+	if err != nil {
+		return
+	}
+	agent, err = NewSignerAgent(identity, strings.TrimSpace(string(socket)))
+	return
+}
+
+// Signer serves signing requests for a private key over a unix socket
+type Signer struct {
+	key      ic.PrivKey
+	listener net.Listener
+	log      *Logger
+}
+
+// NewSigner creates a signer for a private key listening on socket, which only the
+// user can connect to
+func NewSigner(key ic.PrivKey, socket string, log *Logger) (s *Signer, err error) {
+	if FileExists(socket) {
+		// remove the socket left over from a previous signer
+		if err = os.Remove(socket); err != nil {
+			return
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(socket), os.ModePerm); err != nil {
+		return
+	}
+	var l net.Listener
+	l, err = net.Listen("unix", socket)
+	if err != nil {
+		return
+	}
+	if err = os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return
+	}
+	s = &Signer{key: key, listener: l, log: log}
+	return
+}
+
+// Serve answers signing requests until the signer is closed
+func (s *Signer) Serve() (err error) {
+	for {
+		var conn net.Conn
+		conn, err = s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops the signer
+func (s *Signer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Signer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(SignerTimeout))
+	var req SignerRequest
+	err := json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		s.log.Logf("bad signer request: %v", err)
+		return
+	}
+	resp, err := s.respond(req)
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	s.log.Logf("%s request: %v", req.Method, err)
+	err = json.NewEncoder(conn).Encode(resp)
+	if err != nil {
+		s.log.Logf("unable to send signer response: %v", err)
+	}
+}
+
+func (s *Signer) respond(req SignerRequest) (resp SignerResponse, err error) {
+	switch req.Method {
+	case SignerPubKeyMethod:
+		resp.PubKey, err = ic.MarshalPublicKey(s.key.GetPublic())
+	case SignerSignMethod:
+		resp.Sig, err = s.key.Sign(req.Data)
+	case SignerSharedMethod:
+		var point, shared [32]byte
+		if len(req.Data) != len(point) {
+			err = errors.New("bad point")
+			return
+		}
+		copy(point[:], req.Data)
+		shared, err = x25519Shared(s.key, point)
+		resp.Secret = shared[:]
+	default:
+		err = ErrSignerUnknownMethod
+	}
+	return
+}
@@ -0,0 +1,108 @@
+package holochain
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSigner(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	id, priv := makePeer("peer_foo")
+	socket := filepath.Join(d, DefaultSignerSocket)
+	s, err := NewSigner(priv, socket, nil)
+	if err != nil {
+		panic(err)
+	}
+	go s.Serve()
+	defer s.Close()
+
+	var a *SignerAgent
+	Convey("NewSignerAgent should get the signer's public key", t, func() {
+		a, err = NewSignerAgent("zippy@someemail.com", socket)
+		So(err, ShouldBeNil)
+		So(a.AgentType(), ShouldEqual, ExternalSigner)
+		So(ic.KeyEqual(a.PubKey(), priv.GetPublic()), ShouldBeTrue)
+		nodeID, _, err := a.NodeID()
+		So(err, ShouldBeNil)
+		So(nodeID, ShouldEqual, id)
+	})
+
+	Convey("it should sign with the signer", t, func() {
+		sig, err := a.PrivKey().Sign([]byte("some data"))
+		So(err, ShouldBeNil)
+		expected, _ := priv.Sign([]byte("some data"))
+		So(sig, ShouldResemble, expected)
+		matches, err := a.PubKey().Verify([]byte("some data"), sig)
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeTrue)
+	})
+
+	Convey("the private key should not leave the signer", t, func() {
+		_, err := a.PrivKey().Bytes()
+		So(err, ShouldEqual, ErrSignerPrivKey)
+		So(a.GenKeys(nil), ShouldEqual, ErrSignerGenKeys)
+	})
+
+	Convey("it should save and load signer agents", t, func() {
+		err := SaveAgent(d, a)
+		So(err, ShouldBeNil)
+		So(FileExists(d, PrivKeyFileName), ShouldBeFalse)
+		a1, err := LoadAgent(d)
+		So(err, ShouldBeNil)
+		So(a1.Identity(), ShouldEqual, a.Identity())
+		So(a1.(*SignerAgent).Socket(), ShouldEqual, socket)
+		So(a1.PrivKey().Equals(a.PrivKey()), ShouldBeTrue)
+	})
+
+	Convey("it should derive the same store key from the signer every time", t, func() {
+		salt := []byte("salt")
+		k1, err := storeKeyFromAgent(a, salt)
+		So(err, ShouldBeNil)
+		k2, err := storeKeyFromAgent(a, salt)
+		So(err, ShouldBeNil)
+		So(k1, ShouldResemble, k2)
+	})
+
+	Convey("it should fail when the signer isn't there", t, func() {
+		s.Close()
+		_, err := a.PrivKey().Sign([]byte("some data"))
+		So(err, ShouldNotBeNil)
+		_, err = NewSignerAgent("zippy@someemail.com", socket)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSignerAgentChain(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	socket := filepath.Join(d, DefaultSignerSocket)
+	signer, err := NewSigner(h.agent.PrivKey(), socket, nil)
+	if err != nil {
+		panic(err)
+	}
+	go signer.Serve()
+	defer signer.Close()
+	a, err := NewSignerAgent(h.agent.Identity(), socket)
+	if err != nil {
+		panic(err)
+	}
+	h.agent = a
+
+	Convey("it should commit entries signed by the signer", t, func() {
+		_, header, err := h.NewEntry(time.Now(), "evenNumbers", &GobEntry{C: "2"})
+		So(err, ShouldBeNil)
+		matches, err := header.Verify(a.PubKey())
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeTrue)
+	})
+
+	Convey("it should not rotate the signer's key", t, func() {
+		err := h.RotateAgentKey("")
+		So(err, ShouldEqual, ErrSignerGenKeys)
+	})
+}
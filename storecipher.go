@@ -0,0 +1,500 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the optional encryption at rest of the chain and dht stores
+
+package holochain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/holochain/holochain-proto/hash"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// constants for the Config StoreEncryption
+
+	StoreEncryptionNone       = "none"
+	StoreEncryptionAgent      = "agent"
+	StoreEncryptionPassphrase = "passphrase"
+
+	StorePassphraseEnvar     = "HC_STORE_PASSPHRASE"      // passphrase for the passphrase store encryption
+	StorePassphraseFileEnvar = "HC_STORE_PASSPHRASE_FILE" // file to read the passphrase from instead
+
+	storeSealMagic     = "HCS1"
+	storeChainMagic    = "HCSCHAIN1"
+	storeKeyInfo       = "holochain store key"
+	storeSaltSize      = 32
+	storeKDFIterations = 100000
+)
+
+var ErrStoreEncrypted = errors.New("store is encrypted but no store encryption is configured")
+var ErrStorePassphraseRequired = errors.New("store encryption passphrase required")
+var ErrStoreDecryption = errors.New("unable to decrypt store (wrong key?)")
+var ErrStoreUnsealed = errors.New("store record isn't encrypted")
+
+// StorePassphraseFn returns the passphrase for the passphrase store encryption.  newStore
+// is true when the stores are being created and will be encrypted with it.
+type StorePassphraseFn func(newStore bool) (passphrase string, err error)
+
+// StorePassphrase gets the passphrase stores are encrypted with.  By default it comes
+// from the environment, command line tools replace it to prompt the user.
+var StorePassphrase StorePassphraseFn = StorePassphraseFromEnv
+
+// StorePassphraseFromEnv returns the passphrase from the HC_STORE_PASSPHRASE environment
+// variable, or read from the file named by HC_STORE_PASSPHRASE_FILE
+func StorePassphraseFromEnv(newStore bool) (passphrase string, err error) {
+	passphrase = os.Getenv(StorePassphraseEnvar)
+	if passphrase != "" {
+		return
+	}
+	file := os.Getenv(StorePassphraseFileEnvar)
+	if file != "" {
+		var b []byte
+		b, err = ioutil.ReadFile(file)
+		if err != nil {
+			return
+		}
+		passphrase = strings.TrimRight(string(b), "\r\n")
+	}
+	return
+}
+
+// storeCipher seals and opens the data written to the chain and dht stores
+type storeCipher struct {
+	aead cipher.AEAD
+
+	// legacy is set for stores that held plaintext records before encryption was turned
+	// on, whose unsealed records are returned as is rather than being an error
+	legacy bool
+
+	// legacyStores holds the names of the store files that held plaintext records when
+	// encryption was turned on
+	legacyStores map[string]bool
+}
+
+// storeCheck is kept sealed in the store check file so that opening the stores with the
+// wrong key fails straight away, and records which stores may hold plaintext records
+type storeCheck struct {
+	Check  string
+	Legacy []string
+}
+
+// checkStoreEncryption returns an error if the method isn't a known store encryption method
+func checkStoreEncryption(method string) (err error) {
+	switch method {
+	case "", StoreEncryptionNone, StoreEncryptionAgent, StoreEncryptionPassphrase:
+	default:
+		err = fmt.Errorf("Invalid store encryption. Must be one of: %s, %s, %s", StoreEncryptionNone, StoreEncryptionAgent, StoreEncryptionPassphrase)
+	}
+	return
+}
+
+// newStoreCipher returns a cipher using a 32 byte key
+func newStoreCipher(key []byte) (c *storeCipher, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	c = &storeCipher{aead: aead}
+	return
+}
+
+// storeKeyFromPassphrase derives a store key from a passphrase with PBKDF2-HMAC-SHA256
+func storeKeyFromPassphrase(passphrase string, salt []byte) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, storeKDFIterations, 32, sha256.New)
+}
+
+// storeKeyFromAgent derives a store key from the agent's private key
+func storeKeyFromAgent(agent Agent, salt []byte) (key []byte, err error) {
+	var k []byte
+	k, err = agent.PrivKey().Bytes()
+	if err == ErrSignerPrivKey {
+		// the key can't leave the signer, but ed25519 signatures are deterministic
+		// so the signer's signature of the key info serves as the secret instead
+		k, err = agent.PrivKey().Sign([]byte(storeKeyInfo))
+	}
+	if err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(storeKeyInfo))
+	mac.Write(salt)
+	key = mac.Sum(nil)
+	return
+}
+
+// loadStoreSalt returns the salt used for deriving store keys in the given directory,
+// creating it if it doesn't exist yet
+func loadStoreSalt(root string) (salt []byte, err error) {
+	path := filepath.Join(root, StoreSaltFileName)
+	if FileExists(path) {
+		salt, err = ReadFile(root, StoreSaltFileName)
+		return
+	}
+	salt = make([]byte, storeSaltSize)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return
+	}
+	err = WriteFile(salt, root, StoreSaltFileName)
+	return
+}
+
+// loadAgentStoreKey returns the key the stores are encrypted with under the agent store
+// encryption.  It is kept in the store key file sealed with a key derived from the agent's
+// key, so that rotating the agent's key only needs it resealed rather than the stores
+// re-encrypted.  Stores from before there was a store key file were encrypted with the
+// agent derived key itself, so that is the key that gets sealed when there isn't one.
+func loadAgentStoreKey(root string, agent Agent, salt []byte) (key []byte, err error) {
+	var agentKey []byte
+	agentKey, err = storeKeyFromAgent(agent, salt)
+	if err != nil {
+		return
+	}
+	if !FileExists(root, StoreKeyFileName) {
+		key = agentKey
+		err = saveAgentStoreKey(root, agent, salt, key)
+		return
+	}
+	var sealed []byte
+	sealed, err = ReadFile(root, StoreKeyFileName)
+	if err != nil {
+		return
+	}
+	if !bytes.HasPrefix(sealed, []byte(storeSealMagic)) {
+		err = ErrStoreDecryption
+		return
+	}
+	var c *storeCipher
+	c, err = newStoreCipher(agentKey)
+	if err != nil {
+		return
+	}
+	key, err = c.open(sealed)
+	return
+}
+
+// saveAgentStoreKey seals the store key with a key derived from the agent's key and writes
+// it to the store key file, replacing any that is there
+func saveAgentStoreKey(root string, agent Agent, salt []byte, key []byte) (err error) {
+	var agentKey, sealed []byte
+	agentKey, err = storeKeyFromAgent(agent, salt)
+	if err != nil {
+		return
+	}
+	var c *storeCipher
+	c, err = newStoreCipher(agentKey)
+	if err != nil {
+		return
+	}
+	sealed, err = c.seal(key)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(root, StoreKeyFileName)
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, sealed, 0600)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, path)
+	return
+}
+
+// resealStoreKey reseals the store key under the agent store encryption for the agent's
+// current key, after oldAgent's key was rotated out, so that the stores can still be opened
+func (h *Holochain) resealStoreKey(oldAgent Agent) (err error) {
+	if h.Config.StoreEncryption != StoreEncryptionAgent {
+		return
+	}
+	var salt, key []byte
+	salt, err = loadStoreSalt(h.rootPath)
+	if err != nil {
+		return
+	}
+	key, err = loadAgentStoreKey(h.rootPath, oldAgent, salt)
+	if err != nil {
+		return
+	}
+	err = saveAgentStoreKey(h.rootPath, h.agent, salt, key)
+	return
+}
+
+// openStoreCipher returns the cipher for encrypting the chain and dht stores at rest as set
+// by the Config's StoreEncryption, or nil if they aren't encrypted.  The passphrase is taken
+// from StorePassphrase.
+func (h *Holochain) openStoreCipher() (c *storeCipher, err error) {
+	method := h.Config.StoreEncryption
+	if method == "" || method == StoreEncryptionNone {
+		return
+	}
+	if err = checkStoreEncryption(method); err != nil {
+		return
+	}
+	var salt, key []byte
+	newStore := !FileExists(filepath.Join(h.rootPath, StoreSaltFileName))
+	salt, err = loadStoreSalt(h.rootPath)
+	if err != nil {
+		return
+	}
+	switch method {
+	case StoreEncryptionAgent:
+		key, err = loadAgentStoreKey(h.rootPath, h.agent, salt)
+		if err != nil {
+			return
+		}
+	case StoreEncryptionPassphrase:
+		var passphrase string
+		passphrase, err = StorePassphrase(newStore)
+		if err != nil {
+			return
+		}
+		if passphrase == "" {
+			err = ErrStorePassphraseRequired
+			return
+		}
+		key = storeKeyFromPassphrase(passphrase, salt)
+	}
+	c, err = newStoreCipher(key)
+	if err != nil {
+		return
+	}
+	err = c.check(h.rootPath, h.DBPath())
+	if err != nil {
+		c = nil
+	}
+	return
+}
+
+// check opens the store check file to make sure the cipher has the stores' key, and loads
+// which stores may hold plaintext records.  If there isn't a check file encryption is being
+// turned on, so one is written recording the stores that already exist in dbPath as legacy.
+func (c *storeCipher) check(root string, dbPath string) (err error) {
+	var sc storeCheck
+	if FileExists(root, StoreCheckFileName) {
+		var sealed, data []byte
+		sealed, err = ReadFile(root, StoreCheckFileName)
+		if err != nil {
+			return
+		}
+		if data, err = c.open(sealed); err != nil {
+			err = ErrStoreDecryption
+			return
+		}
+		if err = json.Unmarshal(data, &sc); err != nil || sc.Check != storeKeyInfo {
+			err = ErrStoreDecryption
+			return
+		}
+	} else {
+		// stores encrypted before there were check files can still be checked by their chain
+		if err = c.checkChainFile(filepath.Join(dbPath, StoreFileName)); err != nil {
+			return
+		}
+		sc.Check = storeKeyInfo
+		for _, name := range []string{DHTStoreFileName, DHTBoltStoreFileName, DHTQueueFileName} {
+			if FileExists(dbPath, name) {
+				sc.Legacy = append(sc.Legacy, name)
+			}
+		}
+		var data, sealed []byte
+		if data, err = json.Marshal(sc); err != nil {
+			return
+		}
+		if sealed, err = c.seal(data); err != nil {
+			return
+		}
+		if err = WriteFile(sealed, root, StoreCheckFileName); err != nil {
+			return
+		}
+	}
+	c.legacyStores = make(map[string]bool)
+	for _, name := range sc.Legacy {
+		c.legacyStores[name] = true
+	}
+	return
+}
+
+// checkChainFile returns ErrStoreDecryption if the chain file at path is encrypted and its
+// first pair doesn't open with the cipher
+func (c *storeCipher) checkChainFile(path string) (err error) {
+	if !FileExists(path) {
+		return
+	}
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+	var encrypted bool
+	if encrypted, err = isEncryptedChainFile(f); err != nil || !encrypted {
+		return
+	}
+	_, _, err = c.readSealedPair(bufio.NewReader(f))
+	if err == io.EOF {
+		err = nil
+	} else if err != nil {
+		err = ErrStoreDecryption
+	}
+	return
+}
+
+// forStore returns the cipher for the store kept in the named files, which returns
+// unsealed records as is if any of them held plaintext records when encryption was
+// turned on
+func (c *storeCipher) forStore(names ...string) *storeCipher {
+	sc := *c
+	for _, name := range names {
+		if c.legacyStores[name] {
+			sc.legacy = true
+		}
+	}
+	return &sc
+}
+
+// seal encrypts data, prefixing it with a marker and the nonce
+func (c *storeCipher) seal(data []byte) (sealed []byte, err error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	sealed = append([]byte(storeSealMagic), nonce...)
+	sealed = c.aead.Seal(sealed, nonce, data, nil)
+	return
+}
+
+// open decrypts data sealed by seal.  Data without the seal marker is an error unless
+// the cipher is for a legacy store, where it was stored before encryption was turned on
+// and is returned as is.
+func (c *storeCipher) open(sealed []byte) (data []byte, err error) {
+	if !bytes.HasPrefix(sealed, []byte(storeSealMagic)) {
+		if c.legacy {
+			data = sealed
+		} else {
+			err = ErrStoreUnsealed
+		}
+		return
+	}
+	sealed = sealed[len(storeSealMagic):]
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		err = ErrStoreDecryption
+		return
+	}
+	data, err = c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		err = ErrStoreDecryption
+	}
+	return
+}
+
+// writeSealedPair writes a header/entry pair to an encrypted chain file as a single sealed frame
+func (c *storeCipher) writeSealedPair(writer io.Writer, header *Header, entry Entry, encoding string) (err error) {
+	var b bytes.Buffer
+	if err = writeEncodedPair(&b, header, entry, encoding); err != nil {
+		return
+	}
+	var sealed []byte
+	if sealed, err = c.seal(b.Bytes()); err != nil {
+		return
+	}
+	_, err = writeFrame(writer, sealed)
+	return
+}
+
+// readSealedPair reads a header/entry pair written by writeSealedPair
+func (c *storeCipher) readSealedPair(reader io.Reader) (header *Header, entry Entry, err error) {
+	var sealed, data []byte
+	if sealed, err = readFrame(reader, wireMaxFrameSize); err != nil {
+		return
+	}
+	if data, err = c.open(sealed); err != nil {
+		return
+	}
+	header, entry, err = readPair(ChainMarshalFlagsNone, bufio.NewReader(bytes.NewReader(data)))
+	return
+}
+
+// rewriteChainFile writes out all the pairs of the chain to a new encrypted chain file,
+// replacing the file at path
+func (c *storeCipher) rewriteChainFile(chain *Chain, path string) (err error) {
+	tmp := path + ".tmp"
+	var f *os.File
+	f, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	_, err = f.Write([]byte(storeChainMagic))
+	for i := 0; err == nil && i < len(chain.Headers); i++ {
+		err = c.writeSealedPair(f, chain.Headers[i], chain.Entries[i], chain.encoding)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, path)
+	return
+}
+
+// encryptedHT wraps a HashTable encrypting the entry values it stores.
+// Keys, links and the change index are left in the clear because they are
+// made up of hashes and peer IDs which the backends need for indexing.
+type encryptedHT struct {
+	HashTable
+	cipher *storeCipher
+}
+
+// newEncryptedHT returns the HashTable wrapped so that entry values are encrypted at rest
+func newEncryptedHT(ht HashTable, c *storeCipher) HashTable {
+	return &encryptedHT{HashTable: ht, cipher: c}
+}
+
+// Put stores the encrypted value
+func (ht *encryptedHT) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	var sealed []byte
+	sealed, err = ht.cipher.seal(value)
+	if err != nil {
+		return
+	}
+	err = ht.HashTable.Put(m, entryType, key, src, sealed, status)
+	return
+}
+
+// Get retrieves and decrypts a value
+func (ht *encryptedHT) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	data, entryType, sources, status, err = ht.HashTable.Get(key, statusMask, getMask)
+	if err == nil && data != nil {
+		data, err = ht.cipher.open(data)
+	}
+	return
+}
@@ -0,0 +1,302 @@
+package holochain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/holochain/holochain-proto/hash"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testStoreCipher(passphrase string) *storeCipher {
+	c, err := newStoreCipher(storeKeyFromPassphrase(passphrase, []byte("salt")))
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestCheckStoreEncryption(t *testing.T) {
+	Convey("it should accept the known store encryption methods", t, func() {
+		So(checkStoreEncryption(""), ShouldBeNil)
+		So(checkStoreEncryption(StoreEncryptionNone), ShouldBeNil)
+		So(checkStoreEncryption(StoreEncryptionAgent), ShouldBeNil)
+		So(checkStoreEncryption(StoreEncryptionPassphrase), ShouldBeNil)
+		So(checkStoreEncryption("rot13").Error(), ShouldEqual, "Invalid store encryption. Must be one of: none, agent, passphrase")
+	})
+}
+
+func TestStoreKeys(t *testing.T) {
+	Convey("passphrase keys should be PBKDF2-HMAC-SHA256 of the passphrase", t, func() {
+		key := storeKeyFromPassphrase("passwd", []byte("salt"))
+		So(hex.EncodeToString(key), ShouldEqual, "15361a12e9cdf546262d468fe84b03a9bdc1e711b99d0429db9f8d9167e52366")
+	})
+
+	Convey("the passphrase should come from the environment or a file", t, func() {
+		d := SetupTestDir()
+		defer CleanupTestDir(d)
+		os.Setenv(StorePassphraseEnvar, "from env")
+		p, err := StorePassphraseFromEnv(false)
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, "from env")
+		os.Unsetenv(StorePassphraseEnvar)
+
+		file := filepath.Join(d, "passphrase")
+		So(ioutil.WriteFile(file, []byte("from file\n"), 0600), ShouldBeNil)
+		os.Setenv(StorePassphraseFileEnvar, file)
+		defer os.Unsetenv(StorePassphraseFileEnvar)
+		p, err = StorePassphraseFromEnv(false)
+		So(err, ShouldBeNil)
+		So(p, ShouldEqual, "from file")
+	})
+
+	Convey("agent keys should depend on the salt", t, func() {
+		a, _ := NewAgent(LibP2P, "agent id", MakeTestSeed(""))
+		k1, err := storeKeyFromAgent(a, []byte("salt1"))
+		So(err, ShouldBeNil)
+		So(len(k1), ShouldEqual, 32)
+		k2, _ := storeKeyFromAgent(a, []byte("salt2"))
+		So(bytes.Equal(k1, k2), ShouldBeFalse)
+	})
+
+	Convey("agent store keys should be sealed with the agent's key and resealable", t, func() {
+		d := SetupTestDir()
+		defer CleanupTestDir(d)
+		a1, _ := NewAgent(LibP2P, "agent id", MakeTestSeed(""))
+		a2, _ := NewAgent(LibP2P, "agent id", MakeTestSeed("other"))
+		salt := []byte("salt")
+		k1, err := loadAgentStoreKey(d, a1, salt)
+		So(err, ShouldBeNil)
+		k, _ := storeKeyFromAgent(a1, salt)
+		So(k1, ShouldResemble, k)
+		So(FileExists(d, StoreKeyFileName), ShouldBeTrue)
+
+		So(saveAgentStoreKey(d, a2, salt, k1), ShouldBeNil)
+		k2, err := loadAgentStoreKey(d, a2, salt)
+		So(err, ShouldBeNil)
+		So(k2, ShouldResemble, k1)
+		_, err = loadAgentStoreKey(d, a1, salt)
+		So(err, ShouldEqual, ErrStoreDecryption)
+	})
+
+	Convey("the salt should be created once and then reloaded", t, func() {
+		d := SetupTestDir()
+		defer CleanupTestDir(d)
+		s1, err := loadStoreSalt(d)
+		So(err, ShouldBeNil)
+		So(len(s1), ShouldEqual, storeSaltSize)
+		s2, err := loadStoreSalt(d)
+		So(err, ShouldBeNil)
+		So(s2, ShouldResemble, s1)
+	})
+}
+
+func TestStoreCipherSeal(t *testing.T) {
+	c := testStoreCipher("fish")
+
+	Convey("sealed data should open", t, func() {
+		sealed, err := c.seal([]byte("some data"))
+		So(err, ShouldBeNil)
+		So(bytes.Contains(sealed, []byte("some data")), ShouldBeFalse)
+		data, err := c.open(sealed)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "some data")
+	})
+
+	Convey("unsealed data should only be returned as is for legacy stores", t, func() {
+		_, err := c.open([]byte("some data"))
+		So(err, ShouldEqual, ErrStoreUnsealed)
+
+		c.legacyStores = map[string]bool{DHTStoreFileName: true}
+		data, err := c.forStore(DHTStoreFileName, DHTBoltStoreFileName).open([]byte("some data"))
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "some data")
+		_, err = c.forStore(DHTQueueFileName).open([]byte("some data"))
+		So(err, ShouldEqual, ErrStoreUnsealed)
+	})
+
+	Convey("sealed data should not open with the wrong key", t, func() {
+		sealed, _ := c.seal([]byte("some data"))
+		_, err := testStoreCipher("cow").open(sealed)
+		So(err, ShouldEqual, ErrStoreDecryption)
+	})
+}
+
+func TestStoreCheck(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	dbPath := filepath.Join(d, "db")
+	os.MkdirAll(dbPath, os.ModePerm)
+	WriteFile([]byte("plaintext store"), dbPath, DHTQueueFileName)
+
+	Convey("the first check should record the stores that already exist as legacy", t, func() {
+		c := testStoreCipher("fish")
+		err := c.check(d, dbPath)
+		So(err, ShouldBeNil)
+		So(FileExists(d, StoreCheckFileName), ShouldBeTrue)
+		So(c.forStore(DHTQueueFileName).legacy, ShouldBeTrue)
+		So(c.forStore(DHTStoreFileName, DHTBoltStoreFileName).legacy, ShouldBeFalse)
+	})
+
+	Convey("later checks should load the legacy stores", t, func() {
+		WriteFile([]byte("store created later"), dbPath, DHTStoreFileName)
+		c := testStoreCipher("fish")
+		err := c.check(d, dbPath)
+		So(err, ShouldBeNil)
+		So(c.forStore(DHTQueueFileName).legacy, ShouldBeTrue)
+		So(c.forStore(DHTStoreFileName, DHTBoltStoreFileName).legacy, ShouldBeFalse)
+	})
+
+	Convey("the check should fail with the wrong key", t, func() {
+		err := testStoreCipher("cow").check(d, dbPath)
+		So(err, ShouldEqual, ErrStoreDecryption)
+	})
+}
+
+func TestEncryptedChainFile(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+	c := testStoreCipher("fish")
+	path := filepath.Join(d, "chain.dat")
+
+	chain, err := newChainFromFile(hashSpec, path, c)
+	if err != nil {
+		panic(err)
+	}
+	e := GobEntry{C: "some secret data"}
+	chain.AddEntry(now, "entryTypeFoo1", &e, key)
+	e = GobEntry{C: "some other secret data"}
+	chain.AddEntry(now, "entryTypeFoo2", &e, key)
+	dump := chain.String()
+	chain.Close()
+
+	Convey("the chain file should not contain the entries in the clear", t, func() {
+		b, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.HasPrefix(b, []byte(storeChainMagic)), ShouldBeTrue)
+		So(bytes.Contains(b, []byte("secret")), ShouldBeFalse)
+	})
+
+	Convey("it should reload and validate the encrypted chain", t, func() {
+		chain, err = newChainFromFile(hashSpec, path, c)
+		So(err, ShouldBeNil)
+		So(chain.String(), ShouldEqual, dump)
+		So(chain.Validate(false, key.GetPublic()), ShouldBeNil)
+
+		e = GobEntry{C: "yet more secret data"}
+		chain.AddEntry(now, "entryTypeFoo1", &e, key)
+		dump = chain.String()
+		chain.Close()
+
+		chain, err = newChainFromFile(hashSpec, path, c)
+		So(err, ShouldBeNil)
+		So(chain.String(), ShouldEqual, dump)
+		So(chain.Validate(false, key.GetPublic()), ShouldBeNil)
+	})
+
+	Convey("the encrypted chain should marshal and unmarshal", t, func() {
+		var b bytes.Buffer
+		err := chain.MarshalChain(&b, ChainMarshalFlagsNone, nil, nil)
+		So(err, ShouldBeNil)
+		flags, c1, err := UnmarshalChain(hashSpec, &b)
+		So(err, ShouldBeNil)
+		So(flags, ShouldEqual, ChainMarshalFlagsNone)
+		So(c1.String(), ShouldEqual, dump)
+		chain.Close()
+	})
+
+	Convey("it should fail to load without the cipher or with the wrong key", t, func() {
+		_, err := NewChainFromFile(hashSpec, path)
+		So(err, ShouldEqual, ErrStoreEncrypted)
+		_, err = newChainFromFile(hashSpec, path, testStoreCipher("cow"))
+		So(err, ShouldEqual, ErrStoreDecryption)
+	})
+
+	Convey("it should encrypt an existing unencrypted chain file", t, func() {
+		plainPath := filepath.Join(d, "plain.dat")
+		chain, err := NewChainFromFile(hashSpec, plainPath)
+		So(err, ShouldBeNil)
+		e := GobEntry{C: "some secret data"}
+		chain.AddEntry(now, "entryTypeFoo1", &e, key)
+		dump := chain.String()
+		chain.Close()
+
+		chain, err = newChainFromFile(hashSpec, plainPath, c)
+		So(err, ShouldBeNil)
+		So(chain.String(), ShouldEqual, dump)
+		chain.Close()
+		b, _ := ioutil.ReadFile(plainPath)
+		So(bytes.HasPrefix(b, []byte(storeChainMagic)), ShouldBeTrue)
+		So(bytes.Contains(b, []byte("secret")), ShouldBeFalse)
+	})
+}
+
+func TestEncryptedHT(t *testing.T) {
+	node, err := makeNode(1260, "")
+	if err != nil {
+		panic(err)
+	}
+	defer node.Close()
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+
+	testHashTables(t, func(htType string, ht HashTable) {
+		eht := newEncryptedHT(ht, testStoreCipher("fish"))
+		Convey(fmt.Sprintf("%s: it should store values encrypted and retrieve them decrypted", htType), t, func() {
+			err := eht.Put(node.NewMessage(PUT_REQUEST, HoldReq{EntryHash: hash}), "someType", hash, node.HashAddr, []byte("some value"), StatusLive)
+			So(err, ShouldBeNil)
+
+			data, entryType, _, _, err := eht.Get(hash, StatusLive, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some value")
+			So(entryType, ShouldEqual, "someType")
+
+			data, _, _, _, err = ht.Get(hash, StatusLive, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(bytes.Contains(data, []byte("some value")), ShouldBeFalse)
+		})
+	})
+}
+
+func TestRotateAgentKeyWithAgentStoreEncryption(t *testing.T) {
+	d, s, h := SetupTestChain("test")
+	defer CleanupTestDir(d)
+	h.Config.StoreEncryption = StoreEncryptionAgent
+	f, err := os.Create(filepath.Join(h.rootPath, ConfigFileName+"."+h.encodingFormat))
+	if err != nil {
+		panic(err)
+	}
+	err = Encode(f, h.encodingFormat, &h.Config)
+	f.Close()
+	if err != nil {
+		panic(err)
+	}
+	h.Close()
+	h, err = s.Load("test")
+	if err != nil {
+		panic(err)
+	}
+	prepareTestChain(h)
+	hash := commit(h, "evenNumbers", "2")
+
+	Convey("the stores should still open after rotating the agent's key", t, func() {
+		So(h.storeCipher, ShouldNotBeNil)
+		err := h.RotateAgentKey("")
+		So(err, ShouldBeNil)
+		pubKey := h.agent.PubKey()
+		h.Close()
+
+		h, err = s.Load("test")
+		So(err, ShouldBeNil)
+		So(h.agent.PubKey().Equals(pubKey), ShouldBeTrue)
+		entry, _, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "2")
+	})
+	h.Close()
+}
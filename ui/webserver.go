@@ -8,6 +8,7 @@ package ui
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,17 +19,35 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 type WebServer struct {
-	h      *holo.Holochain
-	port   string
-	log    holo.Logger
-	errs   holo.Logger
-	stop   chan bool
-	server *http.Server
+	h           *holo.Holochain
+	port        string
+	log         holo.Logger
+	errs        holo.Logger
+	stop        chan bool
+	server      *http.Server
+	loginSecret string
 }
 
+// LoginRequest is the body of a request to the /login endpoint
+type LoginRequest struct {
+	Secret string
+	TTL    int // requested session length in seconds, zero for the default
+}
+
+// LoginResponse is returned by the /login endpoint
+type LoginResponse struct {
+	Token   string
+	Expires time.Time
+}
+
+// SessionCookieName is the name of the cookie /login sets to hold the session token for
+// the browser UI, whose websockets can't set an Authorization header
+const SessionCookieName = "hc_session"
+
 func NewWebServer(h *holo.Holochain, port string) *WebServer {
 	w := WebServer{h: h, port: port}
 	w.log = holo.Logger{Format: "%{color:magenta}%{message}"}
@@ -37,6 +56,12 @@ func NewWebServer(h *holo.Holochain, port string) *WebServer {
 	return &w
 }
 
+// SetLoginSecret enables the /login endpoint, which issues session tokens to requests
+// presenting the secret.  Calls made with a session token can call AUTHENTICATED_EXPOSURE functions.
+func (ws *WebServer) SetLoginSecret(secret string) {
+	ws.loginSecret = secret
+}
+
 //Start starts up a web server and returns a channel which will shutdown
 func (ws *WebServer) Start() {
 
@@ -55,6 +80,7 @@ func (ws *WebServer) Start() {
 	}
 
 	mux.HandleFunc("/_sock/", func(w http.ResponseWriter, r *http.Request) {
+		sockToken := requestToken(r)
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			ws.errs.Logf(err.Error())
@@ -73,7 +99,17 @@ func (ws *WebServer) Start() {
 			}
 			zome := v["zome"]
 			function := v["fn"]
-			result, err := ws.call(zome, function, v["arg"])
+			token := v["token"]
+			if token == "" {
+				token = sockToken
+			}
+			exposure, err := ws.exposure(token)
+			if err != nil {
+				ws.errs.Log(err)
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+				return
+			}
+			result, err := ws.call(zome, function, v["arg"], exposure)
 			switch t := result.(type) {
 			case string:
 				err = conn.WriteMessage(websocket.TextMessage, []byte(t))
@@ -119,7 +155,12 @@ func (ws *WebServer) Start() {
 		zome := path[2]
 		function := path[3]
 		args := string(body)
-		result, err := ws.call(zome, function, args)
+		exposure, err := ws.exposure(requestToken(r))
+		if err != nil {
+			errCode = 401
+			return
+		}
+		result, err := ws.call(zome, function, args, exposure)
 		if err != nil {
 			ws.log.Logf("call of %s:%s resulted in error: %v\n", zome, function, err)
 			return
@@ -135,6 +176,59 @@ func (ws *WebServer) Start() {
 		}
 	})
 
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var errCode = 400
+		defer func() {
+			if err != nil {
+				ws.log.Logf("ERROR:%s,code:%d", err.Error(), errCode)
+				http.Error(w, err.Error(), errCode)
+			}
+		}()
+
+		if ws.loginSecret == "" {
+			errCode, err = mkErr("login not enabled", 403)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			errCode, err = mkErr("unable to read body", 500)
+			return
+		}
+		var req LoginRequest
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(req.Secret), []byte(ws.loginSecret)) != 1 {
+			errCode, err = mkErr("invalid login", 401)
+			return
+		}
+		var resp LoginResponse
+		resp.Token, resp.Expires, err = ws.h.NewSession(time.Duration(req.TTL) * time.Second)
+		if err != nil {
+			errCode, err = mkErr("unable to create session: "+err.Error(), 500)
+			return
+		}
+		ws.log.Logf("session started, expires %v\n", resp.Expires)
+		http.SetCookie(w, &http.Cookie{Name: SessionCookieName, Value: resp.Token, Path: "/", Expires: resp.Expires, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(resp)
+	})
+
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var errCode = 401
+		defer func() {
+			if err != nil {
+				ws.log.Logf("ERROR:%s,code:%d", err.Error(), errCode)
+				http.Error(w, err.Error(), errCode)
+			}
+		}()
+		http.SetCookie(w, &http.Cookie{Name: SessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+		err = ws.h.RevokeSession(requestToken(r))
+	})
+
 	mux.HandleFunc("/setup-bridge/", func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		var errCode = 400
@@ -207,8 +301,17 @@ func (ws *WebServer) Start() {
 		function := path[4]
 		args := string(body)
 
+		var who string
+		if pk := r.Header.Get(holo.BridgeAgentHeader); pk != "" {
+			who, err = ws.h.VerifyBridgeCaller(pk, r.Header.Get(holo.BridgeSignatureHeader), token, zome, function, r.Header.Get(holo.BridgeTimeHeader), args)
+			if err != nil {
+				errCode, err = mkErr(err.Error(), 401)
+				return
+			}
+		}
+
 		ws.log.Logf("bridge calling %s:%s(%s)\n", zome, function, args)
-		result, err := ws.h.BridgeCall(zome, function, args, token)
+		result, err := ws.h.BridgeCall(zome, function, args, token, who)
 		if err != nil {
 			ws.log.Logf("call of %s:%s resulted in error: %v\n", zome, function, err)
 			errCode, err = mkErr(err.Error(), 400)
@@ -261,10 +364,37 @@ func mkErr(etext string, code int) (int, error) {
 	return code, errors.New(etext)
 }
 
-func (ws *WebServer) call(zome string, function string, args string) (result interface{}, err error) {
+// requestToken returns the session token from the request's Authorization header, or
+// from the session cookie for the browser UI.  Tokens are never taken from the URL, where
+// they would end up in logs and Referer headers.
+func requestToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if c, err := r.Cookie(SessionCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// exposure returns the exposure context for calls made with the session token
+func (ws *WebServer) exposure(token string) (exposure string, err error) {
+	if token == "" {
+		exposure = holo.PUBLIC_EXPOSURE
+		return
+	}
+	if err = ws.h.ValidateSession(token); err != nil {
+		return
+	}
+	exposure = holo.AUTHENTICATED_EXPOSURE
+	return
+}
+
+func (ws *WebServer) call(zome string, function string, args string, exposure string) (result interface{}, err error) {
 
 	ws.log.Logf("calling %s:%s(%s)\n", zome, function, args)
-	result, err = ws.h.Call(zome, function, args, holo.PUBLIC_EXPOSURE)
+	result, err = ws.h.Call(zome, function, args, exposure)
 
 	if err != nil {
 		_, err = mkErr(err.Error(), 400)
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"bytes"
+	"encoding/json"
 	. "github.com/holochain/holochain-proto"
 	. "github.com/holochain/holochain-proto/hash"
 	. "github.com/smartystreets/goconvey/convey"
@@ -108,6 +109,99 @@ func TestWebServer(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(string(b), ShouldEqual, "en")
 	})
+
+	zome, _ := h.GetZome("jsSampleZome")
+	for i := range zome.Functions {
+		if zome.Functions[i].Name == "getProperty" {
+			zome.Functions[i].Exposure = AUTHENTICATED_EXPOSURE
+		}
+	}
+	authCall := func(token string) (code int, result string) {
+		req, _ := http.NewRequest("POST", "http://0.0.0.0:31415/fn/jsSampleZome/getProperty", bytes.NewBuffer([]byte("language")))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	login := func(secret string) (code int, resp LoginResponse) {
+		body := bytes.NewBuffer([]byte(`{"Secret":"` + secret + `"}`))
+		r, err := http.Post("http://0.0.0.0:31415/login", "application/json", body)
+		So(err, ShouldBeNil)
+		defer r.Body.Close()
+		json.NewDecoder(r.Body).Decode(&resp)
+		return r.StatusCode, resp
+	}
+
+	Convey("login should be disabled without a login secret", t, func() {
+		code, _ := login("")
+		So(code, ShouldEqual, 403)
+	})
+
+	ws.SetLoginSecret("sesame")
+
+	Convey("it should not call authenticated functions without a session", t, func() {
+		code, result := authCall("")
+		So(code, ShouldEqual, 400)
+		So(result, ShouldEqual, "function not available\n")
+		code, result = authCall("bogus")
+		So(code, ShouldEqual, 401)
+		So(result, ShouldEqual, ErrSessionInvalid.Error()+"\n")
+		code, _ = login("open up")
+		So(code, ShouldEqual, 401)
+	})
+
+	Convey("it should call authenticated functions with a session token until logout", t, func() {
+		code, resp := login("sesame")
+		So(code, ShouldEqual, 200)
+		So(resp.Token, ShouldNotEqual, "")
+		So(resp.Expires.After(time.Now()), ShouldBeTrue)
+
+		code, result := authCall(resp.Token)
+		So(code, ShouldEqual, 200)
+		So(result, ShouldEqual, "en")
+
+		req, _ := http.NewRequest("POST", "http://0.0.0.0:31415/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+resp.Token)
+		r, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		r.Body.Close()
+		So(r.StatusCode, ShouldEqual, 200)
+
+		code, _ = authCall(resp.Token)
+		So(code, ShouldEqual, 401)
+	})
+
+	Convey("it should take the session token from the session cookie but not the URL", t, func() {
+		body := bytes.NewBuffer([]byte(`{"Secret":"sesame"}`))
+		r, err := http.Post("http://0.0.0.0:31415/login", "application/json", body)
+		So(err, ShouldBeNil)
+		r.Body.Close()
+		var cookie *http.Cookie
+		for _, c := range r.Cookies() {
+			if c.Name == SessionCookieName {
+				cookie = c
+			}
+		}
+		So(cookie, ShouldNotBeNil)
+		So(cookie.HttpOnly, ShouldBeTrue)
+
+		req, _ := http.NewRequest("POST", "http://0.0.0.0:31415/fn/jsSampleZome/getProperty", bytes.NewBuffer([]byte("language")))
+		req.AddCookie(cookie)
+		resp, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, 200)
+
+		resp, err = http.Post("http://0.0.0.0:31415/fn/jsSampleZome/getProperty?token="+cookie.Value, "", bytes.NewBuffer([]byte("language")))
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, 400)
+	})
+
 	ws.Stop()
 	ws.Wait()
 }
@@ -57,6 +57,10 @@ const (
 	OS_ALL_X   = OS_USER_X | OS_GROUP_X | OS_OTH_X
 	OS_ALL_RW  = OS_ALL_R | OS_ALL_W
 	OS_ALL_RWX = OS_ALL_RW | OS_GROUP_X
+
+	// exactTimeLayout is RFC 3339 with the offset always numeric, so that only UTC times
+	// are written with Z and times parse back to the same binary marshaling
+	exactTimeLayout = "2006-01-02T15:04:05.999999999-07:00"
 )
 
 func writeToml(path string, file string, data interface{}, overwrite bool) error {
@@ -405,3 +409,12 @@ func EscapeJSONValue(json string) string {
 
 	return strings.Replace(cleanStr, `"`, `\"`, -1)
 }
+
+// exactTimeString formats a time in RFC 3339 so that it parses back to the same time,
+// down to whether it was in UTC
+func exactTimeString(tm time.Time) string {
+	if tm.Location() == time.UTC {
+		return tm.Format(time.RFC3339Nano)
+	}
+	return tm.Format(exactTimeLayout)
+}
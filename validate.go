@@ -11,6 +11,8 @@ import (
 	"bytes"
 	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
 )
 
 // Package holds app specified data needed for validation (wire package)
@@ -97,9 +99,9 @@ func MakePackage(h *Holochain, req PackagingReq) (pkg Package, err error) {
 	return
 }
 
-// MakeValidationPackage converts a received Package into a ValidationPackage and validates
-// any chain data that was included
-func MakeValidationPackage(h *Holochain, pkg *Package) (vpkg *ValidationPackage, err error) {
+// MakeValidationPackage converts a Package received from source into a ValidationPackage and
+// validates any chain data that was included, checking that the headers were signed by source
+func MakeValidationPackage(h *Holochain, pkg *Package, source peer.ID) (vpkg *ValidationPackage, err error) {
 	vp := ValidationPackage{}
 	if (pkg != nil) && (pkg.Chain != nil) {
 		buf := bytes.NewBuffer(pkg.Chain)
@@ -113,7 +115,12 @@ func MakeValidationPackage(h *Holochain, pkg *Package) (vpkg *ValidationPackage,
 			vp.Chain.Entries[0].(*GobEntry).C = h.chain.Entries[0].(*GobEntry).C
 		}
 		if flags&ChainMarshalFlagsNoHeaders == 0 {
-			err = vp.Chain.Validate(flags&ChainMarshalFlagsNoEntries != 0)
+			var pubKey ic.PubKey
+			pubKey, err = h.getSourcePubKey(source)
+			if err != nil {
+				return
+			}
+			err = vp.Chain.Validate(flags&ChainMarshalFlagsNoEntries != 0, pubKey)
 			if err != nil {
 				return
 			}
@@ -135,6 +142,15 @@ func ValidateReceiver(h *Holochain, msg *Message) (response interface{}, err err
 		a = &ActionDel{}
 	case VALIDATE_LINK_REQUEST:
 		a = &ActionLink{}
+	case HEADER_REQUEST:
+		// the header alone is asked for when getting an entry's history
+		switch t := msg.Body.(type) {
+		case ValidateQuery:
+			response, err = h.getRevisionHeader(t.H)
+		default:
+			err = fmt.Errorf("expected ValidateQuery got %T", t)
+		}
+		return
 	default:
 		err = fmt.Errorf("message type %d not in holochain-validate protocol", int(msg.Type))
 	}
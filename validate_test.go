@@ -230,7 +230,7 @@ func TestMakeValidatePackage(t *testing.T) {
 
 	pkg, _ := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptFull)})
 	Convey("it should be able to make a validate package", t, func() {
-		vpkg, err := MakeValidationPackage(h, &pkg)
+		vpkg, err := MakeValidationPackage(h, &pkg, h.nodeID)
 		So(err, ShouldBeNil)
 		So(fmt.Sprintf("%v", vpkg.Chain), ShouldEqual, fmt.Sprintf("%v", h.chain))
 	})
@@ -238,7 +238,7 @@ func TestMakeValidatePackage(t *testing.T) {
 	Convey("it should return an error if the package data was tweaked", t, func() {
 		// tweak the agent header
 		pkg.Chain = []byte(strings.Replace(string(pkg.Chain), "%agent", "!agent", -1))
-		vpkg, err := MakeValidationPackage(h, &pkg)
+		vpkg, err := MakeValidationPackage(h, &pkg, h.nodeID)
 		So(err, ShouldNotBeNil)
 		So(vpkg, ShouldBeNil)
 
@@ -248,8 +248,24 @@ func TestMakeValidatePackage(t *testing.T) {
 		// tweak
 		pkg.Chain = []byte(strings.Replace(string(pkg.Chain), "Zippy", "Zappy", -1))
 
-		vpkg, err = MakeValidationPackage(h, &pkg)
+		vpkg, err = MakeValidationPackage(h, &pkg, h.nodeID)
 		So(err, ShouldNotBeNil)
 
+		// restore
+		pkg.Chain = []byte(strings.Replace(string(pkg.Chain), "Zappy", "Zippy", -1))
+	})
+
+	Convey("it should return a validation failure if the chain isn't signed by the source", t, func() {
+		other, key := makePeer("peer_bar")
+		h.node.peerstore.AddPubKey(other, key.GetPublic())
+		vpkg, err := MakeValidationPackage(h, &pkg, other)
+		So(err.Error(), ShouldEqual, ValidationFailedErrMsg+": chain's agent key doesn't match the author's key")
+		So(vpkg, ShouldBeNil)
+
+		pkg, _ = MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptHeaders)})
+		_, err = MakeValidationPackage(h, &pkg, h.nodeID)
+		So(err, ShouldBeNil)
+		_, err = MakeValidationPackage(h, &pkg, other)
+		So(err.Error(), ShouldEqual, ValidationFailedErrMsg+": "+ValidationFailureBadHeaderSignature+" at link 0")
 	})
 }
@@ -7,14 +7,19 @@
 package holochain
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	. "github.com/holochain/holochain-proto/hash"
 	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
+	"time"
 )
 
 const (
 	SelfRevocationType = iota
+	BlockType
+	ForkType
 )
 
 // Warrant abstracts the notion of a multi-party cryptographically verifiable signed claim
@@ -62,6 +67,12 @@ func DecodeWarrant(warrantType int, data []byte) (w Warrant, err error) {
 	case SelfRevocationType:
 		w = &SelfRevocationWarrant{}
 		err = w.Decode(data)
+	case BlockType:
+		w = &BlockWarrant{}
+		err = w.Decode(data)
+	case ForkType:
+		w = &ForkWarrant{}
+		err = w.Decode(data)
 	default:
 		err = UnknownWarrantTypeErr
 	}
@@ -158,3 +169,279 @@ func (w *SelfRevocationWarrant) Decode(data []byte) (err error) {
 	err = w.Revocation.Unmarshal(string(data))
 	return
 }
+
+// BlockWarrant warrants that the signing node has blocked the peers for the given reason.
+// It only attests to the blocker's own decision, it is not evidence of the peers' behavior,
+// so nodes that receive one only count it towards blocking the peers for a while.
+type BlockWarrant struct {
+	Blocker   string   // b58 encoded public key of the blocking node
+	Peers     []string // b58 encoded ids of the blocked peers
+	Reason    string
+	Time      time.Time
+	Signature []byte
+}
+
+var ErrBlockWarrantSignature = errors.New("block warrant signature doesn't match")
+var ErrBlockWarrantTime = errors.New("block warrant is too old or from the future")
+
+// NewBlockWarrant creates a warrant for blocking peers signed by the holochain's agent
+func NewBlockWarrant(h *Holochain, peers []peer.ID, reason string) (w *BlockWarrant, err error) {
+	var blocker string
+	blocker, err = h.agent.EncodePubKey()
+	if err != nil {
+		return
+	}
+	w = &BlockWarrant{Blocker: blocker, Reason: reason, Time: time.Now().Round(0)}
+	for _, p := range peers {
+		w.Peers = append(w.Peers, peer.IDB58Encode(p))
+	}
+	var data []byte
+	data, err = w.signedData()
+	if err != nil {
+		return
+	}
+	var sig Signature
+	sig, err = h.Sign(data)
+	if err != nil {
+		return
+	}
+	w.Signature = sig.S
+	return
+}
+
+// signedData returns the data the blocker signs
+func (w *BlockWarrant) signedData() (data []byte, err error) {
+	unsigned := *w
+	unsigned.Signature = nil
+	data, err = json.Marshal(unsigned)
+	return
+}
+
+// blockerID returns the node id of the blocker
+func (w *BlockWarrant) blockerID() (id peer.ID, err error) {
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(w.Blocker)
+	if err != nil {
+		return
+	}
+	id, err = peer.IDFromPublicKey(pubKey)
+	return
+}
+
+// checkListAdd returns an error if the warrant doesn't cover a list add request from sender for peers
+func (w *BlockWarrant) checkListAdd(sender peer.ID, peers []string) (err error) {
+	var blocker peer.ID
+	blocker, err = w.blockerID()
+	if err != nil {
+		return
+	}
+	if blocker != sender {
+		err = errors.New("block warrant is not from the sender")
+		return
+	}
+	for _, p := range peers {
+		found := false
+		for _, wp := range w.Peers {
+			if p == wp {
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = fmt.Errorf("block warrant doesn't cover %s", p)
+			return
+		}
+	}
+	return
+}
+
+// checkTime returns an error if the warrant is older than the window or from the future,
+// so that old warrants can't be replayed
+func (w *BlockWarrant) checkTime(window time.Duration) (err error) {
+	age := time.Since(w.Time)
+	if age > window || age < -BlockWarrantClockSkew {
+		err = ErrBlockWarrantTime
+	}
+	return
+}
+
+func (w *BlockWarrant) Type() int {
+	return BlockType
+}
+
+func (w *BlockWarrant) Parties() (parties []Hash, err error) {
+	var id peer.ID
+	id, err = w.blockerID()
+	if err != nil {
+		return
+	}
+	parties = append(parties, HashFromPeerID(id))
+	return
+}
+
+func (w *BlockWarrant) Verify(h *Holochain) (err error) {
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(w.Blocker)
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = w.signedData()
+	if err != nil {
+		return
+	}
+	var matches bool
+	matches, err = h.VerifySignature(Signature{S: w.Signature}, string(data), pubKey)
+	if err != nil {
+		return
+	}
+	if !matches {
+		err = ErrBlockWarrantSignature
+	}
+	return
+}
+
+func (w *BlockWarrant) Property(key string) (value interface{}, err error) {
+	switch key {
+	case "blocker":
+		value, err = w.blockerID()
+	case "peers":
+		value = w.Peers
+	case "reason":
+		value = w.Reason
+	default:
+		err = WarrantPropertyNotFoundErr
+	}
+	return
+}
+
+func (w *BlockWarrant) Encode() (data []byte, err error) {
+	data, err = json.Marshal(w)
+	return
+}
+
+func (w *BlockWarrant) Decode(data []byte) (err error) {
+	err = json.Unmarshal(data, w)
+	return
+}
+
+// ForkWarrant warrants that an agent forked its chain by signing two different headers
+// that both follow the same header.  The headers must be fully signed, as version 0 headers
+// only signed their entry links and so don't prove which header they follow.
+type ForkWarrant struct {
+	Agent   string // b58 encoded public key of the forking agent
+	Headers [2]Header
+}
+
+var ErrForkWarrantInvalid = errors.New("headers don't prove a fork")
+var ErrForkWarrantNotFullySigned = errors.New("fork warrant headers must be fully signed")
+
+func NewForkWarrant(agent ic.PubKey, header1 *Header, header2 *Header) (w *ForkWarrant, err error) {
+	var b58pk string
+	b58pk, err = encodePubKey(agent)
+	if err != nil {
+		return
+	}
+	w = &ForkWarrant{Agent: b58pk, Headers: [2]Header{*header1, *header2}}
+	return
+}
+
+// agentID returns the node id of the forking agent
+func (w *ForkWarrant) agentID() (id peer.ID, err error) {
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(w.Agent)
+	if err != nil {
+		return
+	}
+	id, err = peer.IDFromPublicKey(pubKey)
+	return
+}
+
+// checkListAdd returns an error if the warrant doesn't cover a list add request for peers
+func (w *ForkWarrant) checkListAdd(peers []string) (err error) {
+	var id peer.ID
+	id, err = w.agentID()
+	if err != nil {
+		return
+	}
+	if len(peers) != 1 || peers[0] != peer.IDB58Encode(id) {
+		err = errors.New("fork warrant only covers the forking agent")
+	}
+	return
+}
+
+func (w *ForkWarrant) Type() int {
+	return ForkType
+}
+
+func (w *ForkWarrant) Parties() (parties []Hash, err error) {
+	var id peer.ID
+	id, err = w.agentID()
+	if err != nil {
+		return
+	}
+	parties = append(parties, HashFromPeerID(id))
+	return
+}
+
+func (w *ForkWarrant) Verify(h *Holochain) (err error) {
+	var pubKey ic.PubKey
+	pubKey, err = DecodePubKey(w.Agent)
+	if err != nil {
+		return
+	}
+	h1, h2 := &w.Headers[0], &w.Headers[1]
+	if !h1.FullySigned() || !h2.FullySigned() {
+		err = ErrForkWarrantNotFullySigned
+		return
+	}
+	if !h1.HeaderLink.Equal(h2.HeaderLink) {
+		err = ErrForkWarrantInvalid
+		return
+	}
+	var hash1, hash2 Hash
+	if hash1, _, err = h1.Sum(h.hashSpec); err != nil {
+		return
+	}
+	if hash2, _, err = h2.Sum(h.hashSpec); err != nil {
+		return
+	}
+	if hash1.Equal(hash2) {
+		err = ErrForkWarrantInvalid
+		return
+	}
+	for _, hd := range w.Headers {
+		var matches bool
+		matches, err = hd.Verify(pubKey)
+		if err != nil {
+			return
+		}
+		if !matches {
+			err = fmt.Errorf("fork warrant header for %v not signed by agent", hd.EntryLink)
+			return
+		}
+	}
+	return
+}
+
+func (w *ForkWarrant) Property(key string) (value interface{}, err error) {
+	switch key {
+	case "agent":
+		value, err = w.agentID()
+	case "headerLink":
+		value = w.Headers[0].HeaderLink
+	default:
+		err = WarrantPropertyNotFoundErr
+	}
+	return
+}
+
+func (w *ForkWarrant) Encode() (data []byte, err error) {
+	data, err = ByteEncoder(w)
+	return
+}
+
+func (w *ForkWarrant) Decode(data []byte) (err error) {
+	err = ByteDecoder(data, w)
+	return
+}
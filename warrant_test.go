@@ -2,6 +2,7 @@ package holochain
 
 import (
 	"fmt"
+	. "github.com/holochain/holochain-proto/hash"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/smartystreets/goconvey/convey"
 
@@ -88,3 +89,110 @@ func TestSelfRevocationWarrant(t *testing.T) {
 
 	})
 }
+
+func TestBlockWarrant(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	pid, _ := makePeer("peer1")
+
+	w, err := NewBlockWarrant(h, []peer.ID{pid}, "sent invalid data")
+
+	Convey("NewBlockWarrant should create one", t, func() {
+		So(err, ShouldBeNil)
+		So(w.Type(), ShouldEqual, BlockType)
+		So(w.Peers, ShouldResemble, []string{peer.IDB58Encode(pid)})
+	})
+
+	Convey("it should have the blocker as its party", t, func() {
+		parties, err := w.Parties()
+		So(err, ShouldBeNil)
+		So(len(parties), ShouldEqual, 1)
+		So(parties[0].String(), ShouldEqual, h.nodeIDStr)
+	})
+
+	Convey("it should have properties", t, func() {
+		blocker, err := w.Property("blocker")
+		So(err, ShouldBeNil)
+		So(blocker, ShouldEqual, h.nodeID)
+		reason, err := w.Property("reason")
+		So(err, ShouldBeNil)
+		So(reason, ShouldEqual, "sent invalid data")
+		_, err = w.Property("foo")
+		So(err, ShouldEqual, WarrantPropertyNotFoundErr)
+	})
+
+	Convey("it should verify", t, func() {
+		So(w.Verify(h), ShouldBeNil)
+		tampered := *w
+		tampered.Reason = "something else"
+		So(tampered.Verify(h), ShouldEqual, ErrBlockWarrantSignature)
+	})
+
+	Convey("it should encode and decode warrants", t, func() {
+		encoded, err := w.Encode()
+		So(err, ShouldBeNil)
+		w1, err := DecodeWarrant(BlockType, encoded)
+		So(err, ShouldBeNil)
+		So(w1.Verify(h), ShouldBeNil)
+		So(fmt.Sprintf("%v", w1), ShouldEqual, fmt.Sprintf("%v", w))
+	})
+}
+
+func TestSelfRevocationWarrantRotation(t *testing.T) {
+	nodesCount := 2
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+	processChangeRequestsInTesting(h1)
+
+	// h0 holds h1's key as if it had been gossiped to it
+	err := h0.dht.putKey(h1.agent)
+	if err != nil {
+		panic(err)
+	}
+	oldID := h1.nodeID
+	oldIDStr := h1.nodeIDStr
+
+	Convey("rotating the agent's key should re-key the node", t, func() {
+		err := h1.RotateAgentKey("")
+		So(err, ShouldBeNil)
+		So(h1.nodeIDStr, ShouldNotEqual, oldIDStr)
+		So(h1.node.HashAddr, ShouldEqual, h1.nodeID)
+		So(h1.node.routingTable.Find(h0.nodeID), ShouldEqual, h0.nodeID)
+
+		header := h1.chain.Top()
+		So(header.Type, ShouldEqual, AgentEntryType)
+		entry, _, _ := h1.chain.GetEntry(header.EntryLink)
+		a, _ := AgentEntryFromJSON(entry.Content().(string))
+		revocation := &SelfRevocation{}
+		So(revocation.Unmarshal(a.Revocation), ShouldBeNil)
+		w, _ := NewSelfRevocationWarrant(revocation)
+		parties, err := w.Parties()
+		So(err, ShouldBeNil)
+		So(parties[0].String(), ShouldEqual, oldIDStr)
+		So(parties[1].String(), ShouldEqual, h1.nodeIDStr)
+		payload, _ := w.Property("payload")
+		So(string(payload.([]byte)), ShouldEqual, DefaultRotationPayload)
+	})
+
+	Convey("the old key should be archived where it can't be loaded", t, func() {
+		agent, err := LoadAgent(h1.rootPath)
+		So(err, ShouldBeNil)
+		_, nodeIDStr, _ := agent.NodeID()
+		So(nodeIDStr, ShouldEqual, h1.nodeIDStr)
+		So(FileExists(h1.rootPath, RevokedKeysDir, oldIDStr), ShouldBeTrue)
+	})
+
+	Convey("the other node should block the old node once the warrant is published", t, func() {
+		So(h0.node.IsBlocked(oldID), ShouldBeFalse)
+		processChangeRequestsInTesting(h1)
+		data, _, _, _, err := h0.dht.Get(HashFromPeerID(oldID), StatusDefault, GetMaskDefault)
+		So(err, ShouldEqual, ErrHashModified)
+		So(string(data), ShouldEqual, h1.nodeIDStr)
+		So(h0.node.IsBlocked(oldID), ShouldBeTrue)
+		So(h0.node.IsBlocked(h1.nodeID), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,299 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// implements the optional authenticated encryption of messages sent between nodes
+
+package holochain
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	net "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	// constants for the DHTConfig WireEncryption
+
+	WireEncryptionNone   = "none"
+	WireEncryptionAESGCM = "AES-GCM"
+
+	wireMagic        = "HCW1"
+	wireMaxFrameSize = 128 * 1024 * 1024
+	wireKeyInfo      = "holochain wire key"
+
+	// wireMaxHelloSize limits the frames read before the peer is authenticated, which
+	// only hold a WireHello
+	wireMaxHelloSize = 4 * 1024
+
+	// wireChunkSize is how much of a large frame is allocated at a time, so that what
+	// is allocated grows with the data actually read rather than the size claimed
+	wireChunkSize = 64 * 1024
+
+	wireInitiator = byte(1)
+	wireResponder = byte(2)
+)
+
+var ErrWireEncryptionRequired = errors.New("wire encryption required")
+var ErrWireEncryptionNegotiation = errors.New("wire encryption negotiation failed")
+var ErrWireFrameTooLarge = errors.New("wire frame too large")
+
+// WireHello holds the ephemeral key, signed by the node's key, that each side
+// of a stream sends when negotiating wire encryption
+type WireHello struct {
+	Method string
+	Key    []byte
+	Sig    []byte
+}
+
+// wireConn encrypts and decrypts messages on a stream once wire encryption has been negotiated
+type wireConn struct {
+	rw      io.ReadWriter
+	aead    cipher.AEAD
+	sendDir byte
+	recvDir byte
+	sendSeq uint64
+	recvSeq uint64
+
+	encoding string // encoding messages are written and read in
+}
+
+// isWireEncrypted returns true if the method requires wire encryption
+func isWireEncrypted(method string) bool {
+	return method != "" && method != WireEncryptionNone
+}
+
+// checkWireEncryption returns an error if the method isn't a known wire encryption method
+func checkWireEncryption(method string) (err error) {
+	switch method {
+	case "", WireEncryptionNone, WireEncryptionAESGCM:
+	default:
+		err = fmt.Errorf("Invalid wire encryption. Must be one of: %s, %s", WireEncryptionNone, WireEncryptionAESGCM)
+	}
+	return
+}
+
+// writeFrame writes a length prefixed block of data
+func writeFrame(w io.Writer, data []byte) (n int, err error) {
+	if len(data) > wireMaxFrameSize {
+		err = ErrWireFrameTooLarge
+		return
+	}
+	b := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[4:], data)
+	n, err = w.Write(b)
+	return
+}
+
+// readFrame reads a length prefixed block of data of at most max bytes
+func readFrame(r io.Reader, max uint32) (data []byte, err error) {
+	var l [4]byte
+	_, err = io.ReadFull(r, l[:])
+	if err != nil {
+		return
+	}
+	size := binary.BigEndian.Uint32(l[:])
+	if size > max {
+		err = ErrWireFrameTooLarge
+		return
+	}
+	if size <= wireChunkSize {
+		data = make([]byte, size)
+		_, err = io.ReadFull(r, data)
+		return
+	}
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, r, int64(size))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	data = buf.Bytes()
+	return
+}
+
+// wireSigData builds the data that gets signed in a WireHello
+func wireSigData(method string, role byte, key []byte) []byte {
+	return append([]byte(method+"\x00"+string(role)), key...)
+}
+
+// wireHandshake negotiates wire encryption on a stream with the remote peer, returning a
+// wireConn for sending and receiving messages.  The initiator is the side that opened the stream.
+func (node *Node) wireHandshake(rw io.ReadWriter, remote peer.ID, remoteKey ic.PubKey, initiator bool) (c *wireConn, err error) {
+	defer func() {
+		if err != nil {
+			node.log.Logf("wire encryption with %v failed: %v", remote, err)
+		}
+	}()
+	method := node.wireEncryption
+	if remoteKey == nil {
+		remoteKey = node.peerstore.PubKey(remote)
+	}
+	if remoteKey == nil {
+		err = ErrWireEncryptionNegotiation
+		return
+	}
+	var id peer.ID
+	id, err = peer.IDFromPublicKey(remoteKey)
+	if err != nil {
+		return
+	}
+	if id != remote {
+		err = ErrWireEncryptionNegotiation
+		return
+	}
+
+	curve := elliptic.P256()
+	priv, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return
+	}
+	myRole, theirRole := wireInitiator, wireResponder
+	if !initiator {
+		myRole, theirRole = wireResponder, wireInitiator
+	}
+
+	hello := WireHello{Method: method, Key: elliptic.Marshal(curve, x, y)}
+	hello.Sig, err = node.peerstore.PrivKey(node.HashAddr).Sign(wireSigData(method, myRole, hello.Key))
+	if err != nil {
+		return
+	}
+
+	sendHello := func() (err error) {
+		var data []byte
+		data, err = ByteEncoder(&hello)
+		if err != nil {
+			return
+		}
+		_, err = rw.Write([]byte(wireMagic))
+		if err != nil {
+			return
+		}
+		_, err = writeFrame(rw, data)
+		return
+	}
+
+	// the initiator speaks first
+	if initiator {
+		if err = sendHello(); err != nil {
+			return
+		}
+	}
+
+	var magic [len(wireMagic)]byte
+	_, err = io.ReadFull(rw, magic[:])
+	if err != nil {
+		return
+	}
+	if string(magic[:]) != wireMagic {
+		err = ErrWireEncryptionRequired
+		return
+	}
+	var data []byte
+	data, err = readFrame(rw, wireMaxHelloSize)
+	if err != nil {
+		return
+	}
+	var theirs WireHello
+	err = ByteDecoder(data, &theirs)
+	if err != nil {
+		return
+	}
+	if theirs.Method != method {
+		err = ErrWireEncryptionNegotiation
+		return
+	}
+	var ok bool
+	ok, err = remoteKey.Verify(wireSigData(method, theirRole, theirs.Key), theirs.Sig)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = ErrWireEncryptionNegotiation
+		return
+	}
+	rx, ry := elliptic.Unmarshal(curve, theirs.Key)
+	if rx == nil {
+		err = ErrWireEncryptionNegotiation
+		return
+	}
+
+	if !initiator {
+		if err = sendHello(); err != nil {
+			return
+		}
+	}
+
+	sx, _ := curve.ScalarMult(rx, ry, priv)
+	key := sha256.Sum256(append([]byte(wireKeyInfo), sx.Bytes()...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	c = &wireConn{rw: rw, aead: aead, sendDir: myRole, recvDir: theirRole, encoding: node.encoding}
+	return
+}
+
+// wireAccept negotiates wire encryption on a stream opened by a remote peer
+func (node *Node) wireAccept(s net.Stream) (c *wireConn, err error) {
+	c, err = node.wireHandshake(s, s.Conn().RemotePeer(), s.Conn().RemotePublicKey(), false)
+	return
+}
+
+// wireDial negotiates wire encryption on a stream we opened to a remote peer
+func (node *Node) wireDial(s net.Stream) (c *wireConn, err error) {
+	c, err = node.wireHandshake(s, s.Conn().RemotePeer(), s.Conn().RemotePublicKey(), true)
+	return
+}
+
+func (c *wireConn) nonce(dir byte, seq uint64) []byte {
+	n := make([]byte, c.aead.NonceSize())
+	n[0] = dir
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
+
+// WriteMessage encrypts and writes a message to the stream
+func (c *wireConn) WriteMessage(m *Message) (n int, err error) {
+	var data []byte
+	data, err = m.EncodeAs(c.encoding)
+	if err != nil {
+		return
+	}
+	sealed := c.aead.Seal(nil, c.nonce(c.sendDir, c.sendSeq), data, nil)
+	c.sendSeq++
+	n, err = writeFrame(c.rw, sealed)
+	return
+}
+
+// ReadMessage reads and decrypts a message from the stream
+func (c *wireConn) ReadMessage(m *Message) (err error) {
+	var sealed []byte
+	sealed, err = readFrame(c.rw, wireMaxFrameSize)
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = c.aead.Open(nil, c.nonce(c.recvDir, c.recvSeq), sealed, nil)
+	if err != nil {
+		return
+	}
+	c.recvSeq++
+	err = m.DecodeAs(c.encoding, bytes.NewReader(data))
+	return
+}
@@ -0,0 +1,110 @@
+package holochain
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckWireEncryption(t *testing.T) {
+	Convey("it should accept the known wire encryption methods", t, func() {
+		So(checkWireEncryption(""), ShouldBeNil)
+		So(checkWireEncryption(WireEncryptionNone), ShouldBeNil)
+		So(checkWireEncryption(WireEncryptionAESGCM), ShouldBeNil)
+		So(checkWireEncryption("rot13").Error(), ShouldEqual, "Invalid wire encryption. Must be one of: none, AES-GCM")
+	})
+}
+
+func TestWireFrames(t *testing.T) {
+	Convey("frames should round trip", t, func() {
+		var b bytes.Buffer
+		n, err := writeFrame(&b, []byte("fish"))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 8)
+		data, err := readFrame(&b, wireMaxHelloSize)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "fish")
+	})
+
+	Convey("frames longer than the limit should be refused", t, func() {
+		var b bytes.Buffer
+		writeFrame(&b, make([]byte, wireMaxHelloSize+1))
+		_, err := readFrame(&b, wireMaxHelloSize)
+		So(err, ShouldEqual, ErrWireFrameTooLarge)
+	})
+
+	Convey("frames shorter than they claim should fail", t, func() {
+		b := bytes.NewBuffer([]byte{0x07, 0xff, 0xff, 0xff, 'f', 'i', 's', 'h'})
+		_, err := readFrame(b, wireMaxFrameSize)
+		So(err, ShouldEqual, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestWireEncryption(t *testing.T) {
+	echo := func(h *Holochain, m *Message) (response interface{}, err error) {
+		response = m.Body
+		return
+	}
+
+	node1, err := makeNode(1250, "node1")
+	if err != nil {
+		panic(err)
+	}
+	defer node1.Close()
+	node1.wireEncryption = WireEncryptionAESGCM
+	node1.protocols[ActionProtocol].Receiver = echo
+	node1.StartProtocol(nil, ActionProtocol)
+
+	node2, err := makeNode(1251, "node2")
+	if err != nil {
+		panic(err)
+	}
+	defer node2.Close()
+	node2.wireEncryption = WireEncryptionAESGCM
+	node2.host.Peerstore().AddAddr(node1.HashAddr, node1.NetAddr, pstore.PermanentAddrTTL)
+
+	node3, err := makeNode(1252, "node3")
+	if err != nil {
+		panic(err)
+	}
+	defer node3.Close()
+	node3.host.Peerstore().AddAddr(node1.HashAddr, node1.NetAddr, pstore.PermanentAddrTTL)
+
+	Convey("nodes that both use wire encryption should be able to talk", t, func() {
+		m := node2.NewMessage(APP_MESSAGE, "fish")
+		r, err := node2.Send(context.Background(), ActionProtocol, node1.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, OK_RESPONSE)
+		So(r.From, ShouldEqual, node1.HashAddr)
+		So(r.Body, ShouldEqual, "fish")
+
+		// and again on a new stream
+		m = node2.NewMessage(APP_MESSAGE, "cow")
+		r, err = node2.Send(context.Background(), ActionProtocol, node1.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Body, ShouldEqual, "cow")
+	})
+
+	Convey("a node without wire encryption should be refused", t, func() {
+		m := node3.NewMessage(APP_MESSAGE, "fish")
+		r, err := node3.Send(context.Background(), ActionProtocol, node1.HashAddr, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body.(ErrorResponse).DecodeResponseError(), ShouldEqual, ErrWireEncryptionRequired)
+	})
+
+	Convey("a node with wire encryption should refuse to talk in the clear", t, func() {
+		node3.wireEncryption = WireEncryptionNone
+		node3.protocols[ActionProtocol].Receiver = echo
+		node3.StartProtocol(nil, ActionProtocol)
+		node2.host.Peerstore().AddAddr(node3.HashAddr, node3.NetAddr, pstore.PermanentAddrTTL)
+
+		m := node2.NewMessage(APP_MESSAGE, "fish")
+		_, err := node2.Send(context.Background(), ActionProtocol, node3.HashAddr, m)
+		So(err, ShouldNotBeNil)
+	})
+}
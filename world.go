@@ -27,6 +27,7 @@ type World struct {
 	nodes       map[peer.ID]*NodeRecord
 	responsible map[Hash][]peer.ID
 	ht          HashTable
+	sharding    ShardingMethod
 	log         *Logger
 
 	lk sync.RWMutex
@@ -35,11 +36,12 @@ type World struct {
 var ErrNodeNotFound = errors.New("node not found")
 
 // NewWorld creates and empty world model
-func NewWorld(me peer.ID, ht HashTable, logger *Logger) *World {
+func NewWorld(me peer.ID, ht HashTable, sharding ShardingMethod, logger *Logger) *World {
 	world := World{me: me}
 	world.nodes = make(map[peer.ID]*NodeRecord)
 	world.responsible = make(map[Hash][]peer.ID)
 	world.ht = ht
+	world.sharding = sharding
 	world.log = logger
 	return &world
 }
@@ -110,14 +112,15 @@ func (world *World) AddNode(pi pstore.PeerInfo, pubKey ic.PubKey) (err error) {
 	return
 }
 
-// NodesByHash returns a sorted list of peers, including "me" by distance from a hash
+// NodesByHash returns a sorted list of peers, including "me" by nearness to a hash
+// according to the world's sharding method
 func (world *World) nodesByHash(hash Hash) (nodes []peer.ID, err error) {
 	nodes, err = world.allNodes()
 	if err != nil {
 		return
 	}
 	nodes = append(nodes, world.me)
-	nodes = SortClosestPeers(nodes, hash)
+	nodes = world.sharding.SortPeers(nodes, hash)
 	return
 }
 
@@ -134,6 +137,7 @@ func (world *World) NodeRecordsByHash(hash Hash) (records []*NodeRecord, err err
 }*/
 
 // UpdateResponsible calculates the list of nodes believed to be responsible for a given hash
+// using the world's sharding method
 // note that if redundancy is 0 the assumption is that all nodes are responsible
 func (world *World) UpdateResponsible(hash Hash, redundancy int) (responsible bool, err error) {
 	world.lk.Lock()
@@ -149,8 +153,10 @@ func (world *World) UpdateResponsible(hash Hash, redundancy int) (responsible bo
 		}
 		// TODO add in resilince calculations with uptime
 		// see https://waffle.io/Holochain/holochain-proto/cards/5af33c5b8daa2d001cd1d051
+		world.log.Logf("Number of nodes: %d, Nodes:%v\n", len(nodes), nodes)
+		nodes = world.sharding.Responsible(nodes, hash, redundancy)
 		i := 0
-		for i = 0; i < redundancy; i++ {
+		for i = 0; i < len(nodes); i++ {
 			if nodes[i] == world.me {
 				responsible = true
 				break
@@ -162,12 +168,7 @@ func (world *World) UpdateResponsible(hash Hash, redundancy int) (responsible bo
 		if responsible {
 			// remove myself from the nodes list so I can add set the
 			// responsible nodes
-			world.log.Logf("Number of nodes: %d, Nodes:%v\n", len(nodes), nodes)
-			max := len(nodes)
-			if max > redundancy {
-				max = redundancy
-			}
-			nodes = append(nodes[:i], nodes[i+1:max]...)
+			nodes = append(nodes[:i], nodes[i+1:]...)
 			world.responsible[hash] = nodes
 			world.log.Logf("Responsible for %v: %v", hash, nodes)
 
@@ -36,7 +36,7 @@ func TestWorldNodes(t *testing.T) {
 	peer, _ := peer.IDB58Decode(b58)
 
 	ht := BuntHT{}
-	world := NewWorld(peer, &ht, nil)
+	world := NewWorld(peer, &ht, &XORSharding{}, nil)
 
 	Convey("to start with I should know about nobody", t, func() {
 		nodes, err := world.AllNodes()
@@ -97,7 +97,7 @@ func TestWorldUpdateResponsible(t *testing.T) {
 	var hash1, hash2, hash4 Hash
 	p1, _ = peer.IDB58Decode(b58)
 	ht := BuntHT{}
-	world := NewWorld(p1, &ht, nil)
+	world := NewWorld(p1, &ht, &XORSharding{}, nil)
 	var addr ma.Multiaddr
 	var err error
 	var responsible bool
@@ -35,10 +35,12 @@ func (z *Zome) GetEntryDef(entryName string) (e *EntryDef, err error) {
 	return
 }
 
+// GetPrivateEntryDefs returns the definitions of the zome's entries that aren't published
+// in the clear, which are left out of validation packages
 func (z *Zome) GetPrivateEntryDefs() (privateDefs []EntryDef) {
 	privateDefs = make([]EntryDef, 0)
 	for _, def := range z.Entries {
-		if def.Sharing == Private {
+		if def.Sharing == Private || def.isSharingPartial() {
 			privateDefs = append(privateDefs, def)
 		}
 	}
@@ -143,12 +143,17 @@ func (z *ZygoRibosome) ValidatePackagingRequest(action ValidatingAction, def *En
 }
 
 func prepareZyEntryArgs(def *EntryDef, entry Entry, header *Header) (args string, err error) {
-	entryStr := entry.Content().(string)
+	entryStr := entryContentString(entry)
 	switch def.DataFormat {
 	case DataFormatRawZygo:
 		args = entryStr
+	case DataFormatBytes:
+		fallthrough
 	case DataFormatString:
 		args = "\"" + sanitizeZyString(entryStr) + "\""
+	case DataFormatBlob:
+		// blob entries are validated by their manifest
+		fallthrough
 	case DataFormatLinks:
 		fallthrough
 	case DataFormatJSON:
@@ -250,13 +255,17 @@ func mkZySources(sources []string) (srcs string) {
 }
 
 func (z *ZygoRibosome) prepareValidateArgs(def *EntryDef, entry Entry, sources []string) (e string, srcs string, err error) {
-	c := entry.Content().(string)
+	c := entryContentString(entry)
 	// @todo handle JSON if schema type is different
 	switch def.DataFormat {
 	case DataFormatRawZygo:
 		e = c
+	case DataFormatBytes:
+		fallthrough
 	case DataFormatString:
 		e = "\"" + sanitizeZyString(c) + "\""
+	case DataFormatBlob:
+		fallthrough
 	case DataFormatLinks:
 		fallthrough
 	case DataFormatJSON:
@@ -399,6 +408,9 @@ const (
 
 		`(def HC_LinkAction_Add "` + AddLinkAction + "\")" +
 		`(def HC_LinkAction_Del "` + DelLinkAction + "\")" +
+		`(def HC_LinkOrder_Default "` + LinkOrderDefault + "\")" +
+		`(def HC_LinkOrder_Tag "` + LinkOrderTag + "\")" +
+		`(def HC_LinkOrder_Time "` + LinkOrderTime + "\")" +
 		`(def HC_PkgReq_Chain "` + PkgReqChain + "\")" +
 		`(def HC_PkgReq_ChainOpt_None "` + PkgReqChainOptNoneStr + "\")" +
 		`(def HC_PkgReq_ChainOpt_Headers "` + PkgReqChainOptHeadersStr + "\")" +
@@ -503,6 +515,11 @@ func zyProcessArgs(z *ZygoRibosome, args []Arg, zyArgs []zygo.Sexp) (err error)
 				fallthrough
 			case DataFormatRawJS:
 				fallthrough
+			case DataFormatBytes:
+				fallthrough
+			case DataFormatBlob:
+				// binary entries are passed base64 encoded
+				fallthrough
 			case DataFormatString:
 				switch t := a.(type) {
 				case *zygo.SexpStr:
@@ -848,6 +865,19 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			e := GobEntry{C: entry}
 			a.action.entryType = entryType
 			a.action.entry = &e
+			if len(zyargs) == 3 {
+				options := CommitOptions{}
+				var j []byte
+				j, err = json.Marshal(args[2].value)
+				if err != nil {
+					return zygo.SexpNull, err
+				}
+				err = json.Unmarshal(j, &options)
+				if err != nil {
+					return zygo.SexpNull, err
+				}
+				a.action.recipients = options.Recipients
+			}
 			r, err = a.Call(h)
 			if err != nil {
 				return zygo.SexpNull, err
@@ -951,12 +981,16 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 						fallthrough
 					case DataFormatRawJS:
 						fallthrough
+					case DataFormatBytes:
+						fallthrough
+					case DataFormatBlob:
+						fallthrough
 					case DataFormatString:
 						fallthrough
 					case DataFormatLinks:
 						fallthrough
 					case DataFormatJSON:
-						content = result.Entry.Content().(string)
+						content = entryContentString(result.Entry)
 					default:
 						return zygo.SexpNull, fmt.Errorf("data format not implemented: %s", def.DataFormat)
 					}
@@ -1020,12 +1054,22 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 				if ok {
 					options.Local = local.(bool)
 				}
+				history, ok := opts["History"]
+				if ok {
+					options.History = history.(bool)
+				}
 
 			}
 			req := GetReq{H: args[0].value.(Hash), StatusMask: options.StatusMask, GetMask: options.GetMask}
 
 			var r interface{}
 			r, err = callGet(h, req, &options)
+			if options.History && err == nil {
+				historyResp := r.(HistoryResp)
+				var j string
+				j, err = h.historyJSON(&historyResp)
+				return makeResult(env, &zygo.SexpStr{S: j}, err)
+			}
 			mask := options.GetMask
 			if mask == GetMaskDefault {
 				mask = GetMaskEntry
@@ -1219,17 +1263,63 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 					}
 					options.StatusMask = int(maskval)
 				}
+				prefix, ok := opts["TagPrefix"]
+				if ok {
+					options.TagPrefix, ok = prefix.(string)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting string TagPrefix attribute in object, got %T", prefix)
+					}
+				}
+				order, ok := opts["Order"]
+				if ok {
+					options.Order, ok = order.(string)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting string Order attribute in object, got %T", order)
+					}
+				}
+				desc, ok := opts["Descending"]
+				if ok {
+					options.Descending, ok = desc.(bool)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting boolean Descending attribute in object, got %T", desc)
+					}
+				}
+				count, ok := opts["Count"]
+				if ok {
+					countval, ok := count.(float64)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting int Count attribute in object, got %T", count)
+					}
+					options.Count = int(countval)
+				}
+				cursor, ok := opts["Cursor"]
+				if ok {
+					options.Cursor, ok = cursor.(string)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting string Cursor attribute in object, got %T", cursor)
+					}
+				}
 			}
 
 			var r interface{}
-			fn.action = *NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask}, &options)
+			fn.action = *NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask, TagPrefix: options.TagPrefix, Order: options.Order, Descending: options.Descending, Count: options.Count, Cursor: options.Cursor}, &options)
 			r, err = fn.Call(h)
 			var resultValue zygo.Sexp
 			if err == nil {
 				response := r.(*LinkQueryResp)
 				resultValue = zygo.SexpNull
 				var j []byte
-				j, err = json.Marshal(response.Links)
+				// when paging return the links along with the cursor for the next page
+				if options.Count > 0 || options.Cursor != "" {
+					j, err = json.Marshal(response)
+				} else {
+					j, err = json.Marshal(response.Links)
+				}
 				if err == nil {
 					resultValue = &zygo.SexpStr{S: string(j)}
 				}
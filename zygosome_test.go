@@ -280,6 +280,10 @@ func TestZygoQuery(t *testing.T) {
 			_, err := z.Run(`(debug (str (query (hash Constrain: (hash EntryTypes: ["profile"])))))`)
 			So(err, ShouldBeNil)
 		}, `["{\"firstName\":\"Zippy\",\"lastName\":\"Pinhead\"}"]`)
+		ShouldLog(h.nucleus.alog, func() {
+			_, err := z.Run(`(debug (str (query (hash Return: (hash Fields: ["lastName"]) Constrain: (hash EntryTypes: ["profile"] Where: (hash Or: [(hash Field: "firstName" Op: "=" Value: "Zippy") (hash Field: "firstName" Op: "in" Value: ["Zerbina"])]))))))`)
+			So(err, ShouldBeNil)
+		}, `["{\"lastName\":\"Pinhead\"}"]`)
 		ShouldLog(h.nucleus.alog, func() {
 			_, err := z.Run(`(debug (str (query (hash Constrain: (hash EntryTypes: ["%agent"])))))`)
 			So(err, ShouldBeNil)
@@ -368,7 +372,7 @@ func TestZybuildValidate(t *testing.T) {
 	Convey("it should build put", t, func() {
 		a := NewPutAction("evenNumbers", &e, &header)
 		pkg, _ := MakePackage(h, PackagingReq{PkgReqChain: int64(PkgReqChainOptFull)})
-		vpkg, _ := MakeValidationPackage(h, &pkg)
+		vpkg, _ := MakeValidationPackage(h, &pkg, h.nodeID)
 		_, err := buildZyValidateAction(a, &def, vpkg, []string{"fake_src_hash"})
 		So(err, ShouldBeNil)
 		//So(code, ShouldEqual, `validatePut("evenNumbers","2",{"EntryLink":"","Type":"","Time":"0001-01-01T00:00:00Z"},pgk,["fake_src_hash"])`)
@@ -622,6 +626,43 @@ func TestZygoDHT(t *testing.T) {
 		So(r.(*zygo.SexpStr).S, ShouldEqual, fmt.Sprintf(`[{"H":"QmYeinX5vhuA91D3v24YbgyLofw9QAxY6PoATrBHnRwbtt","E":"{\"firstName\":\"Zippy\",\"lastName\":\"Pinhead\"}","EntryType":"profile","T":"","Source":"%s"}]`, h.nodeIDStr))
 	})
 
+	Convey("getLinks function with Count option should return pages of Links with a Cursor", t, func() {
+		commit(h, "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"pages"},{"Base":"%s","Link":"%s","Tag":"pages"}]}`, hash.String(), profileHash.String(), hash.String(), hash.String()))
+		first, second := profileHash.String(), hash.String()
+		if second < first {
+			first, second = second, first
+		}
+		getPage := func(opts string) (page LinkQueryResp) {
+			v, err := NewZygoRibosome(h, &Zome{RibosomeType: ZygoRibosomeType, Code: fmt.Sprintf(`(getLinks "%s" "pages" (hash Count:1 %s))`, hash.String(), opts)})
+			So(err, ShouldBeNil)
+			z := v.(*ZygoRibosome)
+			r, err := z.lastResult.(*zygo.SexpHash).HashGet(z.env, z.env.MakeSymbol("result"))
+			So(err, ShouldBeNil)
+			So(json.Unmarshal([]byte(r.(*zygo.SexpStr).S), &page), ShouldBeNil)
+			return
+		}
+		page := getPage("")
+		So(len(page.Links), ShouldEqual, 1)
+		So(page.Links[0].H, ShouldEqual, first)
+		So(page.Cursor, ShouldNotEqual, "")
+
+		page = getPage(fmt.Sprintf(`Cursor:"%s"`, page.Cursor))
+		So(len(page.Links), ShouldEqual, 1)
+		So(page.Links[0].H, ShouldEqual, second)
+		So(page.Cursor, ShouldEqual, "")
+	})
+
+	Convey("getLinks function without paging should fail rather than truncate when there are more links than MaxLinkSets", t, func() {
+		h.nucleus.dna.DHTConfig.MaxLinkSets = 1
+		defer func() { h.nucleus.dna.DHTConfig.MaxLinkSets = 0 }()
+		v, err := NewZygoRibosome(h, &Zome{RibosomeType: ZygoRibosomeType, Code: fmt.Sprintf(`(getLinks "%s" "pages")`, hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*ZygoRibosome)
+		r, err := z.lastResult.(*zygo.SexpHash).HashGet(z.env, z.env.MakeSymbol("error"))
+		So(err, ShouldBeNil)
+		So(r.(*zygo.SexpStr).S, ShouldEqual, ErrLinksCapped.Error())
+	})
+
 	Convey("commit with del link should delete link", t, func() {
 		v, err := NewZygoRibosome(h, &Zome{RibosomeType: ZygoRibosomeType, Code: fmt.Sprintf(`(commit "rating" (hash Links:[(hash LinkAction:HC_LinkAction_Del Base:"%s" Link:"%s" Tag:"4stars")]))`, hash.String(), profileHash.String())})
 		So(err, ShouldBeNil)